@@ -2,23 +2,39 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	pbAbf "github.com/FluVirus2/antibruteforce/api/gen/v1/antibruteforce"
 	pbMgmt "github.com/FluVirus2/antibruteforce/api/gen/v1/antibruteforce_management"
+	"github.com/FluVirus2/antibruteforce/pkg/tlsreload"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
-	defaultServerAddr = "localhost:80"
-	defaultTimeout    = 10 * time.Second
-	serverAddrEnvKey  = "ABF_SERVER_ADDR"
+	defaultServerAddr          = "localhost:80"
+	defaultTimeout             = 10 * time.Second
+	serverAddrEnvKey           = "ABF_SERVER_ADDR"
+	managementServerAddrEnvKey = "ABF_MANAGEMENT_ADDR"
+	tokenEnvKey                = "ABF_TOKEN"
+	caFileEnvKey               = "ABF_CA_FILE"
+	certFileEnvKey             = "ABF_CERT_FILE"
+	keyFileEnvKey              = "ABF_KEY_FILE"
+	tenantEnvKey               = "ABF_TENANT"
 )
 
 var errInvalidUsage = errors.New("invalid usage")
@@ -30,6 +46,58 @@ func getDefaultServerAddr() string {
 	return defaultServerAddr
 }
 
+// bearerToken attaches the management API token to every call.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
+
+type tlsOptions struct {
+	enabled    bool
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+}
+
+func (o tlsOptions) isSet() bool {
+	return o.enabled || o.caFile != "" || o.certFile != "" || o.keyFile != "" || o.serverName != ""
+}
+
+func transportCredentials(opts tlsOptions) (credentials.TransportCredentials, error) {
+	if !opts.isSet() {
+		return insecure.NewCredentials(), nil
+	}
+
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: opts.serverName,
+	}
+
+	if opts.caFile != "" {
+		pool, err := tlsreload.LoadCertPool(opts.caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+
+	if opts.certFile != "" || opts.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(conf), nil
+}
+
 func main() {
 	if err := run(); err != nil {
 		if !errors.Is(err, errInvalidUsage) {
@@ -41,7 +109,19 @@ func main() {
 
 func run() error {
 	serverAddr := flag.String("server", getDefaultServerAddr(), "gRPC server address (env: ABF_SERVER_ADDR)")
+	managementAddr := flag.String("management-server", os.Getenv(managementServerAddrEnvKey),
+		"management gRPC server address, defaults to -server (env: ABF_MANAGEMENT_ADDR)")
+	token := flag.String("token", os.Getenv(tokenEnvKey), "management API bearer token (env: ABF_TOKEN)")
 	timeout := flag.Duration("timeout", defaultTimeout, "request timeout")
+	tenant := flag.String("tenant", os.Getenv(tenantEnvKey),
+		"tenant of check, whitelist, blacklist, reset and watch, empty for the default one (env: ABF_TENANT)")
+
+	var tlsOpts tlsOptions
+	flag.BoolVar(&tlsOpts.enabled, "tls", false, "use TLS with system root CAs (implied by the other TLS flags)")
+	flag.StringVar(&tlsOpts.caFile, "ca", os.Getenv(caFileEnvKey), "CA bundle to verify the server (env: ABF_CA_FILE)")
+	flag.StringVar(&tlsOpts.certFile, "cert", os.Getenv(certFileEnvKey), "client certificate (env: ABF_CERT_FILE)")
+	flag.StringVar(&tlsOpts.keyFile, "key", os.Getenv(keyFileEnvKey), "client private key (env: ABF_KEY_FILE)")
+	flag.StringVar(&tlsOpts.serverName, "server-name", "", "override the server name used to verify its certificate")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Anti-Bruteforce CLI\n\n")
@@ -49,10 +129,18 @@ func run() error {
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nEnvironment:\n")
-		fmt.Fprintf(os.Stderr, "  ABF_SERVER_ADDR    Server address (default: %s)\n", defaultServerAddr)
+		fmt.Fprintf(os.Stderr, "  ABF_SERVER_ADDR        Server address (default: %s)\n", defaultServerAddr)
+		fmt.Fprintf(os.Stderr, "  ABF_MANAGEMENT_ADDR    Management server address (default: server address)\n")
+		fmt.Fprintf(os.Stderr, "  ABF_TOKEN              Management API bearer token\n")
+		fmt.Fprintf(os.Stderr, "  ABF_TENANT             Tenant, empty for the default one\n")
+		fmt.Fprintf(os.Stderr, "  ABF_CA_FILE            CA bundle to verify the server\n")
+		fmt.Fprintf(os.Stderr, "  ABF_CERT_FILE          Client certificate for mTLS\n")
+		fmt.Fprintf(os.Stderr, "  ABF_KEY_FILE           Client private key for mTLS\n")
 		fmt.Fprintf(os.Stderr, "\nCommands:\n")
 		fmt.Fprintf(os.Stderr, "  ping                              Check server health\n")
-		fmt.Fprintf(os.Stderr, "  check <login> <password> <ip>     Check access for credentials\n")
+		fmt.Fprintf(os.Stderr, "  check <login> <password> <ip> [client-id]\n")
+		fmt.Fprintf(os.Stderr, "                                    Check access for credentials\n")
+		fmt.Fprintf(os.Stderr, "  challenge-passed <login> <ip>     Let login through soft limits from ip for a while\n")
 		fmt.Fprintf(os.Stderr, "  whitelist add <cidr>              Add subnet to whitelist\n")
 		fmt.Fprintf(os.Stderr, "  whitelist remove <cidr>           Remove subnet from whitelist\n")
 		fmt.Fprintf(os.Stderr, "  whitelist list                    List whitelist subnets\n")
@@ -61,12 +149,44 @@ func run() error {
 		fmt.Fprintf(os.Stderr, "  blacklist list                    List blacklist subnets\n")
 		fmt.Fprintf(os.Stderr, "  reset ip <ip>                     Reset rate limit bucket for IP\n")
 		fmt.Fprintf(os.Stderr, "  reset login <login>               Reset rate limit bucket for login\n")
+		fmt.Fprintf(os.Stderr, "  stuffing [-limit N]               List sources trying the most distinct logins\n")
+		fmt.Fprintf(os.Stderr, "  spray-alert get                   Show whether a spray alert tightens limits\n")
+		fmt.Fprintf(os.Stderr, "  spray-alert clear                 End the spray alert before it expires\n")
+		fmt.Fprintf(os.Stderr, "  limits get                        Show effective, configured and overridden limits\n")
+		fmt.Fprintf(os.Stderr, "  limits set [-login N] [-password N] [-ip N]\n")
+		fmt.Fprintf(os.Stderr, "                                    Override limits on all servers\n")
+		fmt.Fprintf(os.Stderr, "  limits reset                      Drop all overrides\n")
+		fmt.Fprintf(os.Stderr, "  limits history [-offset N] [-limit N]\n")
+		fmt.Fprintf(os.Stderr, "                                    List limit changes, newest first\n")
+		fmt.Fprintf(os.Stderr, "  limits revert <id>                Undo the limit change with this id\n")
+		fmt.Fprintf(os.Stderr, "  rules list                        List limit rules\n")
+		fmt.Fprintf(os.Stderr, "  rules set [-subnet CIDR] [-client ID] [-login N] [-password N] [-ip N]\n")
+		fmt.Fprintf(os.Stderr, "                                    Create or replace the limit rule for subnet and client\n")
+		fmt.Fprintf(os.Stderr, "  rules remove [-subnet CIDR] [-client ID]\n")
+		fmt.Fprintf(os.Stderr, "                                    Remove the limit rule for subnet and client\n")
+		fmt.Fprintf(os.Stderr, "  tenants list                      List tenants\n")
+		fmt.Fprintf(os.Stderr, "  tenants set <name> [-login N] [-password N] [-ip N]\n")
+		fmt.Fprintf(os.Stderr, "                                    Create a tenant or replace its limits\n")
+		fmt.Fprintf(os.Stderr, "  tenants delete <name>             Delete a tenant with its lists\n")
+		fmt.Fprintf(os.Stderr, "  honeypot list                     List honeypot logins\n")
+		fmt.Fprintf(os.Stderr, "  honeypot add <login>              Blacklist sources trying login from now on\n")
+		fmt.Fprintf(os.Stderr, "  honeypot remove <login>           Stop treating login as a honeypot\n")
+		fmt.Fprintf(os.Stderr, "  audit [filters]                   List management audit events (audit -h for filters)\n")
+		fmt.Fprintf(os.Stderr, "  watch [filters]                   Stream access decisions until interrupted (watch -h for filters)\n") //nolint:lll
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s ping\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s check admin password123 192.168.1.100\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s whitelist add 192.168.1.0/24\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -server localhost:8080 blacklist list\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s reset ip 192.168.1.100\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -token $ABF_TOKEN -management-server localhost:8081 whitelist list\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -ca ca.crt -cert admin.crt -key admin.key whitelist add 10.0.0.0/8\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s limits set -ip 100\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s rules set -subnet 203.0.113.0/28 -ip 5000\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s tenants set shop -ip 500\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -tenant shop blacklist add 198.51.100.0/24\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s audit -actor alice -since 24h\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s watch -denied -login admin\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -80,14 +200,40 @@ func run() error {
 	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
 	defer cancel()
 
-	conn, err := grpc.NewClient(*serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := transportCredentials(tlsOpts)
+	if err != nil {
+		return err
+	}
+
+	if *token != "" && !tlsOpts.isSet() {
+		fmt.Fprintln(os.Stderr, "warning: sending the token over a plaintext connection")
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+
+	conn, err := grpc.NewClient(*serverAddr, dialOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 	defer conn.Close()
 
+	if *managementAddr == "" {
+		*managementAddr = *serverAddr
+	}
+
+	mgmtDialOpts := dialOpts
+	if *token != "" {
+		mgmtDialOpts = append(mgmtDialOpts, grpc.WithPerRPCCredentials(bearerToken(*token)))
+	}
+
+	mgmtConn, err := grpc.NewClient(*managementAddr, mgmtDialOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to management server: %w", err)
+	}
+	defer mgmtConn.Close()
+
 	abfClient := pbAbf.NewAntiBruteforceClient(conn)
-	mgmtClient := pbMgmt.NewBruteforceManagementClient(conn)
+	mgmtClient := pbMgmt.NewBruteforceManagementClient(mgmtConn)
 
 	command := args[0]
 
@@ -95,25 +241,66 @@ func run() error {
 	case "ping":
 		return handlePing(ctx, abfClient)
 	case "check":
-		return handleCheck(ctx, abfClient, args[1:])
+		return handleCheck(ctx, abfClient, *tenant, args[1:])
+	case "challenge-passed":
+		return handleChallengePassed(ctx, abfClient, *tenant, args[1:])
 	case "whitelist":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "usage: whitelist <add|remove|list> [args]")
 			return errInvalidUsage
 		}
-		return handleWhitelist(ctx, mgmtClient, args[1], args[2:])
+		return handleWhitelist(ctx, mgmtClient, *tenant, args[1], args[2:])
 	case "blacklist":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "usage: blacklist <add|remove|list> [args]")
 			return errInvalidUsage
 		}
-		return handleBlacklist(ctx, mgmtClient, args[1], args[2:])
+		return handleBlacklist(ctx, mgmtClient, *tenant, args[1], args[2:])
 	case "reset":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "usage: reset <ip|login> <value>")
 			return errInvalidUsage
 		}
-		return handleReset(ctx, mgmtClient, args[1], args[2:])
+		return handleReset(ctx, mgmtClient, *tenant, args[1], args[2:])
+	case "stuffing":
+		return handleStuffing(ctx, mgmtClient, *tenant, args[1:])
+	case "spray-alert":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: spray-alert <get|clear>")
+			return errInvalidUsage
+		}
+		return handleSprayAlert(ctx, mgmtClient, args[1])
+	case "limits":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: limits <get|set|reset|history|revert> [args]")
+			return errInvalidUsage
+		}
+		return handleLimits(ctx, mgmtClient, args[1], args[2:])
+	case "rules":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: rules <list|set|remove> [args]")
+			return errInvalidUsage
+		}
+		return handleRules(ctx, mgmtClient, args[1], args[2:])
+	case "tenants":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: tenants <list|set|delete> [args]")
+			return errInvalidUsage
+		}
+		return handleTenants(ctx, mgmtClient, args[1], args[2:])
+	case "honeypot":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: honeypot <list|add|remove> [login]")
+			return errInvalidUsage
+		}
+		return handleHoneypot(ctx, mgmtClient, args[1], args[2:])
+	case "audit":
+		return handleAudit(ctx, mgmtClient, args[1:])
+	case "watch":
+		// the stream is open-ended, so it is not bound to -timeout
+		watchCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return handleWatch(watchCtx, mgmtClient, *tenant, args[1:])
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", command)
 		flag.Usage()
@@ -132,9 +319,9 @@ func handlePing(ctx context.Context, client pbAbf.AntiBruteforceClient) error {
 	return nil
 }
 
-func handleCheck(ctx context.Context, client pbAbf.AntiBruteforceClient, args []string) error {
+func handleCheck(ctx context.Context, client pbAbf.AntiBruteforceClient, tenant string, args []string) error {
 	if len(args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: check <login> <password> <ip>")
+		fmt.Fprintln(os.Stderr, "usage: check <login> <password> <ip> [client-id]")
 		return errInvalidUsage
 	}
 
@@ -142,21 +329,85 @@ func handleCheck(ctx context.Context, client pbAbf.AntiBruteforceClient, args []
 	password := args[1]
 	ip := args[2]
 
+	var clientID string
+	if len(args) > 3 {
+		clientID = args[3]
+	}
+
 	resp, err := client.CheckAccess(ctx, &pbAbf.CheckAccessRequest{
 		Login:    login,
 		Password: password,
 		Ip:       ip,
+		ClientId: clientID,
+		Tenant:   tenant,
 	})
 	if err != nil {
 		return fmt.Errorf("check access failed: %w", err)
 	}
 
-	if resp.Allowed {
+	switch {
+	case resp.Allowed:
 		fmt.Println("Access: ALLOWED")
-	} else {
+	case resp.Decision == pbAbf.Decision_DECISION_CHALLENGE:
+		fmt.Printf("Access: CHALLENGE (%s)\n", formatDeniedReason(resp.Reason))
+	default:
 		fmt.Printf("Access: DENIED (%s)\n", formatDeniedReason(resp.Reason))
 	}
 
+	if resp.PasswordBreached {
+		fmt.Println("Password: BREACHED")
+	}
+
+	if resp.RetryAfter != nil {
+		fmt.Printf("Retry after: %s\n", resp.RetryAfter.AsDuration())
+	}
+
+	if resp.IpQuota != nil {
+		fmt.Println("Quota:")
+		printQuota("ip", resp.IpQuota)
+		printQuota("login", resp.LoginQuota)
+		printQuota("password", resp.PasswordQuota)
+	}
+
+	if len(resp.RiskSignals) > 0 {
+		fmt.Printf("Risk: %d/100\n", resp.RiskScore)
+		for _, signal := range resp.RiskSignals {
+			fmt.Printf("  %-20s %5.2f  +%d\n", signal.Name, signal.Value, signal.Points)
+		}
+	}
+
+	if resp.Degraded {
+		fmt.Println("Warning: a backend is down, the server answered by its degradation policy")
+	}
+
+	return nil
+}
+
+func printQuota(dimension string, quota *pbAbf.Quota) {
+	fmt.Printf("  %-10s %d of %d left", dimension, quota.Remaining, quota.Limit)
+	if quota.RetryAfter != nil {
+		fmt.Printf(", refills in %s", quota.RetryAfter.AsDuration())
+	}
+	fmt.Println()
+}
+
+func handleChallengePassed(ctx context.Context, client pbAbf.AntiBruteforceClient, tenant string, args []string) error {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: challenge-passed <login> <ip>")
+		return errInvalidUsage
+	}
+
+	_, err := client.ReportChallengePassed(ctx, &pbAbf.ReportChallengePassedRequest{
+		Login:  args[0],
+		Ip:     args[1],
+		Tenant: tenant,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to report passed challenge: %w", err)
+	}
+
+	fmt.Printf("Challenge passed: %s from %s\n", args[0], args[1])
+
 	return nil
 }
 
@@ -172,6 +423,14 @@ func formatDeniedReason(reason pbAbf.AccessDeniedReason) string {
 		return "too many requests for login"
 	case pbAbf.AccessDeniedReason_ACCESS_DENIED_REASON_TOO_MANY_REQUESTS_PASSWORD:
 		return "too many requests for password"
+	case pbAbf.AccessDeniedReason_ACCESS_DENIED_REASON_BACKEND_UNAVAILABLE:
+		return "backend unavailable"
+	case pbAbf.AccessDeniedReason_ACCESS_DENIED_REASON_CREDENTIAL_STUFFING:
+		return "too many distinct logins from the source"
+	case pbAbf.AccessDeniedReason_ACCESS_DENIED_REASON_BREACHED_PASSWORD:
+		return "password is breached"
+	case pbAbf.AccessDeniedReason_ACCESS_DENIED_REASON_HONEYPOT_LOGIN:
+		return "login is a honeypot, IP has been blacklisted"
 	case pbAbf.AccessDeniedReason_ACCESS_DENIED_REASON_UNSPECIFIED:
 		return "unspecified reason"
 	default:
@@ -179,8 +438,13 @@ func formatDeniedReason(reason pbAbf.AccessDeniedReason) string {
 	}
 }
 
-//nolint:dupl,lll
-func handleWhitelist(ctx context.Context, client pbMgmt.BruteforceManagementClient, subcommand string, args []string) error {
+//nolint:dupl
+func handleWhitelist(
+	ctx context.Context,
+	client pbMgmt.BruteforceManagementClient,
+	tenant, subcommand string,
+	args []string,
+) error {
 	switch subcommand {
 	case "add":
 		if len(args) < 1 {
@@ -190,6 +454,7 @@ func handleWhitelist(ctx context.Context, client pbMgmt.BruteforceManagementClie
 
 		_, err := client.AddIPToWhiteList(ctx, &pbMgmt.SubnetRequest{
 			Subnet: &pbMgmt.Subnet{Cidr: args[0]},
+			Tenant: tenant,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to add to whitelist: %w", err)
@@ -205,6 +470,7 @@ func handleWhitelist(ctx context.Context, client pbMgmt.BruteforceManagementClie
 
 		_, err := client.RemoveIPFromWhiteList(ctx, &pbMgmt.SubnetRequest{
 			Subnet: &pbMgmt.Subnet{Cidr: args[0]},
+			Tenant: tenant,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to remove from whitelist: %w", err)
@@ -215,6 +481,7 @@ func handleWhitelist(ctx context.Context, client pbMgmt.BruteforceManagementClie
 	case "list":
 		resp, err := client.ListIPAddressWhiteList(ctx, &pbMgmt.ListSubnetsRequest{
 			Pagination: &pbMgmt.Pagination{Offset: 0, Limit: 1000},
+			Tenant:     tenant,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to list whitelist: %w", err)
@@ -238,8 +505,13 @@ func handleWhitelist(ctx context.Context, client pbMgmt.BruteforceManagementClie
 	return nil
 }
 
-//nolint:dupl,lll
-func handleBlacklist(ctx context.Context, client pbMgmt.BruteforceManagementClient, subcommand string, args []string) error {
+//nolint:dupl
+func handleBlacklist(
+	ctx context.Context,
+	client pbMgmt.BruteforceManagementClient,
+	tenant, subcommand string,
+	args []string,
+) error {
 	switch subcommand {
 	case "add":
 		if len(args) < 1 {
@@ -249,6 +521,7 @@ func handleBlacklist(ctx context.Context, client pbMgmt.BruteforceManagementClie
 
 		_, err := client.AddIPToBlackList(ctx, &pbMgmt.SubnetRequest{
 			Subnet: &pbMgmt.Subnet{Cidr: args[0]},
+			Tenant: tenant,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to add to blacklist: %w", err)
@@ -264,6 +537,7 @@ func handleBlacklist(ctx context.Context, client pbMgmt.BruteforceManagementClie
 
 		_, err := client.RemoveIPFromBlackList(ctx, &pbMgmt.SubnetRequest{
 			Subnet: &pbMgmt.Subnet{Cidr: args[0]},
+			Tenant: tenant,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to remove from blacklist: %w", err)
@@ -274,6 +548,7 @@ func handleBlacklist(ctx context.Context, client pbMgmt.BruteforceManagementClie
 	case "list":
 		resp, err := client.ListIPAddressBlackList(ctx, &pbMgmt.ListSubnetsRequest{
 			Pagination: &pbMgmt.Pagination{Offset: 0, Limit: 1000},
+			Tenant:     tenant,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to list blacklist: %w", err)
@@ -298,7 +573,7 @@ func handleBlacklist(ctx context.Context, client pbMgmt.BruteforceManagementClie
 }
 
 //nolint:lll
-func handleReset(ctx context.Context, client pbMgmt.BruteforceManagementClient, subcommand string, args []string) error {
+func handleReset(ctx context.Context, client pbMgmt.BruteforceManagementClient, tenant, subcommand string, args []string) error {
 	if len(args) < 1 {
 		fmt.Fprintf(os.Stderr, "usage: reset %s <value>\n", subcommand)
 		return errInvalidUsage
@@ -306,7 +581,7 @@ func handleReset(ctx context.Context, client pbMgmt.BruteforceManagementClient,
 
 	switch subcommand {
 	case "ip":
-		resp, err := client.ResetBucketByIP(ctx, &pbMgmt.ResetBucketByIPRequest{Ip: args[0]})
+		resp, err := client.ResetBucketByIP(ctx, &pbMgmt.ResetBucketByIPRequest{Ip: args[0], Tenant: tenant})
 		if err != nil {
 			return fmt.Errorf("failed to reset IP bucket: %w", err)
 		}
@@ -318,7 +593,7 @@ func handleReset(ctx context.Context, client pbMgmt.BruteforceManagementClient,
 		}
 
 	case "login":
-		resp, err := client.ResetBucketByLogin(ctx, &pbMgmt.ResetBucketByLoginRequest{Login: args[0]})
+		resp, err := client.ResetBucketByLogin(ctx, &pbMgmt.ResetBucketByLoginRequest{Login: args[0], Tenant: tenant})
 		if err != nil {
 			return fmt.Errorf("failed to reset login bucket: %w", err)
 		}
@@ -338,3 +613,507 @@ func handleReset(ctx context.Context, client pbMgmt.BruteforceManagementClient,
 
 	return nil
 }
+
+func handleStuffing(ctx context.Context, client pbMgmt.BruteforceManagementClient, tenant string, args []string) error {
+	flags := flag.NewFlagSet("stuffing", flag.ContinueOnError)
+	limit := flags.Uint64("limit", 20, "maximum number of sources")
+
+	if err := flags.Parse(args); err != nil {
+		return errInvalidUsage
+	}
+
+	resp, err := client.ListStuffingSources(ctx, &pbMgmt.ListStuffingSourcesRequest{Tenant: tenant, Limit: *limit})
+	if err != nil {
+		return fmt.Errorf("failed to list stuffing sources: %w", err)
+	}
+
+	if len(resp.Sources) == 0 {
+		fmt.Println("No sources")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tSOURCE\tDISTINCT LOGINS")
+	for _, source := range resp.Sources {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", source.Kind, source.Source, source.DistinctLogins)
+	}
+	return w.Flush()
+}
+
+func handleSprayAlert(ctx context.Context, client pbMgmt.BruteforceManagementClient, subcommand string) error {
+	switch subcommand {
+	case "get":
+		alert, err := client.GetSprayAlert(ctx, &emptypb.Empty{})
+		if err != nil {
+			return fmt.Errorf("failed to get spray alert: %w", err)
+		}
+
+		if !alert.Active {
+			fmt.Println("No spray alert")
+			return nil
+		}
+
+		raisedBy := alert.Tenant
+		if raisedBy == "" {
+			raisedBy = "default tenant"
+		}
+		fmt.Printf("Spray alert raised by %s at %s, expires at %s\n", raisedBy,
+			alert.RaisedAt.AsTime().Local().Format(time.DateTime),
+			alert.ExpiresAt.AsTime().Local().Format(time.DateTime))
+
+	case "clear":
+		resp, err := client.ClearSprayAlert(ctx, &emptypb.Empty{})
+		if err != nil {
+			return fmt.Errorf("failed to clear spray alert: %w", err)
+		}
+
+		if resp.WasActive {
+			fmt.Println("Cleared spray alert")
+		} else {
+			fmt.Println("No spray alert")
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown spray-alert subcommand: %s\n", subcommand)
+		fmt.Fprintln(os.Stderr, "available: get, clear")
+
+		return errInvalidUsage
+	}
+
+	return nil
+}
+
+//nolint:lll
+func handleLimits(ctx context.Context, client pbMgmt.BruteforceManagementClient, subcommand string, args []string) error {
+	var resp *pbMgmt.LimitsResponse
+	var err error
+
+	switch subcommand {
+	case "get":
+		resp, err = client.GetLimits(ctx, &emptypb.Empty{})
+
+	case "set":
+		flags := flag.NewFlagSet("limits set", flag.ContinueOnError)
+		login := flags.Int64("login", 0, "attempts per login, 0 keeps the current value")
+		password := flags.Int64("password", 0, "attempts per password, 0 keeps the current value")
+		ip := flags.Int64("ip", 0, "attempts per IP, 0 keeps the current value")
+
+		if err := flags.Parse(args); err != nil {
+			return errInvalidUsage
+		}
+
+		resp, err = client.UpdateLimits(ctx, &pbMgmt.UpdateLimitsRequest{
+			Limits: &pbMgmt.Limits{Login: *login, Password: *password, Ip: *ip},
+		})
+
+	case "reset":
+		resp, err = client.UpdateLimits(ctx, &pbMgmt.UpdateLimitsRequest{ResetOverrides: true})
+
+	case "history":
+		return handleLimitsHistory(ctx, client, args)
+
+	case "revert":
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: limits revert <id>")
+			return errInvalidUsage
+		}
+
+		id, parseErr := strconv.ParseInt(args[0], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid change id %q", args[0])
+		}
+
+		resp, err = client.RevertLimits(ctx, &pbMgmt.RevertLimitsRequest{Id: id})
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown limits subcommand: %s\n", subcommand)
+		return errInvalidUsage
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s limits: %w", subcommand, err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LIMIT\tEFFECTIVE\tCONFIGURED\tOVERRIDE")
+	rows := []struct {
+		name                            string
+		effective, configured, override int64
+	}{
+		{"login", resp.Effective.GetLogin(), resp.Configured.GetLogin(), resp.Overrides.GetLogin()},
+		{"password", resp.Effective.GetPassword(), resp.Configured.GetPassword(), resp.Overrides.GetPassword()},
+		{"ip", resp.Effective.GetIp(), resp.Configured.GetIp(), resp.Overrides.GetIp()},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", row.name, row.effective, row.configured, formatOverride(row.override))
+	}
+
+	return w.Flush()
+}
+
+func handleLimitsHistory(ctx context.Context, client pbMgmt.BruteforceManagementClient, args []string) error {
+	flags := flag.NewFlagSet("limits history", flag.ContinueOnError)
+	offset := flags.Uint64("offset", 0, "number of newest changes to skip")
+	limit := flags.Uint64("limit", 100, "maximum number of changes")
+
+	if err := flags.Parse(args); err != nil {
+		return errInvalidUsage
+	}
+
+	resp, err := client.ListLimitsHistory(ctx, &pbMgmt.ListLimitsHistoryRequest{
+		Pagination: &pbMgmt.Pagination{Offset: *offset, Limit: *limit},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list limits history: %w", err)
+	}
+
+	if len(resp.Changes) == 0 {
+		fmt.Println("Limits were never overridden")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tACTOR\tBEFORE\tAFTER")
+	for _, change := range resp.Changes {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			change.Id, change.Time.AsTime().Local().Format(time.DateTime), change.Actor,
+			formatOverrides(change.Before), formatOverrides(change.After))
+	}
+
+	return w.Flush()
+}
+
+func formatOverride(value int64) string {
+	if value == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+func formatOverrides(limits *pbMgmt.Limits) string {
+	var parts []string
+	if limits.GetLogin() != 0 {
+		parts = append(parts, fmt.Sprintf("login=%d", limits.GetLogin()))
+	}
+	if limits.GetPassword() != 0 {
+		parts = append(parts, fmt.Sprintf("password=%d", limits.GetPassword()))
+	}
+	if limits.GetIp() != 0 {
+		parts = append(parts, fmt.Sprintf("ip=%d", limits.GetIp()))
+	}
+	return orDash(strings.Join(parts, " "))
+}
+
+//nolint:lll
+func handleRules(ctx context.Context, client pbMgmt.BruteforceManagementClient, subcommand string, args []string) error {
+	switch subcommand {
+	case "list":
+		resp, err := client.ListLimitRules(ctx, &emptypb.Empty{})
+		if err != nil {
+			return fmt.Errorf("failed to list limit rules: %w", err)
+		}
+
+		if len(resp.Rules) == 0 {
+			fmt.Println("No limit rules")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SUBNET\tCLIENT\tLIMITS")
+		for _, rule := range resp.Rules {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", orDash(rule.Subnet), orDash(rule.ClientId), formatOverrides(rule.Limits))
+		}
+		return w.Flush()
+
+	case "set":
+		flags := flag.NewFlagSet("rules set", flag.ContinueOnError)
+		subnet := flags.String("subnet", "", "source subnet the rule applies to")
+		clientID := flags.String("client", "", "client id the rule applies to")
+		login := flags.Int64("login", 0, "attempts per login, 0 keeps the global limit")
+		password := flags.Int64("password", 0, "attempts per password, 0 keeps the global limit")
+		ip := flags.Int64("ip", 0, "attempts per IP, 0 keeps the global limit")
+
+		if err := flags.Parse(args); err != nil {
+			return errInvalidUsage
+		}
+
+		rule, err := client.SetLimitRule(ctx, &pbMgmt.SetLimitRuleRequest{Rule: &pbMgmt.LimitRule{
+			Subnet:   *subnet,
+			ClientId: *clientID,
+			Limits:   &pbMgmt.Limits{Login: *login, Password: *password, Ip: *ip},
+		}})
+		if err != nil {
+			return fmt.Errorf("failed to set limit rule: %w", err)
+		}
+
+		fmt.Printf("Set limit rule for subnet %s and client %s: %s\n",
+			orDash(rule.Subnet), orDash(rule.ClientId), formatOverrides(rule.Limits))
+		return nil
+
+	case "remove":
+		flags := flag.NewFlagSet("rules remove", flag.ContinueOnError)
+		subnet := flags.String("subnet", "", "source subnet of the rule")
+		clientID := flags.String("client", "", "client id of the rule")
+
+		if err := flags.Parse(args); err != nil {
+			return errInvalidUsage
+		}
+
+		_, err := client.RemoveLimitRule(ctx, &pbMgmt.RemoveLimitRuleRequest{Subnet: *subnet, ClientId: *clientID})
+		if err != nil {
+			return fmt.Errorf("failed to remove limit rule: %w", err)
+		}
+
+		fmt.Println("Removed limit rule")
+		return nil
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown rules subcommand: %s\n", subcommand)
+		return errInvalidUsage
+	}
+}
+
+//nolint:lll
+func handleTenants(ctx context.Context, client pbMgmt.BruteforceManagementClient, subcommand string, args []string) error {
+	switch subcommand {
+	case "list":
+		resp, err := client.ListTenants(ctx, &emptypb.Empty{})
+		if err != nil {
+			return fmt.Errorf("failed to list tenants: %w", err)
+		}
+
+		if len(resp.Tenants) == 0 {
+			fmt.Println("No tenants")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TENANT\tLIMITS")
+		for _, tenant := range resp.Tenants {
+			fmt.Fprintf(w, "%s\t%s\n", tenant.Name, formatOverrides(tenant.Limits))
+		}
+		return w.Flush()
+
+	case "set":
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: tenants set <name> [-login N] [-password N] [-ip N]")
+			return errInvalidUsage
+		}
+
+		flags := flag.NewFlagSet("tenants set", flag.ContinueOnError)
+		login := flags.Int64("login", 0, "attempts per login, 0 keeps the global limit")
+		password := flags.Int64("password", 0, "attempts per password, 0 keeps the global limit")
+		ip := flags.Int64("ip", 0, "attempts per IP, 0 keeps the global limit")
+
+		if err := flags.Parse(args[1:]); err != nil {
+			return errInvalidUsage
+		}
+
+		tenant, err := client.SetTenant(ctx, &pbMgmt.SetTenantRequest{Tenant: &pbMgmt.Tenant{
+			Name:   args[0],
+			Limits: &pbMgmt.Limits{Login: *login, Password: *password, Ip: *ip},
+		}})
+		if err != nil {
+			return fmt.Errorf("failed to set tenant: %w", err)
+		}
+
+		fmt.Printf("Set tenant %s: %s\n", tenant.Name, formatOverrides(tenant.Limits))
+		return nil
+
+	case "delete":
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: tenants delete <name>")
+			return errInvalidUsage
+		}
+
+		if _, err := client.DeleteTenant(ctx, &pbMgmt.DeleteTenantRequest{Name: args[0]}); err != nil {
+			return fmt.Errorf("failed to delete tenant: %w", err)
+		}
+
+		fmt.Printf("Deleted tenant %s\n", args[0])
+		return nil
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown tenants subcommand: %s\n", subcommand)
+		return errInvalidUsage
+	}
+}
+
+//nolint:lll
+func handleHoneypot(ctx context.Context, client pbMgmt.BruteforceManagementClient, subcommand string, args []string) error {
+	switch subcommand {
+	case "list":
+		resp, err := client.ListHoneypotLogins(ctx, &emptypb.Empty{})
+		if err != nil {
+			return fmt.Errorf("failed to list honeypot logins: %w", err)
+		}
+
+		if len(resp.Logins) == 0 {
+			fmt.Println("No honeypot logins")
+			return nil
+		}
+
+		for _, login := range resp.Logins {
+			fmt.Println(login)
+		}
+		return nil
+
+	case "add":
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: honeypot add <login>")
+			return errInvalidUsage
+		}
+
+		if _, err := client.AddHoneypotLogin(ctx, &pbMgmt.HoneypotLoginRequest{Login: args[0]}); err != nil {
+			return fmt.Errorf("failed to add honeypot login: %w", err)
+		}
+
+		fmt.Printf("Added honeypot login %s\n", args[0])
+		return nil
+
+	case "remove":
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: honeypot remove <login>")
+			return errInvalidUsage
+		}
+
+		if _, err := client.RemoveHoneypotLogin(ctx, &pbMgmt.HoneypotLoginRequest{Login: args[0]}); err != nil {
+			return fmt.Errorf("failed to remove honeypot login: %w", err)
+		}
+
+		fmt.Printf("Removed honeypot login %s\n", args[0])
+		return nil
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown honeypot subcommand: %s\n", subcommand)
+		return errInvalidUsage
+	}
+}
+
+// parseTime accepts either an RFC 3339 timestamp or a duration meaning "that long ago".
+func parseTime(value string) (*timestamppb.Timestamp, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return timestamppb.New(time.Now().Add(-d)), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q, want RFC 3339 or a duration like 24h", value)
+	}
+
+	return timestamppb.New(t), nil
+}
+
+func handleAudit(ctx context.Context, client pbMgmt.BruteforceManagementClient, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	actor := flags.String("actor", "", "only events of this actor")
+	rpc := flags.String("rpc", "", "only events of this RPC, e.g. AddIPToWhiteList")
+	result := flags.String("result", "", "only events with this result: ok or error")
+	since := flags.String("since", "", "only events after this time (RFC 3339 or a duration like 24h)")
+	until := flags.String("until", "", "only events before this time (RFC 3339 or a duration like 1h)")
+	offset := flags.Uint64("offset", 0, "number of newest events to skip")
+	limit := flags.Uint64("limit", 100, "maximum number of events")
+
+	if err := flags.Parse(args); err != nil {
+		return errInvalidUsage
+	}
+
+	sinceTS, err := parseTime(*since)
+	if err != nil {
+		return err
+	}
+
+	untilTS, err := parseTime(*until)
+	if err != nil {
+		return err
+	}
+
+	rpcName := *rpc
+	if rpcName != "" && !strings.HasPrefix(rpcName, "/") {
+		rpcName = "/" + pbMgmt.BruteforceManagement_ServiceDesc.ServiceName + "/" + rpcName
+	}
+
+	resp, err := client.ListAuditEvents(ctx, &pbMgmt.ListAuditEventsRequest{
+		Pagination: &pbMgmt.Pagination{Offset: *offset, Limit: *limit},
+		Actor:      *actor,
+		Rpc:        rpcName,
+		Result:     *result,
+		Since:      sinceTS,
+		Until:      untilTS,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	if len(resp.Events) == 0 {
+		fmt.Println("No audit events")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTOR\tRPC\tARGUMENTS\tBEFORE\tAFTER\tRESULT")
+	for _, event := range resp.Events {
+		outcome := event.Result
+		if event.Error != "" {
+			outcome += ": " + event.Error
+		}
+
+		rpcShort := event.Rpc[strings.LastIndex(event.Rpc, "/")+1:]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			event.Time.AsTime().Local().Format(time.DateTime), event.Actor, rpcShort,
+			event.ArgumentsJson, orDash(event.BeforeJson), orDash(event.AfterJson), outcome)
+	}
+
+	return w.Flush()
+}
+
+func handleWatch(ctx context.Context, client pbMgmt.BruteforceManagementClient, tenant string, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	deniedOnly := flags.Bool("denied", false, "only denied and challenged attempts")
+	ip := flags.String("ip", "", "only attempts from this IP")
+	login := flags.String("login", "", "only attempts for this login")
+
+	if err := flags.Parse(args); err != nil {
+		return errInvalidUsage
+	}
+
+	stream, err := client.WatchDecisions(ctx, &pbMgmt.WatchDecisionsRequest{
+		DeniedOnly: *deniedOnly,
+		Ip:         *ip,
+		Login:      *login,
+		Tenant:     tenant,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch decisions: %w", err)
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to receive decision: %w", err)
+		}
+
+		// servers without challenges do not send the decision
+		verdict := event.Decision
+		if verdict == "" && event.Allowed {
+			verdict = "allowed"
+		} else if verdict == "" {
+			verdict = "denied"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s\n", event.Time.AsTime().Local().Format(time.DateTime),
+			orDash(event.Tenant), event.Ip, event.Login, verdict, event.Reason, orDash(event.Severity))
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
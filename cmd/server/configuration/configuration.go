@@ -2,10 +2,17 @@ package configuration
 
 import (
 	"log/slog"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/FluVirus2/antibruteforce/internal/events"
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/FluVirus2/antibruteforce/internal/tracing"
+	"github.com/FluVirus2/antibruteforce/pkg/breaker"
 	"github.com/FluVirus2/antibruteforce/pkg/configuration"
 )
 
@@ -17,15 +24,139 @@ const (
 	LoginRateLimitKey        = "ABF_LOGIN_RATE_LIMIT"
 	PasswordRateLimitKey     = "ABF_PASSWORD_RATE_LIMIT"
 	IPRateLimitKey           = "ABF_IP_RATE_LIMIT"
+	AuthConfigFileKey        = "ABF_AUTH_CONFIG_FILE"
+	ManagementPortKey        = "ABF_MANAGEMENT_PORT"
+	ManagementInsecureKey    = "ABF_MANAGEMENT_INSECURE"
+	TLSCertFileKey           = "ABF_TLS_CERT_FILE"
+	TLSKeyFileKey            = "ABF_TLS_KEY_FILE"
+	TLSClientCAFileKey       = "ABF_TLS_CLIENT_CA_FILE"
+	TLSRequireClientCertKey  = "ABF_TLS_REQUIRE_CLIENT_CERT"
+	MetricsPortKey           = "ABF_METRICS_PORT"
+	TracingExporterKey       = "ABF_TRACING_EXPORTER"
+	TracingOTLPEndpointKey   = "ABF_TRACING_OTLP_ENDPOINT"
+	TracingOTLPInsecureKey   = "ABF_TRACING_OTLP_INSECURE"
+	TracingSampleRatioKey    = "ABF_TRACING_SAMPLE_RATIO"
+	EventsFileKey            = "ABF_EVENTS_FILE"
+	EventsSyslogAddrKey      = "ABF_EVENTS_SYSLOG_ADDR"
+	EventsWebhookURLKey      = "ABF_EVENTS_WEBHOOK_URL"
+	EventsBufferSizeKey      = "ABF_EVENTS_BUFFER_SIZE"
+	EventsIncludeAllowedKey  = "ABF_EVENTS_INCLUDE_ALLOWED"
+	PostgresFailurePolicyKey = "ABF_POSTGRES_FAILURE_POLICY"
+	RedisFailurePolicyKey    = "ABF_REDIS_FAILURE_POLICY"
+	PostgresTimeoutKey       = "ABF_POSTGRES_TIMEOUT"
+	RedisTimeoutKey          = "ABF_REDIS_TIMEOUT"
+	BreakerFailuresKey       = "ABF_BREAKER_FAILURE_THRESHOLD"
+	BreakerOpenTimeoutKey    = "ABF_BREAKER_OPEN_TIMEOUT"
+	RedisModeKey             = "ABF_REDIS_MODE"
+	RedisAddrsKey            = "ABF_REDIS_ADDRS"
+	RedisSentinelMasterKey   = "ABF_REDIS_SENTINEL_MASTER"
+	RedisSentinelPasswordKey = "ABF_REDIS_SENTINEL_PASSWORD"
+	AutoMigrateKey           = "ABF_AUTO_MIGRATE"
+	RateLimitWindowKey       = "ABF_RATE_LIMIT_WINDOW"
 )
 
+// Shadow policy keys, see readShadowConfiguration.
+const (
+	ShadowLoginRateLimitKey    = "ABF_SHADOW_LOGIN_RATE_LIMIT"
+	ShadowPasswordRateLimitKey = "ABF_SHADOW_PASSWORD_RATE_LIMIT"
+	ShadowIPRateLimitKey       = "ABF_SHADOW_IP_RATE_LIMIT"
+	ShadowWhitelistKey         = "ABF_SHADOW_WHITELIST"
+	ShadowBlacklistKey         = "ABF_SHADOW_BLACKLIST"
+)
+
+// Challenge policy keys, see readChallengeConfiguration.
+const (
+	ChallengeLoginLimitKey    = "ABF_CHALLENGE_LOGIN_LIMIT"
+	ChallengePasswordLimitKey = "ABF_CHALLENGE_PASSWORD_LIMIT"
+	ChallengeIPLimitKey       = "ABF_CHALLENGE_IP_LIMIT"
+	ChallengeAllowanceKey     = "ABF_CHALLENGE_ALLOWANCE"
+
+	DefaultChallengeAllowance = 15 * time.Minute
+)
+
+// Credential stuffing policy keys, see readStuffingConfiguration.
+const (
+	StuffingIPLimitKey     = "ABF_STUFFING_IP_LIMIT"
+	StuffingSubnetLimitKey = "ABF_STUFFING_SUBNET_LIMIT"
+	StuffingWindowKey      = "ABF_STUFFING_WINDOW"
+
+	DefaultStuffingWindow = 10 * time.Minute
+)
+
+// Password spraying policy keys, see readSprayConfiguration.
+const (
+	SprayLoginLimitKey        = "ABF_SPRAY_LOGIN_LIMIT"
+	SprayWindowKey            = "ABF_SPRAY_WINDOW"
+	SprayAlertDurationKey     = "ABF_SPRAY_ALERT_DURATION"
+	SprayAlertLimitPercentKey = "ABF_SPRAY_ALERT_LIMIT_PERCENT"
+	SprayHMACKeyKey           = "ABF_SPRAY_HMAC_KEY"
+
+	DefaultSprayWindow            = time.Hour
+	DefaultSprayAlertDuration     = time.Hour
+	DefaultSprayAlertLimitPercent = int64(50)
+	// MinSprayHMACKeyLength is the shortest HMAC key accepted, in bytes.
+	MinSprayHMACKeyLength = 16
+)
+
+// Breached password corpus keys, see readBreachedConfiguration.
+const (
+	BreachedCorpusFileKey = "ABF_BREACHED_CORPUS_FILE"
+	BreachedIndexFileKey  = "ABF_BREACHED_INDEX_FILE"
+	BreachedActionKey     = "ABF_BREACHED_ACTION"
+
+	DefaultBreachedAction = antibruteforce.BreachedActionFlag
+	// breachedIndexSuffix names the default index file after the corpus.
+	breachedIndexSuffix = ".idx"
+)
+
+// HoneypotBlacklistTTLKey is how long a source trying a honeypot login stays blacklisted,
+// the logins themselves are managed through the management API.
+const HoneypotBlacklistTTLKey = "ABF_HONEYPOT_BLACKLIST_TTL"
+
+// Risk weight keys, see readRiskWeights.
+const (
+	RiskIPFillWeightKey        = "ABF_RISK_IP_FILL_WEIGHT"
+	RiskLoginFillWeightKey     = "ABF_RISK_LOGIN_FILL_WEIGHT"
+	RiskPasswordFillWeightKey  = "ABF_RISK_PASSWORD_FILL_WEIGHT"
+	RiskViolationsWeightKey    = "ABF_RISK_VIOLATIONS_WEIGHT"
+	RiskBlacklistNearWeightKey = "ABF_RISK_BLACKLIST_NEAR_WEIGHT"
+	RiskLockoutsWeightKey      = "ABF_RISK_LOCKOUTS_WEIGHT"
+)
+
+// DefaultRiskWeights add up to 100, so a weight is the most points its signal can add.
+var DefaultRiskWeights = antibruteforce.RiskWeights{
+	IPFill:        15,
+	LoginFill:     25,
+	PasswordFill:  10,
+	Violations:    20,
+	BlacklistNear: 10,
+	Lockouts:      20,
+}
+
 const (
 	DefaultPort              = 80
 	DefaultLoginRateLimit    = int64(10)
 	DefaultPasswordRateLimit = int64(100)
 	DefaultIPRateLimit       = int64(1000)
+	DefaultMetricsPort       = 9090
+	DefaultTracingExporter   = tracing.ExporterNone
+	DefaultSampleRatio       = 1.0
+	DefaultEventsBufferSize  = events.DefaultBufferSize
+	DefaultFailurePolicy     = antibruteforce.FailurePolicyError
+	DefaultPostgresTimeout   = 2 * time.Second
+	DefaultRedisTimeout      = 500 * time.Millisecond
+	DefaultBreakerFailures   = breaker.DefaultFailureThreshold
+	DefaultBreakerOpenTime   = breaker.DefaultOpenTimeout
+	DefaultRedisMode         = storage.RedisModeSingle
+	DefaultRateLimitWindow   = time.Minute
 )
 
+var tracingExporters = map[string]struct{}{
+	tracing.ExporterNone:   {},
+	tracing.ExporterStdout: {},
+	tracing.ExporterOTLP:   {},
+}
+
 var strToLevel = map[string]slog.Level{
 	"error":   slog.LevelError,
 	"warning": slog.LevelWarn,
@@ -42,22 +173,140 @@ type Configuration struct {
 	LoginRateLimit        int64
 	PasswordRateLimit     int64
 	IPRateLimit           int64
+	RateLimitWindow       time.Duration
+	AuthConfigFile        string
+	// ManagementPort is 0 when management is served on the same listener as CheckAccess.
+	ManagementPort int
+	// ManagementInsecure lets the server start without AuthConfigFile, management calls are then not authenticated.
+	ManagementInsecure bool
+	// TLS is nil when the server accepts plaintext connections.
+	TLS *TLSConfiguration
+	// MetricsPort serves /metrics, /livez and /readyz over HTTP, 0 disables it.
+	MetricsPort int
+	Tracing     TracingConfiguration
+	Events      EventsConfiguration
+	Degradation DegradationConfiguration
+	Guards      GuardsConfiguration
+	Redis       RedisConfiguration
+	// Shadow is evaluated next to the enforced limits and lists and only reported, it is zero when unset.
+	Shadow antibruteforce.ShadowPolicy
+	// Challenge is zero when no soft limit is set, attempts are then only allowed or denied.
+	Challenge antibruteforce.ChallengePolicy
+	// Stuffing has zero limits when credential stuffing is not detected.
+	Stuffing antibruteforce.StuffingPolicy
+	// Spray has a zero login limit when password spraying is not detected. SprayHMACKey keys the HMACs
+	// that name passwords in Redis, in the password rate limit buckets as well as the distinct login counters.
+	Spray        antibruteforce.SprayPolicy
+	SprayHMACKey string
+	Breached     BreachedConfiguration
+	// HoneypotBlacklistTTL is how long sources trying a honeypot login stay blacklisted.
+	HoneypotBlacklistTTL time.Duration
+	// RiskWeights are all zero when risk scoring is turned off.
+	RiskWeights antibruteforce.RiskWeights
+	// AutoMigrate applies pending schema migrations at startup.
+	AutoMigrate bool
+}
+
+// RedisConfiguration describes a sentinel or cluster deployment, RedisConnectionString is then
+// the first sentinel or cluster seed and still carries credentials and TLS.
+type RedisConfiguration struct {
+	Mode storage.RedisMode
+	// Addrs are the other sentinels or cluster seeds.
+	Addrs            []string
+	SentinelMaster   string
+	SentinelPassword string
+}
+
+// GuardsConfiguration bounds calls to Postgres and Redis, a zero timeout disables it.
+// Both backends get their own circuit breaker with the same settings.
+type GuardsConfiguration struct {
+	PostgresTimeout         time.Duration
+	RedisTimeout            time.Duration
+	BreakerFailureThreshold int
+	BreakerOpenTimeout      time.Duration
+}
+
+// DegradationConfiguration tells CheckAccess what to answer while a backend is down.
+// Postgres backs the subnet lists, Redis backs the rate limits.
+type DegradationConfiguration struct {
+	Postgres antibruteforce.FailurePolicy
+	Redis    antibruteforce.FailurePolicy
+}
+
+// EventsConfiguration selects decision event sinks, empty destinations are disabled.
+// WatchDecisions streams are always available.
+type EventsConfiguration struct {
+	File           string
+	SyslogAddr     string
+	WebhookURL     string
+	BufferSize     int
+	IncludeAllowed bool
+}
+
+// BreachedConfiguration points to a corpus of breached password hashes, an empty CorpusFile disables lookups.
+type BreachedConfiguration struct {
+	CorpusFile string
+	// IndexFile is built from the corpus, it defaults to the corpus file with an .idx suffix.
+	IndexFile string
+	Action    antibruteforce.BreachedAction
+}
+
+type TracingConfiguration struct {
+	// Exporter is one of none, stdout or otlp.
+	Exporter     string
+	OTLPEndpoint string
+	OTLPInsecure bool
+	SampleRatio  float64
+}
+
+type TLSConfiguration struct {
+	CertFile          string
+	KeyFile           string
+	ClientCAFile      string
+	RequireClientCert bool
+}
+
+// ReadConfiguration reads the file named by ConfigFileKey, if any, with environment variables taking precedence
+// over its values.
+func ReadConfiguration() (*Configuration, error) {
+	src, err := newSource(os.Getenv(ConfigFileKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return readConfiguration(src)
+}
+
+// ReadPgsqlConnectionString reads only the Postgres connection string, from the same sources as ReadConfiguration,
+// for commands that need nothing else.
+func ReadPgsqlConnectionString() (string, error) {
+	src, err := newSource(os.Getenv(ConfigFileKey))
+	if err != nil {
+		return "", err
+	}
+
+	pgsql := src.get(PgsqlConnectionStringKey)
+	if pgsql == "" {
+		return "", configuration.NewCorruptedConfigurationError([]string{PgsqlConnectionStringKey})
+	}
+
+	return pgsql, nil
 }
 
-func ReadConfigurationFromEnv() (*Configuration, error) {
+func readConfiguration(src *source) (*Configuration, error) {
 	var corruptedKeys []string
 
-	pgsql := os.Getenv(PgsqlConnectionStringKey)
+	pgsql := src.get(PgsqlConnectionStringKey)
 	if pgsql == "" {
 		corruptedKeys = append(corruptedKeys, PgsqlConnectionStringKey)
 	}
 
-	redis := os.Getenv(RedisConnectionStingKey)
+	redis := src.get(RedisConnectionStingKey)
 	if redis == "" {
 		corruptedKeys = append(corruptedKeys, RedisConnectionStingKey)
 	}
 
-	logLevelStr := os.Getenv(LogLevelKey)
+	logLevelStr := src.get(LogLevelKey)
 	logLevelStr = strings.ToLower(logLevelStr)
 	logLevel, ok := strToLevel[logLevelStr]
 	if !ok {
@@ -65,7 +314,7 @@ func ReadConfigurationFromEnv() (*Configuration, error) {
 	}
 
 	port := DefaultPort
-	portStr := os.Getenv(AppPortKey)
+	portStr := src.get(AppPortKey)
 	if portStr != "" {
 		var err error
 		port, err = strconv.Atoi(portStr)
@@ -75,7 +324,7 @@ func ReadConfigurationFromEnv() (*Configuration, error) {
 	}
 
 	loginRateLimit := DefaultLoginRateLimit
-	if val := os.Getenv(LoginRateLimitKey); val != "" {
+	if val := src.get(LoginRateLimitKey); val != "" {
 		var err error
 		loginRateLimit, err = strconv.ParseInt(val, 10, 64)
 		if err != nil {
@@ -84,7 +333,7 @@ func ReadConfigurationFromEnv() (*Configuration, error) {
 	}
 
 	passwordRateLimit := DefaultPasswordRateLimit
-	if val := os.Getenv(PasswordRateLimitKey); val != "" {
+	if val := src.get(PasswordRateLimitKey); val != "" {
 		var err error
 		passwordRateLimit, err = strconv.ParseInt(val, 10, 64)
 		if err != nil {
@@ -93,7 +342,7 @@ func ReadConfigurationFromEnv() (*Configuration, error) {
 	}
 
 	ipRateLimit := DefaultIPRateLimit
-	if val := os.Getenv(IPRateLimitKey); val != "" {
+	if val := src.get(IPRateLimitKey); val != "" {
 		var err error
 		ipRateLimit, err = strconv.ParseInt(val, 10, 64)
 		if err != nil {
@@ -101,6 +350,106 @@ func ReadConfigurationFromEnv() (*Configuration, error) {
 		}
 	}
 
+	rateLimitWindow := DefaultRateLimitWindow
+	if val := src.get(RateLimitWindowKey); val != "" {
+		var err error
+		rateLimitWindow, err = time.ParseDuration(val)
+		if err != nil || rateLimitWindow <= 0 {
+			corruptedKeys = append(corruptedKeys, RateLimitWindowKey)
+		}
+	}
+
+	managementPort := 0
+	if val := src.get(ManagementPortKey); val != "" {
+		var err error
+		managementPort, err = strconv.Atoi(val)
+		if err != nil || managementPort == port {
+			corruptedKeys = append(corruptedKeys, ManagementPortKey)
+		}
+	}
+
+	metricsPort := DefaultMetricsPort
+	if val := src.get(MetricsPortKey); val != "" {
+		var err error
+		metricsPort, err = strconv.Atoi(val)
+		if err != nil || (metricsPort != 0 && (metricsPort == port || metricsPort == managementPort)) {
+			corruptedKeys = append(corruptedKeys, MetricsPortKey)
+		}
+	}
+
+	autoMigrate := false
+	if val := src.get(AutoMigrateKey); val != "" {
+		var err error
+		autoMigrate, err = strconv.ParseBool(val)
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, AutoMigrateKey)
+		}
+	}
+
+	authConfigFile := src.get(AuthConfigFileKey)
+
+	managementInsecure := false
+	if val := src.get(ManagementInsecureKey); val != "" {
+		var err error
+		managementInsecure, err = strconv.ParseBool(val)
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, ManagementInsecureKey)
+		}
+	}
+
+	tlsConf, tlsCorruptedKeys := readTLSConfiguration(src)
+	corruptedKeys = append(corruptedKeys, tlsCorruptedKeys...)
+
+	tracingConf, tracingCorruptedKeys := readTracingConfiguration(src)
+	corruptedKeys = append(corruptedKeys, tracingCorruptedKeys...)
+
+	eventsConf, eventsCorruptedKeys := readEventsConfiguration(src)
+	corruptedKeys = append(corruptedKeys, eventsCorruptedKeys...)
+
+	degradationConf, degradationCorruptedKeys := readDegradationConfiguration(src)
+	corruptedKeys = append(corruptedKeys, degradationCorruptedKeys...)
+
+	shadowConf, shadowCorruptedKeys := readShadowConfiguration(src)
+	corruptedKeys = append(corruptedKeys, shadowCorruptedKeys...)
+
+	hardLimits := antibruteforce.RateLimitConfig{
+		LoginLimit:    loginRateLimit,
+		PasswordLimit: passwordRateLimit,
+		IPLimit:       ipRateLimit,
+	}
+	challengeConf, challengeCorruptedKeys := readChallengeConfiguration(src, hardLimits)
+	corruptedKeys = append(corruptedKeys, challengeCorruptedKeys...)
+
+	stuffingConf, stuffingCorruptedKeys := readStuffingConfiguration(src)
+	corruptedKeys = append(corruptedKeys, stuffingCorruptedKeys...)
+
+	sprayConf, sprayHMACKey, sprayCorruptedKeys := readSprayConfiguration(src)
+	corruptedKeys = append(corruptedKeys, sprayCorruptedKeys...)
+
+	breachedConf, breachedCorruptedKeys := readBreachedConfiguration(src)
+	corruptedKeys = append(corruptedKeys, breachedCorruptedKeys...)
+
+	honeypotTTL := antibruteforce.DefaultHoneypotBlacklistTTL
+	if val := src.get(HoneypotBlacklistTTLKey); val != "" {
+		var err error
+		honeypotTTL, err = time.ParseDuration(val)
+		if err != nil || honeypotTTL <= 0 {
+			corruptedKeys = append(corruptedKeys, HoneypotBlacklistTTLKey)
+		}
+	}
+
+	riskWeights, riskCorruptedKeys := readRiskWeights(src)
+	corruptedKeys = append(corruptedKeys, riskCorruptedKeys...)
+
+	guardsConf, guardsCorruptedKeys := readGuardsConfiguration(src)
+	corruptedKeys = append(corruptedKeys, guardsCorruptedKeys...)
+
+	redisConf, redisCorruptedKeys := readRedisConfiguration(src)
+	corruptedKeys = append(corruptedKeys, redisCorruptedKeys...)
+
+	// keys in the file that no setting reads are most likely typos
+	corruptedKeys = append(corruptedKeys, src.unused()...)
+
 	if len(corruptedKeys) > 0 {
 		return nil, configuration.NewCorruptedConfigurationError(corruptedKeys)
 	}
@@ -113,7 +462,459 @@ func ReadConfigurationFromEnv() (*Configuration, error) {
 		LoginRateLimit:        loginRateLimit,
 		PasswordRateLimit:     passwordRateLimit,
 		IPRateLimit:           ipRateLimit,
+		RateLimitWindow:       rateLimitWindow,
+		AuthConfigFile:        authConfigFile,
+		ManagementPort:        managementPort,
+		ManagementInsecure:    managementInsecure,
+		TLS:                   tlsConf,
+		MetricsPort:           metricsPort,
+		Tracing:               tracingConf,
+		Events:                eventsConf,
+		Degradation:           degradationConf,
+		Shadow:                shadowConf,
+		Challenge:             challengeConf,
+		Stuffing:              stuffingConf,
+		Spray:                 sprayConf,
+		SprayHMACKey:          sprayHMACKey,
+		Breached:              breachedConf,
+		HoneypotBlacklistTTL:  honeypotTTL,
+		RiskWeights:           riskWeights,
+		Guards:                guardsConf,
+		Redis:                 redisConf,
+		AutoMigrate:           autoMigrate,
 	}
 
 	return conf, nil
 }
+
+func readTLSConfiguration(src *source) (*TLSConfiguration, []string) {
+	conf := &TLSConfiguration{
+		CertFile:     src.get(TLSCertFileKey),
+		KeyFile:      src.get(TLSKeyFileKey),
+		ClientCAFile: src.get(TLSClientCAFileKey),
+	}
+
+	var corruptedKeys []string
+	if val := src.get(TLSRequireClientCertKey); val != "" {
+		var err error
+		conf.RequireClientCert, err = strconv.ParseBool(val)
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, TLSRequireClientCertKey)
+		}
+	}
+
+	if conf.CertFile == "" && conf.KeyFile == "" {
+		if conf.ClientCAFile != "" {
+			corruptedKeys = append(corruptedKeys, TLSClientCAFileKey)
+		}
+		return nil, corruptedKeys
+	}
+
+	if conf.CertFile == "" {
+		corruptedKeys = append(corruptedKeys, TLSCertFileKey)
+	}
+
+	if conf.KeyFile == "" {
+		corruptedKeys = append(corruptedKeys, TLSKeyFileKey)
+	}
+
+	if conf.RequireClientCert && conf.ClientCAFile == "" {
+		corruptedKeys = append(corruptedKeys, TLSClientCAFileKey)
+	}
+
+	return conf, corruptedKeys
+}
+
+func readTracingConfiguration(src *source) (TracingConfiguration, []string) {
+	conf := TracingConfiguration{
+		Exporter:     strings.ToLower(src.get(TracingExporterKey)),
+		OTLPEndpoint: src.get(TracingOTLPEndpointKey),
+		SampleRatio:  DefaultSampleRatio,
+	}
+
+	var corruptedKeys []string
+	if conf.Exporter == "" {
+		conf.Exporter = DefaultTracingExporter
+	}
+	if _, ok := tracingExporters[conf.Exporter]; !ok {
+		corruptedKeys = append(corruptedKeys, TracingExporterKey)
+	}
+
+	if val := src.get(TracingOTLPInsecureKey); val != "" {
+		var err error
+		conf.OTLPInsecure, err = strconv.ParseBool(val)
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, TracingOTLPInsecureKey)
+		}
+	}
+
+	if val := src.get(TracingSampleRatioKey); val != "" {
+		var err error
+		conf.SampleRatio, err = strconv.ParseFloat(val, 64)
+		if err != nil || conf.SampleRatio < 0 || conf.SampleRatio > 1 {
+			corruptedKeys = append(corruptedKeys, TracingSampleRatioKey)
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+func readEventsConfiguration(src *source) (EventsConfiguration, []string) {
+	conf := EventsConfiguration{
+		File:       src.get(EventsFileKey),
+		SyslogAddr: src.get(EventsSyslogAddrKey),
+		WebhookURL: src.get(EventsWebhookURLKey),
+		BufferSize: DefaultEventsBufferSize,
+	}
+
+	var corruptedKeys []string
+	if val := src.get(EventsBufferSizeKey); val != "" {
+		var err error
+		conf.BufferSize, err = strconv.Atoi(val)
+		if err != nil || conf.BufferSize <= 0 {
+			corruptedKeys = append(corruptedKeys, EventsBufferSizeKey)
+		}
+	}
+
+	if val := src.get(EventsIncludeAllowedKey); val != "" {
+		var err error
+		conf.IncludeAllowed, err = strconv.ParseBool(val)
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, EventsIncludeAllowedKey)
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+func readDegradationConfiguration(src *source) (DegradationConfiguration, []string) {
+	conf := DegradationConfiguration{
+		Postgres: DefaultFailurePolicy,
+		Redis:    DefaultFailurePolicy,
+	}
+
+	var corruptedKeys []string
+	if val := src.get(PostgresFailurePolicyKey); val != "" {
+		var err error
+		conf.Postgres, err = antibruteforce.ParseFailurePolicy(val)
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, PostgresFailurePolicyKey)
+		}
+	}
+
+	if val := src.get(RedisFailurePolicyKey); val != "" {
+		var err error
+		conf.Redis, err = antibruteforce.ParseFailurePolicy(val)
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, RedisFailurePolicyKey)
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+// readShadowConfiguration reads shadow limits, zero keeps the enforced limit of a dimension,
+// and shadow lists of comma separated CIDRs.
+func readShadowConfiguration(src *source) (antibruteforce.ShadowPolicy, []string) {
+	var conf antibruteforce.ShadowPolicy
+	var corruptedKeys []string
+
+	limits := []struct {
+		key   string
+		value *int64
+	}{
+		{ShadowLoginRateLimitKey, &conf.RateLimits.LoginLimit},
+		{ShadowPasswordRateLimitKey, &conf.RateLimits.PasswordLimit},
+		{ShadowIPRateLimitKey, &conf.RateLimits.IPLimit},
+	}
+	for _, limit := range limits {
+		if val := src.get(limit.key); val != "" {
+			var err error
+			*limit.value, err = strconv.ParseInt(val, 10, 64)
+			if err != nil || *limit.value < 0 {
+				corruptedKeys = append(corruptedKeys, limit.key)
+			}
+		}
+	}
+
+	lists := []struct {
+		key     string
+		subnets *[]*net.IPNet
+	}{
+		{ShadowWhitelistKey, &conf.Whitelist},
+		{ShadowBlacklistKey, &conf.Blacklist},
+	}
+	for _, list := range lists {
+		for _, cidr := range strings.Split(src.get(list.key), ",") {
+			if cidr = strings.TrimSpace(cidr); cidr == "" {
+				continue
+			}
+
+			_, subnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				corruptedKeys = append(corruptedKeys, list.key)
+				break
+			}
+			*list.subnets = append(*list.subnets, subnet)
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+// readChallengeConfiguration reads soft limits, zero disables challenges for a dimension.
+// A soft limit must stay below the configured rate limit of its dimension, or it would never be reached.
+func readChallengeConfiguration(
+	src *source, rateLimits antibruteforce.RateLimitConfig,
+) (antibruteforce.ChallengePolicy, []string) {
+	conf := antibruteforce.ChallengePolicy{Allowance: DefaultChallengeAllowance}
+	var corruptedKeys []string
+
+	limits := []struct {
+		key   string
+		value *int64
+		hard  int64
+	}{
+		{ChallengeLoginLimitKey, &conf.Limits.LoginLimit, rateLimits.LoginLimit},
+		{ChallengePasswordLimitKey, &conf.Limits.PasswordLimit, rateLimits.PasswordLimit},
+		{ChallengeIPLimitKey, &conf.Limits.IPLimit, rateLimits.IPLimit},
+	}
+	for _, limit := range limits {
+		if val := src.get(limit.key); val != "" {
+			var err error
+			*limit.value, err = strconv.ParseInt(val, 10, 64)
+			if err != nil || *limit.value < 0 || *limit.value >= limit.hard {
+				corruptedKeys = append(corruptedKeys, limit.key)
+			}
+		}
+	}
+
+	if val := src.get(ChallengeAllowanceKey); val != "" {
+		var err error
+		conf.Allowance, err = time.ParseDuration(val)
+		if err != nil || conf.Allowance <= 0 {
+			corruptedKeys = append(corruptedKeys, ChallengeAllowanceKey)
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+// readStuffingConfiguration reads how many distinct logins an IP and its subnet may try within the window,
+// zero limits never deny.
+func readStuffingConfiguration(src *source) (antibruteforce.StuffingPolicy, []string) {
+	conf := antibruteforce.StuffingPolicy{Window: DefaultStuffingWindow}
+	var corruptedKeys []string
+
+	limits := []struct {
+		key   string
+		value *int64
+	}{
+		{StuffingIPLimitKey, &conf.IPLimit},
+		{StuffingSubnetLimitKey, &conf.SubnetLimit},
+	}
+	for _, limit := range limits {
+		if val := src.get(limit.key); val != "" {
+			var err error
+			*limit.value, err = strconv.ParseInt(val, 10, 64)
+			if err != nil || *limit.value < 0 {
+				corruptedKeys = append(corruptedKeys, limit.key)
+			}
+		}
+	}
+
+	if val := src.get(StuffingWindowKey); val != "" {
+		var err error
+		conf.Window, err = time.ParseDuration(val)
+		if err != nil || conf.Window <= 0 {
+			corruptedKeys = append(corruptedKeys, StuffingWindowKey)
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+// readSprayConfiguration reads how many distinct logins a password may be tried against within the window
+// and how an alert tightens rate limits, a zero login limit never raises one. The HMAC key is required
+// while the login limit is set, without it password rate limit buckets are named by unkeyed hashes.
+func readSprayConfiguration(src *source) (antibruteforce.SprayPolicy, string, []string) {
+	conf := antibruteforce.SprayPolicy{
+		Window:            DefaultSprayWindow,
+		AlertDuration:     DefaultSprayAlertDuration,
+		AlertLimitPercent: DefaultSprayAlertLimitPercent,
+	}
+	var corruptedKeys []string
+
+	if val := src.get(SprayLoginLimitKey); val != "" {
+		var err error
+		conf.LoginLimit, err = strconv.ParseInt(val, 10, 64)
+		if err != nil || conf.LoginLimit < 0 {
+			corruptedKeys = append(corruptedKeys, SprayLoginLimitKey)
+		}
+	}
+
+	durations := []struct {
+		key   string
+		value *time.Duration
+	}{
+		{SprayWindowKey, &conf.Window},
+		{SprayAlertDurationKey, &conf.AlertDuration},
+	}
+	for _, duration := range durations {
+		if val := src.get(duration.key); val != "" {
+			var err error
+			*duration.value, err = time.ParseDuration(val)
+			if err != nil || *duration.value <= 0 {
+				corruptedKeys = append(corruptedKeys, duration.key)
+			}
+		}
+	}
+
+	if val := src.get(SprayAlertLimitPercentKey); val != "" {
+		var err error
+		conf.AlertLimitPercent, err = strconv.ParseInt(val, 10, 64)
+		if err != nil || conf.AlertLimitPercent <= 0 || conf.AlertLimitPercent > 100 {
+			corruptedKeys = append(corruptedKeys, SprayAlertLimitPercentKey)
+		}
+	}
+
+	hmacKey := src.get(SprayHMACKeyKey)
+	if (conf.LoginLimit > 0 || hmacKey != "") && len(hmacKey) < MinSprayHMACKeyLength {
+		corruptedKeys = append(corruptedKeys, SprayHMACKeyKey)
+	}
+
+	return conf, hmacKey, corruptedKeys
+}
+
+// readBreachedConfiguration reads where the breached password corpus is and what to do with attempts using
+// a breached password.
+func readBreachedConfiguration(src *source) (BreachedConfiguration, []string) {
+	conf := BreachedConfiguration{
+		CorpusFile: src.get(BreachedCorpusFileKey),
+		IndexFile:  src.get(BreachedIndexFileKey),
+		Action:     DefaultBreachedAction,
+	}
+	var corruptedKeys []string
+
+	if conf.IndexFile == "" && conf.CorpusFile != "" {
+		conf.IndexFile = conf.CorpusFile + breachedIndexSuffix
+	}
+
+	if val := src.get(BreachedActionKey); val != "" {
+		var err error
+		conf.Action, err = antibruteforce.ParseBreachedAction(val)
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, BreachedActionKey)
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+// readRiskWeights reads weights of risk signals, unset ones keep their default.
+func readRiskWeights(src *source) (antibruteforce.RiskWeights, []string) {
+	conf := DefaultRiskWeights
+	var corruptedKeys []string
+
+	weights := []struct {
+		key   string
+		value *int64
+	}{
+		{RiskIPFillWeightKey, &conf.IPFill},
+		{RiskLoginFillWeightKey, &conf.LoginFill},
+		{RiskPasswordFillWeightKey, &conf.PasswordFill},
+		{RiskViolationsWeightKey, &conf.Violations},
+		{RiskBlacklistNearWeightKey, &conf.BlacklistNear},
+		{RiskLockoutsWeightKey, &conf.Lockouts},
+	}
+	for _, weight := range weights {
+		if val := src.get(weight.key); val != "" {
+			var err error
+			*weight.value, err = strconv.ParseInt(val, 10, 64)
+			if err != nil || *weight.value < 0 {
+				corruptedKeys = append(corruptedKeys, weight.key)
+			}
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+func readGuardsConfiguration(src *source) (GuardsConfiguration, []string) {
+	conf := GuardsConfiguration{
+		PostgresTimeout:         DefaultPostgresTimeout,
+		RedisTimeout:            DefaultRedisTimeout,
+		BreakerFailureThreshold: DefaultBreakerFailures,
+		BreakerOpenTimeout:      DefaultBreakerOpenTime,
+	}
+
+	var corruptedKeys []string
+	if val := src.get(PostgresTimeoutKey); val != "" {
+		var err error
+		conf.PostgresTimeout, err = time.ParseDuration(val)
+		if err != nil || conf.PostgresTimeout < 0 {
+			corruptedKeys = append(corruptedKeys, PostgresTimeoutKey)
+		}
+	}
+
+	if val := src.get(RedisTimeoutKey); val != "" {
+		var err error
+		conf.RedisTimeout, err = time.ParseDuration(val)
+		if err != nil || conf.RedisTimeout < 0 {
+			corruptedKeys = append(corruptedKeys, RedisTimeoutKey)
+		}
+	}
+
+	if val := src.get(BreakerFailuresKey); val != "" {
+		var err error
+		conf.BreakerFailureThreshold, err = strconv.Atoi(val)
+		if err != nil || conf.BreakerFailureThreshold <= 0 {
+			corruptedKeys = append(corruptedKeys, BreakerFailuresKey)
+		}
+	}
+
+	if val := src.get(BreakerOpenTimeoutKey); val != "" {
+		var err error
+		conf.BreakerOpenTimeout, err = time.ParseDuration(val)
+		if err != nil || conf.BreakerOpenTimeout <= 0 {
+			corruptedKeys = append(corruptedKeys, BreakerOpenTimeoutKey)
+		}
+	}
+
+	return conf, corruptedKeys
+}
+
+func readRedisConfiguration(src *source) (RedisConfiguration, []string) {
+	conf := RedisConfiguration{
+		Mode:             DefaultRedisMode,
+		SentinelMaster:   src.get(RedisSentinelMasterKey),
+		SentinelPassword: src.get(RedisSentinelPasswordKey),
+	}
+
+	var corruptedKeys []string
+	if val := src.get(RedisModeKey); val != "" {
+		var err error
+		conf.Mode, err = storage.ParseRedisMode(strings.ToLower(val))
+		if err != nil {
+			corruptedKeys = append(corruptedKeys, RedisModeKey)
+		}
+	}
+
+	if val := src.get(RedisAddrsKey); val != "" {
+		for _, addr := range strings.Split(val, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				conf.Addrs = append(conf.Addrs, addr)
+			}
+		}
+	}
+
+	if conf.Mode == storage.RedisModeSentinel && conf.SentinelMaster == "" {
+		corruptedKeys = append(corruptedKeys, RedisSentinelMasterKey)
+	}
+
+	if conf.Mode == storage.RedisModeSingle && len(conf.Addrs) > 0 {
+		corruptedKeys = append(corruptedKeys, RedisAddrsKey)
+	}
+
+	return conf, corruptedKeys
+}
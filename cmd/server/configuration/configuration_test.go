@@ -0,0 +1,316 @@
+package configuration
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/FluVirus2/antibruteforce/pkg/configuration"
+)
+
+func writeConfigFile(t *testing.T, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write configuration file: %v", err)
+	}
+	t.Setenv(ConfigFileKey, path)
+
+	return path
+}
+
+func TestReadConfigurationMergesFileAndEnv(t *testing.T) {
+	writeConfigFile(t, "server.yaml", `
+pgsql_connection_string: postgres://file
+redis:
+  connection_string: redis://file
+  mode: cluster
+  addrs: [node-2:6379, node-3:6379]
+login_rate_limit: 5
+ip_rate_limit: 50
+rate_limit_window: 30s
+tracing:
+  sample_ratio: 0.5
+management:
+  insecure: true
+`)
+	t.Setenv(IPRateLimitKey, "70")
+
+	conf, err := ReadConfiguration()
+	if err != nil {
+		t.Fatalf("ReadConfiguration() error = %v", err)
+	}
+
+	if conf.PgsqlConnectionString != "postgres://file" || conf.RedisConnectionString != "redis://file" {
+		t.Errorf("connection strings = %q, %q, want them from the file",
+			conf.PgsqlConnectionString, conf.RedisConnectionString)
+	}
+	wantAddrs := []string{"node-2:6379", "node-3:6379"}
+	if conf.Redis.Mode != storage.RedisModeCluster || !slices.Equal(conf.Redis.Addrs, wantAddrs) {
+		t.Errorf("redis = %+v, want cluster with two more seeds", conf.Redis)
+	}
+	if conf.LoginRateLimit != 5 || conf.PasswordRateLimit != DefaultPasswordRateLimit || conf.IPRateLimit != 70 {
+		t.Errorf("limits = %d, %d, %d, want 5 from the file, the default and 70 from the environment",
+			conf.LoginRateLimit, conf.PasswordRateLimit, conf.IPRateLimit)
+	}
+	if conf.RateLimitWindow != 30*time.Second {
+		t.Errorf("RateLimitWindow = %v, want 30s", conf.RateLimitWindow)
+	}
+	if conf.Tracing.SampleRatio != 0.5 {
+		t.Errorf("SampleRatio = %v, want 0.5", conf.Tracing.SampleRatio)
+	}
+	if !conf.ManagementInsecure {
+		t.Errorf("ManagementInsecure = false, want true from the file")
+	}
+}
+
+func TestReadConfigurationTOML(t *testing.T) {
+	writeConfigFile(t, "server.toml", `
+pgsql_connection_string = "postgres://file"
+redis_connection_string = "redis://file"
+postgres_failure_policy = "open"
+
+[tls]
+cert_file = "/etc/abf/server.crt"
+key_file = "/etc/abf/server.key"
+`)
+
+	conf, err := ReadConfiguration()
+	if err != nil {
+		t.Fatalf("ReadConfiguration() error = %v", err)
+	}
+
+	if conf.Degradation.Postgres != antibruteforce.FailurePolicyOpen {
+		t.Errorf("Degradation.Postgres = %v, want open", conf.Degradation.Postgres)
+	}
+	if conf.TLS == nil || conf.TLS.CertFile != "/etc/abf/server.crt" || conf.TLS.KeyFile != "/etc/abf/server.key" {
+		t.Errorf("TLS = %+v, want files from the [tls] table", conf.TLS)
+	}
+}
+
+func TestReadPgsqlConnectionString(t *testing.T) {
+	// the migrate command needs no Redis, so its absence is not an error
+	writeConfigFile(t, "server.yaml", "pgsql_connection_string: postgres://file\n")
+
+	got, err := ReadPgsqlConnectionString()
+	if err != nil || got != "postgres://file" {
+		t.Errorf("ReadPgsqlConnectionString() = %q, %v, want the file value", got, err)
+	}
+
+	t.Setenv(PgsqlConnectionStringKey, "postgres://env")
+	if got, err := ReadPgsqlConnectionString(); err != nil || got != "postgres://env" {
+		t.Errorf("ReadPgsqlConnectionString() = %q, %v, want the environment to take precedence", got, err)
+	}
+
+	writeConfigFile(t, "empty.yaml", "log_level: info\n")
+	t.Setenv(PgsqlConnectionStringKey, "")
+	var confErr *configuration.CorruptedConfigurationError
+	if _, err := ReadPgsqlConnectionString(); !errors.As(err, &confErr) ||
+		!slices.Equal(confErr.Keys, []string{PgsqlConnectionStringKey}) {
+		t.Errorf("ReadPgsqlConnectionString() without a connection string error = %v, want it corrupted", err)
+	}
+}
+
+func TestReadConfigurationRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		keys []string
+	}{
+		{
+			name: "unknown key",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nlogin_rate_limt: 5\n",
+			keys: []string{"ABF_LOGIN_RATE_LIMT"},
+		},
+		{
+			name: "bad value",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nrate_limit_window: 0s\n",
+			keys: []string{RateLimitWindowKey},
+		},
+		{
+			name: "bad shadow subnet",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nshadow:\n  whitelist: [10.0.0.0/33]\n",
+			keys: []string{ShadowWhitelistKey},
+		},
+		{
+			name: "soft limit above the rate limit",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nchallenge:\n  login_limit: 10\n",
+			keys: []string{ChallengeLoginLimitKey},
+		},
+		{
+			name: "zero stuffing window",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nstuffing:\n  window: 0s\n",
+			keys: []string{StuffingWindowKey},
+		},
+		{
+			name: "spray limit without an hmac key",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nspray:\n  login_limit: 50\n  hmac_key: short\n",
+			keys: []string{SprayHMACKeyKey},
+		},
+		{
+			name: "short hmac key without a spray limit",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nspray:\n  hmac_key: short\n",
+			keys: []string{SprayHMACKeyKey},
+		},
+		{
+			name: "spray alert percent over 100",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nspray:\n  alert_limit_percent: 150\n",
+			keys: []string{SprayAlertLimitPercentKey},
+		},
+		{
+			name: "unknown breached password action",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nbreached:\n  action: block\n",
+			keys: []string{BreachedActionKey},
+		},
+		{
+			name: "zero honeypot blacklist ttl",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nhoneypot:\n  blacklist_ttl: 0s\n",
+			keys: []string{HoneypotBlacklistTTLKey},
+		},
+		{
+			name: "negative risk weight",
+			file: "server.yaml",
+			data: "pgsql_connection_string: a\nredis_connection_string: b\nrisk:\n  lockouts_weight: -1\n",
+			keys: []string{RiskLockoutsWeightKey},
+		},
+		{
+			name: "unsupported format",
+			file: "server.json",
+			data: "{}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigFile(t, tt.file, tt.data)
+
+			_, err := ReadConfiguration()
+			if err == nil {
+				t.Fatal("ReadConfiguration() error = nil, want an error")
+			}
+
+			var confErr *configuration.CorruptedConfigurationError
+			if tt.keys == nil {
+				if !errors.Is(err, ErrUnsupportedConfigFile) {
+					t.Errorf("ReadConfiguration() error = %v, want ErrUnsupportedConfigFile", err)
+				}
+			} else if !errors.As(err, &confErr) || !slices.Equal(confErr.Keys, tt.keys) {
+				t.Errorf("ReadConfiguration() error = %v, want corrupted keys %v", err, tt.keys)
+			}
+		})
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	const base = "pgsql_connection_string: a\nredis_connection_string: b\n"
+	path := writeConfigFile(t, "server.yaml", base+"login_rate_limit: 5\n")
+
+	current, err := ReadConfiguration()
+	if err != nil {
+		t.Fatalf("ReadConfiguration() error = %v", err)
+	}
+
+	var applied []Reloadable
+	var rejectApply error
+	watcher := NewWatcher(current, func(conf Reloadable) error {
+		if rejectApply != nil {
+			return rejectApply
+		}
+		applied = append(applied, conf)
+		return nil
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	rewrite := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(base+data), 0o600); err != nil {
+			t.Fatalf("failed to rewrite configuration file: %v", err)
+		}
+	}
+
+	rewrite("login_rate_limit: 7\nlog_level: debug\nredis_failure_policy: open\n" +
+		"shadow:\n  login_rate_limit: 3\n  blacklist: [203.0.113.0/24]\n" +
+		"challenge:\n  login_limit: 5\n  allowance: 5m\n" +
+		"stuffing:\n  ip_limit: 20\n  window: 5m\n" +
+		"spray:\n  login_limit: 50\n  alert_limit_percent: 25\n  hmac_key: 0123456789abcdef\n" +
+		"breached:\n  action: deny\n" +
+		"honeypot:\n  blacklist_ttl: 6h\n" +
+		"risk:\n  violations_weight: 0\n")
+	if err := watcher.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	withoutViolations := DefaultRiskWeights
+	withoutViolations.Violations = 0
+	want := Reloadable{
+		SlogLevel:         slog.LevelDebug,
+		LoginRateLimit:    7,
+		PasswordRateLimit: DefaultPasswordRateLimit,
+		IPRateLimit:       DefaultIPRateLimit,
+		RateLimitWindow:   DefaultRateLimitWindow,
+		Degradation: DegradationConfiguration{
+			Postgres: DefaultFailurePolicy,
+			Redis:    antibruteforce.FailurePolicyOpen,
+		},
+		Shadow: antibruteforce.ShadowPolicy{
+			RateLimits: antibruteforce.RateLimitConfig{LoginLimit: 3},
+			Blacklist:  []*net.IPNet{{IP: net.IPv4(203, 0, 113, 0).To4(), Mask: net.CIDRMask(24, 32)}},
+		},
+		Challenge: antibruteforce.ChallengePolicy{
+			Limits:    antibruteforce.RateLimitConfig{LoginLimit: 5},
+			Allowance: 5 * time.Minute,
+		},
+		Stuffing: antibruteforce.StuffingPolicy{IPLimit: 20, Window: 5 * time.Minute},
+		Spray: antibruteforce.SprayPolicy{
+			LoginLimit:        50,
+			Window:            DefaultSprayWindow,
+			AlertDuration:     DefaultSprayAlertDuration,
+			AlertLimitPercent: 25,
+		},
+		SprayHMACKey:         "0123456789abcdef",
+		BreachedAction:       antibruteforce.BreachedActionDeny,
+		HoneypotBlacklistTTL: 6 * time.Hour,
+		RiskWeights:          withoutViolations,
+	}
+	if len(applied) != 1 || !reflect.DeepEqual(applied[0], want) {
+		t.Fatalf("applied %+v, want %+v", applied, want)
+	}
+
+	// one bad key rejects the whole file
+	rewrite("login_rate_limit: 9\nip_rate_limit: lots\n")
+	if err := watcher.Reload(); err == nil {
+		t.Error("Reload() of an invalid file error = nil, want an error")
+	}
+
+	rejectApply = errors.New("rejected")
+	rewrite("login_rate_limit: 9\n")
+	if err := watcher.Reload(); !errors.Is(err, rejectApply) {
+		t.Errorf("Reload() error = %v, want the apply error", err)
+	}
+
+	if len(applied) != 1 {
+		t.Errorf("applied %d configurations, want rejected ones dropped", len(applied))
+	}
+	if watcher.current.LoginRateLimit != 7 {
+		t.Errorf("current LoginRateLimit = %d, want 7 from the last applied file", watcher.current.LoginRateLimit)
+	}
+}
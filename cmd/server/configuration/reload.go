@@ -0,0 +1,201 @@
+package configuration
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/pkg/configuration"
+)
+
+const DefaultReloadInterval = 10 * time.Second
+
+// Reloadable holds the settings that are applied without a restart.
+type Reloadable struct {
+	SlogLevel            slog.Level
+	LoginRateLimit       int64
+	PasswordRateLimit    int64
+	IPRateLimit          int64
+	RateLimitWindow      time.Duration
+	Degradation          DegradationConfiguration
+	Shadow               antibruteforce.ShadowPolicy
+	Challenge            antibruteforce.ChallengePolicy
+	Stuffing             antibruteforce.StuffingPolicy
+	Spray                antibruteforce.SprayPolicy
+	SprayHMACKey         string
+	BreachedAction       antibruteforce.BreachedAction
+	HoneypotBlacklistTTL time.Duration
+	RiskWeights          antibruteforce.RiskWeights
+}
+
+func (c *Configuration) Reloadable() Reloadable {
+	return Reloadable{
+		SlogLevel:            c.SlogLevel,
+		LoginRateLimit:       c.LoginRateLimit,
+		PasswordRateLimit:    c.PasswordRateLimit,
+		IPRateLimit:          c.IPRateLimit,
+		RateLimitWindow:      c.RateLimitWindow,
+		Degradation:          c.Degradation,
+		Shadow:               c.Shadow,
+		Challenge:            c.Challenge,
+		Stuffing:             c.Stuffing,
+		Spray:                c.Spray,
+		SprayHMACKey:         c.SprayHMACKey,
+		BreachedAction:       c.Breached.Action,
+		HoneypotBlacklistTTL: c.HoneypotBlacklistTTL,
+		RiskWeights:          c.RiskWeights,
+	}
+}
+
+// restartOnly returns a copy of c without the reloadable settings, for comparing what still needs a restart.
+func (c *Configuration) restartOnly() Configuration {
+	conf := *c
+	conf.SlogLevel = 0
+	conf.LoginRateLimit = 0
+	conf.PasswordRateLimit = 0
+	conf.IPRateLimit = 0
+	conf.RateLimitWindow = 0
+	conf.Degradation = DegradationConfiguration{}
+	conf.Shadow = antibruteforce.ShadowPolicy{}
+	conf.Challenge = antibruteforce.ChallengePolicy{}
+	conf.Stuffing = antibruteforce.StuffingPolicy{}
+	conf.Spray = antibruteforce.SprayPolicy{}
+	conf.SprayHMACKey = ""
+	conf.Breached.Action = antibruteforce.BreachedActionNone
+	conf.HoneypotBlacklistTTL = 0
+	conf.RiskWeights = antibruteforce.RiskWeights{}
+	return conf
+}
+
+// Watcher re-reads the configuration on SIGHUP and when the configuration file changes,
+// and hands the reloadable part of it to apply. A configuration that fails to read or that
+// apply rejects is logged and dropped, the running one stays in place.
+type Watcher struct {
+	current *Configuration
+	apply   func(Reloadable) error
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+}
+
+// NewWatcher starts from current, the configuration the server was started with.
+// apply must either take all settings or none of them.
+func NewWatcher(current *Configuration, apply func(Reloadable) error, logger *slog.Logger) *Watcher {
+	w := &Watcher{
+		current: current,
+		apply:   apply,
+		logger:  logger,
+		path:    os.Getenv(ConfigFileKey),
+	}
+	w.modTime, _ = w.currentModTime()
+
+	return w
+}
+
+func (w *Watcher) currentModTime() (time.Time, error) {
+	if w.path == "" {
+		return time.Time{}, nil
+	}
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+// Reload reads the configuration again and applies it if it is valid.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if modTime, err := w.currentModTime(); err == nil {
+		w.modTime = modTime
+	}
+
+	src, err := newSource(w.path)
+	if err != nil {
+		return err
+	}
+
+	conf, err := readConfiguration(src)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(conf.restartOnly(), w.current.restartOnly()) {
+		w.logger.Warn("configuration has changes that need a restart, only limits, windows, " +
+			"failure policies, shadow, challenge, stuffing and spray policies, the breached password action, " +
+			"the honeypot blacklist ttl, risk weights and log level are applied now")
+	}
+
+	if err := w.apply(conf.Reloadable()); err != nil {
+		return err
+	}
+
+	w.current = conf
+
+	return nil
+}
+
+func (w *Watcher) changed() bool {
+	if w.path == "" {
+		return false
+	}
+
+	modTime, err := w.currentModTime()
+	if err != nil {
+		// an editor may be replacing the file right now, try again on the next tick
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return !modTime.Equal(w.modTime)
+}
+
+// Run reloads on SIGHUP and polls the configuration file every interval until ctx is done.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+		case <-ticker.C:
+			if !w.changed() {
+				continue
+			}
+		}
+
+		if err := w.Reload(); err != nil {
+			var confErr *configuration.CorruptedConfigurationError
+			if errors.As(err, &confErr) {
+				w.logger.Error("failed to reload configuration, keeping the previous one",
+					"error", err, "keys", confErr.Keys)
+			} else {
+				w.logger.Error("failed to reload configuration, keeping the previous one", "error", err)
+			}
+			continue
+		}
+
+		w.logger.Info("reloaded configuration")
+	}
+}
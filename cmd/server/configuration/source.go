@@ -0,0 +1,105 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+// ConfigFileKey names a YAML or TOML file with settings, it is only read from the environment.
+const ConfigFileKey = "ABF_CONFIG_FILE"
+
+var ErrUnsupportedConfigFile = errors.New("unsupported configuration file, expected .yaml, .yml or .toml")
+
+// source looks settings up in the environment first and then in the configuration file.
+// File keys are environment variable names without the ABF_ prefix in lower case, nested tables
+// are joined with underscores, so tls.cert_file and tls_cert_file both set ABF_TLS_CERT_FILE.
+type source struct {
+	file map[string]string
+	used map[string]struct{}
+}
+
+// newSource reads the configuration file at path, an empty path gives an environment-only source.
+func newSource(path string) (*source, error) {
+	src := &source{
+		file: make(map[string]string),
+		used: make(map[string]struct{}),
+	}
+
+	if path == "" {
+		return src, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	var tree map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedConfigFile, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file %q: %w", path, err)
+	}
+
+	flatten(src.file, "ABF", tree)
+
+	return src, nil
+}
+
+func flatten(dst map[string]string, prefix string, tree map[string]any) {
+	for key, value := range tree {
+		key = prefix + "_" + strings.ToUpper(key)
+
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(dst, key, v)
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			dst[key] = strings.Join(items, ",")
+		case nil:
+			dst[key] = ""
+		default:
+			dst[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+func (s *source) get(key string) string {
+	s.used[key] = struct{}{}
+
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+
+	return s.file[key]
+}
+
+// unused returns file keys that were never looked up, sorted.
+func (s *source) unused() []string {
+	var keys []string
+	for key := range s.file {
+		if _, ok := s.used[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -14,18 +15,44 @@ import (
 	pbAntiBruteForce "github.com/FluVirus2/antibruteforce/api/gen/v1/antibruteforce"
 	pbManagement "github.com/FluVirus2/antibruteforce/api/gen/v1/antibruteforce_management"
 	epConfig "github.com/FluVirus2/antibruteforce/cmd/server/configuration"
+	"github.com/FluVirus2/antibruteforce/internal/api/grpc/interceptor"
 	grpcAntibruteforce "github.com/FluVirus2/antibruteforce/internal/api/grpc/v1/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/internal/auth"
+	"github.com/FluVirus2/antibruteforce/internal/events"
+	"github.com/FluVirus2/antibruteforce/internal/health"
+	"github.com/FluVirus2/antibruteforce/internal/metrics"
 	antibruteforceService "github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
 	managementService "github.com/FluVirus2/antibruteforce/internal/service/management"
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/FluVirus2/antibruteforce/internal/storage/audit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/breached"
+	"github.com/FluVirus2/antibruteforce/internal/storage/migrate"
 	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
 	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
+	"github.com/FluVirus2/antibruteforce/internal/tracing"
+	"github.com/FluVirus2/antibruteforce/pkg/breaker"
 	"github.com/FluVirus2/antibruteforce/pkg/configuration"
+	"github.com/FluVirus2/antibruteforce/pkg/tlsreload"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
+type namedServer struct {
+	name   string
+	port   int
+	server *grpc.Server
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	// ---------------------------------------------------------------------------------
 	// BEGIN ----------------------- ROOT CONTEXT --------------------------------------
 	// ---------------------------------------------------------------------------------
@@ -39,7 +66,7 @@ func main() {
 	// BEGIN --------------------- READ CONFIGURATION ----------------------------------
 	// ---------------------------------------------------------------------------------
 	var confErr *configuration.CorruptedConfigurationError
-	appConf, err := epConfig.ReadConfigurationFromEnv()
+	appConf, err := epConfig.ReadConfiguration()
 	if err != nil {
 		if errors.As(err, &confErr) {
 			slog.Error(confErr.Error(), "keys", confErr.Keys)
@@ -56,8 +83,11 @@ func main() {
 	// ---------------------------------------------------------------------------------
 	// BEGIN -------------------------- SETUP LOGGER -----------------------------------
 	// ---------------------------------------------------------------------------------
+	// the level is swapped on configuration reloads
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(appConf.SlogLevel)
 	jsonHandlerOpts := &slog.HandlerOptions{
-		Level: appConf.SlogLevel,
+		Level: logLevel,
 	}
 
 	jsonHandler := slog.NewJSONHandler(os.Stdout, jsonHandlerOpts)
@@ -66,17 +96,55 @@ func main() {
 	// ENDOF -------------------------- SETUP LOGGER -----------------------------------
 	// ---------------------------------------------------------------------------------
 
+	// ---------------------------------------------------------------------------------
+	// BEGIN -------------------------- SETUP TRACING ----------------------------------
+	// ---------------------------------------------------------------------------------
+	shutdownTracing, err := tracing.Setup(rootCtx, tracing.Config{
+		Exporter:     appConf.Tracing.Exporter,
+		OTLPEndpoint: appConf.Tracing.OTLPEndpoint,
+		OTLPInsecure: appConf.Tracing.OTLPInsecure,
+		SampleRatio:  appConf.Tracing.SampleRatio,
+	})
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+
+		return
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Warn("failed to flush traces", "error", err)
+		}
+	}()
+	// ---------------------------------------------------------------------------------
+	// ENDOF -------------------------- SETUP TRACING ----------------------------------
+	// ---------------------------------------------------------------------------------
+
 	// ---------------------------------------------------------------------------------
 	// BEGIN --------------------------- SETUP REDIS -----------------------------------
 	// ---------------------------------------------------------------------------------
-	redisOpts, err := redis.ParseURL(appConf.RedisConnectionString)
+	breakerConf := breaker.Config{
+		FailureThreshold: appConf.Guards.BreakerFailureThreshold,
+		OpenTimeout:      appConf.Guards.BreakerOpenTimeout,
+	}
+
+	redisClient, err := storage.NewRedisClient(storage.RedisConfig{
+		Mode:             appConf.Redis.Mode,
+		URL:              appConf.RedisConnectionString,
+		Addrs:            appConf.Redis.Addrs,
+		MasterName:       appConf.Redis.SentinelMaster,
+		SentinelPassword: appConf.Redis.SentinelPassword,
+	})
 	if err != nil {
-		logger.Error("failed to parse redis url", "error", err)
+		logger.Error("failed to configure redis client", "error", err)
 
 		return
 	}
 
-	redisClient := redis.NewClient(redisOpts)
+	redisGuard := storage.NewGuard("redis", appConf.Guards.RedisTimeout, breakerConf, logger)
+	redisClient.AddHook(tracing.NewRedisHook(otel.GetTracerProvider()))
+	redisClient.AddHook(redisGuard.RedisHook())
 	if err := redisClient.Ping(rootCtx).Err(); err != nil {
 		logger.Error("failed to connect to redis", "error", err)
 
@@ -90,24 +158,73 @@ func main() {
 	// ---------------------------------------------------------------------------------
 	// BEGIN --------------------------- SETUP PGXPOOL ---------------------------------
 	// ---------------------------------------------------------------------------------
-	pgPool, err := pgxpool.New(rootCtx, appConf.PgsqlConnectionString)
+	pgConf, err := pgxpool.ParseConfig(appConf.PgsqlConnectionString)
+	if err != nil {
+		logger.Error("failed to parse pgsql connection string", "error", err)
+
+		return
+	}
+	pgConf.ConnConfig.Tracer = tracing.NewPgxTracer(otel.GetTracerProvider())
+
+	pgPool, err := pgxpool.NewWithConfig(rootCtx, pgConf)
 	if err != nil {
 		logger.Error("failed to init pgx pool", "error", err)
 
 		return
 	}
 	defer pgPool.Close()
+
+	pgGuard := storage.NewGuard("postgres", appConf.Guards.PostgresTimeout, breakerConf, logger)
+	pgDB := storage.NewPool(pgPool, pgGuard)
 	// ---------------------------------------------------------------------------------
 	// ENDOF --------------------------- SETUP PGXPOOL ---------------------------------
 	// ---------------------------------------------------------------------------------
 
+	// ---------------------------------------------------------------------------------
+	// BEGIN ------------------------- SETUP MIGRATIONS --------------------------------
+	// ---------------------------------------------------------------------------------
+	if appConf.AutoMigrate {
+		migrator, err := migrate.New(pgPool, logger)
+		if err != nil {
+			logger.Error("failed to load migrations", "error", err)
+
+			return
+		}
+
+		// bypasses the guard, migrations may take longer than its timeout
+		if _, err := migrator.Up(rootCtx); err != nil {
+			logger.Error("failed to migrate database", "error", err)
+
+			return
+		}
+	}
+	// ---------------------------------------------------------------------------------
+	// ENDOF ------------------------- SETUP MIGRATIONS --------------------------------
+	// ---------------------------------------------------------------------------------
+
+	// ---------------------------------------------------------------------------------
+	// BEGIN -------------------------- SETUP METRICS ----------------------------------
+	// ---------------------------------------------------------------------------------
+	appMetrics := metrics.New()
+	appMetrics.MustRegister(metrics.NewPgxPoolCollector(pgPool), metrics.NewRedisPoolCollector(redisClient))
+	appMetrics.MustRegister(metrics.NewBreakerCollector(pgGuard, redisGuard))
+	// ---------------------------------------------------------------------------------
+	// ENDOF -------------------------- SETUP METRICS ----------------------------------
+	// ---------------------------------------------------------------------------------
+
 	// ---------------------------------------------------------------------------------
 	/// BEGIN ------------------------- SETUP REPOS ------------------------------------
 	// ---------------------------------------------------------------------------------
-	subnetCache := subnet.NewCache(redisClient, logger)
-	subnetRepo := subnet.NewRepository(pgPool, logger)
+	subnetCache := subnet.NewCache(redisClient, appMetrics, logger)
+	subnetRepo := subnet.NewRepository(pgDB, appMetrics, logger)
 
-	subnetProvider := subnet.NewProvider(subnetRepo, subnetCache, logger)
+	subnetProvider := subnet.NewProvider(subnetRepo, subnetCache, appMetrics, logger)
+	auditRepo := audit.NewRepository(pgDB, appMetrics, logger)
+	settingsRepo := settings.NewRepository(pgDB, appMetrics, logger)
+	transactor := storage.NewTransactor(pgDB)
+	go appMetrics.TrackListSizes(rootCtx, subnetRepo, metrics.DefaultListSizeInterval, logger)
+	// sources blacklisted by honeypot logins expire
+	go subnetProvider.RunExpiry(rootCtx, subnet.DefaultExpiryInterval)
 	// ---------------------------------------------------------------------------------
 	// ENDOF -------------------------- SETUP REPOS ------------------------------------
 	// ---------------------------------------------------------------------------------
@@ -115,7 +232,7 @@ func main() {
 	// ---------------------------------------------------------------------------------
 	/// BEGIN ----------------------- SETUP RATE LIMITER --------------------------------
 	// ---------------------------------------------------------------------------------
-	rateLimitStorage := ratelimit.NewStorage(redisClient, time.Minute, logger)
+	rateLimitStorage := ratelimit.NewStorage(redisClient, appConf.RateLimitWindow, appMetrics, logger)
 	rateLimitConfig := antibruteforceService.RateLimitConfig{
 		LoginLimit:    appConf.LoginRateLimit,
 		PasswordLimit: appConf.PasswordRateLimit,
@@ -125,64 +242,438 @@ func main() {
 	// ENDOF ------------------------ SETUP RATE LIMITER --------------------------------
 	// ---------------------------------------------------------------------------------
 
+	// ---------------------------------------------------------------------------------
+	// BEGIN ---------------------- SETUP DECISION EVENTS ------------------------------
+	// ---------------------------------------------------------------------------------
+	decisionBroadcaster := events.NewBroadcaster()
+	eventSinks := []events.Sink{decisionBroadcaster}
+
+	if appConf.Events.File != "" {
+		fileSink, err := events.NewFileSink(appConf.Events.File)
+		if err != nil {
+			logger.Error("failed to set up events file", "error", err)
+
+			return
+		}
+		eventSinks = append(eventSinks, fileSink)
+	}
+
+	if appConf.Events.SyslogAddr != "" {
+		syslogSink, err := events.NewSyslogSink(appConf.Events.SyslogAddr)
+		if err != nil {
+			logger.Error("failed to set up syslog events", "error", err)
+
+			return
+		}
+		eventSinks = append(eventSinks, syslogSink)
+	}
+
+	if appConf.Events.WebhookURL != "" {
+		eventSinks = append(eventSinks, events.NewWebhookSink(appConf.Events.WebhookURL, &http.Client{}))
+	}
+
+	decisionEmitter := events.NewEmitter(logger, appConf.Events.BufferSize, appConf.Events.IncludeAllowed, eventSinks...)
+	appMetrics.MustRegister(metrics.NewEventSinkCollector(decisionEmitter))
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := decisionEmitter.Close(closeCtx); err != nil {
+			logger.Warn("failed to flush decision events", "error", err)
+		}
+	}()
+	// ---------------------------------------------------------------------------------
+	// ENDOF ---------------------- SETUP DECISION EVENTS ------------------------------
+	// ---------------------------------------------------------------------------------
+
+	// ---------------------------------------------------------------------------------
+	// BEGIN ------------------------ SETUP DEGRADATION --------------------------------
+	// ---------------------------------------------------------------------------------
+	degradation := antibruteforceService.DegradationPolicy{
+		SubnetLists: appConf.Degradation.Postgres,
+		RateLimits:  appConf.Degradation.Redis,
+	}
+
+	// the snapshot also finds blacklisted subnets near an IP for risk scores, so it always runs
+	subnetSnapshot := subnet.NewSnapshot(subnetRepo, logger)
+	go subnetSnapshot.Run(rootCtx, subnet.DefaultSnapshotInterval)
+	if degradation.SubnetLists == antibruteforceService.FailurePolicyFallback {
+		degradation.SubnetFallback = subnetSnapshot
+	}
+
+	var rateLimitFallback *ratelimit.LocalStorage
+	if degradation.RateLimits == antibruteforceService.FailurePolicyFallback {
+		rateLimitFallback = ratelimit.NewLocalStorage(appConf.RateLimitWindow)
+		degradation.RateLimitFallback = rateLimitFallback
+	}
+
+	var breachedPasswords antibruteforceService.BreachedPasswords = antibruteforceService.NopBreachedPasswords{}
+	if appConf.Breached.CorpusFile != "" {
+		// the index is built in the background, passwords are not flagged until it is ready
+		corpus := breached.NewCorpus(appConf.Breached.CorpusFile, appConf.Breached.IndexFile, appMetrics, logger)
+		go corpus.Run(rootCtx, breached.DefaultRefreshInterval)
+		breachedPasswords = corpus
+	}
+	// ---------------------------------------------------------------------------------
+	// ENDOF ------------------------ SETUP DEGRADATION --------------------------------
+	// ---------------------------------------------------------------------------------
+
 	// ---------------------------------------------------------------------------------
 	/// BEGIN ----------------------- SETUP SERVICES ------------------------------------
 	// ---------------------------------------------------------------------------------
-	antiBruteForceSvc := antibruteforceService.NewService(logger, subnetProvider, rateLimitStorage, rateLimitConfig)
-	managementSvc := managementService.NewService(logger, subnetProvider, subnetRepo, rateLimitStorage)
+	// honeypot sources are blacklisted in the background, so a burst of them does not slow down CheckAccess
+	honeypotQueue := antibruteforceService.NewHoneypotQueue(
+		subnetProvider, logger, antibruteforceService.DefaultHoneypotQueueSize)
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := honeypotQueue.Close(closeCtx); err != nil {
+			logger.Warn("failed to blacklist queued honeypot sources", "error", err)
+		}
+	}()
+
+	antiBruteForceSvc := antibruteforceService.NewService(
+		antibruteforceService.Dependencies{
+			Logger:            logger,
+			SubnetProvider:    subnetProvider,
+			RateLimitStorage:  rateLimitStorage,
+			HistoryStorage:    rateLimitStorage,
+			BlacklistIndex:    subnetSnapshot,
+			BreachedPasswords: breachedPasswords,
+			HoneypotBlacklist: honeypotQueue,
+			DecisionObserver:  appMetrics,
+			DecisionEmitter:   decisionEmitter,
+		},
+		rateLimitConfig,
+		degradation,
+	)
+	antiBruteForceSvc.SetShadowPolicy(appConf.Shadow)
+	antiBruteForceSvc.SetChallengePolicy(appConf.Challenge)
+	antiBruteForceSvc.SetStuffingPolicy(appConf.Stuffing)
+	antiBruteForceSvc.SetRiskWeights(appConf.RiskWeights)
+	rateLimitStorage.SetDistinctWindow(distinctWindow(appConf.Stuffing))
+	antiBruteForceSvc.SetSprayPolicy(appConf.Spray)
+	antiBruteForceSvc.SetBreachedAction(appConf.Breached.Action)
+	antiBruteForceSvc.SetHoneypotBlacklistTTL(appConf.HoneypotBlacklistTTL)
+	rateLimitStorage.SetPasswordKey([]byte(appConf.SprayHMACKey))
+	rateLimitStorage.SetSprayTracking(sprayTracking(appConf.Spray))
+	managementSvc := managementService.NewService(managementService.Dependencies{
+		Logger:            logger,
+		SubnetProvider:    subnetProvider,
+		SubnetRepository:  subnetRepo,
+		RateLimitResetter: rateLimitStorage,
+		StuffingSources:   rateLimitStorage,
+		SprayAlerts:       rateLimitStorage,
+		Transactor:        transactor,
+		AuditLog:          auditRepo,
+		LimitsStore:       settingsRepo,
+		LimitRulesStore:   settingsRepo,
+		TenantStore:       settingsRepo,
+		HoneypotStore:     settingsRepo,
+		ConfiguredLimits:  antiBruteForceSvc,
+	})
 	// ---------------------------------------------------------------------------------
 	// ENDOF ------------------------ SETUP SERVICES -----------------------------------
 	// ---------------------------------------------------------------------------------
 
+	// ---------------------------------------------------------------------------------
+	// BEGIN ---------------------- SETUP LIMIT OVERRIDES ------------------------------
+	// ---------------------------------------------------------------------------------
+	// overrides, rules, tenants and honeypot logins set through the management API on any replica reach this one
+	// by notification, all of them are small, so any change reloads everything
+	loadSettings := func(ctx context.Context, _ string) {
+		overrides, err := settingsRepo.GetLimits(ctx)
+		if err != nil {
+			logger.Error("failed to load limit overrides, keeping previous ones", "error", err)
+		} else {
+			antiBruteForceSvc.OverrideLimits(overrides)
+		}
+
+		rules, err := settingsRepo.ListLimitRules(ctx)
+		if err == nil {
+			err = antiBruteForceSvc.SetLimitRules(rules)
+		}
+		if err != nil {
+			logger.Error("failed to load limit rules, keeping previous ones", "error", err)
+		}
+
+		tenants, err := settingsRepo.ListTenants(ctx)
+		if err != nil {
+			logger.Error("failed to load tenants, keeping previous ones", "error", err)
+		} else {
+			antiBruteForceSvc.SetTenants(tenants)
+		}
+
+		honeypotLogins, err := settingsRepo.ListHoneypotLogins(ctx)
+		if err != nil {
+			logger.Error("failed to load honeypot logins, keeping previous ones", "error", err)
+		} else {
+			antiBruteForceSvc.SetHoneypotLogins(honeypotLogins)
+		}
+	}
+	// settings are loaded before serving, so attempts of existing tenants are not rejected while LISTEN connects
+	loadSettings(rootCtx, "")
+	settingsListener := settings.NewListener(pgPool, settings.DefaultRetryInterval, logger)
+	go settingsListener.Run(rootCtx, loadSettings)
+	// ---------------------------------------------------------------------------------
+	// ENDOF ---------------------- SETUP LIMIT OVERRIDES ------------------------------
+	// ---------------------------------------------------------------------------------
+
+	// ---------------------------------------------------------------------------------
+	// BEGIN -------------------------- SETUP HEALTH -----------------------------------
+	// ---------------------------------------------------------------------------------
+	prober := health.NewProber(logger, health.DefaultInterval, health.DefaultTimeout)
+	prober.AddDependency("postgres", pgDB.Ping)
+	prober.AddDependency("redis", func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
+	})
+	prober.AddService(pbAntiBruteForce.AntiBruteforce_ServiceDesc.ServiceName,
+		checkAccessDependencies(appConf.Degradation)...)
+	prober.AddService(pbManagement.BruteforceManagement_ServiceDesc.ServiceName, "postgres", "redis")
+	go prober.Run(rootCtx)
+	// ---------------------------------------------------------------------------------
+	// ENDOF -------------------------- SETUP HEALTH -----------------------------------
+	// ---------------------------------------------------------------------------------
+
+	// ---------------------------------------------------------------------------------
+	// BEGIN ----------------------- SETUP CONFIG RELOAD -------------------------------
+	// ---------------------------------------------------------------------------------
+	configWatcher := epConfig.NewWatcher(appConf, func(conf epConfig.Reloadable) error {
+		// the service validates, so it goes first and nothing else changes when it rejects
+		err := antiBruteForceSvc.Reconfigure(
+			antibruteforceService.RateLimitConfig{
+				LoginLimit:    conf.LoginRateLimit,
+				PasswordLimit: conf.PasswordRateLimit,
+				IPLimit:       conf.IPRateLimit,
+			},
+			conf.Degradation.Postgres,
+			conf.Degradation.Redis,
+		)
+		if err != nil {
+			return err
+		}
+		antiBruteForceSvc.SetShadowPolicy(conf.Shadow)
+		antiBruteForceSvc.SetChallengePolicy(conf.Challenge)
+		antiBruteForceSvc.SetStuffingPolicy(conf.Stuffing)
+		antiBruteForceSvc.SetSprayPolicy(conf.Spray)
+		antiBruteForceSvc.SetBreachedAction(conf.BreachedAction)
+		antiBruteForceSvc.SetHoneypotBlacklistTTL(conf.HoneypotBlacklistTTL)
+		antiBruteForceSvc.SetRiskWeights(conf.RiskWeights)
+
+		rateLimitStorage.SetWindow(conf.RateLimitWindow)
+		rateLimitStorage.SetDistinctWindow(distinctWindow(conf.Stuffing))
+		rateLimitStorage.SetPasswordKey([]byte(conf.SprayHMACKey))
+		rateLimitStorage.SetSprayTracking(sprayTracking(conf.Spray))
+		if rateLimitFallback != nil {
+			rateLimitFallback.SetWindow(conf.RateLimitWindow)
+		}
+		logLevel.Set(conf.SlogLevel)
+		prober.AddService(pbAntiBruteForce.AntiBruteforce_ServiceDesc.ServiceName,
+			checkAccessDependencies(conf.Degradation)...)
+
+		return nil
+	}, logger)
+	go configWatcher.Run(rootCtx, epConfig.DefaultReloadInterval)
+	// ---------------------------------------------------------------------------------
+	// ENDOF ----------------------- SETUP CONFIG RELOAD -------------------------------
+	// ---------------------------------------------------------------------------------
+
 	// ---------------------------------------------------------------------------------
 	// BEGIN -------------------------- SETUP GRPC SERVER ------------------------------
 	// ---------------------------------------------------------------------------------
-	addr := fmt.Sprintf(":%d", appConf.Port)
-	listenerConfig := net.ListenConfig{}
-	listener, err := listenerConfig.Listen(rootCtx, "tcp", addr)
-	if err != nil {
-		logger.Error(err.Error())
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		interceptor.UnaryRequestMetrics(appMetrics),
+		interceptor.UnaryErrorMapper(logger),
+		interceptor.UnaryRPCName(),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		interceptor.StreamRequestMetrics(appMetrics),
+		interceptor.StreamErrorMapper(logger),
+		interceptor.StreamRPCName(),
+	}
+
+	if appConf.AuthConfigFile != "" {
+		authConf, err := auth.LoadConfig(appConf.AuthConfigFile)
+		if err != nil {
+			logger.Error("failed to load auth config", "error", err)
+
+			return
+		}
+
+		authenticator, err := auth.NewAuthenticator(authConf)
+		if err != nil {
+			logger.Error("invalid auth config", "error", err)
+
+			return
+		}
+
+		unaryInterceptors = append(unaryInterceptors,
+			interceptor.UnaryAuthorization(logger, authenticator, grpcAntibruteforce.ManagementRequiredRole))
+		streamInterceptors = append(streamInterceptors,
+			interceptor.StreamAuthorization(logger, authenticator, grpcAntibruteforce.ManagementRequiredRole))
+	} else if appConf.ManagementInsecure {
+		logger.Warn("management API is not protected, set " + epConfig.AuthConfigFileKey + " to enable authentication")
+	} else {
+		logger.Error("management API requires authentication, set " + epConfig.AuthConfigFileKey +
+			" or, for local development only, " + epConfig.ManagementInsecureKey + "=true")
 
 		return
 	}
 
-	server := grpc.NewServer()
-	antiBruteForceGrpcService := grpcAntibruteforce.NewService(antiBruteForceSvc)
-	managementGrpcService := grpcAntibruteforce.NewManagement(managementSvc)
+	serverOpts := []grpc.ServerOption{
+		grpc.StatsHandler(tracing.ServerStatsHandler()),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+
+	if appConf.TLS != nil {
+		reloader, err := tlsreload.NewReloader(appConf.TLS.CertFile, appConf.TLS.KeyFile, appConf.TLS.ClientCAFile, logger)
+		if err != nil {
+			logger.Error("failed to load TLS certificates", "error", err)
+
+			return
+		}
+		go reloader.Run(rootCtx, tlsreload.DefaultInterval)
+
+		tlsConf := reloader.ServerConfig(appConf.TLS.RequireClientCert)
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	} else {
+		logger.Warn("TLS is disabled, credentials are sent in plaintext")
+	}
+
+	server := grpc.NewServer(serverOpts...)
+	servers := []namedServer{{name: "grpc", port: appConf.Port, server: server}}
+
+	managementServer := server
+	if appConf.ManagementPort != 0 {
+		managementServer = grpc.NewServer(serverOpts...)
+		servers = append(servers, namedServer{
+			name:   "management grpc",
+			port:   appConf.ManagementPort,
+			server: managementServer,
+		})
+	}
+
+	antiBruteForceGrpcService := grpcAntibruteforce.NewService(antiBruteForceSvc, prober)
+	managementGrpcService := grpcAntibruteforce.NewManagement(managementSvc, decisionBroadcaster)
 
 	pbAntiBruteForce.RegisterAntiBruteforceServer(server, antiBruteForceGrpcService)
-	pbManagement.RegisterBruteforceManagementServer(server, managementGrpcService)
+	pbManagement.RegisterBruteforceManagementServer(managementServer, managementGrpcService)
+
+	for _, s := range servers {
+		healthpb.RegisterHealthServer(s.server, prober.Server())
+		reflection.Register(s.server)
+	}
 	// ---------------------------------------------------------------------------------
 	// ENDOF -------------------------- SETUP GRPC SERVER ------------------------------
 	// ---------------------------------------------------------------------------------
 
+	// ---------------------------------------------------------------------------------
+	// BEGIN -------------------------- SETUP HTTP SERVER ------------------------------
+	// ---------------------------------------------------------------------------------
+	var httpServer *http.Server
+	if appConf.MetricsPort != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", appMetrics.Handler())
+		mux.Handle("/livez", health.LivenessHandler())
+		mux.Handle("/readyz", prober.ReadinessHandler())
+
+		httpServer = &http.Server{
+			Addr:              fmt.Sprintf(":%d", appConf.MetricsPort),
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+	}
+	// ---------------------------------------------------------------------------------
+	// ENDOF -------------------------- SETUP HTTP SERVER ------------------------------
+	// ---------------------------------------------------------------------------------
+
 	// ---------------------------------------------------------------------------------
 	// BEGIN ----------------------------- RUN SERVER ----------------------------------
 	// ---------------------------------------------------------------------------------
-	serverErrChan := make(chan error, 1)
-	go func() {
-		logger.Info("starting grpc server", "addr", addr)
-		serverErrChan <- server.Serve(listener)
-	}()
+	listenerConfig := net.ListenConfig{}
+	serverErrChan := make(chan error, len(servers))
 
-	select {
-	case err := <-serverErrChan:
+	if httpServer != nil {
+		go func() {
+			logger.Info("starting http server", "addr", httpServer.Addr)
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("http server failed", "error", err)
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = httpServer.Shutdown(shutdownCtx)
+		}()
+	}
+	for _, s := range servers {
+		addr := fmt.Sprintf(":%d", s.port)
+		listener, err := listenerConfig.Listen(rootCtx, "tcp", addr)
 		if err != nil {
 			logger.Error(err.Error())
 
 			return
 		}
+
+		go func() {
+			logger.Info("starting "+s.name+" server", "addr", addr)
+			serverErrChan <- s.server.Serve(listener)
+		}()
+	}
+
+	select {
+	case err := <-serverErrChan:
+		if err != nil {
+			logger.Error(err.Error())
+		}
+		for _, s := range servers {
+			s.server.Stop()
+		}
 	case <-rootCtx.Done():
 		logger.Debug("received shutdown signal")
-		server.GracefulStop()
-		err := <-serverErrChan
-		if err != nil {
-			logger.Warn(err.Error())
-		} else {
-			logger.Info("server stopped gracefully")
+		// WatchDecisions streams never end on their own and would block GracefulStop
+		_ = decisionBroadcaster.Close()
+		for _, s := range servers {
+			s.server.GracefulStop()
+		}
+		for range servers {
+			if err := <-serverErrChan; err != nil {
+				logger.Warn(err.Error())
+			}
 		}
+		logger.Info("server stopped gracefully")
 	}
 	// ---------------------------------------------------------------------------------
 	// ENDOF ----------------------------- RUN SERVER ----------------------------------
 	// ---------------------------------------------------------------------------------
 }
+
+// checkAccessDependencies lists dependencies CheckAccess cannot answer without,
+// it keeps answering without those that have a degradation policy.
+func checkAccessDependencies(degradation epConfig.DegradationConfiguration) []string {
+	var dependencies []string
+	if degradation.Postgres == antibruteforceService.FailurePolicyError {
+		dependencies = append(dependencies, "postgres")
+	}
+	if degradation.Redis == antibruteforceService.FailurePolicyError {
+		dependencies = append(dependencies, "redis")
+	}
+
+	return dependencies
+}
+
+// distinctWindow keeps Redis from counting distinct logins while no stuffing limit needs them.
+func distinctWindow(policy antibruteforceService.StuffingPolicy) time.Duration {
+	if !policy.Enabled() {
+		return 0
+	}
+	return policy.Window
+}
+
+// sprayTracking keeps Redis from counting distinct logins per password while no spray policy needs them.
+func sprayTracking(policy antibruteforceService.SprayPolicy) ratelimit.SprayTracking {
+	if !policy.Enabled() {
+		return ratelimit.SprayTracking{}
+	}
+	return ratelimit.SprayTracking{Window: policy.Window}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	epConfig "github.com/FluVirus2/antibruteforce/cmd/server/configuration"
+	"github.com/FluVirus2/antibruteforce/internal/storage/migrate"
+	"github.com/FluVirus2/antibruteforce/pkg/configuration"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func migrateUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s migrate <command>\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  up        Apply all pending migrations\n")
+	fmt.Fprintf(os.Stderr, "  down      Revert the latest applied migration\n")
+	fmt.Fprintf(os.Stderr, "  status    List migrations and when they were applied\n")
+	fmt.Fprintf(os.Stderr, "\nEnvironment:\n")
+	fmt.Fprintf(os.Stderr, "  %s    Postgres to migrate\n", epConfig.PgsqlConnectionStringKey)
+	fmt.Fprintf(os.Stderr, "  %s                Configuration file the connection string may come from instead\n",
+		epConfig.ConfigFileKey)
+}
+
+// runMigrate implements the migrate subcommand and returns the exit code.
+func runMigrate(args []string) int {
+	if len(args) != 1 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		migrateUsage()
+		return 2
+	}
+
+	connString, err := epConfig.ReadPgsqlConnectionString()
+	if err != nil {
+		var confErr *configuration.CorruptedConfigurationError
+		if errors.As(err, &confErr) {
+			fmt.Fprintf(os.Stderr, "%s is not set\n", strings.Join(confErr.Keys, ", "))
+		} else {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to init pgx pool: %v\n", err)
+		return 1
+	}
+	defer pool.Close()
+
+	migrator, err := migrate.New(pool, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("Applied %d migrations\n", applied)
+
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if reverted == nil {
+			fmt.Println("Nothing to revert")
+		} else {
+			fmt.Printf("Reverted %04d_%s\n", reverted.Version, reverted.Name)
+		}
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied() {
+				appliedAt = s.AppliedAt.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		_ = w.Flush()
+	}
+
+	return 0
+}
@@ -0,0 +1,73 @@
+package interceptor
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/FluVirus2/antibruteforce/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RequiredRoleFunc returns the role needed to call fullMethod, or false if the method is not protected.
+type RequiredRoleFunc func(fullMethod string) (auth.Role, bool)
+
+type Authenticator interface {
+	Authenticate(ctx context.Context) (auth.Identity, error)
+}
+
+func authorize(
+	ctx context.Context,
+	logger *slog.Logger,
+	authenticator Authenticator,
+	requiredRole RequiredRoleFunc,
+	fullMethod string,
+) (context.Context, error) {
+	required, protected := requiredRole(fullMethod)
+	if !protected {
+		return ctx, nil
+	}
+
+	identity, err := authenticator.Authenticate(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "valid credentials are required")
+	}
+
+	if !identity.Role.Allows(required) {
+		logger.Warn("permission denied", "method", fullMethod, "identity", identity.Name,
+			"role", identity.Role.String(), "required", required.String())
+
+		return nil, status.Errorf(codes.PermissionDenied, "role %q is required", required.String())
+	}
+
+	return auth.WithIdentity(ctx, identity), nil
+}
+
+func UnaryAuthorization(
+	logger *slog.Logger,
+	authenticator Authenticator,
+	requiredRole RequiredRoleFunc,
+) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authorize(ctx, logger, authenticator, requiredRole, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamAuthorization(
+	logger *slog.Logger,
+	authenticator Authenticator,
+	requiredRole RequiredRoleFunc,
+) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), logger, authenticator, requiredRole, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
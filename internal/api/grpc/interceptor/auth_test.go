@@ -0,0 +1,147 @@
+package interceptor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const protectedMethod = "/test.Management/Reset"
+
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func requireOperator(fullMethod string) (auth.Role, bool) {
+	if fullMethod == protectedMethod {
+		return auth.RoleOperator, true
+	}
+	return 0, false
+}
+
+//nolint:funlen
+func TestUnaryAuthorization(t *testing.T) {
+	t.Parallel()
+
+	authenticator, err := auth.NewAuthenticator(&auth.Config{
+		Tokens: []auth.TokenEntry{
+			{Name: "viewer", TokenSHA256: tokenHash("viewer-token"), Role: "viewer"},
+			{Name: "operator", TokenSHA256: tokenHash("operator-token"), Role: "operator"},
+			{Name: "admin", TokenSHA256: tokenHash("admin-token"), Role: "admin"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator() unexpected error = %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	unary := UnaryAuthorization(logger, authenticator, requireOperator)
+
+	tests := []struct {
+		Name             string
+		Method           string
+		Authorization    string
+		ExpectedCode     codes.Code
+		ExpectedIdentity string
+	}{
+		{
+			Name:         "unprotected method needs no credentials",
+			Method:       "/test.Public/Check",
+			ExpectedCode: codes.OK,
+		},
+		{
+			Name:         "missing token",
+			Method:       protectedMethod,
+			ExpectedCode: codes.Unauthenticated,
+		},
+		{
+			Name:          "unknown token",
+			Method:        protectedMethod,
+			Authorization: "Bearer guessed-token",
+			ExpectedCode:  codes.Unauthenticated,
+		},
+		{
+			Name:          "role too low",
+			Method:        protectedMethod,
+			Authorization: "Bearer viewer-token",
+			ExpectedCode:  codes.PermissionDenied,
+		},
+		{
+			Name:             "exact role",
+			Method:           protectedMethod,
+			Authorization:    "Bearer operator-token",
+			ExpectedCode:     codes.OK,
+			ExpectedIdentity: "operator",
+		},
+		{
+			Name:             "higher role",
+			Method:           protectedMethod,
+			Authorization:    "bearer admin-token",
+			ExpectedCode:     codes.OK,
+			ExpectedIdentity: "admin",
+		},
+	}
+
+	for _, testcase := range tests {
+		t.Run(testcase.Name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if testcase.Authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", testcase.Authorization))
+			}
+
+			var identity auth.Identity
+			handler := func(ctx context.Context, _ any) (any, error) {
+				identity, _ = auth.IdentityFromContext(ctx)
+				return struct{}{}, nil
+			}
+
+			_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: testcase.Method}, handler)
+
+			if code := status.Code(err); code != testcase.ExpectedCode {
+				t.Errorf("UnaryAuthorization() code = %v, want %v", code, testcase.ExpectedCode)
+			}
+
+			if identity.Name != testcase.ExpectedIdentity {
+				t.Errorf("UnaryAuthorization() identity = %q, want %q", identity.Name, testcase.ExpectedIdentity)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticatorRejectsInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	configs := map[string]*auth.Config{
+		"unknown role": {
+			Tokens: []auth.TokenEntry{{Name: "t", TokenSHA256: tokenHash("t"), Role: "root"}},
+		},
+		"plaintext token": {
+			Tokens: []auth.TokenEntry{{Name: "t", TokenSHA256: "t", Role: "admin"}},
+		},
+		"unknown certificate role": {
+			Certificates: []auth.CertificateEntry{{CommonName: "cn", Role: "superuser"}},
+		},
+	}
+
+	for name, conf := range configs {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := auth.NewAuthenticator(conf); err == nil {
+				t.Errorf("NewAuthenticator() expected error for %s", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const ErrorDomain = "antibruteforce"
+
+type errorMapping struct {
+	err    error
+	code   codes.Code
+	reason string
+}
+
+// domainErrors is checked in order, the first match wins.
+var domainErrors = []errorMapping{
+	{service.ErrInvalidIP, codes.InvalidArgument, "INVALID_IP"},
+	{service.ErrInvalidCIDR, codes.InvalidArgument, "INVALID_CIDR"},
+	{service.ErrInvalidLogin, codes.InvalidArgument, "INVALID_LOGIN"},
+	{service.ErrInvalidPassword, codes.InvalidArgument, "INVALID_PASSWORD"},
+	{service.ErrBatchTooLarge, codes.InvalidArgument, "BATCH_TOO_LARGE"},
+	{service.ErrInvalidTimeRange, codes.InvalidArgument, "INVALID_TIME_RANGE"},
+	{service.ErrInvalidLimit, codes.InvalidArgument, "INVALID_LIMIT"},
+	{service.ErrNoLimits, codes.InvalidArgument, "NO_LIMITS"},
+	{service.ErrNoRuleSelector, codes.InvalidArgument, "NO_RULE_SELECTOR"},
+	{service.ErrInvalidTenant, codes.InvalidArgument, "INVALID_TENANT"},
+	{service.ErrUnknownTenant, codes.InvalidArgument, "UNKNOWN_TENANT"},
+	{service.ErrSubnetNotFound, codes.NotFound, "SUBNET_NOT_FOUND"},
+	{service.ErrBucketNotFound, codes.NotFound, "BUCKET_NOT_FOUND"},
+	{service.ErrLimitsChangeNotFound, codes.NotFound, "LIMITS_CHANGE_NOT_FOUND"},
+	{service.ErrLimitRuleNotFound, codes.NotFound, "LIMIT_RULE_NOT_FOUND"},
+	{service.ErrTenantNotFound, codes.NotFound, "TENANT_NOT_FOUND"},
+	{service.ErrHoneypotLoginNotFound, codes.NotFound, "HONEYPOT_LOGIN_NOT_FOUND"},
+	{service.ErrRateLimitExceeded, codes.ResourceExhausted, "RATE_LIMIT_EXCEEDED"},
+	{service.ErrChallengesDisabled, codes.FailedPrecondition, "CHALLENGES_DISABLED"},
+}
+
+// ToStatus converts an error returned by the service layer to a gRPC status.
+// Only messages of known domain errors reach the client, everything else is replaced with a generic message.
+func ToStatus(err error) *status.Status {
+	if err == nil {
+		return nil
+	}
+
+	if st, ok := apiStatus(err); ok {
+		return st
+	}
+
+	for _, m := range domainErrors {
+		if errors.Is(err, m.err) {
+			return newStatus(m.code, m.err.Error(), m.reason, badRequest(err))
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return newStatus(codes.DeadlineExceeded, "deadline exceeded", "DEADLINE_EXCEEDED", nil)
+	case errors.Is(err, context.Canceled):
+		return newStatus(codes.Canceled, "request canceled", "CANCELED", nil)
+	case storage.IsUnavailable(err):
+		return newStatus(codes.Unavailable, "backend temporarily unavailable", "BACKEND_UNAVAILABLE", nil)
+	default:
+		return newStatus(codes.Internal, "internal error", "INTERNAL", nil)
+	}
+}
+
+// apiStatus passes through statuses the API layer returned as they are. Wrapped statuses may come from a
+// dependency and Internal or Unknown ones may carry internal details, so they are mapped like other errors.
+func apiStatus(err error) (*status.Status, bool) {
+	grpcErr, ok := err.(interface{ GRPCStatus() *status.Status }) //nolint:errorlint // wrapped statuses are mapped
+	if !ok {
+		return nil, false
+	}
+
+	st := grpcErr.GRPCStatus()
+	if st.Code() == codes.Internal || st.Code() == codes.Unknown {
+		return nil, false
+	}
+
+	return st, true
+}
+
+func badRequest(err error) *errdetails.BadRequest {
+	var invalidArgErr *service.InvalidArgumentError
+	if !errors.As(err, &invalidArgErr) {
+		return nil
+	}
+
+	return &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{
+				Field:       invalidArgErr.Field,
+				Description: invalidArgErr.Err.Error(),
+			},
+		},
+	}
+}
+
+func newStatus(code codes.Code, msg, reason string, badReq *errdetails.BadRequest) *status.Status {
+	st := status.New(code, msg)
+
+	info := &errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: ErrorDomain,
+	}
+
+	var withDetails *status.Status
+	var err error
+	if badReq != nil {
+		withDetails, err = st.WithDetails(info, badReq)
+	} else {
+		withDetails, err = st.WithDetails(info)
+	}
+	if err != nil {
+		return st
+	}
+
+	return withDetails
+}
+
+func logMappedError(ctx context.Context, logger *slog.Logger, method string, err error, st *status.Status) {
+	level := slog.LevelDebug
+	if st.Code() == codes.Internal || st.Code() == codes.Unavailable {
+		level = slog.LevelError
+	}
+
+	logger.Log(ctx, level, "request failed", "method", method, "code", st.Code().String(), "error", err)
+}
+
+// UnaryErrorMapper converts errors returned by unary handlers with ToStatus and logs the original error.
+func UnaryErrorMapper(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		st := ToStatus(err)
+		logMappedError(ctx, logger, info.FullMethod, err, st)
+
+		return nil, st.Err()
+	}
+}
+
+// StreamErrorMapper is the streaming counterpart of UnaryErrorMapper.
+func StreamErrorMapper(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		if err == nil {
+			return nil
+		}
+
+		st := ToStatus(err)
+		logMappedError(ss.Context(), logger, info.FullMethod, err, st)
+
+		return st.Err()
+	}
+}
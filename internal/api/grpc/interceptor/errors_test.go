@@ -0,0 +1,189 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//nolint:funlen
+func TestToStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name           string
+		Err            error
+		ExpectedCode   codes.Code
+		ExpectedReason string
+		ExpectedField  string
+	}{
+		{
+			Name:           "invalid IP with field",
+			Err:            service.NewInvalidArgumentError("ip", service.ErrInvalidIP),
+			ExpectedCode:   codes.InvalidArgument,
+			ExpectedReason: "INVALID_IP",
+			ExpectedField:  "ip",
+		},
+		{
+			Name:           "invalid CIDR with field",
+			Err:            fmt.Errorf("wrapped: %w", service.NewInvalidArgumentError("subnet.cidr", service.ErrInvalidCIDR)),
+			ExpectedCode:   codes.InvalidArgument,
+			ExpectedReason: "INVALID_CIDR",
+			ExpectedField:  "subnet.cidr",
+		},
+		{
+			Name:           "invalid login",
+			Err:            service.ErrInvalidLogin,
+			ExpectedCode:   codes.InvalidArgument,
+			ExpectedReason: "INVALID_LOGIN",
+		},
+		{
+			Name:           "invalid password",
+			Err:            service.ErrInvalidPassword,
+			ExpectedCode:   codes.InvalidArgument,
+			ExpectedReason: "INVALID_PASSWORD",
+		},
+		{
+			Name:           "batch too large",
+			Err:            service.NewInvalidArgumentError("requests", service.ErrBatchTooLarge),
+			ExpectedCode:   codes.InvalidArgument,
+			ExpectedReason: "BATCH_TOO_LARGE",
+			ExpectedField:  "requests",
+		},
+		{
+			Name:           "subnet not found",
+			Err:            service.ErrSubnetNotFound,
+			ExpectedCode:   codes.NotFound,
+			ExpectedReason: "SUBNET_NOT_FOUND",
+		},
+		{
+			Name:           "bucket not found",
+			Err:            service.ErrBucketNotFound,
+			ExpectedCode:   codes.NotFound,
+			ExpectedReason: "BUCKET_NOT_FOUND",
+		},
+		{
+			Name:           "rate limit exceeded",
+			Err:            service.ErrRateLimitExceeded,
+			ExpectedCode:   codes.ResourceExhausted,
+			ExpectedReason: "RATE_LIMIT_EXCEEDED",
+		},
+		{
+			Name:           "challenges disabled",
+			Err:            service.ErrChallengesDisabled,
+			ExpectedCode:   codes.FailedPrecondition,
+			ExpectedReason: "CHALLENGES_DISABLED",
+		},
+		{
+			Name:           "deadline exceeded",
+			Err:            fmt.Errorf("query: %w", context.DeadlineExceeded),
+			ExpectedCode:   codes.DeadlineExceeded,
+			ExpectedReason: "DEADLINE_EXCEEDED",
+		},
+		{
+			Name:           "canceled",
+			Err:            fmt.Errorf("query: %w", context.Canceled),
+			ExpectedCode:   codes.Canceled,
+			ExpectedReason: "CANCELED",
+		},
+		{
+			Name:           "redis client closed",
+			Err:            fmt.Errorf("%w: %w", service.ErrSubnetCheckFailed, redis.ErrClosed),
+			ExpectedCode:   codes.Unavailable,
+			ExpectedReason: "BACKEND_UNAVAILABLE",
+		},
+		{
+			Name:           "connection refused",
+			Err:            fmt.Errorf("dial: %w", syscall.ECONNREFUSED),
+			ExpectedCode:   codes.Unavailable,
+			ExpectedReason: "BACKEND_UNAVAILABLE",
+		},
+		{
+			Name:           "wrapped status",
+			Err:            fmt.Errorf("secret call: %w", status.Error(codes.NotFound, "secret row")),
+			ExpectedCode:   codes.Internal,
+			ExpectedReason: "INTERNAL",
+		},
+		{
+			Name:           "internal status",
+			Err:            status.Error(codes.Internal, "secret internal details"),
+			ExpectedCode:   codes.Internal,
+			ExpectedReason: "INTERNAL",
+		},
+		{
+			Name:           "unknown status",
+			Err:            status.Error(codes.Unknown, "secret internal details"),
+			ExpectedCode:   codes.Internal,
+			ExpectedReason: "INTERNAL",
+		},
+		{
+			Name:           "unknown error",
+			Err:            errors.New("secret internal details"),
+			ExpectedCode:   codes.Internal,
+			ExpectedReason: "INTERNAL",
+		},
+	}
+
+	for _, testcase := range tests {
+		t.Run(testcase.Name, func(t *testing.T) {
+			t.Parallel()
+
+			st := ToStatus(testcase.Err)
+
+			if st.Code() != testcase.ExpectedCode {
+				t.Errorf("ToStatus() code = %v, want %v", st.Code(), testcase.ExpectedCode)
+			}
+
+			if strings.Contains(st.Message(), "secret") || strings.Contains(st.Message(), "wrapped") {
+				t.Errorf("ToStatus() message leaks internal details: %q", st.Message())
+			}
+
+			var info *errdetails.ErrorInfo
+			var badReq *errdetails.BadRequest
+			for _, detail := range st.Details() {
+				switch d := detail.(type) {
+				case *errdetails.ErrorInfo:
+					info = d
+				case *errdetails.BadRequest:
+					badReq = d
+				}
+			}
+
+			if info == nil || info.GetReason() != testcase.ExpectedReason || info.GetDomain() != ErrorDomain {
+				t.Errorf("ToStatus() error info = %v, want reason %q", info, testcase.ExpectedReason)
+			}
+
+			if testcase.ExpectedField == "" {
+				if badReq != nil {
+					t.Errorf("ToStatus() unexpected bad request details: %v", badReq)
+				}
+				return
+			}
+
+			if badReq == nil || len(badReq.GetFieldViolations()) != 1 ||
+				badReq.GetFieldViolations()[0].GetField() != testcase.ExpectedField {
+				t.Errorf("ToStatus() bad request = %v, want field %q", badReq, testcase.ExpectedField)
+			}
+		})
+	}
+}
+
+func TestToStatusKeepsExistingStatus(t *testing.T) {
+	t.Parallel()
+
+	err := status.Error(codes.PermissionDenied, "permission denied")
+
+	st := ToStatus(err)
+	if st.Code() != codes.PermissionDenied || st.Message() != "permission denied" {
+		t.Errorf("ToStatus() = %v, want the original status", st)
+	}
+}
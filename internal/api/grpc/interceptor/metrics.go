@@ -0,0 +1,36 @@
+package interceptor
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type RequestObserver interface {
+	ObserveRequest(method string, code codes.Code, duration time.Duration)
+}
+
+// UnaryRequestMetrics must run after the error mapper, so the observed code is the one the client receives.
+func UnaryRequestMetrics(observer RequestObserver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observer.ObserveRequest(info.FullMethod, status.Code(err), time.Since(start))
+
+		return resp, err
+	}
+}
+
+// StreamRequestMetrics observes the whole lifetime of a stream.
+func StreamRequestMetrics(observer RequestObserver) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observer.ObserveRequest(info.FullMethod, status.Code(err), time.Since(start))
+
+		return err
+	}
+}
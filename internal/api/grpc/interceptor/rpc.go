@@ -0,0 +1,33 @@
+package interceptor
+
+import (
+	"context"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"google.golang.org/grpc"
+)
+
+// UnaryRPCName makes the full method name available to services through service.RPCFromContext.
+func UnaryRPCName() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(service.WithRPC(ctx, info.FullMethod), req)
+	}
+}
+
+// StreamRPCName is UnaryRPCName for streaming calls.
+func StreamRPCName() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: service.WithRPC(ss.Context(), info.FullMethod)})
+	}
+}
+
+// contextStream replaces the context of a stream with one carrying values added by an interceptor.
+type contextStream struct {
+	grpc.ServerStream
+
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
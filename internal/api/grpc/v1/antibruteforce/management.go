@@ -2,40 +2,45 @@ package antibruteforce
 
 import (
 	"context"
-	"errors"
 
 	grpc_v1 "github.com/FluVirus2/antibruteforce/api/gen/v1/antibruteforce_management"
-	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
 	"github.com/FluVirus2/antibruteforce/internal/service/management"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"github.com/FluVirus2/antibruteforce/internal/storage/audit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// DecisionSubscriber hands out live access decisions, cancel must be called once the stream ends.
+type DecisionSubscriber interface {
+	Subscribe() (decisions <-chan antibruteforce.Decision, cancel func())
+}
+
 type Management struct {
 	grpc_v1.UnimplementedBruteforceManagementServer
 
 	managementSvc *management.Service
+	decisions     DecisionSubscriber
 }
 
-func NewManagement(managementSvc *management.Service) *Management {
+func NewManagement(managementSvc *management.Service, decisions DecisionSubscriber) *Management {
 	return &Management{
 		managementSvc: managementSvc,
+		decisions:     decisions,
 	}
 }
 
 func (s *Management) AddIPToWhiteList(ctx context.Context, req *grpc_v1.SubnetRequest) (*emptypb.Empty, error) {
-	if err := s.managementSvc.AddToWhitelist(ctx, req.GetSubnet().GetCidr()); err != nil {
+	if err := s.managementSvc.AddToWhitelist(ctx, req.GetTenant(), req.GetSubnet().GetCidr()); err != nil {
 		return nil, err
 	}
 	return &emptypb.Empty{}, nil
 }
 
 func (s *Management) RemoveIPFromWhiteList(ctx context.Context, req *grpc_v1.SubnetRequest) (*emptypb.Empty, error) {
-	if err := s.managementSvc.RemoveFromWhitelist(ctx, req.GetSubnet().GetCidr()); err != nil {
-		if errors.Is(err, service.ErrSubnetNotFound) {
-			return nil, status.Errorf(codes.NotFound, "subnet %q not found in whitelist", req.GetSubnet().GetCidr())
-		}
+	if err := s.managementSvc.RemoveFromWhitelist(ctx, req.GetTenant(), req.GetSubnet().GetCidr()); err != nil {
 		return nil, err
 	}
 	return &emptypb.Empty{}, nil
@@ -46,7 +51,7 @@ func (s *Management) ListIPAddressWhiteList(ctx context.Context, req *grpc_v1.Li
 	offset := req.GetPagination().GetOffset()
 	limit := req.GetPagination().GetLimit()
 
-	subnets, err := s.managementSvc.ListWhitelist(ctx, offset, limit)
+	subnets, err := s.managementSvc.ListWhitelist(ctx, req.GetTenant(), offset, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -55,17 +60,14 @@ func (s *Management) ListIPAddressWhiteList(ctx context.Context, req *grpc_v1.Li
 }
 
 func (s *Management) AddIPToBlackList(ctx context.Context, req *grpc_v1.SubnetRequest) (*emptypb.Empty, error) {
-	if err := s.managementSvc.AddToBlacklist(ctx, req.GetSubnet().GetCidr()); err != nil {
+	if err := s.managementSvc.AddToBlacklist(ctx, req.GetTenant(), req.GetSubnet().GetCidr()); err != nil {
 		return nil, err
 	}
 	return &emptypb.Empty{}, nil
 }
 
 func (s *Management) RemoveIPFromBlackList(ctx context.Context, req *grpc_v1.SubnetRequest) (*emptypb.Empty, error) {
-	if err := s.managementSvc.RemoveFromBlacklist(ctx, req.GetSubnet().GetCidr()); err != nil {
-		if errors.Is(err, service.ErrSubnetNotFound) {
-			return nil, status.Errorf(codes.NotFound, "subnet %q not found in blacklist", req.GetSubnet().GetCidr())
-		}
+	if err := s.managementSvc.RemoveFromBlacklist(ctx, req.GetTenant(), req.GetSubnet().GetCidr()); err != nil {
 		return nil, err
 	}
 	return &emptypb.Empty{}, nil
@@ -76,7 +78,7 @@ func (s *Management) ListIPAddressBlackList(ctx context.Context, req *grpc_v1.Li
 	offset := req.GetPagination().GetOffset()
 	limit := req.GetPagination().GetLimit()
 
-	subnets, err := s.managementSvc.ListBlacklist(ctx, offset, limit)
+	subnets, err := s.managementSvc.ListBlacklist(ctx, req.GetTenant(), offset, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +88,7 @@ func (s *Management) ListIPAddressBlackList(ctx context.Context, req *grpc_v1.Li
 
 //nolint:lll
 func (s *Management) ResetBucketByIP(ctx context.Context, req *grpc_v1.ResetBucketByIPRequest) (*grpc_v1.ResetBucketResponse, error) {
-	wasDone, err := s.managementSvc.ResetBucketByIP(ctx, req.GetIp())
+	wasDone, err := s.managementSvc.ResetBucketByIP(ctx, req.GetTenant(), req.GetIp())
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +97,7 @@ func (s *Management) ResetBucketByIP(ctx context.Context, req *grpc_v1.ResetBuck
 
 //nolint:lll
 func (s *Management) ResetBucketByLogin(ctx context.Context, req *grpc_v1.ResetBucketByLoginRequest) (*grpc_v1.ResetBucketResponse, error) {
-	wasDone, err := s.managementSvc.ResetBucketByLogin(ctx, req.GetLogin())
+	wasDone, err := s.managementSvc.ResetBucketByLogin(ctx, req.GetTenant(), req.GetLogin())
 	if err != nil {
 		return nil, err
 	}
@@ -104,9 +106,331 @@ func (s *Management) ResetBucketByLogin(ctx context.Context, req *grpc_v1.ResetB
 
 //nolint:lll
 func (s *Management) ResetBucketByPassword(ctx context.Context, req *grpc_v1.ResetBucketByPasswordRequest) (*grpc_v1.ResetBucketResponse, error) {
-	wasDone, err := s.managementSvc.ResetBucketByPassword(ctx, req.GetPassword())
+	wasDone, err := s.managementSvc.ResetBucketByPassword(ctx, req.GetTenant(), req.GetPassword())
 	if err != nil {
 		return nil, err
 	}
 	return &grpc_v1.ResetBucketResponse{WasDone: wasDone}, nil
 }
+
+//nolint:lll
+func (s *Management) ListStuffingSources(ctx context.Context, req *grpc_v1.ListStuffingSourcesRequest) (*grpc_v1.ListStuffingSourcesResponse, error) {
+	sources, err := s.managementSvc.ListStuffingSources(ctx, req.GetTenant(), req.GetLimit())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &grpc_v1.ListStuffingSourcesResponse{Sources: make([]*grpc_v1.StuffingSource, len(sources))}
+	for i, source := range sources {
+		resp.Sources[i] = &grpc_v1.StuffingSource{
+			Kind:           source.Kind,
+			Source:         source.Source,
+			DistinctLogins: source.DistinctLogins,
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *Management) GetSprayAlert(ctx context.Context, _ *emptypb.Empty) (*grpc_v1.SprayAlert, error) {
+	alert, active, err := s.managementSvc.GetSprayAlert(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return &grpc_v1.SprayAlert{}, nil
+	}
+
+	return &grpc_v1.SprayAlert{
+		Active:    true,
+		Tenant:    alert.Tenant,
+		RaisedAt:  timestamppb.New(alert.RaisedAt),
+		ExpiresAt: timestamppb.New(alert.ExpiresAt),
+	}, nil
+}
+
+//nolint:lll
+func (s *Management) ClearSprayAlert(ctx context.Context, _ *emptypb.Empty) (*grpc_v1.ClearSprayAlertResponse, error) {
+	wasActive, err := s.managementSvc.ClearSprayAlert(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &grpc_v1.ClearSprayAlertResponse{WasActive: wasActive}, nil
+}
+
+//nolint:lll
+func (s *Management) ListAuditEvents(ctx context.Context, req *grpc_v1.ListAuditEventsRequest) (*grpc_v1.ListAuditEventsResponse, error) {
+	filter := audit.Filter{
+		Actor:  req.GetActor(),
+		RPC:    req.GetRpc(),
+		Result: req.GetResult(),
+		Offset: req.GetPagination().GetOffset(),
+		Limit:  req.GetPagination().GetLimit(),
+	}
+	if req.GetSince() != nil {
+		filter.Since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		filter.Until = req.GetUntil().AsTime()
+	}
+
+	events, err := s.managementSvc.ListAuditEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &grpc_v1.ListAuditEventsResponse{Events: make([]*grpc_v1.AuditEvent, len(events))}
+	for i, event := range events {
+		resp.Events[i] = &grpc_v1.AuditEvent{
+			Id:            event.ID,
+			Time:          timestamppb.New(event.CreatedAt),
+			Actor:         event.Actor,
+			Rpc:           event.RPC,
+			ArgumentsJson: string(event.Arguments),
+			BeforeJson:    string(event.Before),
+			AfterJson:     string(event.After),
+			Result:        event.Result,
+			Error:         event.Error,
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *Management) GetLimits(ctx context.Context, _ *emptypb.Empty) (*grpc_v1.LimitsResponse, error) {
+	limits, err := s.managementSvc.GetLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return toLimitsResponse(limits), nil
+}
+
+//nolint:lll
+func (s *Management) UpdateLimits(ctx context.Context, req *grpc_v1.UpdateLimitsRequest) (*grpc_v1.LimitsResponse, error) {
+	var limits management.Limits
+	var err error
+	if req.GetResetOverrides() {
+		limits, err = s.managementSvc.ResetLimits(ctx)
+	} else {
+		limits, err = s.managementSvc.UpdateLimits(ctx, fromLimits(req.GetLimits()))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toLimitsResponse(limits), nil
+}
+
+//nolint:lll
+func (s *Management) ListLimitsHistory(ctx context.Context, req *grpc_v1.ListLimitsHistoryRequest) (*grpc_v1.ListLimitsHistoryResponse, error) {
+	offset := req.GetPagination().GetOffset()
+	limit := req.GetPagination().GetLimit()
+
+	entries, err := s.managementSvc.ListLimitsHistory(ctx, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &grpc_v1.ListLimitsHistoryResponse{Changes: make([]*grpc_v1.LimitsChange, len(entries))}
+	for i, entry := range entries {
+		resp.Changes[i] = &grpc_v1.LimitsChange{
+			Id:     entry.ID,
+			Time:   timestamppb.New(entry.CreatedAt),
+			Actor:  entry.Actor,
+			Before: toLimits(entry.Before),
+			After:  toLimits(entry.After),
+		}
+	}
+
+	return resp, nil
+}
+
+//nolint:lll
+func (s *Management) RevertLimits(ctx context.Context, req *grpc_v1.RevertLimitsRequest) (*grpc_v1.LimitsResponse, error) {
+	limits, err := s.managementSvc.RevertLimits(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toLimitsResponse(limits), nil
+}
+
+//nolint:lll
+func (s *Management) ListLimitRules(ctx context.Context, _ *emptypb.Empty) (*grpc_v1.ListLimitRulesResponse, error) {
+	rules, err := s.managementSvc.ListLimitRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &grpc_v1.ListLimitRulesResponse{Rules: make([]*grpc_v1.LimitRule, len(rules))}
+	for i, rule := range rules {
+		resp.Rules[i] = toLimitRule(rule)
+	}
+
+	return resp, nil
+}
+
+func (s *Management) SetLimitRule(ctx context.Context, req *grpc_v1.SetLimitRuleRequest) (*grpc_v1.LimitRule, error) {
+	rule, err := s.managementSvc.SetLimitRule(ctx, settings.LimitRule{
+		Subnet:   req.GetRule().GetSubnet(),
+		ClientID: req.GetRule().GetClientId(),
+		Limits:   fromLimits(req.GetRule().GetLimits()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toLimitRule(rule), nil
+}
+
+func (s *Management) RemoveLimitRule(ctx context.Context, req *grpc_v1.RemoveLimitRuleRequest) (*emptypb.Empty, error) {
+	if err := s.managementSvc.RemoveLimitRule(ctx, req.GetSubnet(), req.GetClientId()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+//nolint:lll
+func (s *Management) ListTenants(ctx context.Context, _ *emptypb.Empty) (*grpc_v1.ListTenantsResponse, error) {
+	tenants, err := s.managementSvc.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &grpc_v1.ListTenantsResponse{Tenants: make([]*grpc_v1.Tenant, len(tenants))}
+	for i, tenant := range tenants {
+		resp.Tenants[i] = toTenant(tenant)
+	}
+
+	return resp, nil
+}
+
+func (s *Management) SetTenant(ctx context.Context, req *grpc_v1.SetTenantRequest) (*grpc_v1.Tenant, error) {
+	tenant := settings.Tenant{Name: req.GetTenant().GetName(), Limits: fromLimits(req.GetTenant().GetLimits())}
+	if err := s.managementSvc.SetTenant(ctx, tenant); err != nil {
+		return nil, err
+	}
+	return toTenant(tenant), nil
+}
+
+func (s *Management) DeleteTenant(ctx context.Context, req *grpc_v1.DeleteTenantRequest) (*emptypb.Empty, error) {
+	if err := s.managementSvc.DeleteTenant(ctx, req.GetName()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+//nolint:lll
+func (s *Management) ListHoneypotLogins(ctx context.Context, _ *emptypb.Empty) (*grpc_v1.ListHoneypotLoginsResponse, error) {
+	logins, err := s.managementSvc.ListHoneypotLogins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &grpc_v1.ListHoneypotLoginsResponse{Logins: logins}, nil
+}
+
+func (s *Management) AddHoneypotLogin(ctx context.Context, req *grpc_v1.HoneypotLoginRequest) (*emptypb.Empty, error) {
+	if err := s.managementSvc.AddHoneypotLogin(ctx, req.GetLogin()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+//nolint:lll
+func (s *Management) RemoveHoneypotLogin(ctx context.Context, req *grpc_v1.HoneypotLoginRequest) (*emptypb.Empty, error) {
+	if err := s.managementSvc.RemoveHoneypotLogin(ctx, req.GetLogin()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func toTenant(tenant settings.Tenant) *grpc_v1.Tenant {
+	return &grpc_v1.Tenant{Name: tenant.Name, Limits: toLimits(tenant.Limits)}
+}
+
+func toLimitRule(rule settings.LimitRule) *grpc_v1.LimitRule {
+	return &grpc_v1.LimitRule{Subnet: rule.Subnet, ClientId: rule.ClientID, Limits: toLimits(rule.Limits)}
+}
+
+func toLimits(limits settings.Limits) *grpc_v1.Limits {
+	return &grpc_v1.Limits{Login: limits.Login, Password: limits.Password, Ip: limits.IP}
+}
+
+func fromLimits(limits *grpc_v1.Limits) settings.Limits {
+	return settings.Limits{Login: limits.GetLogin(), Password: limits.GetPassword(), IP: limits.GetIp()}
+}
+
+func toLimitsResponse(limits management.Limits) *grpc_v1.LimitsResponse {
+	return &grpc_v1.LimitsResponse{
+		Effective:  toLimits(limits.Effective),
+		Configured: toLimits(limits.Configured),
+		Overrides:  toLimits(limits.Overrides),
+	}
+}
+
+// WatchDecisions streams decisions until the client goes away. Decisions are dropped
+// for clients that read slower than they are produced.
+//
+//nolint:lll
+func (s *Management) WatchDecisions(req *grpc_v1.WatchDecisionsRequest, stream grpc.ServerStreamingServer[grpc_v1.DecisionEvent]) error {
+	ctx := stream.Context()
+	filter := map[string]any{
+		"denied_only": req.GetDeniedOnly(),
+		"ip":          req.GetIp(),
+		"login":       req.GetLogin(),
+		"tenant":      req.GetTenant(),
+	}
+	if err := s.managementSvc.AuditWatch(ctx, filter); err != nil {
+		return err
+	}
+
+	decisions, cancel := s.decisions.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case decision, ok := <-decisions:
+			if !ok {
+				return nil
+			}
+
+			if !matchesWatchRequest(req, decision) {
+				continue
+			}
+
+			event := &grpc_v1.DecisionEvent{
+				Time:     timestamppb.New(decision.Time),
+				Ip:       decision.IP,
+				Login:    decision.Login,
+				Allowed:  decision.Result.Allowed(),
+				Reason:   decision.Result.Reason(),
+				Degraded: decision.Degraded,
+				Tenant:   decision.Tenant,
+				Decision: decision.Result.Outcome(),
+				Severity: decision.Result.Severity().String(),
+			}
+			if decision.Shadow != 0 {
+				event.Shadow = decision.Shadow.String()
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func matchesWatchRequest(req *grpc_v1.WatchDecisionsRequest, decision antibruteforce.Decision) bool {
+	if req.GetDeniedOnly() && decision.Result.Allowed() {
+		return false
+	}
+	if req.GetIp() != "" && req.GetIp() != decision.IP {
+		return false
+	}
+	if req.GetLogin() != "" && req.GetLogin() != decision.Login {
+		return false
+	}
+	if req.GetTenant() != "" && req.GetTenant() != decision.Tenant {
+		return false
+	}
+	return true
+}
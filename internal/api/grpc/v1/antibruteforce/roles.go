@@ -0,0 +1,60 @@
+package antibruteforce
+
+import (
+	"strings"
+
+	grpc_v1 "github.com/FluVirus2/antibruteforce/api/gen/v1/antibruteforce_management"
+	"github.com/FluVirus2/antibruteforce/internal/auth"
+)
+
+var managementMethodPrefix = "/" + grpc_v1.BruteforceManagement_ServiceDesc.ServiceName + "/"
+
+// managementRoles lists the minimal role per management RPC.
+// Whitelist changes bypass every other check, so they are reserved for admins,
+// as are tenants and the audit log that reveals who changed what.
+// Operators may tighten or loosen limits during an attack.
+var managementRoles = map[string]auth.Role{
+	grpc_v1.BruteforceManagement_ListIPAddressWhiteList_FullMethodName: auth.RoleViewer,
+	grpc_v1.BruteforceManagement_ListIPAddressBlackList_FullMethodName: auth.RoleViewer,
+	grpc_v1.BruteforceManagement_GetLimits_FullMethodName:              auth.RoleViewer,
+	grpc_v1.BruteforceManagement_ListLimitRules_FullMethodName:         auth.RoleViewer,
+	grpc_v1.BruteforceManagement_ListTenants_FullMethodName:            auth.RoleViewer,
+	grpc_v1.BruteforceManagement_ListStuffingSources_FullMethodName:    auth.RoleViewer,
+	grpc_v1.BruteforceManagement_GetSprayAlert_FullMethodName:          auth.RoleViewer,
+	grpc_v1.BruteforceManagement_ListHoneypotLogins_FullMethodName:     auth.RoleViewer,
+
+	grpc_v1.BruteforceManagement_AddIPToBlackList_FullMethodName:      auth.RoleOperator,
+	grpc_v1.BruteforceManagement_RemoveIPFromBlackList_FullMethodName: auth.RoleOperator,
+	grpc_v1.BruteforceManagement_ResetBucketByIP_FullMethodName:       auth.RoleOperator,
+	grpc_v1.BruteforceManagement_ResetBucketByLogin_FullMethodName:    auth.RoleOperator,
+	grpc_v1.BruteforceManagement_ResetBucketByPassword_FullMethodName: auth.RoleOperator,
+	grpc_v1.BruteforceManagement_WatchDecisions_FullMethodName:        auth.RoleOperator,
+	grpc_v1.BruteforceManagement_UpdateLimits_FullMethodName:          auth.RoleOperator,
+	grpc_v1.BruteforceManagement_ListLimitsHistory_FullMethodName:     auth.RoleOperator,
+	grpc_v1.BruteforceManagement_RevertLimits_FullMethodName:          auth.RoleOperator,
+	grpc_v1.BruteforceManagement_SetLimitRule_FullMethodName:          auth.RoleOperator,
+	grpc_v1.BruteforceManagement_RemoveLimitRule_FullMethodName:       auth.RoleOperator,
+	grpc_v1.BruteforceManagement_ClearSprayAlert_FullMethodName:       auth.RoleOperator,
+	grpc_v1.BruteforceManagement_AddHoneypotLogin_FullMethodName:      auth.RoleOperator,
+	grpc_v1.BruteforceManagement_RemoveHoneypotLogin_FullMethodName:   auth.RoleOperator,
+
+	grpc_v1.BruteforceManagement_AddIPToWhiteList_FullMethodName:      auth.RoleAdmin,
+	grpc_v1.BruteforceManagement_RemoveIPFromWhiteList_FullMethodName: auth.RoleAdmin,
+	grpc_v1.BruteforceManagement_ListAuditEvents_FullMethodName:       auth.RoleAdmin,
+	grpc_v1.BruteforceManagement_SetTenant_FullMethodName:             auth.RoleAdmin,
+	grpc_v1.BruteforceManagement_DeleteTenant_FullMethodName:          auth.RoleAdmin,
+}
+
+// ManagementRequiredRole protects every BruteforceManagement RPC.
+// Methods missing in managementRoles require admin, so new RPCs are never left open by mistake.
+func ManagementRequiredRole(fullMethod string) (auth.Role, bool) {
+	if !strings.HasPrefix(fullMethod, managementMethodPrefix) {
+		return 0, false
+	}
+
+	if role, ok := managementRoles[fullMethod]; ok {
+		return role, true
+	}
+
+	return auth.RoleAdmin, true
+}
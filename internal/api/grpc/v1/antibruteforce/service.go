@@ -2,18 +2,30 @@ package antibruteforce
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 
 	grpc_v1 "github.com/FluVirus2/antibruteforce/api/gen/v1/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/internal/api/grpc/interceptor"
 	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
+// ServingChecker reports whether dependencies of a gRPC service are healthy.
+type ServingChecker interface {
+	IsServing(service string) bool
+}
+
 type Service struct {
 	grpc_v1.UnimplementedAntiBruteforceServer
 
 	antiBruteForceSvc *antibruteforce.Service
+	servingChecker    ServingChecker
 }
 
 //nolint:lll
@@ -23,50 +35,188 @@ var accessResultToGRPC = map[antibruteforce.AccessResult]grpc_v1.AccessDeniedRea
 	antibruteforce.AccessDeniedTooManyRequestsIP:       grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_TOO_MANY_REQUESTS_IP,
 	antibruteforce.AccessDeniedTooManyRequestsLogin:    grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_TOO_MANY_REQUESTS_LOGIN,
 	antibruteforce.AccessDeniedTooManyRequestsPassword: grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_TOO_MANY_REQUESTS_PASSWORD,
+	antibruteforce.AccessDeniedBackendUnavailable:      grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_BACKEND_UNAVAILABLE,
+	antibruteforce.AccessDeniedCredentialStuffing:      grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_CREDENTIAL_STUFFING,
+	antibruteforce.AccessDeniedBreachedPassword:        grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_BREACHED_PASSWORD,
+	antibruteforce.AccessDeniedHoneypotLogin:           grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_HONEYPOT_LOGIN,
+
+	antibruteforce.AccessChallengedTooManyRequestsIP:       grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_TOO_MANY_REQUESTS_IP,
+	antibruteforce.AccessChallengedTooManyRequestsLogin:    grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_TOO_MANY_REQUESTS_LOGIN,
+	antibruteforce.AccessChallengedTooManyRequestsPassword: grpc_v1.AccessDeniedReason_ACCESS_DENIED_REASON_TOO_MANY_REQUESTS_PASSWORD,
 }
 
-func NewService(antiBruteForceSvc *antibruteforce.Service) *Service {
+func NewService(antiBruteForceSvc *antibruteforce.Service, servingChecker ServingChecker) *Service {
 	return &Service{
 		antiBruteForceSvc: antiBruteForceSvc,
+		servingChecker:    servingChecker,
 	}
 }
 
+// Ping is kept for older clients, new ones should use grpc.health.v1.
 func (s *Service) Ping(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	if !s.servingChecker.IsServing(grpc_v1.AntiBruteforce_ServiceDesc.ServiceName) {
+		return nil, status.Error(codes.Unavailable, "service is not serving")
+	}
 	return &emptypb.Empty{}, nil
 }
 
 //nolint:lll
 func (s *Service) CheckAccess(ctx context.Context, req *grpc_v1.CheckAccessRequest) (*grpc_v1.CheckAccessResponse, error) {
-	ip := req.GetIp()
-	login := req.GetLogin()
-	password := req.GetPassword()
+	verdict, err := s.antiBruteForceSvc.CheckAccess(ctx, toAccessAttempt(req))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check access: %w", err)
+	}
+
+	response := mapVerdictToResponse(verdict)
+
+	return response, nil
+}
+
+//nolint:lll
+func (s *Service) CheckAccessBatch(ctx context.Context, req *grpc_v1.CheckAccessBatchRequest) (*grpc_v1.CheckAccessBatchResponse, error) {
+	requests := req.GetRequests()
 
-	if net.ParseIP(ip) == nil {
-		return nil, fmt.Errorf("invalid IP address: %q", ip)
+	attempts := make([]antibruteforce.AccessAttempt, len(requests))
+	for i, r := range requests {
+		attempts[i] = toAccessAttempt(r)
 	}
 
-	result, err := s.antiBruteForceSvc.CheckAccess(ctx, login, password, ip)
+	verdicts, err := s.antiBruteForceSvc.CheckAccessBatch(ctx, attempts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check access: %w", err)
+		return nil, fmt.Errorf("failed to check access batch: %w", err)
 	}
 
-	response := mapAccessResultToResponse(result)
+	responses := make([]*grpc_v1.CheckAccessResponse, len(verdicts))
+	for i, verdict := range verdicts {
+		if verdict.Invalid != nil {
+			if responses[i] = toCheckAccessError(verdict.Invalid); responses[i] == nil {
+				return nil, fmt.Errorf("failed to check access batch: %w", verdict.Invalid)
+			}
+			continue
+		}
+		responses[i] = mapVerdictToResponse(verdict)
+	}
 
-	return response, nil
+	return &grpc_v1.CheckAccessBatchResponse{Responses: responses}, nil
 }
 
-func mapAccessResultToResponse(result antibruteforce.AccessResult) *grpc_v1.CheckAccessResponse {
-	response := &grpc_v1.CheckAccessResponse{}
+//nolint:lll
+func (s *Service) ReportChallengePassed(ctx context.Context, req *grpc_v1.ReportChallengePassedRequest) (*emptypb.Empty, error) {
+	err := s.antiBruteForceSvc.ReportChallengePassed(ctx, req.GetTenant(), req.GetLogin(), req.GetIp())
+	if err != nil {
+		return nil, fmt.Errorf("failed to report passed challenge: %w", err)
+	}
 
-	if result == antibruteforce.AccessAllowed {
+	return &emptypb.Empty{}, nil
+}
+
+// CheckAccessStream answers every request with exactly the same response CheckAccess would give,
+// in the order requests were received. An invalid request is answered with the error CheckAccess would
+// have returned and the stream goes on, any other error ends the stream.
+func (s *Service) CheckAccessStream(stream grpc_v1.AntiBruteforce_CheckAccessStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.CheckAccess(stream.Context(), req)
+		if err != nil {
+			if resp = toCheckAccessError(err); resp == nil {
+				return err
+			}
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// toCheckAccessError answers a request CheckAccess rejected as invalid, it returns nil for other errors.
+func toCheckAccessError(err error) *grpc_v1.CheckAccessResponse {
+	st := interceptor.ToStatus(err)
+	if st.Code() != codes.InvalidArgument {
+		return nil
+	}
+
+	checkErr := &grpc_v1.CheckAccessError{Message: st.Message()}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			checkErr.Reason = detail.GetReason()
+		case *errdetails.BadRequest:
+			for _, violation := range detail.GetFieldViolations() {
+				checkErr.Field = violation.GetField()
+			}
+		}
+	}
+
+	return &grpc_v1.CheckAccessResponse{Error: checkErr}
+}
+
+func toAccessAttempt(req *grpc_v1.CheckAccessRequest) antibruteforce.AccessAttempt {
+	return antibruteforce.AccessAttempt{
+		Tenant:   req.GetTenant(),
+		Login:    req.GetLogin(),
+		Password: req.GetPassword(),
+		IP:       req.GetIp(),
+		ClientID: req.GetClientId(),
+	}
+}
+
+func mapVerdictToResponse(verdict antibruteforce.Verdict) *grpc_v1.CheckAccessResponse {
+	response := &grpc_v1.CheckAccessResponse{
+		Degraded:         verdict.Degraded,
+		RiskScore:        int32(verdict.Risk.Score), //nolint:gosec // the score is 0-100
+		PasswordBreached: verdict.PasswordBreached,
+	}
+	for _, signal := range verdict.Risk.Signals {
+		response.RiskSignals = append(response.RiskSignals, &grpc_v1.RiskSignal{
+			Name:   signal.Name,
+			Value:  signal.Value,
+			Points: int32(signal.Points), //nolint:gosec // points are 0-100
+		})
+	}
+
+	if verdict.Quotas != (antibruteforce.Quotas{}) {
+		response.IpQuota = mapQuota(verdict.Quotas.IP)
+		response.LoginQuota = mapQuota(verdict.Quotas.Login)
+		response.PasswordQuota = mapQuota(verdict.Quotas.Password)
+	}
+	if retryAfter := verdict.RetryAfter(); retryAfter > 0 {
+		response.RetryAfter = durationpb.New(retryAfter)
+	}
+
+	switch {
+	case verdict.Result.Allowed():
 		response.Allowed = true
+		response.Decision = grpc_v1.Decision_DECISION_ALLOW
+	case verdict.Result.Challenged():
+		response.Decision = grpc_v1.Decision_DECISION_CHALLENGE
+	default:
+		response.Decision = grpc_v1.Decision_DECISION_DENY
 	}
 
-	if reason, ok := accessResultToGRPC[result]; ok {
+	if reason, ok := accessResultToGRPC[verdict.Result]; ok {
 		response.Reason = reason
 	} else {
-		panic(fmt.Sprintf("unexpected access result: %d", result))
+		panic(fmt.Sprintf("unexpected access result: %d", verdict.Result))
 	}
 
 	return response
 }
+
+func mapQuota(quota antibruteforce.Quota) *grpc_v1.Quota {
+	mapped := &grpc_v1.Quota{
+		Limit:     quota.Limit,
+		Remaining: quota.Remaining,
+	}
+	if quota.RetryAfter > 0 {
+		mapped.RetryAfter = durationpb.New(quota.RetryAfter)
+	}
+	return mapped
+}
@@ -0,0 +1,136 @@
+package antibruteforce
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	grpc_v1 "github.com/FluVirus2/antibruteforce/api/gen/v1/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
+	"google.golang.org/grpc"
+)
+
+// unlistedSubnets lists no IP at all.
+type unlistedSubnets struct{}
+
+func (unlistedSubnets) CheckIPInBothLists(context.Context, string, string) (bool, bool, error) {
+	return false, false, nil
+}
+
+func (unlistedSubnets) CheckIPsInBothLists(_ context.Context, _ string, ips []string) ([]subnet.IPCheckResult, error) {
+	return make([]subnet.IPCheckResult, len(ips)), nil
+}
+
+// checkAccessStream replays requests and records responses.
+type checkAccessStream struct {
+	grpc.ServerStream
+
+	requests  []*grpc_v1.CheckAccessRequest
+	responses []*grpc_v1.CheckAccessResponse
+}
+
+func (s *checkAccessStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *checkAccessStream) Recv() (*grpc_v1.CheckAccessRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *checkAccessStream) Send(resp *grpc_v1.CheckAccessResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func TestCheckAccessStreamAnswersInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	checker := antibruteforce.NewService(
+		antibruteforce.Dependencies{
+			SubnetProvider:   unlistedSubnets{},
+			RateLimitStorage: ratelimit.NewLocalStorage(time.Minute),
+		},
+		antibruteforce.RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000},
+		antibruteforce.DegradationPolicy{},
+	)
+	checker.SetTenants(nil)
+	svc := NewService(checker, nil)
+
+	stream := &checkAccessStream{requests: []*grpc_v1.CheckAccessRequest{
+		{Ip: "10.0.0.1", Login: "alice", Password: "secret"},
+		{Ip: "not an ip", Login: "alice", Password: "secret"},
+		{Ip: "10.0.0.2", Login: "bob", Password: "secret"},
+		{Ip: "10.0.0.3", Login: "carol", Password: "secret", Tenant: "acme"},
+	}}
+	if err := svc.CheckAccessStream(stream); err != nil {
+		t.Fatalf("CheckAccessStream() error = %v", err)
+	}
+
+	if len(stream.responses) != 4 {
+		t.Fatalf("CheckAccessStream() sent %d responses, want 4", len(stream.responses))
+	}
+	for _, i := range []int{0, 2} {
+		if resp := stream.responses[i]; !resp.GetAllowed() || resp.GetError() != nil {
+			t.Errorf("response %d = %v, want allowed", i, resp)
+		}
+	}
+
+	checkErr := stream.responses[1].GetError()
+	if checkErr.GetReason() != "INVALID_IP" || checkErr.GetField() != "ip" {
+		t.Errorf("response to the invalid request has error %v, want INVALID_IP for ip", checkErr)
+	}
+	if stream.responses[1].GetDecision() != grpc_v1.Decision_DECISION_UNSPECIFIED {
+		t.Errorf("response to the invalid request has decision %v", stream.responses[1].GetDecision())
+	}
+
+	checkErr = stream.responses[3].GetError()
+	if checkErr.GetReason() != "UNKNOWN_TENANT" || checkErr.GetField() != "tenant" {
+		t.Errorf("response to the request of an unknown tenant has error %v, want UNKNOWN_TENANT for tenant", checkErr)
+	}
+}
+
+func TestCheckAccessBatchAnswersInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(antibruteforce.NewService(
+		antibruteforce.Dependencies{
+			SubnetProvider:   unlistedSubnets{},
+			RateLimitStorage: ratelimit.NewLocalStorage(time.Minute),
+		},
+		antibruteforce.RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000},
+		antibruteforce.DegradationPolicy{},
+	), nil)
+
+	resp, err := svc.CheckAccessBatch(context.Background(), &grpc_v1.CheckAccessBatchRequest{
+		Requests: []*grpc_v1.CheckAccessRequest{
+			{Ip: "10.0.0.1", Login: "alice", Password: "secret"},
+			{Ip: "not an ip", Login: "alice", Password: "secret"},
+			{Ip: "10.0.0.2", Login: "bob", Password: "secret"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() error = %v", err)
+	}
+
+	responses := resp.GetResponses()
+	if len(responses) != 3 {
+		t.Fatalf("CheckAccessBatch() sent %d responses, want 3", len(responses))
+	}
+	for _, i := range []int{0, 2} {
+		if !responses[i].GetAllowed() || responses[i].GetError() != nil {
+			t.Errorf("response %d = %v, want allowed", i, responses[i])
+		}
+	}
+	if checkErr := responses[1].GetError(); checkErr.GetReason() != "INVALID_IP" || checkErr.GetField() != "ip" {
+		t.Errorf("response to the invalid request has error %v, want INVALID_IP for ip", checkErr)
+	}
+}
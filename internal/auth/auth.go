@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+type Role int
+
+const (
+	RoleViewer Role = iota + 1
+	RoleOperator
+	RoleAdmin
+)
+
+var strToRole = map[string]Role{
+	"viewer":   RoleViewer,
+	"operator": RoleOperator,
+	"admin":    RoleAdmin,
+}
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrUnknownRole     = errors.New("unknown role")
+)
+
+func ParseRole(s string) (Role, error) {
+	role, ok := strToRole[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownRole, s)
+	}
+	return role, nil
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+// Allows reports whether r is at least as privileged as required.
+func (r Role) Allows(required Role) bool {
+	return r >= required
+}
+
+type Identity struct {
+	Name string
+	Role Role
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// TokenEntry describes a bearer token. Only the SHA-256 of the token is kept in the file.
+type TokenEntry struct {
+	Name        string `json:"name"`
+	TokenSHA256 string `json:"tokenSha256"`
+	Role        string `json:"role"`
+}
+
+// CertificateEntry maps a verified client certificate to a role by its subject common name.
+type CertificateEntry struct {
+	CommonName string `json:"commonName"`
+	Role       string `json:"role"`
+}
+
+type Config struct {
+	Tokens       []TokenEntry       `json:"tokens"`
+	Certificates []CertificateEntry `json:"certificates"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config %q: %w", path, err)
+	}
+
+	var conf Config
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse auth config %q: %w", path, err)
+	}
+
+	return &conf, nil
+}
+
+type tokenIdentity struct {
+	hash     []byte
+	identity Identity
+}
+
+type Authenticator struct {
+	tokens       []tokenIdentity
+	certificates map[string]Identity
+}
+
+func NewAuthenticator(conf *Config) (*Authenticator, error) {
+	a := &Authenticator{
+		certificates: make(map[string]Identity, len(conf.Certificates)),
+	}
+
+	for _, entry := range conf.Tokens {
+		role, err := ParseRole(entry.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token %q: %w", entry.Name, err)
+		}
+
+		hash, err := hex.DecodeString(entry.TokenSHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("token %q: tokenSha256 must be a hex encoded SHA-256 digest", entry.Name)
+		}
+
+		a.tokens = append(a.tokens, tokenIdentity{
+			hash:     hash,
+			identity: Identity{Name: entry.Name, Role: role},
+		})
+	}
+
+	for _, entry := range conf.Certificates {
+		role, err := ParseRole(entry.Role)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %q: %w", entry.CommonName, err)
+		}
+
+		a.certificates[entry.CommonName] = Identity{Name: "cert:" + entry.CommonName, Role: role}
+	}
+
+	return a, nil
+}
+
+// Authenticate resolves the caller from a bearer token in the "authorization" metadata
+// or, if there is none, from the verified TLS client certificate.
+func (a *Authenticator) Authenticate(ctx context.Context) (Identity, error) {
+	if token, ok := bearerToken(ctx); ok {
+		return a.authenticateToken(token)
+	}
+
+	if cn, ok := verifiedCommonName(ctx); ok {
+		if identity, ok := a.certificates[cn]; ok {
+			return identity, nil
+		}
+	}
+
+	return Identity{}, ErrUnauthenticated
+}
+
+func (a *Authenticator) authenticateToken(token string) (Identity, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	var found *Identity
+	for i := range a.tokens {
+		// every entry is compared so the response time does not depend on which token matched
+		if subtle.ConstantTimeCompare(hash[:], a.tokens[i].hash) == 1 {
+			found = &a.tokens[i].identity
+		}
+	}
+
+	if found == nil {
+		return Identity{}, ErrUnauthenticated
+	}
+
+	return *found, nil
+}
+
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	for _, value := range md.Get("authorization") {
+		scheme, token, found := strings.Cut(value, " ")
+		if found && strings.EqualFold(scheme, "bearer") && token != "" {
+			return token, true
+		}
+	}
+
+	return "", false
+}
+
+func verifiedCommonName(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName, true
+}
@@ -0,0 +1,87 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+)
+
+const subscriberBufferSize = 256
+
+// Broadcaster is a sink that hands decisions to live subscribers such as WatchDecisions streams.
+// A subscriber that does not keep up loses decisions instead of holding back the others.
+type Broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan antibruteforce.Decision]struct{}
+	dropped     atomic.Uint64
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		subscribers: make(map[chan antibruteforce.Decision]struct{}),
+	}
+}
+
+// Subscribe returns a channel of decisions and a function that must be called to unsubscribe.
+// The channel is closed after unsubscribing or when the broadcaster is closed.
+func (b *Broadcaster) Subscribe() (<-chan antibruteforce.Decision, func()) {
+	ch := make(chan antibruteforce.Decision, subscriberBufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if _, ok := b.subscribers[ch]; ok {
+				delete(b.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+
+	return ch, cancel
+}
+
+func (b *Broadcaster) Name() string {
+	return "stream"
+}
+
+func (b *Broadcaster) Write(_ context.Context, decisions []antibruteforce.Decision) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		for _, decision := range decisions {
+			select {
+			case ch <- decision:
+			default:
+				b.dropped.Add(1)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *Broadcaster) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+func (b *Broadcaster) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+)
+
+const (
+	DefaultBufferSize = 1024
+
+	maxBatchSize = 256
+	writeTimeout = 5 * time.Second
+)
+
+// Sink delivers decisions to an external system. Write is only ever called from one goroutine at a time.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, decisions []antibruteforce.Decision) error
+	Close() error
+}
+
+// SinkStats are cumulative counters of one sink.
+type SinkStats struct {
+	Name      string
+	Delivered uint64
+	// Dropped counts decisions discarded because the sink's buffer was full.
+	Dropped uint64
+	// Failed counts decisions that were taken from the buffer but could not be written.
+	Failed uint64
+}
+
+// droppingSink is implemented by sinks that drop decisions on their own, e.g. for slow subscribers.
+type droppingSink interface {
+	Dropped() uint64
+}
+
+type queue struct {
+	sink      Sink
+	ch        chan antibruteforce.Decision
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// Emitter fans decisions out to sinks. Every sink has its own bounded buffer and goroutine,
+// so a slow or broken sink loses its own events instead of slowing down CheckAccess or other sinks.
+type Emitter struct {
+	logger         *slog.Logger
+	includeAllowed bool
+	queues         []*queue
+	wg             sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewEmitter starts one writer goroutine per sink. includeAllowed also emits allowed attempts,
+// otherwise only denials are emitted.
+func NewEmitter(logger *slog.Logger, bufferSize int, includeAllowed bool, sinks ...Sink) *Emitter {
+	e := &Emitter{
+		logger:         logger,
+		includeAllowed: includeAllowed,
+		queues:         make([]*queue, len(sinks)),
+	}
+
+	for i, sink := range sinks {
+		q := &queue{
+			sink: sink,
+			ch:   make(chan antibruteforce.Decision, bufferSize),
+		}
+		e.queues[i] = q
+
+		e.wg.Add(1)
+		go e.run(q)
+	}
+
+	return e
+}
+
+// EmitDecision skips allowed decisions unless they are included or the shadow policy would have denied them.
+func (e *Emitter) EmitDecision(decision antibruteforce.Decision) {
+	shadowDenied := decision.Shadow != 0 && !decision.Shadow.Allowed()
+	if decision.Result.Allowed() && !shadowDenied && !e.includeAllowed {
+		return
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return
+	}
+
+	for _, q := range e.queues {
+		select {
+		case q.ch <- decision:
+		default:
+			q.dropped.Add(1)
+		}
+	}
+}
+
+func (e *Emitter) run(q *queue) {
+	defer e.wg.Done()
+
+	batch := make([]antibruteforce.Decision, 0, maxBatchSize)
+	for decision := range q.ch {
+		batch = append(batch[:0], decision)
+	drain:
+		for len(batch) < maxBatchSize {
+			select {
+			case next, ok := <-q.ch:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		err := q.sink.Write(ctx, batch)
+		cancel()
+
+		if err != nil {
+			q.failed.Add(uint64(len(batch)))
+			e.logger.Warn("failed to write decision events", "sink", q.sink.Name(), "events", len(batch), "error", err)
+			continue
+		}
+		q.delivered.Add(uint64(len(batch)))
+	}
+}
+
+// Close stops accepting decisions, flushes buffered ones and closes the sinks.
+// Sinks still flushing when ctx is done are abandoned.
+func (e *Emitter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	for _, q := range e.queues {
+		close(q.ch)
+	}
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var errs []error
+	for _, q := range e.queues {
+		errs = append(errs, q.sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Emitter) SinkStats() []SinkStats {
+	stats := make([]SinkStats, len(e.queues))
+	for i, q := range e.queues {
+		stats[i] = SinkStats{
+			Name:      q.sink.Name(),
+			Delivered: q.delivered.Load(),
+			Dropped:   q.dropped.Load(),
+			Failed:    q.failed.Load(),
+		}
+		if ds, ok := q.sink.(droppingSink); ok {
+			stats[i].Dropped += ds.Dropped()
+		}
+	}
+	return stats
+}
+
+// record is the JSON representation shared by the file and webhook sinks.
+type record struct {
+	Time     time.Time `json:"time"`
+	IP       string    `json:"ip"`
+	Login    string    `json:"login"`
+	Decision string    `json:"decision"`
+	Reason   string    `json:"reason"`
+	Severity string    `json:"severity"`
+	Degraded bool      `json:"degraded"`
+	// Tenant is empty for the default tenant. Tenants share logins, so an event is only identified with it.
+	Tenant string `json:"tenant"`
+	// Shadow is the decision of the shadow policy, e.g. denied_too_many_requests_login.
+	Shadow string `json:"shadow,omitempty"`
+}
+
+func newRecord(decision antibruteforce.Decision) record {
+	rec := record{
+		Time:     decision.Time.UTC(),
+		Tenant:   decision.Tenant,
+		IP:       decision.IP,
+		Login:    decision.Login,
+		Decision: decision.Result.Outcome(),
+		Reason:   decision.Result.Reason(),
+		Severity: decision.Result.Severity().String(),
+		Degraded: decision.Degraded,
+	}
+	if decision.Shadow != 0 {
+		rec.Shadow = decision.Shadow.String()
+	}
+
+	return rec
+}
+
+func marshalRecord(decision antibruteforce.Decision) []byte {
+	// record only holds strings and a time, so marshalling cannot fail
+	data, _ := json.Marshal(newRecord(decision))
+	return data
+}
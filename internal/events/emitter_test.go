@@ -0,0 +1,206 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+)
+
+type recordingSink struct {
+	mu        sync.Mutex
+	decisions []antibruteforce.Decision
+	release   chan struct{}
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Write(_ context.Context, decisions []antibruteforce.Decision) error {
+	if s.release != nil {
+		<-s.release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.decisions = append(s.decisions, decisions...)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func (s *recordingSink) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.decisions)
+}
+
+func denied(login string) antibruteforce.Decision {
+	return antibruteforce.Decision{
+		Time:   time.Now(),
+		IP:     "10.0.0.1",
+		Login:  login,
+		Result: antibruteforce.AccessDeniedTooManyRequestsLogin,
+	}
+}
+
+func TestEmitterSkipsAllowedDecisions(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sink := &recordingSink{}
+	e := NewEmitter(logger, 16, false, sink)
+
+	e.EmitDecision(antibruteforce.Decision{Login: "alice", Result: antibruteforce.AccessAllowed})
+	e.EmitDecision(denied("bob"))
+	e.EmitDecision(antibruteforce.Decision{
+		Login:  "carol",
+		Result: antibruteforce.AccessAllowed,
+		Shadow: antibruteforce.AccessDeniedTooManyRequestsLogin,
+	})
+
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error = %v", err)
+	}
+
+	if len(sink.decisions) != 2 || sink.decisions[0].Login != "bob" || sink.decisions[1].Login != "carol" {
+		t.Errorf("sink got %+v, want the denial of bob and the shadow denial of carol", sink.decisions)
+	}
+}
+
+func TestEmitterDropsWhenSinkIsSlow(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	slow := &recordingSink{release: make(chan struct{})}
+	fast := &recordingSink{}
+	e := NewEmitter(logger, 2, false, slow, fast)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 10 {
+			e.EmitDecision(denied("alice"))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("EmitDecision blocked on a slow sink")
+	}
+
+	close(slow.release)
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error = %v", err)
+	}
+
+	stats := e.SinkStats()
+	if stats[0].Dropped == 0 {
+		t.Errorf("slow sink dropped nothing, stats = %+v", stats[0])
+	}
+	if stats[0].Delivered+stats[0].Dropped != 10 {
+		t.Errorf("slow sink accounted for %d decisions, want 10", stats[0].Delivered+stats[0].Dropped)
+	}
+	if int(stats[0].Delivered) != slow.len() {
+		t.Errorf("slow sink delivered = %d, but received %d", stats[0].Delivered, slow.len())
+	}
+	if stats[1].Delivered+stats[1].Dropped != 10 {
+		t.Errorf("fast sink accounted for %d decisions, want 10", stats[1].Delivered+stats[1].Dropped)
+	}
+}
+
+func TestBroadcasterSubscribe(t *testing.T) {
+	t.Parallel()
+
+	b := NewBroadcaster()
+	decisions, cancel := b.Subscribe()
+
+	if err := b.Write(context.Background(), []antibruteforce.Decision{denied("alice")}); err != nil {
+		t.Fatalf("Write() unexpected error = %v", err)
+	}
+
+	got := <-decisions
+	if got.Login != "alice" {
+		t.Errorf("received %+v, want the denial of alice", got)
+	}
+
+	cancel()
+	if _, ok := <-decisions; ok {
+		t.Errorf("channel is still open after cancel")
+	}
+
+	// cancelling again after Close must not panic on a closed channel
+	_ = b.Close()
+	cancel()
+}
+
+func TestFormatSyslog(t *testing.T) {
+	t.Parallel()
+
+	decision := antibruteforce.Decision{
+		Time:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Tenant: "acme",
+		IP:     "10.0.0.1",
+		Login:  `ev"il]`,
+		Result: antibruteforce.AccessDeniedIPBlacklisted,
+	}
+
+	got := string(formatSyslog("host", decision))
+	want := `<84>1 2026-01-02T03:04:05Z host antibruteforce `
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("header = %q, want prefix %q", got, want)
+	}
+
+	sd := `[decision@32473 tenant="acme" ip="10.0.0.1" login="ev\"il\]" decision="denied" ` +
+		`reason="ip_blacklisted" degraded="false"]`
+	if !strings.Contains(got, sd) {
+		t.Errorf("message %q does not contain %q", got, sd)
+	}
+}
+
+func TestMarshalRecord(t *testing.T) {
+	t.Parallel()
+
+	decision := denied("admin")
+	decision.Tenant = "acme"
+
+	var rec map[string]any
+	if err := json.Unmarshal(marshalRecord(decision), &rec); err != nil {
+		t.Fatalf("marshalRecord() is not JSON: %v", err)
+	}
+	if rec["tenant"] != "acme" || rec["login"] != "admin" {
+		t.Errorf("record = %v, want tenant acme and login admin", rec)
+	}
+}
+
+func TestFormatSyslogSeverity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		result antibruteforce.AccessResult
+		want   string
+	}{
+		{antibruteforce.AccessAllowed, "<86>"},
+		{antibruteforce.AccessChallengedTooManyRequestsLogin, "<84>"},
+		{antibruteforce.AccessDeniedHoneypotLogin, "<82>"},
+	}
+
+	for _, tt := range tests {
+		got := string(formatSyslog("host", antibruteforce.Decision{Result: tt.result}))
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%v: message %q, want priority %s", tt.result, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+)
+
+const (
+	syslogFacilityAuthPriv = 10
+	syslogSeverityCritical = 2
+	syslogSeverityWarning  = 4
+	syslogSeverityInfo     = 6
+
+	syslogAppName = "antibruteforce"
+	syslogMsgID   = "decision"
+	// syslogSDID uses the reserved example enterprise number, SIEM parsers only match on the name.
+	syslogSDID = "decision@32473"
+
+	syslogDialTimeout = 5 * time.Second
+)
+
+// SyslogSink sends RFC 5424 messages. Over TCP messages are octet-counted (RFC 6587),
+// over UDP and unix datagram sockets every message is a separate datagram.
+type SyslogSink struct {
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+}
+
+// NewSyslogSink accepts addresses like udp://host:514, tcp://host:601 or unix:///dev/log.
+// The connection is established lazily and re-established after write errors.
+func NewSyslogSink(addr string) (*SyslogSink, error) {
+	network, address, ok := strings.Cut(addr, "://")
+	if !ok {
+		return nil, fmt.Errorf("syslog address %q has no scheme", addr)
+	}
+
+	switch network {
+	case "udp", "tcp":
+	case "unix":
+		network = "unixgram"
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q", network)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &SyslogSink{
+		network:  network,
+		address:  address,
+		hostname: hostname,
+	}, nil
+}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) Write(ctx context.Context, decisions []antibruteforce.Decision) error {
+	if s.conn == nil {
+		dialer := net.Dialer{Timeout: syslogDialTimeout}
+		conn, err := dialer.DialContext(ctx, s.network, s.address)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		s.conn = conn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.conn.SetWriteDeadline(deadline)
+	}
+
+	for _, decision := range decisions {
+		msg := formatSyslog(s.hostname, decision)
+		if s.network == "tcp" {
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+
+		if _, err := s.conn.Write(msg); err != nil {
+			_ = s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to write to syslog: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func formatSyslog(hostname string, decision antibruteforce.Decision) []byte {
+	severity := syslogSeverityWarning
+	switch decision.Result.Severity() {
+	case antibruteforce.SeverityInfo:
+		severity = syslogSeverityInfo
+	case antibruteforce.SeverityCritical:
+		severity = syslogSeverityCritical
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d %s ",
+		syslogFacilityAuthPriv*8+severity,
+		decision.Time.UTC().Format(time.RFC3339Nano),
+		hostname, syslogAppName, os.Getpid(), syslogMsgID)
+	fmt.Fprintf(&buf, `[%s tenant="%s" ip="%s" login="%s" decision="%s" reason="%s" degraded="%t"`,
+		syslogSDID, escapeSDParam(decision.Tenant), escapeSDParam(decision.IP), escapeSDParam(decision.Login),
+		decision.Result.Outcome(), decision.Result.Reason(), decision.Degraded)
+	if decision.Shadow != 0 {
+		fmt.Fprintf(&buf, ` shadow="%s"`, decision.Shadow.String())
+	}
+	fmt.Fprintf(&buf, "] %s", decision.Result.String())
+
+	return buf.Bytes()
+}
+
+var sdParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func escapeSDParam(value string) string {
+	return sdParamEscaper.Replace(value)
+}
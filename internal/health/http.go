@@ -0,0 +1,40 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// LivenessHandler answers 200 while the process is able to serve HTTP at all.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadinessHandler answers 200 while every dependency passed its last check, 503 otherwise.
+func (p *Prober) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if p.IsServing(OverallService) {
+			w.WriteHeader(http.StatusOK)
+			_, _ = fmt.Fprintln(w, "ok")
+			return
+		}
+
+		errs := p.DependencyErrors()
+		names := make([]string, 0, len(errs))
+		for name := range errs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, name := range names {
+			if errs[name] != nil {
+				_, _ = fmt.Fprintf(w, "%s: %v\n", name, errs[name])
+			}
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	DefaultInterval = 5 * time.Second
+	DefaultTimeout  = 2 * time.Second
+
+	// OverallService is the name grpc.health.v1 clients use to ask about the whole server.
+	OverallService = ""
+)
+
+// Check returns nil when the dependency is usable.
+type Check func(ctx context.Context) error
+
+// Prober periodically runs dependency checks and publishes serving status
+// of every registered gRPC service to a standard health server.
+type Prober struct {
+	logger   *slog.Logger
+	server   *health.Server
+	interval time.Duration
+	timeout  time.Duration
+
+	checks map[string]Check
+
+	mu       sync.RWMutex
+	services map[string][]string
+	results  map[string]error
+}
+
+func NewProber(logger *slog.Logger, interval, timeout time.Duration) *Prober {
+	return &Prober{
+		logger:   logger,
+		server:   health.NewServer(),
+		interval: interval,
+		timeout:  timeout,
+		checks:   make(map[string]Check),
+		services: map[string][]string{OverallService: nil},
+		results:  make(map[string]error),
+	}
+}
+
+// AddDependency registers a check. The overall status depends on every dependency.
+// Must be called before Run.
+func (p *Prober) AddDependency(name string, check Check) {
+	p.checks[name] = check
+	p.services[OverallService] = append(p.services[OverallService], name)
+}
+
+// AddService makes a gRPC service report SERVING only while all of its dependencies are up.
+// It may be called while running to replace the dependencies, they apply from the next probe.
+func (p *Prober) AddService(service string, dependencies ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.services[service] = dependencies
+}
+
+func (p *Prober) Server() *health.Server {
+	return p.server
+}
+
+// Run probes immediately and then every interval until ctx is done, after which every service is NOT_SERVING.
+func (p *Prober) Run(ctx context.Context) {
+	p.ProbeOnce(ctx)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.server.Shutdown()
+			return
+		case <-ticker.C:
+			p.ProbeOnce(ctx)
+		}
+	}
+}
+
+func (p *Prober) ProbeOnce(ctx context.Context) {
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(p.checks))
+	var resultsMu sync.Mutex
+
+	for name, check := range p.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+
+			err := check(checkCtx)
+
+			resultsMu.Lock()
+			results[name] = err
+			resultsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	for name, err := range results {
+		if prev, known := p.results[name]; !known || (prev == nil) != (err == nil) {
+			if err != nil {
+				p.logger.Warn("dependency is down", "dependency", name, "error", err)
+			} else {
+				p.logger.Info("dependency is up", "dependency", name)
+			}
+		}
+		p.results[name] = err
+	}
+	services := make([]string, 0, len(p.services))
+	for service := range p.services {
+		services = append(services, service)
+	}
+	p.mu.Unlock()
+
+	for _, service := range services {
+		p.server.SetServingStatus(service, p.status(service))
+	}
+}
+
+func (p *Prober) status(service string) healthpb.HealthCheckResponse_ServingStatus {
+	if p.IsServing(service) {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// IsServing reports whether all dependencies of service passed their last check.
+func (p *Prober) IsServing(service string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, dependency := range p.services[service] {
+		err, known := p.results[dependency]
+		if !known || err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DependencyErrors returns the last check error of every dependency, nil meaning healthy.
+func (p *Prober) DependencyErrors() map[string]error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	results := make(map[string]error, len(p.results))
+	for name, err := range p.results {
+		results[name] = err
+	}
+
+	return results
+}
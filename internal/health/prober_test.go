@@ -0,0 +1,111 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type switchableCheck struct {
+	down atomic.Bool
+}
+
+func (c *switchableCheck) check(context.Context) error {
+	if c.down.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func servingStatus(t *testing.T, p *Prober, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	resp, err := p.Server().Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q) unexpected error = %v", service, err)
+	}
+	return resp.GetStatus()
+}
+
+func TestProberServingStatus(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	postgres := &switchableCheck{}
+	redis := &switchableCheck{}
+
+	p := NewProber(logger, time.Hour, time.Second)
+	p.AddDependency("postgres", postgres.check)
+	p.AddDependency("redis", redis.check)
+	p.AddService("test.Limits", "redis")
+	p.AddService("test.Lists", "postgres", "redis")
+
+	p.ProbeOnce(context.Background())
+
+	for _, service := range []string{OverallService, "test.Limits", "test.Lists"} {
+		if status := servingStatus(t, p, service); status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("status of %q = %v, want SERVING", service, status)
+		}
+	}
+
+	postgres.down.Store(true)
+	p.ProbeOnce(context.Background())
+
+	expected := map[string]healthpb.HealthCheckResponse_ServingStatus{
+		OverallService: healthpb.HealthCheckResponse_NOT_SERVING,
+		"test.Limits":  healthpb.HealthCheckResponse_SERVING,
+		"test.Lists":   healthpb.HealthCheckResponse_NOT_SERVING,
+	}
+	for service, want := range expected {
+		if status := servingStatus(t, p, service); status != want {
+			t.Errorf("status of %q = %v, want %v", service, status, want)
+		}
+	}
+
+	if err := p.DependencyErrors()["postgres"]; err == nil {
+		t.Errorf("DependencyErrors() has no error for postgres")
+	}
+
+	postgres.down.Store(false)
+	p.ProbeOnce(context.Background())
+
+	if !p.IsServing(OverallService) {
+		t.Errorf("IsServing() = false after recovery, want true")
+	}
+}
+
+func TestProberShutdown(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	p := NewProber(logger, time.Hour, time.Second)
+	p.AddDependency("redis", func(context.Context) error { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servingStatus(t, p, OverallService) != healthpb.HealthCheckResponse_SERVING {
+		if time.Now().After(deadline) {
+			t.Fatalf("prober never reported SERVING")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	if status := servingStatus(t, p, OverallService); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status after shutdown = %v, want NOT_SERVING", status)
+	}
+}
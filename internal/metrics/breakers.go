@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"github.com/FluVirus2/antibruteforce/pkg/breaker"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BreakerSource is implemented by storage.Guard.
+type BreakerSource interface {
+	Name() string
+	Breaker() *breaker.Breaker
+}
+
+type breakerCollector struct {
+	sources []BreakerSource
+
+	state    *prometheus.Desc
+	opened   *prometheus.Desc
+	rejected *prometheus.Desc
+}
+
+func NewBreakerCollector(sources ...BreakerSource) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		fqName := prometheus.BuildFQName(namespace, "circuit_breaker", name)
+		return prometheus.NewDesc(fqName, help, []string{"dependency"}, nil)
+	}
+
+	return &breakerCollector{
+		sources:  sources,
+		state:    desc("state", "Breaker state: 0 closed, 1 half-open, 2 open."),
+		opened:   desc("opened_total", "Times the breaker opened after consecutive failures."),
+		rejected: desc("rejected_total", "Calls failed fast because the breaker was open."),
+	}
+}
+
+func (c *breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.opened
+	ch <- c.rejected
+}
+
+func (c *breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, source := range c.sources {
+		b := source.Breaker()
+		name := source.Name()
+
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, float64(b.State()), name)
+		ch <- prometheus.MustNewConstMetric(c.opened, prometheus.CounterValue, float64(b.Opened()), name)
+		ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(b.Rejected()), name)
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"github.com/FluVirus2/antibruteforce/internal/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SinkStatsSource is implemented by events.Emitter.
+type SinkStatsSource interface {
+	SinkStats() []events.SinkStats
+}
+
+type eventSinkCollector struct {
+	source SinkStatsSource
+
+	delivered *prometheus.Desc
+	dropped   *prometheus.Desc
+	failed    *prometheus.Desc
+}
+
+func NewEventSinkCollector(source SinkStatsSource) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "event_sink", name), help, []string{"sink"}, nil)
+	}
+
+	return &eventSinkCollector{
+		source:    source,
+		delivered: desc("delivered_total", "Decision events written by the sink."),
+		dropped:   desc("dropped_total", "Decision events discarded because the sink could not keep up."),
+		failed:    desc("failed_total", "Decision events lost because the sink returned an error."),
+	}
+}
+
+func (c *eventSinkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.delivered
+	ch <- c.dropped
+	ch <- c.failed
+}
+
+func (c *eventSinkCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range c.source.SinkStats() {
+		ch <- prometheus.MustNewConstMetric(c.delivered, prometheus.CounterValue, float64(stats.Delivered), stats.Name)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(stats.Dropped), stats.Name)
+		ch <- prometheus.MustNewConstMetric(c.failed, prometheus.CounterValue, float64(stats.Failed), stats.Name)
+	}
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/codes"
+)
+
+const namespace = "abf"
+
+const DefaultListSizeInterval = 30 * time.Second
+
+// Metrics owns a dedicated registry, so tests can create as many instances as they need.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	decisions       *prometheus.CounterVec
+	shadowDecisions *prometheus.CounterVec
+	degradedChecks  *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storageDuration *prometheus.HistogramVec
+	cacheLookups    *prometheus.CounterVec
+	listSize        *prometheus.GaugeVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decisions_total",
+			Help:      "CheckAccess decisions by outcome and challenge or deny reason.",
+		}, []string{"decision", "reason"}),
+		shadowDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "shadow_decisions_total",
+			Help:      "Decisions of the shadow policy by outcome, deny reason and whether the enforced one differs.",
+		}, []string{"decision", "reason", "changed"}),
+		degradedChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "degraded_checks_total",
+			Help:      "Dependency failures answered by the degradation policy instead of an error.",
+		}, []string{"dependency", "policy"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Duration of gRPC calls by method and status code.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"method", "code"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_call_duration_seconds",
+			Help:      "Duration of Postgres and Redis calls by storage component and operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"component", "operation", "outcome"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "subnet_cache_lookups_total",
+			Help:      "Subnet provider cache lookups by cache and result.",
+		}, []string{"cache", "result"}),
+		listSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "subnet_list_size",
+			Help:      "Number of subnets in the whitelist and the blacklist.",
+		}, []string{"list"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.decisions,
+		m.shadowDecisions,
+		m.degradedChecks,
+		m.requestDuration,
+		m.storageDuration,
+		m.cacheLookups,
+		m.listSize,
+	)
+
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// MustRegister adds extra collectors, e.g. connection pool stats.
+func (m *Metrics) MustRegister(cs ...prometheus.Collector) {
+	m.registry.MustRegister(cs...)
+}
+
+func (m *Metrics) ObserveDecision(result antibruteforce.AccessResult) {
+	m.decisions.WithLabelValues(result.Outcome(), result.Reason()).Inc()
+}
+
+func (m *Metrics) ObserveShadowDecision(enforced, shadow antibruteforce.AccessResult) {
+	m.shadowDecisions.WithLabelValues(shadow.Outcome(), shadow.Reason(), strconv.FormatBool(enforced != shadow)).Inc()
+}
+
+func (m *Metrics) ObserveDegradation(dependency string, policy antibruteforce.FailurePolicy) {
+	m.degradedChecks.WithLabelValues(dependency, policy.String()).Inc()
+}
+
+func (m *Metrics) ObserveRequest(method string, code codes.Code, duration time.Duration) {
+	m.requestDuration.WithLabelValues(method, code.String()).Observe(duration.Seconds())
+}
+
+func (m *Metrics) ObserveCall(component, operation string, duration time.Duration, err error) {
+	outcome := "ok"
+	if err != nil && !errors.Is(err, storage.ErrCacheMiss) {
+		outcome = "error"
+	}
+	m.storageDuration.WithLabelValues(component, operation, outcome).Observe(duration.Seconds())
+}
+
+func (m *Metrics) ObserveCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+type ListCounter interface {
+	CountByType(ctx context.Context) (whitelistSize, blacklistSize int64, err error)
+}
+
+// TrackListSizes refreshes list size gauges every interval until ctx is done.
+// Sizes are polled instead of computed on scrape, so a slow database never stalls the scrape.
+func (m *Metrics) TrackListSizes(
+	ctx context.Context,
+	counter ListCounter,
+	interval time.Duration,
+	logger *slog.Logger,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		whitelistSize, blacklistSize, err := counter.CountByType(ctx)
+		if err != nil {
+			logger.Warn("failed to count subnet lists", "error", err)
+		} else {
+			m.listSize.WithLabelValues("whitelist").Set(float64(whitelistSize))
+			m.listSize.WithLabelValues("blacklist").Set(float64(blacklistSize))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
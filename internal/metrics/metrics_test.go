@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service/antibruteforce"
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"google.golang.org/grpc/codes"
+)
+
+type staticListCounter struct {
+	whitelist int64
+	blacklist int64
+}
+
+func (c staticListCounter) CountByType(context.Context) (int64, int64, error) {
+	return c.whitelist, c.blacklist, nil
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("scrape status = %d, want 200", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+
+	return string(body)
+}
+
+func TestMetricsExposition(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+
+	m.ObserveDecision(antibruteforce.AccessAllowed)
+	m.ObserveDecision(antibruteforce.AccessAllowed)
+	m.ObserveDecision(antibruteforce.AccessDeniedTooManyRequestsLogin)
+	m.ObserveDecision(antibruteforce.AccessChallengedTooManyRequestsIP)
+	m.ObserveShadowDecision(antibruteforce.AccessAllowed, antibruteforce.AccessDeniedTooManyRequestsLogin)
+	m.ObserveDegradation(antibruteforce.DependencyRateLimits, antibruteforce.FailurePolicyFallback)
+	m.ObserveRequest("/antibruteforce.v1.AntiBruteforce/CheckAccess", codes.OK, time.Millisecond)
+	m.ObserveCall("subnet_cache", "get_ip_check_result", time.Millisecond, storage.ErrCacheMiss)
+	m.ObserveCall("ratelimit", "count_and_increment", time.Millisecond, errors.New("connection refused"))
+	m.ObserveCacheLookup("ip_result", true)
+	m.ObserveCacheLookup("ip_result", false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.TrackListSizes(ctx, staticListCounter{whitelist: 3, blacklist: 7}, time.Hour, slog.New(slog.DiscardHandler))
+
+	body := scrape(t, m)
+
+	expected := []string{
+		`abf_decisions_total{decision="allowed",reason="none"} 2`,
+		`abf_decisions_total{decision="denied",reason="too_many_requests_login"} 1`,
+		`abf_decisions_total{decision="challenged",reason="too_many_requests_ip"} 1`,
+		`abf_shadow_decisions_total{changed="true",decision="denied",reason="too_many_requests_login"} 1`,
+		`abf_degraded_checks_total{dependency="rate_limits",policy="fallback"} 1`,
+		`abf_grpc_request_duration_seconds_count{code="OK",method="/antibruteforce.v1.AntiBruteforce/CheckAccess"} 1`,
+		`abf_storage_call_duration_seconds_count{component="subnet_cache",operation="get_ip_check_result",outcome="ok"} 1`,
+		`abf_storage_call_duration_seconds_count{component="ratelimit",operation="count_and_increment",outcome="error"} 1`,
+		`abf_subnet_cache_lookups_total{cache="ip_result",result="hit"} 1`,
+		`abf_subnet_cache_lookups_total{cache="ip_result",result="miss"} 1`,
+		`abf_subnet_list_size{list="whitelist"} 3`,
+		`abf_subnet_list_size{list="blacklist"} 7`,
+		`go_goroutines`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Errorf("scraped metrics do not contain %q", line)
+		}
+	}
+}
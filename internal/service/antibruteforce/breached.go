@@ -0,0 +1,86 @@
+package antibruteforce
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BreachedPasswords tells whether a password is in a corpus of breached ones, without any network I/O.
+type BreachedPasswords interface {
+	Breached(password string) (bool, error)
+}
+
+// NopBreachedPasswords knows no breached passwords, it stands in when no corpus is configured.
+type NopBreachedPasswords struct{}
+
+func (NopBreachedPasswords) Breached(string) (bool, error) {
+	return false, nil
+}
+
+// BreachedAction decides what happens to attempts with a breached password.
+type BreachedAction int
+
+const (
+	// BreachedActionNone does not look passwords up.
+	BreachedActionNone BreachedAction = iota
+	// BreachedActionFlag sets Verdict.PasswordBreached and leaves the result as it is.
+	BreachedActionFlag
+	// BreachedActionDeny also denies allowed and challenged attempts with AccessDeniedBreachedPassword.
+	BreachedActionDeny
+)
+
+var breachedActionNames = map[BreachedAction]string{
+	BreachedActionNone: "none",
+	BreachedActionFlag: "flag",
+	BreachedActionDeny: "deny",
+}
+
+func ParseBreachedAction(s string) (BreachedAction, error) {
+	for action, name := range breachedActionNames {
+		if strings.EqualFold(s, name) {
+			return action, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown breached password action %q", s)
+}
+
+func (a BreachedAction) String() string {
+	if name, ok := breachedActionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("BreachedAction(%d)", int(a))
+}
+
+// SetBreachedAction replaces the breached password action for subsequent checks.
+func (s *Service) SetBreachedAction(action BreachedAction) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.breached = action
+	s.settings.Store(&next)
+
+	s.logger.Info("set breached password action", "action", action.String())
+}
+
+// checkBreached looks up the password of an attempt that reached the rate limits. The corpus only adds
+// a precaution, so a failed lookup is logged and the verdict stands.
+func (s *Service) checkBreached(current *checkSettings, attempt AccessAttempt, verdict Verdict) Verdict {
+	if current.breached == BreachedActionNone {
+		return verdict
+	}
+
+	breached, err := s.breachedPasswords.Breached(attempt.Password)
+	if err != nil {
+		s.logger.Warn("failed to look up breached password", "error", err)
+		return verdict
+	}
+
+	verdict.PasswordBreached = breached
+	if breached && current.breached == BreachedActionDeny && (verdict.Result.Allowed() || verdict.Result.Challenged()) {
+		verdict.Result = AccessDeniedBreachedPassword
+	}
+
+	return verdict
+}
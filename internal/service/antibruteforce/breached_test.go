@@ -0,0 +1,84 @@
+package antibruteforce
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+// breachedSet knows the passwords it holds, or fails every lookup with err.
+type breachedSet struct {
+	passwords map[string]bool
+	err       error
+}
+
+func (b *breachedSet) Breached(password string) (bool, error) {
+	return b.passwords[password], b.err
+}
+
+func TestBreachedAction(t *testing.T) {
+	t.Parallel()
+
+	counts := &mockRateLimitStorage{}
+	corpus := &breachedSet{passwords: map[string]bool{"123456": true}}
+	svc := newTestService(t, Dependencies{
+		RateLimitStorage:  counts,
+		BreachedPasswords: corpus,
+	})
+	ctx := context.Background()
+
+	breached := AccessAttempt{Login: "alice", Password: "123456", IP: "10.0.0.1"}
+	strong := AccessAttempt{Login: "bob", Password: "correct horse battery staple", IP: "10.0.0.2"}
+
+	tests := []struct {
+		name         string
+		action       BreachedAction
+		counts       ratelimit.RequestCounts
+		wantResult   AccessResult
+		wantBreached bool
+	}{
+		{"none", BreachedActionNone, ratelimit.RequestCounts{}, AccessAllowed, false},
+		{"flag", BreachedActionFlag, ratelimit.RequestCounts{}, AccessAllowed, true},
+		{"deny", BreachedActionDeny, ratelimit.RequestCounts{}, AccessDeniedBreachedPassword, true},
+		{"rate limits first", BreachedActionDeny, ratelimit.RequestCounts{Login: 10},
+			AccessDeniedTooManyRequestsLogin, true},
+	}
+
+	for _, tt := range tests {
+		svc.SetBreachedAction(tt.action)
+		counts.counts = tt.counts
+
+		verdicts, err := svc.CheckAccessBatch(ctx, []AccessAttempt{breached, strong})
+		if err != nil {
+			t.Fatalf("%s: CheckAccessBatch() error = %v", tt.name, err)
+		}
+		if verdicts[0].Result != tt.wantResult || verdicts[0].PasswordBreached != tt.wantBreached {
+			t.Errorf("%s: breached password = %v, breached %v, want %v, breached %v", tt.name,
+				verdicts[0].Result, verdicts[0].PasswordBreached, tt.wantResult, tt.wantBreached)
+		}
+		if verdicts[1].PasswordBreached {
+			t.Errorf("%s: a strong password was flagged as breached", tt.name)
+		}
+
+		verdict, err := svc.CheckAccess(ctx, breached)
+		if err != nil {
+			t.Fatalf("%s: CheckAccess() error = %v", tt.name, err)
+		}
+		if verdict.Result != tt.wantResult || verdict.PasswordBreached != tt.wantBreached {
+			t.Errorf("%s: CheckAccess() = %v, breached %v, want %v, breached %v", tt.name,
+				verdict.Result, verdict.PasswordBreached, tt.wantResult, tt.wantBreached)
+		}
+	}
+
+	corpus.err = errors.New("input/output error")
+	counts.counts = ratelimit.RequestCounts{}
+	verdict, err := svc.CheckAccess(ctx, breached)
+	if err != nil {
+		t.Fatalf("CheckAccess() with a failing corpus error = %v", err)
+	}
+	if verdict.Result != AccessAllowed {
+		t.Errorf("CheckAccess() with a failing corpus = %v, want %v", verdict.Result, AccessAllowed)
+	}
+}
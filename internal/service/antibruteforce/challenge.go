@@ -0,0 +1,111 @@
+package antibruteforce
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/tracing"
+)
+
+// ChallengePolicy sets soft limits below the rate limits. Attempts over a soft limit are challenged
+// with a CAPTCHA or MFA instead of being denied, until they reach the rate limit of the same dimension.
+type ChallengePolicy struct {
+	// Limits are the soft limits, zero fields never challenge.
+	Limits RateLimitConfig
+	// Allowance is how long a passed challenge lifts soft limits for its login and IP.
+	Allowance time.Duration
+}
+
+func (p ChallengePolicy) enabled() bool {
+	return p.Limits != RateLimitConfig{} && p.Allowance > 0
+}
+
+// SetChallengePolicy replaces the challenge policy for subsequent checks, a zero policy turns challenges off.
+func (s *Service) SetChallengePolicy(policy ChallengePolicy) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.challenge = policy
+	s.settings.Store(&next)
+
+	s.logger.Info("set challenge policy",
+		"login_limit", policy.Limits.LoginLimit,
+		"password_limit", policy.Limits.PasswordLimit,
+		"ip_limit", policy.Limits.IPLimit,
+		"allowance", policy.Allowance,
+	)
+}
+
+// ReportChallengePassed lets login through soft limits from ip for the allowance of the challenge policy.
+// Rate limits still apply.
+func (s *Service) ReportChallengePassed(ctx context.Context, tenant, login, ip string) (err error) {
+	ctx, span := tracer.Start(ctx, "antibruteforce.Service/ReportChallengePassed")
+	defer tracing.EndSpan(span, &err)
+
+	if net.ParseIP(ip) == nil {
+		return service.NewInvalidArgumentError("ip", service.ErrInvalidIP)
+	}
+	if login == "" {
+		return service.NewInvalidArgumentError("login", service.ErrInvalidLogin)
+	}
+
+	current := s.settings.Load()
+	if err := current.validateTenant(tenant); err != nil {
+		return err
+	}
+	if !current.challenge.enabled() {
+		return service.ErrChallengesDisabled
+	}
+
+	key := ratelimit.AttemptKey{Tenant: tenant, Login: login, IP: ip}
+	if err := s.historyStorage.GrantAllowance(ctx, key, current.challenge.Allowance); err != nil {
+		return fmt.Errorf("failed to grant challenge allowance: %w", err)
+	}
+
+	return nil
+}
+
+// liftChallenges allows challenged attempts whose login passed a challenge from the same IP within the allowance,
+// the shadow result is lifted the same way. Allowances only spare users another challenge, so when they cannot
+// be read the challenge stays.
+func (s *Service) liftChallenges(ctx context.Context, attempts []AccessAttempt, verdicts []Verdict) {
+	var indexes []int
+	var keys []ratelimit.AttemptKey
+	for i, verdict := range verdicts {
+		if verdict.Result.Challenged() || verdict.shadow.Challenged() {
+			indexes = append(indexes, i)
+			keys = append(keys, ratelimit.AttemptKey{
+				Tenant: attempts[i].Tenant,
+				Login:  attempts[i].Login,
+				IP:     attempts[i].IP,
+			})
+		}
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	granted, err := s.historyStorage.HasAllowances(ctx, keys)
+	if err != nil {
+		s.logger.Warn("failed to read challenge allowances, keeping challenges", "error", err)
+		return
+	}
+
+	for j, i := range indexes {
+		if !granted[j] {
+			continue
+		}
+		if verdicts[i].Result.Challenged() {
+			verdicts[i].Result = AccessAllowed
+		}
+		if verdicts[i].shadow.Challenged() {
+			verdicts[i].shadow = AccessAllowed
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package antibruteforce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+type mockHistoryStorage struct {
+	granted  map[ratelimit.AttemptKey]time.Duration
+	lockouts []ratelimit.AttemptKey
+	// sprayAlert is the tenant that raised the active spray alert, nil while none is active.
+	sprayAlert *string
+	err        error
+}
+
+//nolint:lll
+func (m *mockHistoryStorage) GrantAllowance(_ context.Context, key ratelimit.AttemptKey, ttl time.Duration) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.granted == nil {
+		m.granted = make(map[ratelimit.AttemptKey]time.Duration)
+	}
+	m.granted[key] = ttl
+	return nil
+}
+
+func (m *mockHistoryStorage) HasAllowances(_ context.Context, keys []ratelimit.AttemptKey) ([]bool, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	granted := make([]bool, len(keys))
+	for i, key := range keys {
+		_, granted[i] = m.granted[key]
+	}
+	return granted, nil
+}
+
+func (m *mockHistoryStorage) RecordLockouts(_ context.Context, keys []ratelimit.AttemptKey) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.lockouts = append(m.lockouts, keys...)
+	return nil
+}
+
+func (m *mockHistoryStorage) RaiseSprayAlert(_ context.Context, tenant string, _ time.Duration) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	if m.sprayAlert != nil {
+		return false, nil
+	}
+	m.sprayAlert = &tenant
+	return true, nil
+}
+
+func TestEvaluateRateLimitsWithChallenges(t *testing.T) {
+	t.Parallel()
+
+	limits := RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000}
+	challenge := RateLimitConfig{LoginLimit: 3, IPLimit: 500}
+
+	tests := []struct {
+		name   string
+		counts ratelimit.RequestCounts
+		want   AccessResult
+	}{
+		{"below soft limits", ratelimit.RequestCounts{Login: 2, Password: 99, IP: 499}, AccessAllowed},
+		{"soft login limit", ratelimit.RequestCounts{Login: 3}, AccessChallengedTooManyRequestsLogin},
+		{"soft ip limit first", ratelimit.RequestCounts{Login: 3, IP: 500}, AccessChallengedTooManyRequestsIP},
+		{"hard limit wins", ratelimit.RequestCounts{Login: 10, IP: 500}, AccessDeniedTooManyRequestsLogin},
+		{"no soft password limit", ratelimit.RequestCounts{Password: 100}, AccessDeniedTooManyRequestsPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evaluateRateLimits(limits, challenge, tt.counts); got != tt.want {
+				t.Errorf("evaluateRateLimits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+//nolint:funlen
+func TestReportChallengePassed(t *testing.T) {
+	t.Parallel()
+
+	challenges := &mockHistoryStorage{}
+	svc := newTestService(t, Dependencies{
+		RateLimitStorage: &mockRateLimitStorage{counts: ratelimit.RequestCounts{Login: 5}},
+		HistoryStorage:   challenges,
+	})
+	ctx := context.Background()
+
+	err := svc.ReportChallengePassed(ctx, "", "alice", "10.0.0.1")
+	if !errors.Is(err, service.ErrChallengesDisabled) {
+		t.Errorf("ReportChallengePassed() without soft limits error = %v, want ErrChallengesDisabled", err)
+	}
+
+	svc.SetChallengePolicy(ChallengePolicy{Limits: RateLimitConfig{LoginLimit: 3}, Allowance: time.Hour})
+
+	alice := AccessAttempt{Login: "alice", Password: "secret", IP: "10.0.0.1"}
+	otherIP := AccessAttempt{Login: "alice", Password: "secret", IP: "10.0.0.2"}
+
+	verdict, err := svc.CheckAccess(ctx, alice)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Result != AccessChallengedTooManyRequestsLogin {
+		t.Errorf("CheckAccess() = %v, want %v", verdict.Result, AccessChallengedTooManyRequestsLogin)
+	}
+
+	if err := svc.ReportChallengePassed(ctx, "", "alice", "10.0.0.300"); !errors.Is(err, service.ErrInvalidIP) {
+		t.Errorf("ReportChallengePassed() with an invalid IP error = %v, want ErrInvalidIP", err)
+	}
+	if err := svc.ReportChallengePassed(ctx, "", "alice", "10.0.0.1"); err != nil {
+		t.Fatalf("ReportChallengePassed() error = %v", err)
+	}
+	if ttl := challenges.granted[ratelimit.AttemptKey{Login: "alice", IP: "10.0.0.1"}]; ttl != time.Hour {
+		t.Errorf("allowance granted for %v, want %v", ttl, time.Hour)
+	}
+
+	verdict, err = svc.CheckAccess(ctx, alice)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Result != AccessAllowed {
+		t.Errorf("CheckAccess() after a passed challenge = %v, want %v", verdict.Result, AccessAllowed)
+	}
+
+	verdicts, err := svc.CheckAccessBatch(ctx, []AccessAttempt{alice, otherIP})
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() error = %v", err)
+	}
+	if verdicts[0].Result != AccessAllowed || verdicts[1].Result != AccessChallengedTooManyRequestsLogin {
+		t.Errorf("CheckAccessBatch() = %v and %v, want the allowance for its IP only",
+			verdicts[0].Result, verdicts[1].Result)
+	}
+
+	challenges.err = errors.New("connection refused")
+	verdict, err = svc.CheckAccess(ctx, alice)
+	if err != nil {
+		t.Fatalf("CheckAccess() with unreadable allowances error = %v", err)
+	}
+	if verdict.Result != AccessChallengedTooManyRequestsLogin {
+		t.Errorf("CheckAccess() with unreadable allowances = %v, want the challenge kept", verdict.Result)
+	}
+}
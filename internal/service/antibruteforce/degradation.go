@@ -0,0 +1,114 @@
+package antibruteforce
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// FailurePolicy decides what CheckAccess answers when a dependency fails.
+type FailurePolicy int
+
+const (
+	// FailurePolicyError returns the error to the caller.
+	FailurePolicyError FailurePolicy = iota
+	// FailurePolicyOpen skips the failed check: IPs are treated as unlisted and rate limits as not exceeded.
+	FailurePolicyOpen
+	// FailurePolicyClosed denies the attempt with AccessDeniedBackendUnavailable.
+	FailurePolicyClosed
+	// FailurePolicyFallback asks the in-process fallback of the dependency and fails closed if it cannot answer.
+	FailurePolicyFallback
+)
+
+// Dependency names reported to DecisionObserver.ObserveDegradation.
+const (
+	DependencySubnetLists = "subnet_lists"
+	DependencyRateLimits  = "rate_limits"
+)
+
+var failurePolicyNames = map[FailurePolicy]string{
+	FailurePolicyError:    "error",
+	FailurePolicyOpen:     "open",
+	FailurePolicyClosed:   "closed",
+	FailurePolicyFallback: "fallback",
+}
+
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	for policy, name := range failurePolicyNames {
+		if strings.EqualFold(s, name) {
+			return policy, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown failure policy %q", s)
+}
+
+func (p FailurePolicy) String() string {
+	if name, ok := failurePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("FailurePolicy(%d)", int(p))
+}
+
+// DegradationPolicy configures FailurePolicy per dependency. The zero value returns every error to the caller.
+type DegradationPolicy struct {
+	SubnetLists FailurePolicy
+	RateLimits  FailurePolicy
+	// SubnetFallback and RateLimitFallback are used by FailurePolicyFallback, a nil fallback fails closed.
+	SubnetFallback    SubnetProvider
+	RateLimitFallback RateLimitStorage
+}
+
+// degradation tells how a dependency call was answered.
+type degradation int
+
+const (
+	notDegraded degradation = iota
+	// degradedFallback means the value comes from the fallback.
+	degradedFallback
+	// degradedOpen means the check must be treated as passed.
+	degradedOpen
+	// degradedClosed means the attempt must be denied.
+	degradedClosed
+)
+
+// callWithPolicy calls primary and applies policy when it fails. The error is only returned
+// for FailurePolicyError and when ctx is done, since then nobody waits for a degraded answer.
+func callWithPolicy[B, T any](
+	ctx context.Context,
+	s *Service,
+	dependency string,
+	policy FailurePolicy,
+	primary, fallback B,
+	call func(backend B) (T, error),
+) (T, degradation, error) {
+	value, err := call(primary)
+	if err == nil {
+		return value, notDegraded, nil
+	}
+
+	var zero T
+	if policy == FailurePolicyError || ctx.Err() != nil {
+		return zero, notDegraded, err
+	}
+
+	s.decisionObserver.ObserveDegradation(dependency, policy)
+	s.logger.Debug("dependency failed, degrading", "dependency", dependency, "policy", policy.String(), "error", err)
+
+	switch policy {
+	case FailurePolicyOpen:
+		return zero, degradedOpen, nil
+	case FailurePolicyFallback:
+		if any(fallback) == nil {
+			break
+		}
+
+		value, err = call(fallback)
+		if err == nil {
+			return value, degradedFallback, nil
+		}
+		s.logger.Debug("fallback failed, failing closed", "dependency", dependency, "error", err)
+	}
+
+	return zero, degradedClosed, nil
+}
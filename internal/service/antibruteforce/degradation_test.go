@@ -0,0 +1,309 @@
+package antibruteforce
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type countingObserver struct {
+	NopDecisionObserver
+
+	degradations atomic.Int64
+}
+
+func (o *countingObserver) ObserveDegradation(string, FailurePolicy) {
+	o.degradations.Add(1)
+}
+
+// sameVerdict compares what the degradation policy decides.
+//
+//nolint:funlen
+func sameVerdict(got, want Verdict) bool {
+	return got.Result == want.Result && got.Degraded == want.Degraded
+}
+
+func TestCheckAccessDegradation(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config := RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000}
+	broken := errors.New("connection refused")
+
+	tests := []struct {
+		Name            string
+		SubnetProvider  *mockSubnetProvider
+		RateLimitStore  *mockRateLimitStorage
+		Policy          DegradationPolicy
+		Expected        Verdict
+		IsErrorExpected bool
+	}{
+		{
+			Name:            "lists error is returned by default",
+			SubnetProvider:  &mockSubnetProvider{err: broken},
+			RateLimitStore:  &mockRateLimitStorage{},
+			IsErrorExpected: true,
+		},
+		{
+			Name:           "open lists fall through to rate limits",
+			SubnetProvider: &mockSubnetProvider{err: broken},
+			RateLimitStore: &mockRateLimitStorage{counts: ratelimit.RequestCounts{Login: 10}},
+			Policy:         DegradationPolicy{SubnetLists: FailurePolicyOpen},
+			Expected:       Verdict{Result: AccessDeniedTooManyRequestsLogin, Degraded: true},
+		},
+		{
+			Name:           "closed lists deny",
+			SubnetProvider: &mockSubnetProvider{err: broken},
+			RateLimitStore: &mockRateLimitStorage{},
+			Policy:         DegradationPolicy{SubnetLists: FailurePolicyClosed},
+			Expected:       Verdict{Result: AccessDeniedBackendUnavailable, Degraded: true},
+		},
+		{
+			Name:           "lists fall back to the snapshot",
+			SubnetProvider: &mockSubnetProvider{err: broken},
+			RateLimitStore: &mockRateLimitStorage{},
+			Policy: DegradationPolicy{
+				SubnetLists:    FailurePolicyFallback,
+				SubnetFallback: &mockSubnetProvider{inBlacklist: true},
+			},
+			Expected: Verdict{Result: AccessDeniedIPBlacklisted, Degraded: true},
+		},
+		{
+			Name:           "failed lists fallback fails closed",
+			SubnetProvider: &mockSubnetProvider{err: broken},
+			RateLimitStore: &mockRateLimitStorage{},
+			Policy: DegradationPolicy{
+				SubnetLists:    FailurePolicyFallback,
+				SubnetFallback: &mockSubnetProvider{err: subnet.ErrNoSnapshot},
+			},
+			Expected: Verdict{Result: AccessDeniedBackendUnavailable, Degraded: true},
+		},
+		{
+			Name:            "rate limits error is returned by default",
+			SubnetProvider:  &mockSubnetProvider{},
+			RateLimitStore:  &mockRateLimitStorage{err: broken},
+			IsErrorExpected: true,
+		},
+		{
+			Name:           "open rate limits allow",
+			SubnetProvider: &mockSubnetProvider{},
+			RateLimitStore: &mockRateLimitStorage{err: broken},
+			Policy:         DegradationPolicy{RateLimits: FailurePolicyOpen},
+			Expected:       Verdict{Result: AccessAllowed, Degraded: true},
+		},
+		{
+			Name:           "closed rate limits deny",
+			SubnetProvider: &mockSubnetProvider{},
+			RateLimitStore: &mockRateLimitStorage{err: broken},
+			Policy:         DegradationPolicy{RateLimits: FailurePolicyClosed},
+			Expected:       Verdict{Result: AccessDeniedBackendUnavailable, Degraded: true},
+		},
+		{
+			Name:           "rate limits fall back to the local limiter",
+			SubnetProvider: &mockSubnetProvider{},
+			RateLimitStore: &mockRateLimitStorage{err: broken},
+			Policy: DegradationPolicy{
+				RateLimits:        FailurePolicyFallback,
+				RateLimitFallback: &mockRateLimitStorage{counts: ratelimit.RequestCounts{IP: 1000}},
+			},
+			Expected: Verdict{Result: AccessDeniedTooManyRequestsIP, Degraded: true},
+		},
+		{
+			Name:           "rate limits fallback without a limiter fails closed",
+			SubnetProvider: &mockSubnetProvider{},
+			RateLimitStore: &mockRateLimitStorage{err: broken},
+			Policy:         DegradationPolicy{RateLimits: FailurePolicyFallback},
+			Expected:       Verdict{Result: AccessDeniedBackendUnavailable, Degraded: true},
+		},
+		{
+			Name:           "healthy backends are not degraded",
+			SubnetProvider: &mockSubnetProvider{},
+			RateLimitStore: &mockRateLimitStorage{},
+			Policy:         DegradationPolicy{SubnetLists: FailurePolicyClosed, RateLimits: FailurePolicyClosed},
+			Expected:       Verdict{Result: AccessAllowed},
+		},
+	}
+
+	for _, testcase := range tests {
+		t.Run(testcase.Name, func(t *testing.T) {
+			t.Parallel()
+
+			observer := &countingObserver{}
+			svc := NewService(
+				Dependencies{
+					Logger:           logger,
+					SubnetProvider:   testcase.SubnetProvider,
+					RateLimitStorage: testcase.RateLimitStore,
+					DecisionObserver: observer,
+				},
+				config,
+				testcase.Policy,
+			)
+
+			attempt := AccessAttempt{Login: "user", Password: "pass", IP: "192.168.1.1"}
+			verdict, err := svc.CheckAccess(context.Background(), attempt)
+			if (err != nil) != testcase.IsErrorExpected {
+				t.Fatalf("CheckAccess() error = %v, wantErr %v", err, testcase.IsErrorExpected)
+			}
+
+			if !sameVerdict(verdict, testcase.Expected) {
+				t.Errorf("CheckAccess() = %+v, want %+v", verdict, testcase.Expected)
+			}
+
+			if verdict.Degraded && observer.degradations.Load() == 0 {
+				t.Errorf("degraded verdict was not observed")
+			}
+
+			batch, err := svc.CheckAccessBatch(context.Background(), []AccessAttempt{
+				{Login: "user", Password: "pass", IP: "192.168.1.1"},
+			})
+			if (err != nil) != testcase.IsErrorExpected {
+				t.Fatalf("CheckAccessBatch() error = %v, wantErr %v", err, testcase.IsErrorExpected)
+			}
+
+			if err == nil && !sameVerdict(batch[0], testcase.Expected) {
+				t.Errorf("CheckAccessBatch() = %+v, want %+v", batch[0], testcase.Expected)
+			}
+		})
+	}
+}
+
+func TestCheckAccessDegradationKeepsCancellation(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := NewService(
+		Dependencies{
+			Logger:           logger,
+			SubnetProvider:   &mockSubnetProvider{err: context.Canceled},
+			RateLimitStorage: &mockRateLimitStorage{},
+		},
+		RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000},
+		DegradationPolicy{SubnetLists: FailurePolicyOpen},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempt := AccessAttempt{Login: "user", Password: "pass", IP: "192.168.1.1"}
+	if _, err := svc.CheckAccess(ctx, attempt); !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckAccess() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestCheckAccessSurvivesRedisOutage(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := NewService(
+		Dependencies{
+			Logger:           logger,
+			SubnetProvider:   &mockSubnetProvider{},
+			RateLimitStorage: ratelimit.NewStorage(client, time.Minute, storage.NopCallObserver{}, logger),
+		},
+		RateLimitConfig{LoginLimit: 2, PasswordLimit: 100, IPLimit: 1000},
+		DegradationPolicy{
+			RateLimits:        FailurePolicyFallback,
+			RateLimitFallback: ratelimit.NewLocalStorage(time.Minute),
+		},
+	)
+
+	ctx := context.Background()
+	verdict, err := svc.CheckAccess(ctx, AccessAttempt{Login: "alice", Password: "pass", IP: "10.0.0.1"})
+	if err != nil || !sameVerdict(verdict, Verdict{Result: AccessAllowed}) {
+		t.Fatalf("CheckAccess() with Redis up = %+v, %v", verdict, err)
+	}
+
+	server.Close()
+
+	expected := []Verdict{
+		{Result: AccessAllowed, Degraded: true},
+		{Result: AccessAllowed, Degraded: true},
+		{Result: AccessDeniedTooManyRequestsLogin, Degraded: true},
+	}
+	for i, want := range expected {
+		verdict, err := svc.CheckAccess(ctx, AccessAttempt{Login: "alice", Password: "pass", IP: "10.0.0.1"})
+		if err != nil {
+			t.Fatalf("attempt %d with Redis down: unexpected error = %v", i, err)
+		}
+		if !sameVerdict(verdict, want) {
+			t.Errorf("attempt %d with Redis down = %+v, want %+v", i, verdict, want)
+		}
+	}
+}
+
+type switchableLists struct {
+	down atomic.Bool
+}
+
+func (l *switchableLists) GetAllLists(context.Context) (map[string]subnet.Lists, error) {
+	if l.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return map[string]subnet.Lists{
+		"": {Whitelist: []string{"10.0.0.0/8"}, Blacklist: []string{"192.168.0.0/16"}},
+	}, nil
+}
+
+func TestCheckAccessSurvivesPostgresOutage(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	lists := &switchableLists{}
+	snapshot := subnet.NewSnapshot(lists, logger)
+	if err := snapshot.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() unexpected error = %v", err)
+	}
+
+	// the outage starts after the snapshot was taken and a refresh must not lose it
+	lists.down.Store(true)
+	if err := snapshot.Refresh(context.Background()); err == nil {
+		t.Fatalf("Refresh() with Postgres down did not fail")
+	}
+
+	svc := NewService(
+		Dependencies{
+			Logger:           logger,
+			SubnetProvider:   &mockSubnetProvider{err: errors.New("connection refused")},
+			RateLimitStorage: &mockRateLimitStorage{},
+		},
+		RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000},
+		DegradationPolicy{SubnetLists: FailurePolicyFallback, SubnetFallback: snapshot},
+	)
+
+	attempts := []AccessAttempt{
+		{Login: "alice", Password: "pass", IP: "10.1.2.3"},
+		{Login: "alice", Password: "pass", IP: "192.168.1.1"},
+		{Login: "alice", Password: "pass", IP: "172.16.0.1"},
+	}
+	expected := []Verdict{
+		{Result: AccessAllowed, Degraded: true},
+		{Result: AccessDeniedIPBlacklisted, Degraded: true},
+		{Result: AccessAllowed, Degraded: true},
+	}
+
+	verdicts, err := svc.CheckAccessBatch(context.Background(), attempts)
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() unexpected error = %v", err)
+	}
+
+	for i, want := range expected {
+		if !sameVerdict(verdicts[i], want) {
+			t.Errorf("verdict[%d] = %+v, want %+v", i, verdicts[i], want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package antibruteforce
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
+)
+
+// DefaultHoneypotBlacklistTTL is how long a source trying a honeypot login stays blacklisted.
+const DefaultHoneypotBlacklistTTL = 24 * time.Hour
+
+// DefaultHoneypotQueueSize is how many caught sources wait to be blacklisted before further ones are dropped.
+const DefaultHoneypotQueueSize = 1024
+
+const (
+	maxHoneypotBatchSize = 256
+	honeypotWriteTimeout = 5 * time.Second
+)
+
+// HoneypotBlacklist blacklists sources caught by a honeypot login. Blacklist is called on the request path,
+// so it must not block, see HoneypotQueue.
+type HoneypotBlacklist interface {
+	Blacklist(host subnet.ExpiringHost)
+}
+
+// NopHoneypotBlacklist blacklists nothing, attempts for honeypot logins are still denied.
+type NopHoneypotBlacklist struct{}
+
+func (NopHoneypotBlacklist) Blacklist(subnet.ExpiringHost) {}
+
+// HostBlacklist lists single addresses until their TTL passes, it is implemented by subnet.Provider.
+type HostBlacklist interface {
+	AddHostsWithExpiry(ctx context.Context, listType int, hosts []subnet.ExpiringHost) (int64, error)
+}
+
+// HoneypotQueue blacklists caught sources in the background, in batches. Its buffer is bounded,
+// so sources caught while it is full are dropped. Their attempts are denied either way.
+type HoneypotQueue struct {
+	blacklist HostBlacklist
+	logger    *slog.Logger
+	ch        chan subnet.ExpiringHost
+	dropped   atomic.Uint64
+	done      chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewHoneypotQueue starts the goroutine blacklisting queued sources.
+func NewHoneypotQueue(blacklist HostBlacklist, logger *slog.Logger, bufferSize int) *HoneypotQueue {
+	q := &HoneypotQueue{
+		blacklist: blacklist,
+		logger:    logger,
+		ch:        make(chan subnet.ExpiringHost, bufferSize),
+		done:      make(chan struct{}),
+	}
+
+	go q.run()
+
+	return q
+}
+
+func (q *HoneypotQueue) Blacklist(host subnet.ExpiringHost) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return
+	}
+
+	select {
+	case q.ch <- host:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+func (q *HoneypotQueue) run() {
+	defer close(q.done)
+
+	batch := make([]subnet.ExpiringHost, 0, maxHoneypotBatchSize)
+	for host := range q.ch {
+		batch = append(batch[:0], host)
+	drain:
+		for len(batch) < maxHoneypotBatchSize {
+			select {
+			case next, ok := <-q.ch:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		if dropped := q.dropped.Swap(0); dropped > 0 {
+			q.logger.Warn("dropped honeypot sources, the queue is full", "sources", dropped)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), honeypotWriteTimeout)
+		listed, err := q.blacklist.AddHostsWithExpiry(ctx, subnet.BlacklistTypeID, batch)
+		cancel()
+
+		if err != nil {
+			q.logger.Error("failed to blacklist honeypot sources", "sources", len(batch), "error", err)
+			continue
+		}
+		q.logger.Info("blacklisted honeypot sources", "sources", len(batch), "listed", listed)
+	}
+}
+
+// Close stops accepting sources and blacklists the queued ones. Sources still queued when ctx is done are abandoned.
+func (q *HoneypotQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.ch)
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetHoneypotLogins replaces the logins no real user has. Attempts for them are denied with
+// AccessDeniedHoneypotLogin and their source is blacklisted, see SetHoneypotBlacklistTTL.
+func (s *Service) SetHoneypotLogins(logins []string) {
+	set := make(map[string]struct{}, len(logins))
+	for _, login := range logins {
+		set[login] = struct{}{}
+	}
+
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.honeypotLogins = set
+	s.settings.Store(&next)
+
+	s.logger.Info("set honeypot logins", "count", len(set))
+}
+
+// SetHoneypotBlacklistTTL replaces how long sources caught by a honeypot login stay blacklisted.
+func (s *Service) SetHoneypotBlacklistTTL(ttl time.Duration) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.honeypotTTL = ttl
+	s.settings.Store(&next)
+
+	s.logger.Info("set honeypot blacklist ttl", "ttl", ttl)
+}
+
+func (s *checkSettings) isHoneypot(attempt AccessAttempt) bool {
+	_, ok := s.honeypotLogins[attempt.Login]
+	return ok
+}
+
+// honeypotVerdict denies an attempt for a honeypot login. It is neither counted nor shadowed,
+// the source is blacklisted by blacklistHoneypotSources.
+func (s *checkSettings) honeypotVerdict(degraded bool) Verdict {
+	return Verdict{Result: AccessDeniedHoneypotLogin, Degraded: degraded, Risk: s.blacklistedRisk()}
+}
+
+// blacklistHoneypotSources queues the IPs of attempts denied by a honeypot login for the blacklist of their tenant.
+func (s *Service) blacklistHoneypotSources(current *checkSettings, attempts []AccessAttempt, verdicts []Verdict) {
+	type source struct {
+		tenant string
+		ip     string
+	}
+
+	queued := make(map[source]struct{})
+	for i, verdict := range verdicts {
+		if verdict.Result != AccessDeniedHoneypotLogin {
+			continue
+		}
+
+		src := source{tenant: attempts[i].Tenant, ip: attempts[i].IP}
+		if _, ok := queued[src]; ok {
+			continue
+		}
+		queued[src] = struct{}{}
+
+		s.honeypotBlacklist.Blacklist(subnet.ExpiringHost{Tenant: src.tenant, IP: src.ip, TTL: current.honeypotTTL})
+
+		s.logger.Warn("caught honeypot source",
+			"tenant", src.tenant,
+			"ip", src.ip,
+			"login", attempts[i].Login,
+			"ttl", current.honeypotTTL,
+		)
+	}
+}
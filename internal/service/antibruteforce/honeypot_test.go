@@ -0,0 +1,191 @@
+package antibruteforce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
+)
+
+// honeypotRecorder records the sources queued for the blacklist by honeypot logins.
+type honeypotRecorder struct {
+	added []string
+	ttl   time.Duration
+}
+
+func (r *honeypotRecorder) Blacklist(host subnet.ExpiringHost) {
+	r.added = append(r.added, host.Tenant+"|"+host.IP)
+	r.ttl = host.TTL
+}
+
+// hostRecorder records the batches of hosts added to the blacklist, or fails with err.
+// Every batch waits for release when it is set.
+type hostRecorder struct {
+	mu      sync.Mutex
+	batches [][]subnet.ExpiringHost
+	release chan struct{}
+	err     error
+}
+
+//nolint:lll
+func (r *hostRecorder) AddHostsWithExpiry(_ context.Context, listType int, hosts []subnet.ExpiringHost) (int64, error) {
+	if r.release != nil {
+		<-r.release
+	}
+	if listType != subnet.BlacklistTypeID {
+		return 0, errors.New("not the blacklist")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches = append(r.batches, slices.Clone(hosts))
+	if r.err != nil {
+		return 0, r.err
+	}
+	return int64(len(hosts)), nil
+}
+
+func (r *hostRecorder) hosts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, batch := range r.batches {
+		n += len(batch)
+	}
+	return n
+}
+
+func TestHoneypotQueue(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.DiscardHandler)
+
+	// a slow blacklist neither blocks callers nor grows the queue
+	slow := &hostRecorder{release: make(chan struct{})}
+	q := NewHoneypotQueue(slow, logger, 2)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 10 {
+			q.Blacklist(subnet.ExpiringHost{IP: fmt.Sprintf("10.0.0.%d", i), TTL: time.Hour})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Blacklist blocked on a slow blacklist")
+	}
+
+	close(slow.release)
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error = %v", err)
+	}
+	written := slow.hosts()
+	if written == 0 || written >= 10 {
+		t.Errorf("slow blacklist got %d hosts, want some of them dropped", written)
+	}
+
+	// a closed queue drops sources
+	q.Blacklist(subnet.ExpiringHost{IP: "10.0.0.100", TTL: time.Hour})
+	if got := slow.hosts(); got != written {
+		t.Errorf("closed queue blacklisted %d hosts, want %d", got, written)
+	}
+
+	// failed batches are only logged, the next ones are still written
+	failing := &hostRecorder{err: errors.New("connection refused")}
+	q = NewHoneypotQueue(failing, logger, DefaultHoneypotQueueSize)
+	q.Blacklist(subnet.ExpiringHost{IP: "10.0.0.1", TTL: time.Hour})
+	q.Blacklist(subnet.ExpiringHost{IP: "10.0.0.2", TTL: time.Hour})
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error = %v", err)
+	}
+	if got := failing.hosts(); got != 2 {
+		t.Errorf("failing blacklist got %d hosts, want 2", got)
+	}
+}
+
+//nolint:funlen
+func TestHoneypotLogins(t *testing.T) {
+	t.Parallel()
+
+	lists := &mockSubnetProvider{}
+	counts := &mockRateLimitStorage{}
+	blacklist := &honeypotRecorder{}
+	emitter := &recordingEmitter{}
+	svc := newTestService(t, Dependencies{
+		SubnetProvider:    lists,
+		RateLimitStorage:  counts,
+		HoneypotBlacklist: blacklist,
+		DecisionEmitter:   emitter,
+	})
+	svc.SetHoneypotLogins([]string{"admin", "root"})
+	svc.SetHoneypotBlacklistTTL(time.Hour)
+	ctx := context.Background()
+
+	verdict, err := svc.CheckAccess(ctx, AccessAttempt{Login: "admin", Password: "admin", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Result != AccessDeniedHoneypotLogin {
+		t.Errorf("CheckAccess() of a honeypot login = %v, want %v", verdict.Result, AccessDeniedHoneypotLogin)
+	}
+	if !slices.Equal(blacklist.added, []string{"|10.0.0.1"}) || blacklist.ttl != time.Hour {
+		t.Errorf("blacklisted %v for %v, want |10.0.0.1 for 1h", blacklist.added, blacklist.ttl)
+	}
+	if got := emitter.decisions[0].Result.Severity(); got != SeverityCritical {
+		t.Errorf("emitted severity = %v, want %v", got, SeverityCritical)
+	}
+
+	blacklist.added = nil
+	counts.batchKeys = nil
+	verdicts, err := svc.CheckAccessBatch(ctx, []AccessAttempt{
+		{Login: "root", Password: "toor", IP: "2001:db8::1"},
+		{Login: "alice", Password: "secret", IP: "10.0.0.2"},
+		{Login: "admin", Password: "admin", IP: "2001:db8::1"},
+	})
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() error = %v", err)
+	}
+	got := []AccessResult{verdicts[0].Result, verdicts[1].Result, verdicts[2].Result}
+	want := []AccessResult{AccessDeniedHoneypotLogin, AccessAllowed, AccessDeniedHoneypotLogin}
+	if !slices.Equal(got, want) {
+		t.Errorf("CheckAccessBatch() = %v, want %v", got, want)
+	}
+	if !slices.Equal(blacklist.added, []string{"|2001:db8::1"}) {
+		t.Errorf("blacklisted %v, want the source once", blacklist.added)
+	}
+	if len(counts.batchKeys) != 1 || counts.batchKeys[0].Login != "alice" {
+		t.Errorf("counted %v, want only the attempt for alice", counts.batchKeys)
+	}
+
+	// a whitelisted source is trusted even with a honeypot login
+	blacklist.added = nil
+	lists.inWhitelist = true
+	verdict, err = svc.CheckAccess(ctx, AccessAttempt{Login: "admin", Password: "admin", IP: "10.0.0.3"})
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Result != AccessAllowed || blacklist.added != nil {
+		t.Errorf("CheckAccess() from a whitelisted source = %v, blacklisted %v", verdict.Result, blacklist.added)
+	}
+
+	lists.inWhitelist = false
+	svc.SetHoneypotLogins(nil)
+	verdict, err = svc.CheckAccess(ctx, AccessAttempt{Login: "admin", Password: "admin", IP: "10.0.0.5"})
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Result != AccessAllowed {
+		t.Errorf("CheckAccess() after removing honeypot logins = %v, want %v", verdict.Result, AccessAllowed)
+	}
+}
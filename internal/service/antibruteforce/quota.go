@@ -0,0 +1,60 @@
+package antibruteforce
+
+import (
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+// Quota is the state of one rate limit bucket after an attempt, the attempt itself included.
+type Quota struct {
+	Limit int64
+	// Remaining attempts fit into the window.
+	Remaining int64
+	// RetryAfter is set when none remain, it is how long until enough attempts left the window for one more.
+	// Denied attempts are counted too, so a client that keeps trying meanwhile pushes it further.
+	RetryAfter time.Duration
+}
+
+// Quotas are only known for attempts that reached the rate limits with counts of a healthy or fallback storage.
+type Quotas struct {
+	IP       Quota
+	Login    Quota
+	Password Quota
+}
+
+func quotasOf(limits RateLimitConfig, counts ratelimit.RequestCounts) Quotas {
+	return Quotas{
+		IP:       quotaOf(limits.IPLimit, counts.IP, counts.Refills.IP),
+		Login:    quotaOf(limits.LoginLimit, counts.Login, counts.Refills.Login),
+		Password: quotaOf(limits.PasswordLimit, counts.Password, counts.Refills.Password),
+	}
+}
+
+func (c RateLimitConfig) bucketLimits() ratelimit.Limits {
+	return ratelimit.Limits{IP: c.IPLimit, Login: c.LoginLimit, Password: c.PasswordLimit}
+}
+
+func quotaOf(limit, count int64, refill time.Duration) Quota {
+	quota := Quota{Limit: limit, Remaining: max(limit-count-1, 0)}
+	if quota.Remaining == 0 {
+		quota.RetryAfter = refill
+	}
+	return quota
+}
+
+// RetryAfter tells a client denied for too many requests when the next attempt can get through,
+// it is zero for other verdicts. Every bucket without remaining attempts has to refill first.
+func (v Verdict) RetryAfter() time.Duration {
+	switch v.Result {
+	case AccessDeniedTooManyRequestsIP, AccessDeniedTooManyRequestsLogin, AccessDeniedTooManyRequestsPassword:
+	default:
+		return 0
+	}
+
+	var retryAfter time.Duration
+	for _, quota := range []Quota{v.Quotas.IP, v.Quotas.Login, v.Quotas.Password} {
+		retryAfter = max(retryAfter, quota.RetryAfter)
+	}
+	return retryAfter
+}
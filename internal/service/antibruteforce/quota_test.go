@@ -0,0 +1,65 @@
+package antibruteforce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+func TestCheckAccessQuotas(t *testing.T) {
+	t.Parallel()
+
+	subnets := &mockSubnetProvider{}
+	counts := &mockRateLimitStorage{counts: ratelimit.RequestCounts{
+		IP:      3,
+		Login:   9,
+		Refills: ratelimit.Refills{IP: 50 * time.Second, Login: 20 * time.Second, Password: time.Minute},
+	}}
+	svc := newTestService(t, Dependencies{
+		SubnetProvider:   subnets,
+		RateLimitStorage: counts,
+	})
+	ctx := context.Background()
+	alice := AccessAttempt{Login: "alice", Password: "secret", IP: "10.0.0.1"}
+
+	verdict, err := svc.CheckAccess(ctx, alice)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	want := Quotas{
+		IP:       Quota{Limit: 1000, Remaining: 996},
+		Login:    Quota{Limit: 10, Remaining: 0, RetryAfter: 20 * time.Second},
+		Password: Quota{Limit: 100, Remaining: 99},
+	}
+	if verdict.Result != AccessAllowed || verdict.Quotas != want {
+		t.Errorf("CheckAccess() = %v with %+v, want allowed with %+v", verdict.Result, verdict.Quotas, want)
+	}
+	if retryAfter := verdict.RetryAfter(); retryAfter != 0 {
+		t.Errorf("RetryAfter() of an allowed attempt = %v, want 0", retryAfter)
+	}
+
+	// the IP bucket is filled by this attempt, so both have to refill before the next one gets through
+	counts.counts.IP = 999
+	counts.counts.Login = 10
+	verdicts, err := svc.CheckAccessBatch(ctx, []AccessAttempt{alice})
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() error = %v", err)
+	}
+	if verdicts[0].Result != AccessDeniedTooManyRequestsLogin {
+		t.Errorf("CheckAccessBatch() = %v, want %v", verdicts[0].Result, AccessDeniedTooManyRequestsLogin)
+	}
+	if retryAfter := verdicts[0].RetryAfter(); retryAfter != 50*time.Second {
+		t.Errorf("RetryAfter() = %v, want the IP bucket refill of 50s", retryAfter)
+	}
+
+	subnets.inWhitelist = true
+	verdict, err = svc.CheckAccess(ctx, alice)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Quotas != (Quotas{}) {
+		t.Errorf("CheckAccess() quotas of a whitelisted IP = %+v, want none", verdict.Quotas)
+	}
+}
@@ -0,0 +1,219 @@
+package antibruteforce
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+// Names of risk signals.
+const (
+	RiskSignalIPFill        = "ip_fill"
+	RiskSignalLoginFill     = "login_fill"
+	RiskSignalPasswordFill  = "password_fill"
+	RiskSignalViolations    = "violations"
+	RiskSignalBlacklistNear = "blacklist_proximity"
+	RiskSignalLockouts      = "lockouts"
+	RiskSignalBlacklisted   = "ip_blacklisted"
+)
+
+// Counting signals reach full strength at these values.
+const (
+	violationsSaturation    = 10
+	blacklistNearSaturation = 4
+	lockoutsSaturation      = 5
+)
+
+// RiskWeights tell how much every signal contributes to the risk score, only their ratios matter.
+// A zero weight drops its signal, all zero weights turn risk scoring off.
+type RiskWeights struct {
+	// IPFill, LoginFill and PasswordFill weigh how full the rate limit bucket of each dimension is.
+	IPFill       int64
+	LoginFill    int64
+	PasswordFill int64
+	// Violations weighs attempts over any rate limit within the window.
+	Violations int64
+	// BlacklistNear weighs blacklisted subnets in the network around the IP, see BlacklistIndex.
+	BlacklistNear int64
+	// Lockouts weighs lockouts of the IP or the login within ratelimit.LockoutHistory.
+	Lockouts int64
+}
+
+func (w RiskWeights) total() int64 {
+	return w.IPFill + w.LoginFill + w.PasswordFill + w.Violations + w.BlacklistNear + w.Lockouts
+}
+
+// Risk tells how close an attempt is to being blocked. Score is 0-100, Signals are the contributions
+// to it, the largest first. A zero Risk means risk scoring is off or the attempt did not reach the rate limits.
+type Risk struct {
+	Score   int
+	Signals []RiskSignal
+}
+
+// RiskSignal has a Value from 0 to 1 and adds Points to the score.
+type RiskSignal struct {
+	Name   string
+	Value  float64
+	Points int
+}
+
+// BlacklistIndex counts blacklisted subnets in the network around an IP without any I/O.
+type BlacklistIndex interface {
+	BlacklistedNear(tenant, ip string) int
+}
+
+// NopBlacklistIndex knows no blacklisted subnets, so no attempt is riskier for its neighbours.
+type NopBlacklistIndex struct{}
+
+func (NopBlacklistIndex) BlacklistedNear(string, string) int {
+	return 0
+}
+
+// riskInputs hold everything a risk score is computed from.
+type riskInputs struct {
+	limits          RateLimitConfig
+	counts          ratelimit.RequestCounts
+	blacklistedNear int
+}
+
+// SetRiskWeights replaces the risk weights for subsequent checks. Negative weights are treated as zero.
+func (s *Service) SetRiskWeights(weights RiskWeights) {
+	for _, w := range []*int64{
+		&weights.IPFill, &weights.LoginFill, &weights.PasswordFill,
+		&weights.Violations, &weights.BlacklistNear, &weights.Lockouts,
+	} {
+		*w = max(*w, 0)
+	}
+
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.riskWeights = weights
+	s.settings.Store(&next)
+
+	s.logger.Info("set risk weights",
+		"ip_fill", weights.IPFill,
+		"login_fill", weights.LoginFill,
+		"password_fill", weights.PasswordFill,
+		"violations", weights.Violations,
+		"blacklist_near", weights.BlacklistNear,
+		"lockouts", weights.Lockouts,
+	)
+}
+
+// scoreRisk is deterministic, the same weights and inputs always give the same Risk. Every signal adds
+// weight / total weight * value * 100 points, rounded, signals that add nothing are left out.
+func scoreRisk(weights RiskWeights, in riskInputs) Risk {
+	total := weights.total()
+	if total == 0 {
+		return Risk{}
+	}
+
+	signals := []struct {
+		name   string
+		weight int64
+		value  float64
+	}{
+		{RiskSignalIPFill, weights.IPFill, ratio(in.counts.IP, in.limits.IPLimit)},
+		{RiskSignalLoginFill, weights.LoginFill, ratio(in.counts.Login, in.limits.LoginLimit)},
+		{RiskSignalPasswordFill, weights.PasswordFill, ratio(in.counts.Password, in.limits.PasswordLimit)},
+		{RiskSignalViolations, weights.Violations, ratio(violations(in.limits, in.counts), violationsSaturation)},
+		{RiskSignalBlacklistNear, weights.BlacklistNear, ratio(int64(in.blacklistedNear), blacklistNearSaturation)},
+		{RiskSignalLockouts, weights.Lockouts, ratio(in.counts.Lockouts, lockoutsSaturation)},
+	}
+
+	var risk Risk
+	for _, signal := range signals {
+		points := int(math.Round(float64(signal.weight) * signal.value * 100 / float64(total)))
+		if points == 0 {
+			continue
+		}
+
+		risk.Signals = append(risk.Signals, RiskSignal{Name: signal.name, Value: signal.value, Points: points})
+		risk.Score += points
+	}
+
+	// rounding may add up to a few points over 100
+	risk.Score = min(risk.Score, 100)
+	slices.SortStableFunc(risk.Signals, func(a, b RiskSignal) int {
+		return cmp.Compare(b.Points, a.Points)
+	})
+
+	return risk
+}
+
+// ratio is n/saturation capped at 1.
+func ratio(n, saturation int64) float64 {
+	if saturation <= 0 || n <= 0 {
+		return 0
+	}
+	return min(float64(n)/float64(saturation), 1)
+}
+
+// violations counts attempts over any rate limit within the window, this one included.
+func violations(limits RateLimitConfig, counts ratelimit.RequestCounts) int64 {
+	var over int64
+	for _, dimension := range []struct{ count, limit int64 }{
+		{counts.IP, limits.IPLimit},
+		{counts.Login, limits.LoginLimit},
+		{counts.Password, limits.PasswordLimit},
+	} {
+		over += max(dimension.count-dimension.limit+1, 0)
+	}
+	return over
+}
+
+// startsLockout tells whether counts are the first ones over a rate limit, later attempts of the same
+// lockout only make the counts larger.
+func startsLockout(limits RateLimitConfig, counts ratelimit.RequestCounts) bool {
+	return counts.IP == limits.IPLimit || counts.Login == limits.LoginLimit || counts.Password == limits.PasswordLimit
+}
+
+// blacklistedRisk is the risk of every attempt from a blacklisted IP.
+func (s *checkSettings) blacklistedRisk() Risk {
+	if s.riskWeights.total() == 0 {
+		return Risk{}
+	}
+	return Risk{Score: 100, Signals: []RiskSignal{{Name: RiskSignalBlacklisted, Value: 1, Points: 100}}}
+}
+
+// assessRisk scores an attempt that reached the rate limits with counts of a healthy or fallback storage.
+func (s *Service) assessRisk(current *checkSettings, attempt AccessAttempt, counts ratelimit.RequestCounts) Risk {
+	if current.riskWeights.total() == 0 {
+		return Risk{}
+	}
+
+	in := riskInputs{limits: current.effectiveLimits(attempt, counts), counts: counts}
+	if current.riskWeights.BlacklistNear > 0 {
+		in.blacklistedNear = s.blacklistIndex.BlacklistedNear(attempt.Tenant, attempt.IP)
+	}
+
+	return scoreRisk(current.riskWeights, in)
+}
+
+// recordLockouts remembers lockouts started by verdicts for later risk scores. They only feed risk scores,
+// so a failure is logged and the verdicts stand.
+func (s *Service) recordLockouts(ctx context.Context, attempts []AccessAttempt, verdicts []Verdict) {
+	var keys []ratelimit.AttemptKey
+	for i, verdict := range verdicts {
+		if verdict.lockout {
+			keys = append(keys, ratelimit.AttemptKey{
+				Tenant: attempts[i].Tenant,
+				Login:  attempts[i].Login,
+				IP:     attempts[i].IP,
+			})
+		}
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	if err := s.historyStorage.RecordLockouts(ctx, keys); err != nil {
+		s.logger.Warn("failed to record lockouts", "count", len(keys), "error", err)
+	}
+}
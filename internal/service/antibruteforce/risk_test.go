@@ -0,0 +1,157 @@
+package antibruteforce
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+type staticBlacklistIndex int
+
+func (i staticBlacklistIndex) BlacklistedNear(string, string) int {
+	return int(i)
+}
+
+//nolint:funlen
+func TestScoreRisk(t *testing.T) {
+	t.Parallel()
+
+	weights := RiskWeights{IPFill: 15, LoginFill: 25, PasswordFill: 10, Violations: 20, BlacklistNear: 10, Lockouts: 20}
+	limits := RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000}
+
+	tests := []struct {
+		name    string
+		weights RiskWeights
+		in      riskInputs
+		want    Risk
+	}{
+		{
+			name:    "nothing seen",
+			weights: weights,
+			in:      riskInputs{limits: limits},
+			want:    Risk{},
+		},
+		{
+			name:    "every signal, the largest first",
+			weights: weights,
+			in: riskInputs{
+				limits:          limits,
+				counts:          ratelimit.RequestCounts{Login: 5, Password: 50, IP: 100, Lockouts: 2},
+				blacklistedNear: 1,
+			},
+			want: Risk{Score: 31, Signals: []RiskSignal{
+				{Name: RiskSignalLoginFill, Value: 0.5, Points: 13},
+				{Name: RiskSignalLockouts, Value: 0.4, Points: 8},
+				{Name: RiskSignalPasswordFill, Value: 0.5, Points: 5},
+				{Name: RiskSignalBlacklistNear, Value: 0.25, Points: 3},
+				{Name: RiskSignalIPFill, Value: 0.1, Points: 2},
+			}},
+		},
+		{
+			name:    "violations over the limit",
+			weights: weights,
+			in:      riskInputs{limits: limits, counts: ratelimit.RequestCounts{Login: 12}},
+			want: Risk{Score: 31, Signals: []RiskSignal{
+				{Name: RiskSignalLoginFill, Value: 1, Points: 25},
+				{Name: RiskSignalViolations, Value: 0.3, Points: 6},
+			}},
+		},
+		{
+			name:    "zero weight drops its signal",
+			weights: RiskWeights{LoginFill: 1},
+			in:      riskInputs{limits: limits, counts: ratelimit.RequestCounts{Login: 5, IP: 999}},
+			want:    Risk{Score: 50, Signals: []RiskSignal{{Name: RiskSignalLoginFill, Value: 0.5, Points: 50}}},
+		},
+		{
+			name:    "rounding is capped at 100",
+			weights: RiskWeights{IPFill: 1, LoginFill: 1, PasswordFill: 1, Violations: 1, BlacklistNear: 1, Lockouts: 1},
+			in: riskInputs{
+				limits:          limits,
+				counts:          ratelimit.RequestCounts{Login: 20, Password: 100, IP: 1000, Lockouts: 5},
+				blacklistedNear: 4,
+			},
+			want: Risk{Score: 100, Signals: []RiskSignal{
+				{Name: RiskSignalIPFill, Value: 1, Points: 17},
+				{Name: RiskSignalLoginFill, Value: 1, Points: 17},
+				{Name: RiskSignalPasswordFill, Value: 1, Points: 17},
+				{Name: RiskSignalViolations, Value: 1, Points: 17},
+				{Name: RiskSignalBlacklistNear, Value: 1, Points: 17},
+				{Name: RiskSignalLockouts, Value: 1, Points: 17},
+			}},
+		},
+		{
+			name:    "scoring off",
+			weights: RiskWeights{},
+			in:      riskInputs{limits: limits, counts: ratelimit.RequestCounts{Login: 20}},
+			want:    Risk{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := scoreRisk(tt.weights, tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scoreRisk() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAccessRisk(t *testing.T) {
+	t.Parallel()
+
+	subnets := &mockSubnetProvider{}
+	counts := &mockRateLimitStorage{counts: ratelimit.RequestCounts{Login: 10, Lockouts: 5}}
+	history := &mockHistoryStorage{}
+	svc := newTestService(t, Dependencies{
+		SubnetProvider:   subnets,
+		RateLimitStorage: counts,
+		HistoryStorage:   history,
+		BlacklistIndex:   staticBlacklistIndex(4),
+	})
+	ctx := context.Background()
+	alice := AccessAttempt{Login: "alice", Password: "secret", IP: "10.0.0.1"}
+
+	verdict, err := svc.CheckAccess(ctx, alice)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Risk.Score != 0 {
+		t.Errorf("CheckAccess() risk = %+v without risk weights, want none", verdict.Risk)
+	}
+
+	svc.SetRiskWeights(RiskWeights{LoginFill: 1, BlacklistNear: 1, Lockouts: 2, Violations: -5})
+	verdict, err = svc.CheckAccess(ctx, alice)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Risk.Score != 100 || len(verdict.Risk.Signals) != 3 {
+		t.Errorf("CheckAccess() risk = %+v, want 100 from login fill, blacklist proximity and lockouts", verdict.Risk)
+	}
+
+	if len(history.lockouts) != 2 || history.lockouts[0] != (ratelimit.AttemptKey{Login: "alice", IP: "10.0.0.1"}) {
+		t.Errorf("recorded lockouts %v, want one for every attempt that reached the limit", history.lockouts)
+	}
+
+	counts.counts.Login = 11
+	if _, err := svc.CheckAccess(ctx, alice); err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if len(history.lockouts) != 2 {
+		t.Errorf("recorded %d lockouts, want none for attempts after the one that reached the limit",
+			len(history.lockouts))
+	}
+
+	subnets.inBlacklist = true
+	verdict, err = svc.CheckAccess(ctx, alice)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	want := Risk{Score: 100, Signals: []RiskSignal{{Name: RiskSignalBlacklisted, Value: 1, Points: 100}}}
+	if !reflect.DeepEqual(verdict.Risk, want) {
+		t.Errorf("CheckAccess() risk of a blacklisted IP = %+v, want %+v", verdict.Risk, want)
+	}
+}
@@ -0,0 +1,100 @@
+package antibruteforce
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+)
+
+// limitRule is a settings.LimitRule ready to be matched, a nil subnet matches any IP.
+type limitRule struct {
+	subnet   *net.IPNet
+	clientID string
+	limits   settings.Limits
+}
+
+func (r limitRule) matches(ip net.IP, clientID string) bool {
+	if r.clientID != "" && r.clientID != clientID {
+		return false
+	}
+	return r.subnet == nil || r.subnet.Contains(ip)
+}
+
+// specificity orders rules: client only rules, then subnet only rules, then rules with both,
+// longer subnets win within each group.
+func (r limitRule) specificity() (group, prefix int) {
+	if r.subnet != nil {
+		prefix, _ = r.subnet.Mask.Size()
+		group++
+	}
+	if r.clientID != "" && r.subnet != nil {
+		group++
+	}
+	return group, prefix
+}
+
+// compileLimitRules sorts rules from the least specific to the most specific one.
+func compileLimitRules(rules []settings.LimitRule) ([]limitRule, error) {
+	compiled := make([]limitRule, len(rules))
+	for i, rule := range rules {
+		compiled[i] = limitRule{clientID: rule.ClientID, limits: rule.Limits}
+		if rule.Subnet == "" {
+			continue
+		}
+
+		_, subnet, err := net.ParseCIDR(rule.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q of limit rule: %w", rule.Subnet, err)
+		}
+		compiled[i].subnet = subnet
+	}
+
+	slices.SortStableFunc(compiled, func(a, b limitRule) int {
+		aGroup, aPrefix := a.specificity()
+		bGroup, bPrefix := b.specificity()
+		return cmp.Or(cmp.Compare(aGroup, bGroup), cmp.Compare(aPrefix, bPrefix))
+	})
+
+	return compiled, nil
+}
+
+// limitsFor resolves the rate limits of one attempt, each limit comes from the most specific
+// matching rule that sets it and falls back to the limits of the tenant, then to rateLimits.
+func (s *checkSettings) limitsFor(attempt AccessAttempt) RateLimitConfig {
+	tenantLimits := s.tenants[attempt.Tenant]
+	if len(s.rules) == 0 && tenantLimits.IsZero() {
+		return s.rateLimits
+	}
+
+	ip := net.ParseIP(attempt.IP)
+	limits := s.rateLimits.limits().Merge(tenantLimits)
+	for _, rule := range s.rules {
+		if rule.matches(ip, attempt.ClientID) {
+			limits = limits.Merge(rule.limits)
+		}
+	}
+
+	return RateLimitConfig{LoginLimit: limits.Login, PasswordLimit: limits.Password, IPLimit: limits.IP}
+}
+
+// SetLimitRules replaces the limit rules for subsequent checks. Nothing is changed on error.
+func (s *Service) SetLimitRules(rules []settings.LimitRule) error {
+	compiled, err := compileLimitRules(rules)
+	if err != nil {
+		return err
+	}
+
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.rules = compiled
+	s.settings.Store(&next)
+
+	s.logger.Info("set limit rules", "count", len(compiled))
+
+	return nil
+}
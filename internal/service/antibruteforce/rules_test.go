@@ -0,0 +1,107 @@
+package antibruteforce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+)
+
+func TestLimitRulesMostSpecificWins(t *testing.T) {
+	t.Parallel()
+
+	svc := newTestService(t, Dependencies{})
+
+	err := svc.SetLimitRules([]settings.LimitRule{
+		{Subnet: "10.0.0.16/28", Limits: settings.Limits{IP: 5000}},
+		{Subnet: "10.0.0.0/8", Limits: settings.Limits{IP: 2000, Password: 200}},
+		{ClientID: "mobile", Limits: settings.Limits{Login: 3, IP: 500}},
+		{Subnet: "10.0.0.0/8", ClientID: "admin", Limits: settings.Limits{Login: 50}},
+	})
+	if err != nil {
+		t.Fatalf("SetLimitRules() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		attempt AccessAttempt
+		want    RateLimitConfig
+	}{
+		{
+			name:    "no rule matches",
+			attempt: AccessAttempt{IP: "192.168.1.1", ClientID: "web"},
+			want:    RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000},
+		},
+		{
+			name:    "longer subnet wins",
+			attempt: AccessAttempt{IP: "10.0.0.17"},
+			want:    RateLimitConfig{LoginLimit: 10, PasswordLimit: 200, IPLimit: 5000},
+		},
+		{
+			name:    "client only rule",
+			attempt: AccessAttempt{IP: "192.168.1.1", ClientID: "mobile"},
+			want:    RateLimitConfig{LoginLimit: 3, PasswordLimit: 100, IPLimit: 500},
+		},
+		{
+			name:    "subnet wins over client",
+			attempt: AccessAttempt{IP: "10.1.0.1", ClientID: "mobile"},
+			want:    RateLimitConfig{LoginLimit: 3, PasswordLimit: 200, IPLimit: 2000},
+		},
+		{
+			name:    "subnet and client win over subnet",
+			attempt: AccessAttempt{IP: "10.0.0.17", ClientID: "admin"},
+			want:    RateLimitConfig{LoginLimit: 50, PasswordLimit: 200, IPLimit: 5000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := svc.settings.Load().limitsFor(tt.attempt); got != tt.want {
+				t.Errorf("limitsFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitRulesApplyToChecks(t *testing.T) {
+	t.Parallel()
+
+	svc := newTestService(t, Dependencies{
+		RateLimitStorage: &mockRateLimitStorage{counts: ratelimit.RequestCounts{IP: 1500}},
+	})
+
+	if err := svc.SetLimitRules([]settings.LimitRule{{Subnet: "10.0.0.0"}}); err == nil {
+		t.Error("SetLimitRules() with an invalid subnet succeeded, want an error")
+	}
+	err := svc.SetLimitRules([]settings.LimitRule{{Subnet: "10.0.0.0/28", Limits: settings.Limits{IP: 5000}}})
+	if err != nil {
+		t.Fatalf("SetLimitRules() error = %v", err)
+	}
+
+	attempts := []AccessAttempt{
+		{Login: "user", Password: "pass", IP: "10.0.0.1"},
+		{Login: "user", Password: "pass", IP: "192.168.1.1"},
+	}
+	want := []AccessResult{AccessAllowed, AccessDeniedTooManyRequestsIP}
+
+	for i, attempt := range attempts {
+		verdict, err := svc.CheckAccess(context.Background(), attempt)
+		if err != nil {
+			t.Fatalf("CheckAccess() error = %v", err)
+		}
+		if verdict.Result != want[i] {
+			t.Errorf("CheckAccess(%s) = %v, want %v", attempt.IP, verdict.Result, want[i])
+		}
+	}
+
+	verdicts, err := svc.CheckAccessBatch(context.Background(), attempts)
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() error = %v", err)
+	}
+	for i, verdict := range verdicts {
+		if verdict.Result != want[i] {
+			t.Errorf("CheckAccessBatch()[%d] = %v, want %v", i, verdict.Result, want[i])
+		}
+	}
+}
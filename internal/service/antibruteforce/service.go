@@ -1,29 +1,246 @@
 package antibruteforce
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/FluVirus2/antibruteforce/internal/service"
 	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
+	"github.com/FluVirus2/antibruteforce/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = otel.Tracer("github.com/FluVirus2/antibruteforce/internal/service/antibruteforce")
+
 type AccessResult int
 
+const MaxBatchSize = 1000
+
 const (
 	AccessAllowed AccessResult = iota + 1
 	AccessDeniedIPBlacklisted
 	AccessDeniedTooManyRequestsIP
 	AccessDeniedTooManyRequestsLogin
 	AccessDeniedTooManyRequestsPassword
+	AccessDeniedBackendUnavailable
+	AccessChallengedTooManyRequestsIP
+	AccessChallengedTooManyRequestsLogin
+	AccessChallengedTooManyRequestsPassword
+	AccessDeniedCredentialStuffing
+	AccessDeniedBreachedPassword
+	AccessDeniedHoneypotLogin
+)
+
+func (r AccessResult) Allowed() bool {
+	return r == AccessAllowed
+}
+
+// Challenged tells that the attempt may proceed once the user passes a CAPTCHA or MFA.
+func (r AccessResult) Challenged() bool {
+	switch r {
+	case AccessChallengedTooManyRequestsIP, AccessChallengedTooManyRequestsLogin,
+		AccessChallengedTooManyRequestsPassword:
+		return true
+	default:
+		return false
+	}
+}
+
+// Outcome is one of "allowed", "challenged" or "denied".
+func (r AccessResult) Outcome() string {
+	switch {
+	case r.Allowed():
+		return "allowed"
+	case r.Challenged():
+		return "challenged"
+	default:
+		return "denied"
+	}
+}
+
+// Reason tells why access was denied or challenged, it is "none" for allowed attempts.
+func (r AccessResult) Reason() string {
+	switch r {
+	case AccessAllowed:
+		return "none"
+	case AccessDeniedIPBlacklisted:
+		return "ip_blacklisted"
+	case AccessDeniedTooManyRequestsIP, AccessChallengedTooManyRequestsIP:
+		return "too_many_requests_ip"
+	case AccessDeniedTooManyRequestsLogin, AccessChallengedTooManyRequestsLogin:
+		return "too_many_requests_login"
+	case AccessDeniedTooManyRequestsPassword, AccessChallengedTooManyRequestsPassword:
+		return "too_many_requests_password"
+	case AccessDeniedBackendUnavailable:
+		return "backend_unavailable"
+	case AccessDeniedCredentialStuffing:
+		return "credential_stuffing"
+	case AccessDeniedBreachedPassword:
+		return "breached_password"
+	case AccessDeniedHoneypotLogin:
+		return "honeypot_login"
+	default:
+		return "unknown"
+	}
+}
+
+// Severity ranks the decision for consumers such as a SIEM: denials are warnings,
+// while a honeypot login is always an attack.
+func (r AccessResult) Severity() Severity {
+	switch {
+	case r.Allowed():
+		return SeverityInfo
+	case r == AccessDeniedHoneypotLogin:
+		return SeverityCritical
+	default:
+		return SeverityWarning
+	}
+}
+
+func (r AccessResult) String() string {
+	switch {
+	case r.Allowed():
+		return "allowed"
+	case r.Reason() == "unknown":
+		return fmt.Sprintf("AccessResult(%d)", int(r))
+	default:
+		return r.Outcome() + "_" + r.Reason()
+	}
+}
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
 )
 
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// SubnetProvider checks IPs against the global lists and the lists of the tenant.
 type SubnetProvider interface {
-	CheckIPInBothLists(ctx context.Context, ip string) (inWhitelist bool, inBlacklist bool, err error)
+	CheckIPInBothLists(ctx context.Context, tenant, ip string) (inWhitelist bool, inBlacklist bool, err error)
+	CheckIPsInBothLists(ctx context.Context, tenant string, ips []string) ([]subnet.IPCheckResult, error)
 }
 
 type RateLimitStorage interface {
 	CountAndIncrement(ctx context.Context, keys ratelimit.RequestKeys) (ratelimit.RequestCounts, error)
+	CountAndIncrementBatch(ctx context.Context, keys []ratelimit.RequestKeys) ([]ratelimit.RequestCounts, error)
+}
+
+// HistoryStorage keeps what outlives a rate limit window: allowances of passed challenges,
+// so the login is not challenged again from the same IP right away, past lockouts and the spray alert.
+type HistoryStorage interface {
+	GrantAllowance(ctx context.Context, key ratelimit.AttemptKey, ttl time.Duration) error
+	HasAllowances(ctx context.Context, keys []ratelimit.AttemptKey) ([]bool, error)
+	RecordLockouts(ctx context.Context, keys []ratelimit.AttemptKey) error
+	RaiseSprayAlert(ctx context.Context, tenant string, ttl time.Duration) (bool, error)
+}
+
+// NopHistoryStorage remembers nothing, no allowance is ever found and no spray alert raised.
+type NopHistoryStorage struct{}
+
+func (NopHistoryStorage) GrantAllowance(context.Context, ratelimit.AttemptKey, time.Duration) error {
+	return nil
+}
+
+func (NopHistoryStorage) HasAllowances(_ context.Context, keys []ratelimit.AttemptKey) ([]bool, error) {
+	return make([]bool, len(keys)), nil
+}
+
+func (NopHistoryStorage) RecordLockouts(context.Context, []ratelimit.AttemptKey) error {
+	return nil
+}
+
+func (NopHistoryStorage) RaiseSprayAlert(context.Context, string, time.Duration) (bool, error) {
+	return false, nil
+}
+
+// Verdict is the answer to one access attempt.
+type Verdict struct {
+	Result AccessResult
+	// Degraded is set when a dependency failed and Result was chosen by the DegradationPolicy.
+	Degraded bool
+	// Risk is scored when risk weights are set, see SetRiskWeights.
+	Risk   Risk
+	Quotas Quotas
+	// PasswordBreached is set when the password is in the breached corpus, see SetBreachedAction.
+	PasswordBreached bool
+	// shadow is the answer of the ShadowPolicy, zero when it was not evaluated. It is only reported.
+	shadow AccessResult
+	// lockout is set when this attempt is the first one over a rate limit.
+	lockout bool
+	// sprayed is set when this attempt detected password spraying while no alert was active.
+	sprayed bool
+	// Invalid is the error CheckAccess would return for an attempt of a batch, the other fields are zero then.
+	Invalid error
+}
+
+// DecisionObserver is notified about every decision CheckAccess and CheckAccessBatch make,
+// and about every dependency failure answered by the DegradationPolicy instead of an error.
+type DecisionObserver interface {
+	ObserveDecision(result AccessResult)
+	ObserveShadowDecision(enforced, shadow AccessResult)
+	ObserveDegradation(dependency string, policy FailurePolicy)
+}
+
+type NopDecisionObserver struct{}
+
+func (NopDecisionObserver) ObserveDecision(AccessResult) {}
+
+func (NopDecisionObserver) ObserveShadowDecision(AccessResult, AccessResult) {}
+
+func (NopDecisionObserver) ObserveDegradation(string, FailurePolicy) {}
+
+// Decision is the outcome of one attempt as reported to DecisionEmitter. It never carries the password.
+type Decision struct {
+	Time     time.Time
+	Tenant   string
+	IP       string
+	Login    string
+	Result   AccessResult
+	Degraded bool
+	// Shadow is the answer of the ShadowPolicy, zero when it was not evaluated.
+	Shadow AccessResult
+}
+
+// DecisionEmitter forwards decisions to external consumers, EmitDecision must never block.
+type DecisionEmitter interface {
+	EmitDecision(decision Decision)
+}
+
+type NopDecisionEmitter struct{}
+
+func (NopDecisionEmitter) EmitDecision(Decision) {}
+
+type AccessAttempt struct {
+	// Tenant separates lists and rate limit buckets of different products, it is empty for the default tenant.
+	Tenant   string
+	Login    string
+	Password string
+	IP       string
+	// ClientID names the application the attempt came through, it may be empty.
+	ClientID string
 }
 
 type RateLimitConfig struct {
@@ -36,59 +253,401 @@ type Service struct {
 	logger           *slog.Logger
 	subnetProvider   SubnetProvider
 	rateLimitStorage RateLimitStorage
-	rateLimitConfig  RateLimitConfig
+	historyStorage   HistoryStorage
+	blacklistIndex   BlacklistIndex
+	// breachedPasswords is NopBreachedPasswords when no corpus is configured.
+	breachedPasswords BreachedPasswords
+	honeypotBlacklist HoneypotBlacklist
+	decisionObserver  DecisionObserver
+	decisionEmitter   DecisionEmitter
+	settings          atomic.Pointer[checkSettings]
+	// settingsMu serializes Reconfigure and OverrideLimits, readers only load settings.
+	settingsMu sync.Mutex
+}
+
+// Dependencies are what a Service checks attempts against. SubnetProvider and RateLimitStorage are required,
+// the others default to stand-ins that do nothing: no logging, no history, no blacklisted neighbours,
+// no breached passwords, no honeypot blacklisting and nobody told about decisions.
+type Dependencies struct {
+	Logger            *slog.Logger
+	SubnetProvider    SubnetProvider
+	RateLimitStorage  RateLimitStorage
+	HistoryStorage    HistoryStorage
+	BlacklistIndex    BlacklistIndex
+	BreachedPasswords BreachedPasswords
+	HoneypotBlacklist HoneypotBlacklist
+	DecisionObserver  DecisionObserver
+	DecisionEmitter   DecisionEmitter
+}
+
+func NewService(deps Dependencies, rateLimitConfig RateLimitConfig, degradation DegradationPolicy) *Service {
+	s := &Service{
+		logger:            cmp.Or(deps.Logger, slog.New(slog.DiscardHandler)),
+		subnetProvider:    deps.SubnetProvider,
+		rateLimitStorage:  deps.RateLimitStorage,
+		historyStorage:    cmp.Or[HistoryStorage](deps.HistoryStorage, NopHistoryStorage{}),
+		blacklistIndex:    cmp.Or[BlacklistIndex](deps.BlacklistIndex, NopBlacklistIndex{}),
+		breachedPasswords: cmp.Or[BreachedPasswords](deps.BreachedPasswords, NopBreachedPasswords{}),
+		honeypotBlacklist: cmp.Or[HoneypotBlacklist](deps.HoneypotBlacklist, NopHoneypotBlacklist{}),
+		decisionObserver:  cmp.Or[DecisionObserver](deps.DecisionObserver, NopDecisionObserver{}),
+		decisionEmitter:   cmp.Or[DecisionEmitter](deps.DecisionEmitter, NopDecisionEmitter{}),
+	}
+	s.settings.Store(&checkSettings{
+		configured:  rateLimitConfig,
+		rateLimits:  rateLimitConfig,
+		degradation: degradation,
+		honeypotTTL: DefaultHoneypotBlacklistTTL,
+	})
+
+	return s
+}
+
+func validateAttempt(attempt AccessAttempt) error {
+	if net.ParseIP(attempt.IP) == nil {
+		return service.NewInvalidArgumentError("ip", service.ErrInvalidIP)
+	}
+
+	return nil
+}
+
+func (s *Service) CheckAccess(ctx context.Context, attempt AccessAttempt) (_ Verdict, err error) {
+	ctx, span := tracer.Start(ctx, "antibruteforce.Service/CheckAccess")
+	defer tracing.EndSpan(span, &err)
+
+	verdict, err := s.checkAccess(ctx, attempt)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	span.SetAttributes(
+		attribute.String("abf.decision", verdict.Result.String()),
+		attribute.Bool("abf.degraded", verdict.Degraded),
+	)
+	s.report(time.Now(), attempt, verdict)
+
+	return verdict, nil
+}
+
+func (s *Service) report(now time.Time, attempt AccessAttempt, verdict Verdict) {
+	s.decisionObserver.ObserveDecision(verdict.Result)
+	if verdict.shadow != 0 {
+		s.decisionObserver.ObserveShadowDecision(verdict.Result, verdict.shadow)
+	}
+	s.decisionEmitter.EmitDecision(Decision{
+		Time:     now,
+		Tenant:   attempt.Tenant,
+		IP:       attempt.IP,
+		Login:    attempt.Login,
+		Result:   verdict.Result,
+		Degraded: verdict.Degraded,
+		Shadow:   verdict.shadow,
+	})
+}
+
+func (s *Service) checkAccess(ctx context.Context, attempt AccessAttempt) (Verdict, error) {
+	if err := validateAttempt(attempt); err != nil {
+		return Verdict{}, err
+	}
+
+	current := s.settings.Load()
+	if err := current.validateTenant(attempt.Tenant); err != nil {
+		return Verdict{}, err
+	}
+
+	membership, listsState, err := callWithPolicy(ctx, s, DependencySubnetLists, current.degradation.SubnetLists,
+		s.subnetProvider, current.degradation.SubnetFallback,
+		func(provider SubnetProvider) (subnet.IPCheckResult, error) {
+			inWhitelist, inBlacklist, err := provider.CheckIPInBothLists(ctx, attempt.Tenant, attempt.IP)
+			return subnet.IPCheckResult{InWhitelist: inWhitelist, InBlacklist: inBlacklist}, err
+		})
+	if err != nil {
+		return Verdict{}, fmt.Errorf("%w: %w", service.ErrSubnetCheckFailed, err)
+	}
+
+	degraded := listsState != notDegraded
+	switch {
+	case listsState == degradedClosed:
+		return Verdict{Result: AccessDeniedBackendUnavailable, Degraded: true}, nil
+	case membership.InWhitelist:
+		return current.withShadow(attempt, membership, nil, Verdict{Result: AccessAllowed, Degraded: degraded}), nil
+	case membership.InBlacklist:
+		verdict := Verdict{Result: AccessDeniedIPBlacklisted, Degraded: degraded, Risk: current.blacklistedRisk()}
+		return current.withShadow(attempt, membership, nil, verdict), nil
+	case current.isHoneypot(attempt):
+		verdicts := []Verdict{current.honeypotVerdict(degraded)}
+		s.blacklistHoneypotSources(current, []AccessAttempt{attempt}, verdicts)
+		return verdicts[0], nil
+	}
+
+	keys := current.requestKeys(attempt)
+
+	counts, limitsState, err := callWithPolicy(ctx, s, DependencyRateLimits, current.degradation.RateLimits,
+		s.rateLimitStorage, current.degradation.RateLimitFallback,
+		func(storage RateLimitStorage) (ratelimit.RequestCounts, error) {
+			return storage.CountAndIncrement(ctx, keys)
+		})
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to check rate limits: %w", err)
+	}
+
+	verdict := current.evaluateDegradedRateLimits(attempt, counts, limitsState, degraded)
+	if limitsState == notDegraded || limitsState == degradedFallback {
+		verdict.Risk = s.assessRisk(current, attempt, counts)
+	}
+	verdict = s.checkBreached(current, attempt, verdict)
+	verdicts := []Verdict{current.withShadow(attempt, membership, &counts, verdict)}
+	s.liftChallenges(ctx, []AccessAttempt{attempt}, verdicts)
+	s.recordLockouts(ctx, []AccessAttempt{attempt}, verdicts)
+	s.raiseSprayAlert(ctx, current, []AccessAttempt{attempt}, verdicts)
+
+	return verdicts[0], nil
 }
 
-func NewService(
-	logger *slog.Logger,
-	subnetProvider SubnetProvider,
-	rateLimitStorage RateLimitStorage,
-	rateLimitConfig RateLimitConfig,
-) *Service {
-	return &Service{
-		logger:           logger,
-		subnetProvider:   subnetProvider,
-		rateLimitStorage: rateLimitStorage,
-		rateLimitConfig:  rateLimitConfig,
+// CheckAccessBatch evaluates attempts as if CheckAccess was called for each of them in order,
+// but resolves subnet lists and rate limit counters once for the whole batch.
+func (s *Service) CheckAccessBatch(ctx context.Context, attempts []AccessAttempt) (_ []Verdict, err error) {
+	ctx, span := tracer.Start(ctx, "antibruteforce.Service/CheckAccessBatch")
+	span.SetAttributes(attribute.Int("abf.batch.size", len(attempts)))
+	defer tracing.EndSpan(span, &err)
+
+	verdicts, err := s.checkAccessBatch(ctx, attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i, verdict := range verdicts {
+		if verdict.Invalid == nil {
+			s.report(now, attempts[i], verdict)
+		}
+	}
+
+	return verdicts, nil
+}
+
+func (s *Service) checkAccessBatch(ctx context.Context, attempts []AccessAttempt) ([]Verdict, error) {
+	if len(attempts) == 0 {
+		return nil, nil
+	}
+
+	if len(attempts) > MaxBatchSize {
+		return nil, service.NewInvalidArgumentError("requests", service.ErrBatchTooLarge)
+	}
+
+	current := s.settings.Load()
+
+	// invalid attempts are answered on their own, like CheckAccess would, the others are checked together
+	verdicts := make([]Verdict, len(attempts))
+	valid := make([]int, 0, len(attempts))
+	validAttempts := make([]AccessAttempt, 0, len(attempts))
+	for i, attempt := range attempts {
+		err := validateAttempt(attempt)
+		if err == nil {
+			err = current.validateTenant(attempt.Tenant)
+		}
+		if err != nil {
+			verdicts[i] = Verdict{Invalid: err}
+			continue
+		}
+
+		valid = append(valid, i)
+		validAttempts = append(validAttempts, attempt)
+	}
+
+	checked, err := s.checkValidAttempts(ctx, current, validAttempts)
+	if err != nil {
+		return nil, err
 	}
+	for j, i := range valid {
+		verdicts[i] = checked[j]
+	}
+
+	return verdicts, nil
 }
 
-func (s *Service) CheckAccess(ctx context.Context, login, password, ip string) (AccessResult, error) {
-	inWhitelist, inBlacklist, err := s.subnetProvider.CheckIPInBothLists(ctx, ip)
+// checkValidAttempts is checkAccessBatch for attempts that passed validation.
+func (s *Service) checkValidAttempts(
+	ctx context.Context, current *checkSettings, attempts []AccessAttempt,
+) ([]Verdict, error) {
+	if len(attempts) == 0 {
+		return nil, nil
+	}
+
+	// lists are resolved once per tenant, tenants keep the order they first appear in
+	var tenants []string
+	byTenant := make(map[string][]int)
+	for i, attempt := range attempts {
+		if _, ok := byTenant[attempt.Tenant]; !ok {
+			tenants = append(tenants, attempt.Tenant)
+		}
+		byTenant[attempt.Tenant] = append(byTenant[attempt.Tenant], i)
+	}
+
+	memberships, listsState, err := callWithPolicy(ctx, s, DependencySubnetLists, current.degradation.SubnetLists,
+		s.subnetProvider, current.degradation.SubnetFallback,
+		func(provider SubnetProvider) ([]subnet.IPCheckResult, error) {
+			results := make([]subnet.IPCheckResult, len(attempts))
+			for _, tenant := range tenants {
+				indexes := byTenant[tenant]
+				ips := make([]string, len(indexes))
+				for j, i := range indexes {
+					ips[j] = attempts[i].IP
+				}
+
+				tenantResults, err := provider.CheckIPsInBothLists(ctx, tenant, ips)
+				if err != nil {
+					return nil, err
+				}
+				for j, i := range indexes {
+					results[i] = tenantResults[j]
+				}
+			}
+			return results, nil
+		})
 	if err != nil {
-		return 0, fmt.Errorf("failed to check IP in subnets: %w", err)
+		return nil, fmt.Errorf("%w: %w", service.ErrSubnetCheckFailed, err)
 	}
 
-	if inWhitelist {
-		return AccessAllowed, nil
+	verdicts := make([]Verdict, len(attempts))
+	degraded := listsState != notDegraded
+	if listsState == degradedClosed {
+		for i := range verdicts {
+			verdicts[i] = Verdict{Result: AccessDeniedBackendUnavailable, Degraded: true}
+		}
+		return verdicts, nil
+	}
+	if listsState == degradedOpen {
+		memberships = make([]subnet.IPCheckResult, len(attempts))
 	}
 
-	if inBlacklist {
-		return AccessDeniedIPBlacklisted, nil
+	limited := make([]int, 0, len(attempts))
+	keys := make([]ratelimit.RequestKeys, 0, len(attempts))
+	for i, attempt := range attempts {
+		switch {
+		case memberships[i].InWhitelist:
+			verdicts[i] = current.withShadow(attempt, memberships[i], nil,
+				Verdict{Result: AccessAllowed, Degraded: degraded})
+		case memberships[i].InBlacklist:
+			verdicts[i] = current.withShadow(attempt, memberships[i], nil,
+				Verdict{Result: AccessDeniedIPBlacklisted, Degraded: degraded, Risk: current.blacklistedRisk()})
+		case current.isHoneypot(attempt):
+			verdicts[i] = current.honeypotVerdict(degraded)
+		default:
+			limited = append(limited, i)
+			keys = append(keys, current.requestKeys(attempt))
+		}
 	}
 
-	keys := ratelimit.RequestKeys{
-		IP:       ip,
-		Login:    login,
-		Password: password,
+	s.blacklistHoneypotSources(current, attempts, verdicts)
+
+	if len(keys) == 0 {
+		return verdicts, nil
 	}
 
-	counts, err := s.rateLimitStorage.CountAndIncrement(ctx, keys)
+	counts, limitsState, err := callWithPolicy(ctx, s, DependencyRateLimits, current.degradation.RateLimits,
+		s.rateLimitStorage, current.degradation.RateLimitFallback,
+		func(storage RateLimitStorage) ([]ratelimit.RequestCounts, error) {
+			return storage.CountAndIncrementBatch(ctx, keys)
+		})
 	if err != nil {
-		return 0, fmt.Errorf("failed to check rate limits: %w", err)
+		return nil, fmt.Errorf("failed to check rate limits: %w", err)
+	}
+
+	for j, i := range limited {
+		var c ratelimit.RequestCounts
+		counted := limitsState == notDegraded || limitsState == degradedFallback
+		if counted {
+			c = counts[j]
+		}
+		verdict := current.evaluateDegradedRateLimits(attempts[i], c, limitsState, degraded)
+		if counted {
+			verdict.Risk = s.assessRisk(current, attempts[i], c)
+		}
+		verdict = s.checkBreached(current, attempts[i], verdict)
+		verdicts[i] = current.withShadow(attempts[i], memberships[i], &c, verdict)
+	}
+	s.liftChallenges(ctx, attempts, verdicts)
+	s.recordLockouts(ctx, attempts, verdicts)
+	s.raiseSprayAlert(ctx, current, attempts, verdicts)
+
+	return verdicts, nil
+}
+
+// requestKeys name the buckets of the attempt with the limits it is checked against, with and without
+// a spray alert, so that refills are read for them.
+func (s *checkSettings) requestKeys(attempt AccessAttempt) ratelimit.RequestKeys {
+	limits := s.limitsFor(attempt)
+	alertLimits := s.spray.tighten(limits, ratelimit.RequestCounts{SprayAlert: true})
+
+	return ratelimit.RequestKeys{
+		Tenant:      attempt.Tenant,
+		IP:          attempt.IP,
+		Login:       attempt.Login,
+		Password:    attempt.Password,
+		Limits:      limits.bucketLimits(),
+		AlertLimits: alertLimits.bucketLimits(),
+	}
+}
+
+// evaluateDegradedRateLimits applies the outcome of the rate limit call,
+// degraded tells whether the subnet check was already degraded.
+func (s *checkSettings) evaluateDegradedRateLimits(
+	attempt AccessAttempt, counts ratelimit.RequestCounts, state degradation, degraded bool,
+) Verdict {
+	switch state {
+	case degradedClosed:
+		return Verdict{Result: AccessDeniedBackendUnavailable, Degraded: true}
+	case degradedOpen:
+		return Verdict{Result: AccessAllowed, Degraded: true}
+	default:
+		limits := s.effectiveLimits(attempt, counts)
+		return Verdict{
+			Result:   s.evaluateCounts(limits, counts),
+			Degraded: degraded || state == degradedFallback,
+			Quotas:   quotasOf(limits, counts),
+			// local fallback counters do not outlive the outage, so lockouts are only recorded from Redis
+			lockout: state == notDegraded && startsLockout(limits, counts),
+			sprayed: s.spray.detected(counts),
+		}
+	}
+}
+
+// evaluateCounts denies attempts over limits or from a source trying too many logins, then challenges attempts
+// over challenge limits.
+func (s *checkSettings) evaluateCounts(limits RateLimitConfig, counts ratelimit.RequestCounts) AccessResult {
+	result := evaluateRateLimits(limits, s.challenge.Limits, counts)
+	if (result.Allowed() || result.Challenged()) && s.stuffing.exceeded(counts) {
+		return AccessDeniedCredentialStuffing
+	}
+	return result
+}
+
+// evaluateRateLimits denies attempts over limits, then challenges attempts over challenge limits,
+// zero challenge limits never challenge.
+func evaluateRateLimits(limits, challenge RateLimitConfig, counts ratelimit.RequestCounts) AccessResult {
+	if counts.IP >= limits.IPLimit {
+		return AccessDeniedTooManyRequestsIP
+	}
+
+	if counts.Login >= limits.LoginLimit {
+		return AccessDeniedTooManyRequestsLogin
+	}
+
+	if counts.Password >= limits.PasswordLimit {
+		return AccessDeniedTooManyRequestsPassword
 	}
 
-	if counts.IP >= s.rateLimitConfig.IPLimit {
-		return AccessDeniedTooManyRequestsIP, nil
+	if challenge.IPLimit > 0 && counts.IP >= challenge.IPLimit {
+		return AccessChallengedTooManyRequestsIP
 	}
 
-	if counts.Login >= s.rateLimitConfig.LoginLimit {
-		return AccessDeniedTooManyRequestsLogin, nil
+	if challenge.LoginLimit > 0 && counts.Login >= challenge.LoginLimit {
+		return AccessChallengedTooManyRequestsLogin
 	}
 
-	if counts.Password >= s.rateLimitConfig.PasswordLimit {
-		return AccessDeniedTooManyRequestsPassword, nil
+	if challenge.PasswordLimit > 0 && counts.Password >= challenge.PasswordLimit {
+		return AccessChallengedTooManyRequestsPassword
 	}
 
-	return AccessAllowed, nil
+	return AccessAllowed
 }
@@ -7,22 +7,65 @@ import (
 	"os"
 	"testing"
 
+	"github.com/FluVirus2/antibruteforce/internal/service"
 	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
 )
 
+// newTestService builds a service with limits of 10 logins, 100 passwords and 1000 IPs per window.
+// Dependencies left out default to mocks that find nothing in the lists and count nothing.
+func newTestService(t *testing.T, deps Dependencies) *Service {
+	t.Helper()
+
+	if deps.SubnetProvider == nil {
+		deps.SubnetProvider = &mockSubnetProvider{}
+	}
+	if deps.RateLimitStorage == nil {
+		deps.RateLimitStorage = &mockRateLimitStorage{}
+	}
+
+	return NewService(deps, RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000}, DegradationPolicy{})
+}
+
 type mockSubnetProvider struct {
 	inWhitelist bool
 	inBlacklist bool
+	byIP        map[string]subnet.IPCheckResult
+	byTenant    map[string]subnet.IPCheckResult
 	err         error
 }
 
-func (m *mockSubnetProvider) CheckIPInBothLists(_ context.Context, _ string) (bool, bool, error) {
+func (m *mockSubnetProvider) CheckIPInBothLists(_ context.Context, tenant, _ string) (bool, bool, error) {
+	if result, ok := m.byTenant[tenant]; ok {
+		return result.InWhitelist, result.InBlacklist, m.err
+	}
 	return m.inWhitelist, m.inBlacklist, m.err
 }
 
+//nolint:lll
+func (m *mockSubnetProvider) CheckIPsInBothLists(_ context.Context, tenant string, ips []string) ([]subnet.IPCheckResult, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	results := make([]subnet.IPCheckResult, len(ips))
+	for i, ip := range ips {
+		if result, ok := m.byTenant[tenant]; ok {
+			results[i] = result
+		} else if m.byIP == nil {
+			results[i] = subnet.IPCheckResult{InWhitelist: m.inWhitelist, InBlacklist: m.inBlacklist}
+		} else {
+			results[i] = m.byIP[ip]
+		}
+	}
+	return results, nil
+}
+
 type mockRateLimitStorage struct {
-	counts ratelimit.RequestCounts
-	err    error
+	counts      ratelimit.RequestCounts
+	batchCounts map[string]ratelimit.RequestCounts
+	batchKeys   []ratelimit.RequestKeys
+	err         error
 }
 
 //nolint:lll
@@ -30,6 +73,24 @@ func (m *mockRateLimitStorage) CountAndIncrement(_ context.Context, _ ratelimit.
 	return m.counts, m.err
 }
 
+//nolint:lll
+func (m *mockRateLimitStorage) CountAndIncrementBatch(_ context.Context, keys []ratelimit.RequestKeys) ([]ratelimit.RequestCounts, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.batchKeys = keys
+	counts := make([]ratelimit.RequestCounts, len(keys))
+	for i, k := range keys {
+		if m.batchCounts == nil {
+			counts[i] = m.counts
+		} else {
+			counts[i] = m.batchCounts[k.Login]
+		}
+	}
+	return counts, nil
+}
+
 //nolint:funlen
 func TestCheckAccess(t *testing.T) {
 	t.Parallel()
@@ -184,18 +245,211 @@ func TestCheckAccess(t *testing.T) {
 		t.Run(testcase.Name, func(t *testing.T) {
 			t.Parallel()
 
-			svc := NewService(logger, testcase.SubnetProvider, testcase.RateLimiterStore, testcase.RateLimiterConfig)
+			svc := NewService(
+				Dependencies{
+					Logger:           logger,
+					SubnetProvider:   testcase.SubnetProvider,
+					RateLimitStorage: testcase.RateLimiterStore,
+				},
+				testcase.RateLimiterConfig,
+				DegradationPolicy{},
+			)
 
-			result, err := svc.CheckAccess(context.Background(), "user", "pass", "192.168.1.1")
+			attempt := AccessAttempt{Login: "user", Password: "pass", IP: "192.168.1.1"}
+			verdict, err := svc.CheckAccess(context.Background(), attempt)
 
 			if (err != nil) && !testcase.IsErrorExpected {
 				t.Errorf("CheckAccess() error = %v, wantErr %v", err, testcase.IsErrorExpected)
 				return
 			}
 
-			if result != testcase.ExpectedResult {
-				t.Errorf("CheckAccess() result = %v, want %v", result, testcase.ExpectedResult)
+			if verdict.Result != testcase.ExpectedResult {
+				t.Errorf("CheckAccess() result = %v, want %v", verdict.Result, testcase.ExpectedResult)
+			}
+		})
+	}
+}
+
+func TestCheckAccessBatch(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	config := RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000}
+
+	provider := &mockSubnetProvider{
+		byIP: map[string]subnet.IPCheckResult{
+			"10.0.0.1": {InWhitelist: true},
+			"10.0.0.2": {InBlacklist: true},
+		},
+	}
+	store := &mockRateLimitStorage{
+		batchCounts: map[string]ratelimit.RequestCounts{
+			"fresh":   {IP: 1, Login: 1, Password: 1},
+			"retried": {IP: 1, Login: 10, Password: 1},
+		},
+	}
+
+	attempts := []AccessAttempt{
+		{Login: "fresh", Password: "pass", IP: "192.168.1.1"},
+		{Login: "retried", Password: "pass", IP: "10.0.0.1"},
+		{Login: "retried", Password: "pass", IP: "10.0.0.2"},
+		{Login: "retried", Password: "pass", IP: "192.168.1.1"},
+	}
+	expected := []AccessResult{
+		AccessAllowed,
+		AccessAllowed,
+		AccessDeniedIPBlacklisted,
+		AccessDeniedTooManyRequestsLogin,
+	}
+
+	svc := NewService(
+		Dependencies{
+			Logger:           logger,
+			SubnetProvider:   provider,
+			RateLimitStorage: store,
+		},
+		config,
+		DegradationPolicy{},
+	)
+
+	results, err := svc.CheckAccessBatch(context.Background(), attempts)
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() unexpected error = %v", err)
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("CheckAccessBatch() returned %d results, want %d", len(results), len(expected))
+	}
+
+	for i := range expected {
+		if results[i].Result != expected[i] {
+			t.Errorf("CheckAccessBatch() result[%d] = %v, want %v", i, results[i].Result, expected[i])
+		}
+	}
+
+	if len(store.batchKeys) != 2 {
+		t.Errorf("CheckAccessBatch() counted %d attempts, want only the 2 not found in lists", len(store.batchKeys))
+	}
+}
+
+func TestCheckAccessBatchErrors(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	config := RateLimitConfig{LoginLimit: 10, PasswordLimit: 100, IPLimit: 1000}
+	attempts := []AccessAttempt{{Login: "user", Password: "pass", IP: "192.168.1.1"}}
+
+	tests := []struct {
+		Name           string
+		SubnetProvider *mockSubnetProvider
+		RateLimitStore *mockRateLimitStorage
+	}{
+		{
+			Name:           "error from subnet provider",
+			SubnetProvider: &mockSubnetProvider{err: errors.New("database error")},
+			RateLimitStore: &mockRateLimitStorage{},
+		},
+		{
+			Name:           "error from rate limit storage",
+			SubnetProvider: &mockSubnetProvider{},
+			RateLimitStore: &mockRateLimitStorage{err: errors.New("redis error")},
+		},
+	}
+
+	for _, testcase := range tests {
+		t.Run(testcase.Name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := NewService(
+				Dependencies{
+					Logger:           logger,
+					SubnetProvider:   testcase.SubnetProvider,
+					RateLimitStorage: testcase.RateLimitStore,
+				},
+				config,
+				DegradationPolicy{},
+			)
+
+			results, err := svc.CheckAccessBatch(context.Background(), attempts)
+			if err == nil {
+				t.Errorf("CheckAccessBatch() expected error, got results %v", results)
 			}
 		})
 	}
 }
+
+func TestCheckAccessBatchAnswersInvalidAttempts(t *testing.T) {
+	t.Parallel()
+
+	store := &mockRateLimitStorage{}
+	emitter := &recordingEmitter{}
+	svc := newTestService(t, Dependencies{
+		SubnetProvider:   &mockSubnetProvider{},
+		RateLimitStorage: store,
+		DecisionEmitter:  emitter,
+	})
+	svc.SetTenants(nil)
+
+	attempts := []AccessAttempt{
+		{Login: "alice", Password: "secret", IP: "10.0.0.1"},
+		{Login: "alice", Password: "secret", IP: "not an ip"},
+		{Tenant: "acme", Login: "alice", Password: "secret", IP: "10.0.0.2"},
+		{Login: "bob", Password: "secret", IP: "10.0.0.3"},
+	}
+	verdicts, err := svc.CheckAccessBatch(context.Background(), attempts)
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() unexpected error = %v", err)
+	}
+
+	for i, want := range []error{nil, service.ErrInvalidIP, service.ErrUnknownTenant, nil} {
+		_, unaryErr := svc.CheckAccess(context.Background(), attempts[i])
+		if !errors.Is(verdicts[i].Invalid, want) || !errors.Is(unaryErr, want) {
+			t.Errorf("verdicts[%d].Invalid = %v, CheckAccess() error = %v, want %v", i, verdicts[i].Invalid, unaryErr, want)
+		}
+		if want == nil && verdicts[i].Result != AccessAllowed {
+			t.Errorf("verdicts[%d] = %v, want %v", i, verdicts[i].Result, AccessAllowed)
+		}
+	}
+
+	if len(store.batchKeys) != 2 || store.batchKeys[0].IP != "10.0.0.1" || store.batchKeys[1].IP != "10.0.0.3" {
+		t.Errorf("counted %v, want only the valid attempts", store.batchKeys)
+	}
+	if len(emitter.decisions) != 2+2 {
+		t.Errorf("emitted %d decisions, want one per valid attempt of the batch and of CheckAccess", len(emitter.decisions))
+	}
+}
+
+type recordingEmitter struct {
+	decisions []Decision
+}
+
+func (e *recordingEmitter) EmitDecision(decision Decision) {
+	e.decisions = append(e.decisions, decision)
+}
+
+func TestCheckAccessEmitsDecisions(t *testing.T) {
+	t.Parallel()
+
+	emitter := &recordingEmitter{}
+	svc := newTestService(t, Dependencies{
+		SubnetProvider:  &mockSubnetProvider{inBlacklist: true},
+		DecisionEmitter: emitter,
+	})
+
+	attempt := AccessAttempt{Login: "alice", Password: "secret", IP: "10.0.0.1"}
+	if _, err := svc.CheckAccess(context.Background(), attempt); err != nil {
+		t.Fatalf("CheckAccess() unexpected error = %v", err)
+	}
+
+	if len(emitter.decisions) != 1 {
+		t.Fatalf("emitted %d decisions, want 1", len(emitter.decisions))
+	}
+
+	decision := emitter.decisions[0]
+	if decision.Login != "alice" || decision.IP != "10.0.0.1" || decision.Result != AccessDeniedIPBlacklisted {
+		t.Errorf("decision = %+v, want blacklisted alice from 10.0.0.1", decision)
+	}
+	if decision.Time.IsZero() {
+		t.Errorf("decision has no timestamp")
+	}
+}
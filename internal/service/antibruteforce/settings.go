@@ -0,0 +1,164 @@
+package antibruteforce
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+)
+
+var (
+	ErrInvalidRateLimit = errors.New("rate limit must be positive")
+	ErrNoFallback       = errors.New("fallback policy needs a fallback configured at startup")
+)
+
+// checkSettings are swapped as a whole, so a check never mixes old and new values.
+type checkSettings struct {
+	// configured come from the server configuration, overrides from the management API
+	// take precedence over them in rateLimits.
+	configured RateLimitConfig
+	overrides  settings.Limits
+	rateLimits RateLimitConfig
+	// tenants hold the limits of every known tenant, they replace rateLimits for its attempts.
+	tenants map[string]settings.Limits
+	// rules replace rateLimits and tenant limits for matching attempts, see limitsFor.
+	rules []limitRule
+	// shadow is nil when no shadow policy is set.
+	shadow      *ShadowPolicy
+	challenge   ChallengePolicy
+	stuffing    StuffingPolicy
+	spray       SprayPolicy
+	breached    BreachedAction
+	riskWeights RiskWeights
+	degradation DegradationPolicy
+	// honeypotLogins are denied and blacklist their source for honeypotTTL.
+	honeypotLogins map[string]struct{}
+	honeypotTTL    time.Duration
+}
+
+func (c RateLimitConfig) Validate() error {
+	limits := []struct {
+		name  string
+		value int64
+	}{
+		{"login", c.LoginLimit},
+		{"password", c.PasswordLimit},
+		{"ip", c.IPLimit},
+	}
+
+	for _, limit := range limits {
+		if limit.value <= 0 {
+			return fmt.Errorf("%w: %s limit is %d", ErrInvalidRateLimit, limit.name, limit.value)
+		}
+	}
+
+	return nil
+}
+
+func (c RateLimitConfig) withOverrides(overrides settings.Limits) RateLimitConfig {
+	limits := c.limits().Merge(overrides)
+
+	return RateLimitConfig{LoginLimit: limits.Login, PasswordLimit: limits.Password, IPLimit: limits.IP}
+}
+
+func (c RateLimitConfig) limits() settings.Limits {
+	return settings.Limits{Login: c.LoginLimit, Password: c.PasswordLimit, IP: c.IPLimit}
+}
+
+// Reconfigure replaces rate limits and failure policies for subsequent checks, checks in flight
+// finish with the previous ones. Fallbacks cannot be added at runtime, so switching a dependency
+// to FailurePolicyFallback is only accepted when it has one. Nothing is changed on error.
+// Limits overridden through OverrideLimits stay in effect.
+func (s *Service) Reconfigure(rateLimits RateLimitConfig, subnetLists, rateLimitsPolicy FailurePolicy) error {
+	if err := rateLimits.Validate(); err != nil {
+		return err
+	}
+
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.degradation.SubnetLists = subnetLists
+	next.degradation.RateLimits = rateLimitsPolicy
+
+	if subnetLists == FailurePolicyFallback && next.degradation.SubnetFallback == nil {
+		return fmt.Errorf("%w: %s", ErrNoFallback, DependencySubnetLists)
+	}
+
+	if rateLimitsPolicy == FailurePolicyFallback && next.degradation.RateLimitFallback == nil {
+		return fmt.Errorf("%w: %s", ErrNoFallback, DependencyRateLimits)
+	}
+
+	next.configured = rateLimits
+	next.rateLimits = rateLimits.withOverrides(next.overrides)
+	s.settings.Store(&next)
+	s.logger.Info("reconfigured access checks",
+		"login_limit", rateLimits.LoginLimit,
+		"password_limit", rateLimits.PasswordLimit,
+		"ip_limit", rateLimits.IPLimit,
+		"subnet_lists_policy", subnetLists.String(),
+		"rate_limits_policy", rateLimitsPolicy.String(),
+	)
+
+	return nil
+}
+
+// OverrideLimits puts overrides on top of the configured rate limits, replacing previous overrides.
+// Zero or negative fields leave the configured limit in effect.
+func (s *Service) OverrideLimits(overrides settings.Limits) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	current := s.settings.Load()
+	if current.overrides == overrides {
+		return
+	}
+
+	next := *current
+	next.overrides = overrides
+	next.rateLimits = current.configured.withOverrides(overrides)
+	s.settings.Store(&next)
+
+	s.logger.Info("overrode rate limits",
+		"login_limit", next.rateLimits.LoginLimit,
+		"password_limit", next.rateLimits.PasswordLimit,
+		"ip_limit", next.rateLimits.IPLimit,
+	)
+}
+
+// SetTenants replaces the known tenants for subsequent checks, attempts of other tenants are rejected.
+func (s *Service) SetTenants(tenants []settings.Tenant) {
+	limits := make(map[string]settings.Limits, len(tenants))
+	for _, tenant := range tenants {
+		limits[tenant.Name] = tenant.Limits
+	}
+
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.tenants = limits
+	s.settings.Store(&next)
+
+	s.logger.Info("set tenants", "count", len(limits))
+}
+
+// validateTenant accepts the default tenant and tenants passed to SetTenants. Until SetTenants is called
+// every tenant is accepted with the configured limits: tenants are loaded from Postgres like the subnet lists,
+// so the subnet lists check fails as well and the degradation policy decides.
+func (s *checkSettings) validateTenant(tenant string) error {
+	if tenant == "" || s.tenants == nil {
+		return nil
+	}
+	if _, ok := s.tenants[tenant]; !ok {
+		return service.NewInvalidArgumentError("tenant", service.ErrUnknownTenant)
+	}
+	return nil
+}
+
+// ConfiguredLimits returns the rate limits from the server configuration, without overrides.
+func (s *Service) ConfiguredLimits() settings.Limits {
+	return s.settings.Load().configured.limits()
+}
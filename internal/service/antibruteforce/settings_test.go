@@ -0,0 +1,148 @@
+package antibruteforce
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
+)
+
+func TestReconfigure(t *testing.T) {
+	t.Parallel()
+
+	storage := &mockRateLimitStorage{counts: ratelimit.RequestCounts{Login: 5}}
+	svc := newTestService(t, Dependencies{
+		RateLimitStorage: storage,
+	})
+
+	check := func(want AccessResult) {
+		t.Helper()
+
+		attempt := AccessAttempt{Login: "user", Password: "pass", IP: "192.168.1.1"}
+		verdict, err := svc.CheckAccess(context.Background(), attempt)
+		if err != nil {
+			t.Fatalf("CheckAccess() error = %v", err)
+		}
+		if verdict.Result != want {
+			t.Errorf("CheckAccess() = %v, want %v", verdict.Result, want)
+		}
+	}
+
+	check(AccessAllowed)
+
+	if err := svc.Reconfigure(RateLimitConfig{LoginLimit: 5, PasswordLimit: 100, IPLimit: 1000},
+		FailurePolicyError, FailurePolicyError); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	check(AccessDeniedTooManyRequestsLogin)
+
+	err := svc.Reconfigure(RateLimitConfig{LoginLimit: 50, PasswordLimit: 0, IPLimit: 1000},
+		FailurePolicyError, FailurePolicyError)
+	if !errors.Is(err, ErrInvalidRateLimit) {
+		t.Errorf("Reconfigure() with a zero limit error = %v, want ErrInvalidRateLimit", err)
+	}
+
+	err = svc.Reconfigure(RateLimitConfig{LoginLimit: 50, PasswordLimit: 100, IPLimit: 1000},
+		FailurePolicyError, FailurePolicyFallback)
+	if !errors.Is(err, ErrNoFallback) {
+		t.Errorf("Reconfigure() to a missing fallback error = %v, want ErrNoFallback", err)
+	}
+
+	// rejected reloads keep the previous limits
+	check(AccessDeniedTooManyRequestsLogin)
+
+	storage.err = errors.New("connection refused")
+	if err := svc.Reconfigure(RateLimitConfig{LoginLimit: 5, PasswordLimit: 100, IPLimit: 1000},
+		FailurePolicyError, FailurePolicyOpen); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	check(AccessAllowed)
+}
+
+func TestOverrideLimits(t *testing.T) {
+	t.Parallel()
+
+	svc := newTestService(t, Dependencies{
+		RateLimitStorage: &mockRateLimitStorage{counts: ratelimit.RequestCounts{IP: 50}},
+	})
+
+	check := func(want AccessResult) {
+		t.Helper()
+
+		attempt := AccessAttempt{Login: "user", Password: "pass", IP: "192.168.1.1"}
+		verdict, err := svc.CheckAccess(context.Background(), attempt)
+		if err != nil {
+			t.Fatalf("CheckAccess() error = %v", err)
+		}
+		if verdict.Result != want {
+			t.Errorf("CheckAccess() = %v, want %v", verdict.Result, want)
+		}
+	}
+
+	svc.OverrideLimits(settings.Limits{IP: 50})
+	check(AccessDeniedTooManyRequestsIP)
+
+	// configuration reloads keep overrides on top
+	if err := svc.Reconfigure(RateLimitConfig{LoginLimit: 20, PasswordLimit: 200, IPLimit: 2000},
+		FailurePolicyError, FailurePolicyError); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	check(AccessDeniedTooManyRequestsIP)
+	if want := (settings.Limits{Login: 20, Password: 200, IP: 2000}); svc.ConfiguredLimits() != want {
+		t.Errorf("ConfiguredLimits() = %+v, want %+v", svc.ConfiguredLimits(), want)
+	}
+
+	svc.OverrideLimits(settings.Limits{})
+	check(AccessAllowed)
+}
+
+func TestSetTenants(t *testing.T) {
+	t.Parallel()
+
+	limits := &mockRateLimitStorage{counts: ratelimit.RequestCounts{IP: 50}}
+	svc := newTestService(t, Dependencies{
+		SubnetProvider:   &mockSubnetProvider{byTenant: map[string]subnet.IPCheckResult{"shop": {InBlacklist: true}}},
+		RateLimitStorage: limits,
+	})
+
+	// until tenants are loaded the subnet lists check decides
+	attempt := AccessAttempt{Tenant: "shop", Login: "user", Password: "pass", IP: "192.168.1.1"}
+	verdict, err := svc.CheckAccess(context.Background(), attempt)
+	if err != nil || verdict.Result != AccessDeniedIPBlacklisted {
+		t.Fatalf("CheckAccess() before tenants are loaded = %v, %v, want %v", verdict.Result, err,
+			AccessDeniedIPBlacklisted)
+	}
+
+	svc.SetTenants(nil)
+	_, err = svc.CheckAccess(context.Background(), attempt)
+	var invalidArgErr *service.InvalidArgumentError
+	if !errors.Is(err, service.ErrUnknownTenant) || !errors.As(err, &invalidArgErr) {
+		t.Fatalf("CheckAccess() of an unknown tenant error = %v, want %v", err, service.ErrUnknownTenant)
+	}
+
+	svc.SetTenants([]settings.Tenant{{Name: "shop"}, {Name: "forum", Limits: settings.Limits{IP: 50}}})
+
+	attempts := []AccessAttempt{
+		{Tenant: "shop", Login: "user", Password: "pass", IP: "192.168.1.1"},
+		{Tenant: "forum", Login: "user", Password: "pass", IP: "192.168.1.1"},
+		{Login: "user", Password: "pass", IP: "192.168.1.1"},
+	}
+	want := []AccessResult{AccessDeniedIPBlacklisted, AccessDeniedTooManyRequestsIP, AccessAllowed}
+
+	verdicts, err := svc.CheckAccessBatch(context.Background(), attempts)
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() error = %v", err)
+	}
+	for i, verdict := range verdicts {
+		if verdict.Result != want[i] {
+			t.Errorf("CheckAccessBatch()[%d] = %v, want %v", i, verdict.Result, want[i])
+		}
+	}
+	if len(limits.batchKeys) != 2 || limits.batchKeys[0].Tenant != "forum" || limits.batchKeys[1].Tenant != "" {
+		t.Errorf("counted keys = %+v, want keys of tenants forum and the default one", limits.batchKeys)
+	}
+}
@@ -0,0 +1,80 @@
+package antibruteforce
+
+import (
+	"net"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/subnet"
+)
+
+// ShadowPolicy is a candidate policy evaluated next to the enforced one, so stricter limits
+// or new list entries can be trialled before they block anybody. Its results are reported to
+// DecisionObserver and DecisionEmitter, they never change a Verdict.
+type ShadowPolicy struct {
+	// RateLimits replace the effective limit of their dimension, zero fields keep it.
+	RateLimits RateLimitConfig
+	// Whitelist and Blacklist are added to the lists of every tenant, whitelist still wins.
+	Whitelist []*net.IPNet
+	Blacklist []*net.IPNet
+}
+
+func (p ShadowPolicy) IsZero() bool {
+	return p.RateLimits == RateLimitConfig{} && len(p.Whitelist) == 0 && len(p.Blacklist) == 0
+}
+
+// SetShadowPolicy replaces the shadow policy for subsequent checks, a zero policy turns shadow checks off.
+func (s *Service) SetShadowPolicy(policy ShadowPolicy) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.shadow = nil
+	if !policy.IsZero() {
+		next.shadow = &policy
+	}
+	s.settings.Store(&next)
+
+	s.logger.Info("set shadow policy",
+		"login_limit", policy.RateLimits.LoginLimit,
+		"password_limit", policy.RateLimits.PasswordLimit,
+		"ip_limit", policy.RateLimits.IPLimit,
+		"whitelist", len(policy.Whitelist),
+		"blacklist", len(policy.Blacklist),
+	)
+}
+
+// withShadow sets the shadow result of verdict from the list membership and the counts the enforced
+// check already gathered, counts is nil when the enforced check did not reach the rate limits.
+// Degraded verdicts were not chosen by any policy, so there is nothing to compare them with.
+func (s *checkSettings) withShadow(
+	attempt AccessAttempt, membership subnet.IPCheckResult, counts *ratelimit.RequestCounts, verdict Verdict,
+) Verdict {
+	if s.shadow == nil || verdict.Degraded {
+		return verdict
+	}
+
+	ip := net.ParseIP(attempt.IP)
+	switch {
+	case membership.InWhitelist || containsIP(s.shadow.Whitelist, ip):
+		verdict.shadow = AccessAllowed
+	case membership.InBlacklist || containsIP(s.shadow.Blacklist, ip):
+		verdict.shadow = AccessDeniedIPBlacklisted
+	case counts == nil:
+		// shadow lists only add entries, so this is the enforced answer for a listed IP
+		verdict.shadow = verdict.Result
+	default:
+		limits := s.spray.tighten(s.limitsFor(attempt).withOverrides(s.shadow.RateLimits.limits()), *counts)
+		verdict.shadow = s.evaluateCounts(limits, *counts)
+	}
+
+	return verdict
+}
+
+func containsIP(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package antibruteforce
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+type shadowObserver struct {
+	NopDecisionObserver
+
+	enforced, shadow []AccessResult
+}
+
+func (o *shadowObserver) ObserveShadowDecision(enforced, shadow AccessResult) {
+	o.enforced = append(o.enforced, enforced)
+	o.shadow = append(o.shadow, shadow)
+}
+
+func TestShadowPolicy(t *testing.T) {
+	t.Parallel()
+
+	observer := &shadowObserver{}
+	emitter := &recordingEmitter{}
+	svc := newTestService(t, Dependencies{
+		RateLimitStorage: &mockRateLimitStorage{counts: ratelimit.RequestCounts{Login: 5}},
+		DecisionObserver: observer,
+		DecisionEmitter:  emitter,
+	})
+
+	_, blacklisted, _ := net.ParseCIDR("10.0.0.0/8")
+	svc.SetShadowPolicy(ShadowPolicy{
+		RateLimits: RateLimitConfig{LoginLimit: 3},
+		Blacklist:  []*net.IPNet{blacklisted},
+	})
+
+	attempts := []AccessAttempt{
+		{Login: "alice", Password: "secret", IP: "192.168.1.1"},
+		{Login: "bob", Password: "secret", IP: "10.0.0.1"},
+	}
+	wantShadow := []AccessResult{AccessDeniedTooManyRequestsLogin, AccessDeniedIPBlacklisted}
+
+	for i, attempt := range attempts {
+		verdict, err := svc.CheckAccess(context.Background(), attempt)
+		if err != nil {
+			t.Fatalf("CheckAccess() error = %v", err)
+		}
+		if verdict.Result != AccessAllowed {
+			t.Errorf("CheckAccess(%s) = %v, want the enforced %v", attempt.Login, verdict.Result, AccessAllowed)
+		}
+		if verdict.shadow != wantShadow[i] {
+			t.Errorf("CheckAccess(%s) shadow = %v, want %v", attempt.Login, verdict.shadow, wantShadow[i])
+		}
+	}
+
+	verdicts, err := svc.CheckAccessBatch(context.Background(), attempts)
+	if err != nil {
+		t.Fatalf("CheckAccessBatch() error = %v", err)
+	}
+	for i, verdict := range verdicts {
+		if verdict.Result != AccessAllowed || verdict.shadow != wantShadow[i] {
+			t.Errorf("CheckAccessBatch()[%d] = %v shadow %v, want %v shadow %v",
+				i, verdict.Result, verdict.shadow, AccessAllowed, wantShadow[i])
+		}
+	}
+
+	if len(observer.shadow) != 4 {
+		t.Fatalf("observed %d shadow decisions, want 4", len(observer.shadow))
+	}
+	if observer.enforced[1] != AccessAllowed || observer.shadow[1] != AccessDeniedIPBlacklisted {
+		t.Errorf("observed %v shadowed by %v, want allowed shadowed by blacklisted",
+			observer.enforced[1], observer.shadow[1])
+	}
+	if emitter.decisions[0].Shadow != AccessDeniedTooManyRequestsLogin {
+		t.Errorf("emitted shadow = %v, want %v", emitter.decisions[0].Shadow, AccessDeniedTooManyRequestsLogin)
+	}
+
+	svc.SetShadowPolicy(ShadowPolicy{})
+	verdict, err := svc.CheckAccess(context.Background(), attempts[1])
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.shadow != 0 {
+		t.Errorf("CheckAccess() shadow = %v after the shadow policy was cleared, want none", verdict.shadow)
+	}
+}
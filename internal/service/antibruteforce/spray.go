@@ -0,0 +1,91 @@
+package antibruteforce
+
+import (
+	"context"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+// SprayPolicy watches for one password tried against many logins, which a single user never does.
+// A password over the login limit raises a spray alert for every tenant, while it is active all rate limits
+// are tightened, so the sprayer gets fewer attempts per password, login and IP.
+type SprayPolicy struct {
+	// LoginLimit is how many distinct logins a password may be tried against, zero never raises an alert.
+	LoginLimit int64
+	// Window is how long distinct logins are counted, see ratelimit.Storage.SetSprayTracking.
+	Window time.Duration
+	// AlertDuration is how long an alert lasts, it is raised again if spraying goes on after it.
+	AlertDuration time.Duration
+	// AlertLimitPercent is the share of every rate limit kept during an alert, limits never drop below 1.
+	AlertLimitPercent int64
+}
+
+// Enabled tells whether distinct logins per password need to be counted at all.
+func (p SprayPolicy) Enabled() bool {
+	return p.LoginLimit > 0 && p.Window > 0 && p.AlertDuration > 0
+}
+
+// detected tells whether counts are over the login limit while no alert is active yet.
+func (p SprayPolicy) detected(counts ratelimit.RequestCounts) bool {
+	return p.LoginLimit > 0 && counts.LoginsWithPassword > p.LoginLimit && !counts.SprayAlert
+}
+
+// tighten keeps AlertLimitPercent of every limit while an alert is active.
+func (p SprayPolicy) tighten(limits RateLimitConfig, counts ratelimit.RequestCounts) RateLimitConfig {
+	if !counts.SprayAlert || p.AlertLimitPercent <= 0 || p.AlertLimitPercent >= 100 {
+		return limits
+	}
+
+	for _, limit := range []*int64{&limits.LoginLimit, &limits.PasswordLimit, &limits.IPLimit} {
+		*limit = max(*limit*p.AlertLimitPercent/100, 1)
+	}
+	return limits
+}
+
+// effectiveLimits are the limits of the attempt, tightened while a spray alert is active.
+func (s *checkSettings) effectiveLimits(attempt AccessAttempt, counts ratelimit.RequestCounts) RateLimitConfig {
+	return s.spray.tighten(s.limitsFor(attempt), counts)
+}
+
+// SetSprayPolicy replaces the password spraying policy for subsequent checks, a zero policy turns it off.
+// Distinct logins per password are counted by the rate limit storage, its tracking is set separately.
+func (s *Service) SetSprayPolicy(policy SprayPolicy) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.spray = policy
+	s.settings.Store(&next)
+
+	s.logger.Info("set password spraying policy",
+		"login_limit", policy.LoginLimit,
+		"window", policy.Window,
+		"alert_duration", policy.AlertDuration,
+		"alert_limit_percent", policy.AlertLimitPercent,
+	)
+}
+
+// raiseSprayAlert raises the spray alert when any verdict detected spraying. Tightened limits are a
+// precaution, so a failure is logged and the verdicts stand.
+func (s *Service) raiseSprayAlert(
+	ctx context.Context, current *checkSettings, attempts []AccessAttempt, verdicts []Verdict,
+) {
+	for i, verdict := range verdicts {
+		if !verdict.sprayed {
+			continue
+		}
+
+		raised, err := s.historyStorage.RaiseSprayAlert(ctx, attempts[i].Tenant, current.spray.AlertDuration)
+		if err != nil {
+			s.logger.Warn("failed to raise spray alert", "tenant", attempts[i].Tenant, "error", err)
+		} else if raised {
+			s.logger.Warn("raised password spraying alert",
+				"tenant", attempts[i].Tenant,
+				"login_limit", current.spray.LoginLimit,
+				"duration", current.spray.AlertDuration,
+			)
+		}
+		return
+	}
+}
@@ -0,0 +1,104 @@
+package antibruteforce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+func TestSprayPolicyTighten(t *testing.T) {
+	t.Parallel()
+
+	limits := RateLimitConfig{LoginLimit: 10, PasswordLimit: 3, IPLimit: 1000}
+	alert := ratelimit.RequestCounts{SprayAlert: true}
+
+	tests := []struct {
+		name    string
+		percent int64
+		counts  ratelimit.RequestCounts
+		want    RateLimitConfig
+	}{
+		{"no alert", 50, ratelimit.RequestCounts{}, limits},
+		{"half", 50, alert, RateLimitConfig{LoginLimit: 5, PasswordLimit: 1, IPLimit: 500}},
+		{"never below one", 10, alert, RateLimitConfig{LoginLimit: 1, PasswordLimit: 1, IPLimit: 100}},
+		{"full limits", 100, alert, limits},
+		{"no percent", 0, alert, limits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := SprayPolicy{AlertLimitPercent: tt.percent}
+			if got := policy.tighten(limits, tt.counts); got != tt.want {
+				t.Errorf("tighten() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+//nolint:funlen
+func TestSprayAlert(t *testing.T) {
+	t.Parallel()
+
+	counts := &mockRateLimitStorage{}
+	history := &mockHistoryStorage{}
+	svc := newTestService(t, Dependencies{
+		RateLimitStorage: counts,
+		HistoryStorage:   history,
+	})
+	svc.SetSprayPolicy(SprayPolicy{LoginLimit: 3, Window: time.Hour, AlertDuration: time.Hour, AlertLimitPercent: 50})
+	ctx := context.Background()
+	attempt := AccessAttempt{Login: "alice", Password: "secret", IP: "10.0.0.1"}
+
+	counts.counts = ratelimit.RequestCounts{Login: 5, LoginsWithPassword: 3}
+	verdict, err := svc.CheckAccess(ctx, attempt)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Result != AccessAllowed || history.sprayAlert != nil {
+		t.Errorf("CheckAccess() at the login limit = %v, alert %v, want allowed without an alert",
+			verdict.Result, history.sprayAlert)
+	}
+
+	counts.counts = ratelimit.RequestCounts{Login: 5, LoginsWithPassword: 4}
+	if _, err := svc.CheckAccessBatch(ctx, []AccessAttempt{attempt, attempt}); err != nil {
+		t.Fatalf("CheckAccessBatch() error = %v", err)
+	}
+	if history.sprayAlert == nil || *history.sprayAlert != "" {
+		t.Errorf("spray alert = %v, want raised by the default tenant", history.sprayAlert)
+	}
+
+	counts.counts = ratelimit.RequestCounts{Login: 5, SprayAlert: true}
+	verdict, err = svc.CheckAccess(ctx, attempt)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Result != AccessDeniedTooManyRequestsLogin {
+		t.Errorf("CheckAccess() during an alert = %v, want %v", verdict.Result, AccessDeniedTooManyRequestsLogin)
+	}
+	if verdict.Quotas.Login.Limit != 5 {
+		t.Errorf("login quota limit during an alert = %d, want 5", verdict.Quotas.Login.Limit)
+	}
+
+	history.sprayAlert = nil
+	history.err = errors.New("connection refused")
+	counts.counts = ratelimit.RequestCounts{LoginsWithPassword: 100}
+	verdict, err = svc.CheckAccess(ctx, attempt)
+	if err != nil {
+		t.Fatalf("CheckAccess() with an unreachable history error = %v", err)
+	}
+	if verdict.Result != AccessAllowed {
+		t.Errorf("CheckAccess() with an unreachable history = %v, want %v", verdict.Result, AccessAllowed)
+	}
+
+	history.err = nil
+	svc.SetSprayPolicy(SprayPolicy{})
+	if _, err := svc.CheckAccess(ctx, attempt); err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if history.sprayAlert != nil {
+		t.Errorf("spray alert raised without a spray policy")
+	}
+}
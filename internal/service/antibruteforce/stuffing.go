@@ -0,0 +1,45 @@
+package antibruteforce
+
+import (
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+// StuffingPolicy denies sources trying many distinct logins, which a user retrying a forgotten password never does.
+// Attempts from such a source are denied regardless of their rate limits.
+type StuffingPolicy struct {
+	// IPLimit and SubnetLimit are how many distinct logins an IP and its subnet may try, zero never denies.
+	// Subnets are the /24 around an IPv4 address and the /64 around an IPv6 one.
+	IPLimit     int64
+	SubnetLimit int64
+	// Window is how long distinct logins are counted, see ratelimit.Storage.SetDistinctWindow.
+	Window time.Duration
+}
+
+// Enabled tells whether distinct logins need to be counted at all.
+func (p StuffingPolicy) Enabled() bool {
+	return (p.IPLimit > 0 || p.SubnetLimit > 0) && p.Window > 0
+}
+
+func (p StuffingPolicy) exceeded(counts ratelimit.RequestCounts) bool {
+	return p.IPLimit > 0 && counts.LoginsFromIP > p.IPLimit ||
+		p.SubnetLimit > 0 && counts.LoginsFromSubnet > p.SubnetLimit
+}
+
+// SetStuffingPolicy replaces the credential stuffing policy for subsequent checks, a zero policy turns it off.
+// Distinct logins are counted by the rate limit storage, its window is set separately.
+func (s *Service) SetStuffingPolicy(policy StuffingPolicy) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := *s.settings.Load()
+	next.stuffing = policy
+	s.settings.Store(&next)
+
+	s.logger.Info("set credential stuffing policy",
+		"ip_limit", policy.IPLimit,
+		"subnet_limit", policy.SubnetLimit,
+		"window", policy.Window,
+	)
+}
@@ -0,0 +1,54 @@
+package antibruteforce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+func TestStuffingPolicy(t *testing.T) {
+	t.Parallel()
+
+	counts := &mockRateLimitStorage{}
+	svc := newTestService(t, Dependencies{
+		RateLimitStorage: counts,
+	})
+	svc.SetChallengePolicy(ChallengePolicy{Limits: RateLimitConfig{LoginLimit: 5}, Allowance: time.Minute})
+	svc.SetStuffingPolicy(StuffingPolicy{IPLimit: 20, SubnetLimit: 50, Window: time.Minute})
+
+	tests := []struct {
+		name   string
+		counts ratelimit.RequestCounts
+		want   AccessResult
+	}{
+		{"few logins", ratelimit.RequestCounts{LoginsFromIP: 20, LoginsFromSubnet: 50}, AccessAllowed},
+		{"many logins from the ip", ratelimit.RequestCounts{LoginsFromIP: 21}, AccessDeniedCredentialStuffing},
+		{"many logins from the subnet", ratelimit.RequestCounts{LoginsFromSubnet: 51}, AccessDeniedCredentialStuffing},
+		{"denied over a challenge", ratelimit.RequestCounts{Login: 5, LoginsFromIP: 21}, AccessDeniedCredentialStuffing},
+		{"rate limits first", ratelimit.RequestCounts{IP: 1000, LoginsFromIP: 21}, AccessDeniedTooManyRequestsIP},
+	}
+
+	attempt := AccessAttempt{Login: "alice", Password: "secret", IP: "10.0.0.1"}
+	for _, tt := range tests {
+		counts.counts = tt.counts
+		verdict, err := svc.CheckAccess(context.Background(), attempt)
+		if err != nil {
+			t.Fatalf("CheckAccess() error = %v", err)
+		}
+		if verdict.Result != tt.want {
+			t.Errorf("%s: CheckAccess() = %v, want %v", tt.name, verdict.Result, tt.want)
+		}
+	}
+
+	svc.SetStuffingPolicy(StuffingPolicy{})
+	counts.counts = ratelimit.RequestCounts{LoginsFromIP: 1000}
+	verdict, err := svc.CheckAccess(context.Background(), attempt)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	if verdict.Result != AccessAllowed {
+		t.Errorf("CheckAccess() without a stuffing policy = %v, want %v", verdict.Result, AccessAllowed)
+	}
+}
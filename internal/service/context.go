@@ -0,0 +1,15 @@
+package service
+
+import "context"
+
+type rpcKey struct{}
+
+// WithRPC stores the full name of the RPC being served, services record it in the audit log.
+func WithRPC(ctx context.Context, rpc string) context.Context {
+	return context.WithValue(ctx, rpcKey{}, rpc)
+}
+
+func RPCFromContext(ctx context.Context) string {
+	rpc, _ := ctx.Value(rpcKey{}).(string)
+	return rpc
+}
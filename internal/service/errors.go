@@ -1,6 +1,9 @@
 package service
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrSubnetCheckFailed = errors.New("failed to check IP in subnets")
@@ -12,4 +15,43 @@ var (
 	ErrInvalidIP       = errors.New("invalid IP address")
 	ErrInvalidLogin    = errors.New("invalid login")
 	ErrInvalidPassword = errors.New("invalid password")
+	ErrBatchTooLarge   = errors.New("batch is too large")
+	ErrInvalidLimit    = errors.New("limit must not be negative")
+	ErrNoLimits        = errors.New("at least one limit must be set")
+	ErrNoRuleSelector  = errors.New("limit rule needs a subnet or a client id")
+	ErrInvalidTenant   = errors.New("tenant name must be 1-63 lowercase letters, digits, '-' or '_'")
+	// ErrUnknownTenant rejects an access check for a tenant that does not exist, unlike ErrTenantNotFound
+	// it is a bad argument of the check rather than a missing resource.
+	ErrUnknownTenant = errors.New("unknown tenant")
+
+	ErrLimitsChangeNotFound  = errors.New("limits change not found")
+	ErrLimitRuleNotFound     = errors.New("limit rule not found")
+	ErrTenantNotFound        = errors.New("tenant not found")
+	ErrHoneypotLoginNotFound = errors.New("honeypot login not found")
+
+	ErrInvalidTimeRange = errors.New("end of time range must be after its start")
+
+	ErrChallengesDisabled = errors.New("challenges are disabled")
 )
+
+// InvalidArgumentError points at the request field that failed validation.
+// It wraps one of the ErrInvalid* errors, so errors.Is works against them.
+type InvalidArgumentError struct {
+	Field string
+	Err   error
+}
+
+func NewInvalidArgumentError(field string, err error) *InvalidArgumentError {
+	return &InvalidArgumentError{
+		Field: field,
+		Err:   err,
+	}
+}
+
+func (e *InvalidArgumentError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *InvalidArgumentError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,237 @@
+package management
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/FluVirus2/antibruteforce/internal/auth"
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/audit"
+)
+
+const (
+	anonymousActor = "anonymous"
+	redacted       = "[REDACTED]"
+
+	DefaultAuditPageSize = 100
+	MaxAuditPageSize     = 1000
+)
+
+type subnetState struct {
+	Listed bool `json:"listed"`
+}
+
+type bucketState struct {
+	Exists bool `json:"exists"`
+}
+
+type sprayAlertState struct {
+	Active bool `json:"active"`
+}
+
+type honeypotState struct {
+	Honeypot bool `json:"honeypot"`
+}
+
+type change struct {
+	before any
+	after  any
+}
+
+func (t ListType) String() string {
+	switch t {
+	case WhitelistType:
+		return "whitelist"
+	case BlacklistType:
+		return "blacklist"
+	default:
+		return fmt.Sprintf("ListType(%d)", int(t))
+	}
+}
+
+func actorFromContext(ctx context.Context) string {
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		return identity.Name
+	}
+	return anonymousActor
+}
+
+func toJSON(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage(fmt.Sprintf("%q", err.Error()))
+	}
+	return raw
+}
+
+// audited runs fn in a transaction and records the call in the audit log within the same transaction,
+// so a change is never committed without its audit event.
+// When fn fails the transaction is rolled back and the failure is recorded on its own.
+func (s *Service) audited(
+	ctx context.Context,
+	args map[string]any,
+	fn func(ctx context.Context) (change, error),
+) error {
+	event := audit.Event{
+		Actor:     actorFromContext(ctx),
+		RPC:       service.RPCFromContext(ctx),
+		Arguments: toJSON(args),
+	}
+
+	var changeErr error
+	err := s.transactor.InTx(ctx, func(ctx context.Context) error {
+		ch, err := fn(ctx)
+		if err != nil {
+			changeErr = err
+			return err
+		}
+
+		event.Before = toJSON(ch.before)
+		event.After = toJSON(ch.after)
+		event.Result = audit.ResultOK
+
+		return s.auditLog.Insert(ctx, event)
+	})
+	if err == nil {
+		return nil
+	}
+
+	if changeErr == nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	event.Result = audit.ResultError
+	event.Error = changeErr.Error()
+	if err := s.auditLog.Insert(ctx, event); err != nil {
+		s.logger.Error("failed to record failed call in audit log", "rpc", event.RPC, "actor", event.Actor, "error", err)
+	}
+
+	return changeErr
+}
+
+// auditedExternal audits fn, which changes a store outside Postgres that cannot be rolled back with the audit log.
+// Once check passes the call is recorded as pending before fn runs, so a change is never made without its audit
+// event, and the outcome of fn is recorded after it.
+func (s *Service) auditedExternal(
+	ctx context.Context,
+	args map[string]any,
+	check func(ctx context.Context) error,
+	fn func(ctx context.Context) (change, error),
+) error {
+	event := audit.Event{
+		Actor:     actorFromContext(ctx),
+		RPC:       service.RPCFromContext(ctx),
+		Arguments: toJSON(args),
+	}
+
+	if check != nil {
+		if err := check(ctx); err != nil {
+			s.recordOutcome(ctx, event, change{}, err)
+			return err
+		}
+	}
+
+	pending := event
+	pending.Result = audit.ResultPending
+	if err := s.auditLog.Insert(ctx, pending); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	ch, changeErr := fn(ctx)
+	s.recordOutcome(ctx, event, ch, changeErr)
+
+	return changeErr
+}
+
+// recordOutcome records the outcome of a call that is already decided, failing to record it is only logged.
+func (s *Service) recordOutcome(ctx context.Context, event audit.Event, ch change, changeErr error) {
+	if changeErr != nil {
+		event.Result = audit.ResultError
+		event.Error = changeErr.Error()
+	} else {
+		event.Result = audit.ResultOK
+		event.Before = toJSON(ch.before)
+		event.After = toJSON(ch.after)
+	}
+
+	if err := s.auditLog.Insert(ctx, event); err != nil {
+		s.logger.Error("failed to record call outcome in audit log",
+			"rpc", event.RPC, "actor", event.Actor, "result", event.Result, "error", err)
+	}
+}
+
+// auditedRead runs fn and records the call in the audit log. Reads change nothing, so no transaction is needed,
+// but like a change a read is refused when it cannot be recorded.
+func (s *Service) auditedRead(ctx context.Context, args map[string]any, fn func(ctx context.Context) error) error {
+	event := audit.Event{
+		Actor:     actorFromContext(ctx),
+		RPC:       service.RPCFromContext(ctx),
+		Arguments: toJSON(args),
+		Result:    audit.ResultOK,
+	}
+
+	readErr := fn(ctx)
+	if readErr != nil {
+		event.Result = audit.ResultError
+		event.Error = readErr.Error()
+	}
+
+	if err := s.auditLog.Insert(ctx, event); err != nil {
+		if readErr != nil {
+			s.logger.Error("failed to record failed call in audit log", "rpc", event.RPC, "actor", event.Actor, "error", err)
+			return readErr
+		}
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return readErr
+}
+
+// AuditWatch records that the caller started watching decisions matching filter, watching is refused when
+// it cannot be recorded.
+func (s *Service) AuditWatch(ctx context.Context, filter map[string]any) error {
+	return s.auditedRead(ctx, filter, func(context.Context) error { return nil })
+}
+
+func (s *Service) ListAuditEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Until.After(filter.Since) {
+		return nil, service.NewInvalidArgumentError("until", service.ErrInvalidTimeRange)
+	}
+
+	switch {
+	case filter.Limit == 0:
+		filter.Limit = DefaultAuditPageSize
+	case filter.Limit > MaxAuditPageSize:
+		filter.Limit = MaxAuditPageSize
+	}
+
+	args := map[string]any{
+		"actor":  filter.Actor,
+		"rpc":    filter.RPC,
+		"result": filter.Result,
+		"since":  filter.Since,
+		"until":  filter.Until,
+		"offset": filter.Offset,
+		"limit":  filter.Limit,
+	}
+
+	var events []audit.Event
+	err := s.auditedRead(ctx, args, func(ctx context.Context) error {
+		var err error
+		events, err = s.auditLog.ListEvents(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to list audit events: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
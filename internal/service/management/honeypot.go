@@ -0,0 +1,69 @@
+package management
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+)
+
+// HoneypotStore keeps the honeypot logins shared by all replicas. Changes join the transaction
+// started by Transactor, every replica picks them up once they are committed.
+type HoneypotStore interface {
+	ListHoneypotLogins(ctx context.Context) ([]string, error)
+	AddHoneypotLogin(ctx context.Context, actor, login string) (added bool, err error)
+	RemoveHoneypotLogin(ctx context.Context, login string) (removed bool, err error)
+}
+
+func (s *Service) ListHoneypotLogins(ctx context.Context) ([]string, error) {
+	var logins []string
+	err := s.auditedRead(ctx, map[string]any{}, func(ctx context.Context) error {
+		var err error
+		logins, err = s.honeypot.ListHoneypotLogins(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list honeypot logins: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return logins, nil
+}
+
+// AddHoneypotLogin makes login a honeypot in every tenant, an attempt for it blacklists its source in its tenant.
+// Adding a login that already is one succeeds.
+func (s *Service) AddHoneypotLogin(ctx context.Context, login string) error {
+	return s.audited(ctx, map[string]any{"login": login}, func(ctx context.Context) (change, error) {
+		if login == "" {
+			return change{}, service.NewInvalidArgumentError("login", service.ErrInvalidLogin)
+		}
+
+		added, err := s.honeypot.AddHoneypotLogin(ctx, actorFromContext(ctx), login)
+		if err != nil {
+			return change{}, fmt.Errorf("failed to add honeypot login: %w", err)
+		}
+
+		return change{before: honeypotState{Honeypot: !added}, after: honeypotState{Honeypot: true}}, nil
+	})
+}
+
+// RemoveHoneypotLogin stops treating login as a honeypot, sources it blacklisted stay so until they expire.
+func (s *Service) RemoveHoneypotLogin(ctx context.Context, login string) error {
+	return s.audited(ctx, map[string]any{"login": login}, func(ctx context.Context) (change, error) {
+		if login == "" {
+			return change{}, service.NewInvalidArgumentError("login", service.ErrInvalidLogin)
+		}
+
+		removed, err := s.honeypot.RemoveHoneypotLogin(ctx, login)
+		if err != nil {
+			return change{}, fmt.Errorf("failed to remove honeypot login: %w", err)
+		}
+		if !removed {
+			return change{}, service.ErrHoneypotLoginNotFound
+		}
+
+		return change{before: honeypotState{Honeypot: true}, after: honeypotState{Honeypot: false}}, nil
+	})
+}
@@ -0,0 +1,175 @@
+package management
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+)
+
+// LimitsStore keeps rate limit overrides shared by all replicas. SetLimits joins the transaction
+// started by Transactor, every replica picks the change up once it is committed.
+type LimitsStore interface {
+	GetLimits(ctx context.Context) (settings.Limits, error)
+	LockLimits(ctx context.Context) (settings.Limits, error)
+	SetLimits(ctx context.Context, actor string, before, after settings.Limits) error
+	ListLimitsHistory(ctx context.Context, offset, limit uint64) ([]settings.HistoryEntry, error)
+	LimitsHistoryEntry(ctx context.Context, id int64) (settings.HistoryEntry, error)
+}
+
+// ConfiguredLimits reports the rate limits of the server configuration, overrides apply on top of them.
+type ConfiguredLimits interface {
+	ConfiguredLimits() settings.Limits
+}
+
+// Limits describes the rate limits of this replica. Replicas may run with different configured limits,
+// overrides are the same everywhere.
+type Limits struct {
+	Effective  settings.Limits
+	Configured settings.Limits
+	Overrides  settings.Limits
+}
+
+func (s *Service) limitsWith(overrides settings.Limits) Limits {
+	configured := s.configuredLimits.ConfiguredLimits()
+
+	return Limits{Effective: configured.Merge(overrides), Configured: configured, Overrides: overrides}
+}
+
+func (s *Service) GetLimits(ctx context.Context) (Limits, error) {
+	var overrides settings.Limits
+	err := s.auditedRead(ctx, map[string]any{}, func(ctx context.Context) error {
+		var err error
+		overrides, err = s.limits.GetLimits(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get limits: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Limits{}, err
+	}
+
+	return s.limitsWith(overrides), nil
+}
+
+// UpdateLimits overrides the non-zero limits of update, other overrides are kept.
+func (s *Service) UpdateLimits(ctx context.Context, update settings.Limits) (Limits, error) {
+	args := map[string]any{"login": update.Login, "password": update.Password, "ip": update.IP}
+
+	return s.changeLimits(ctx, args, func(_ context.Context, current settings.Limits) (settings.Limits, error) {
+		if err := validateLimits("limits", update); err != nil {
+			return settings.Limits{}, err
+		}
+
+		return current.Merge(update), nil
+	})
+}
+
+// validateLimits rejects negative limits and limits that set nothing, field prefixes the reported field.
+func validateLimits(field string, limits settings.Limits) error {
+	fields := []struct {
+		name  string
+		value int64
+	}{
+		{field + ".login", limits.Login},
+		{field + ".password", limits.Password},
+		{field + ".ip", limits.IP},
+	}
+	for _, f := range fields {
+		if f.value < 0 {
+			return service.NewInvalidArgumentError(f.name, service.ErrInvalidLimit)
+		}
+	}
+
+	if limits.IsZero() {
+		return service.NewInvalidArgumentError(field, service.ErrNoLimits)
+	}
+
+	return nil
+}
+
+// ResetLimits drops every override, so the configured limits apply again.
+func (s *Service) ResetLimits(ctx context.Context) (Limits, error) {
+	return s.changeLimits(ctx, map[string]any{}, func(context.Context, settings.Limits) (settings.Limits, error) {
+		return settings.Limits{}, nil
+	})
+}
+
+// RevertLimits undoes the change with the given history id by restoring the overrides it replaced.
+// The revert is a change of its own and can be reverted too.
+func (s *Service) RevertLimits(ctx context.Context, id int64) (Limits, error) {
+	args := map[string]any{"id": id}
+
+	return s.changeLimits(ctx, args, func(ctx context.Context, _ settings.Limits) (settings.Limits, error) {
+		entry, err := s.limits.LimitsHistoryEntry(ctx, id)
+		if errors.Is(err, settings.ErrHistoryEntryNotFound) {
+			return settings.Limits{}, service.ErrLimitsChangeNotFound
+		}
+		if err != nil {
+			return settings.Limits{}, fmt.Errorf("failed to get limits change %d: %w", id, err)
+		}
+
+		return entry.Before, nil
+	})
+}
+
+func (s *Service) ListLimitsHistory(ctx context.Context, offset, limit uint64) ([]settings.HistoryEntry, error) {
+	switch {
+	case limit == 0:
+		limit = DefaultAuditPageSize
+	case limit > MaxAuditPageSize:
+		limit = MaxAuditPageSize
+	}
+
+	var entries []settings.HistoryEntry
+	err := s.auditedRead(ctx, map[string]any{"offset": offset, "limit": limit}, func(ctx context.Context) error {
+		var err error
+		entries, err = s.limits.ListLimitsHistory(ctx, offset, limit)
+		if err != nil {
+			return fmt.Errorf("failed to list limits history: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// changeLimits locks the overrides, replaces them with what next returns and audits the change.
+// next gets the current overrides, UpdateLimits merges into them while the others replace them.
+func (s *Service) changeLimits(
+	ctx context.Context,
+	args map[string]any,
+	next func(ctx context.Context, current settings.Limits) (settings.Limits, error),
+) (Limits, error) {
+	var after settings.Limits
+	err := s.audited(ctx, args, func(ctx context.Context) (change, error) {
+		before, err := s.limits.LockLimits(ctx)
+		if err != nil {
+			return change{}, fmt.Errorf("failed to lock limits: %w", err)
+		}
+
+		after, err = next(ctx, before)
+		if err != nil {
+			return change{}, err
+		}
+
+		if after != before {
+			if err := s.limits.SetLimits(ctx, actorFromContext(ctx), before, after); err != nil {
+				return change{}, fmt.Errorf("failed to set limits: %w", err)
+			}
+		}
+
+		return change{before: before, after: after}, nil
+	})
+	if err != nil {
+		return Limits{}, err
+	}
+
+	return s.limitsWith(after), nil
+}
@@ -0,0 +1,137 @@
+package management
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+)
+
+// LimitRulesStore keeps limit rules shared by all replicas. Changes join the transaction
+// started by Transactor, every replica picks them up once they are committed.
+type LimitRulesStore interface {
+	ListLimitRules(ctx context.Context) ([]settings.LimitRule, error)
+	LockLimitRules(ctx context.Context) ([]settings.LimitRule, error)
+	SetLimitRule(ctx context.Context, actor string, rule settings.LimitRule) error
+	RemoveLimitRule(ctx context.Context, subnet, clientID string) (removed bool, err error)
+}
+
+func (s *Service) ListLimitRules(ctx context.Context) ([]settings.LimitRule, error) {
+	var rules []settings.LimitRule
+	err := s.auditedRead(ctx, map[string]any{}, func(ctx context.Context) error {
+		var err error
+		rules, err = s.limitRules.ListLimitRules(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list limit rules: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// SetLimitRule creates the rule for its subnet and client or replaces its limits.
+// The subnet is stored in its canonical form, which is returned with the rule.
+func (s *Service) SetLimitRule(ctx context.Context, rule settings.LimitRule) (settings.LimitRule, error) {
+	args := map[string]any{
+		"subnet":    rule.Subnet,
+		"client_id": rule.ClientID,
+		"login":     rule.Limits.Login,
+		"password":  rule.Limits.Password,
+		"ip":        rule.Limits.IP,
+	}
+
+	err := s.audited(ctx, args, func(ctx context.Context) (change, error) {
+		var err error
+		if rule.Subnet, err = normalizeRuleSelector("rule.", rule.Subnet, rule.ClientID); err != nil {
+			return change{}, err
+		}
+		if err := validateLimits("rule.limits", rule.Limits); err != nil {
+			return change{}, err
+		}
+
+		rules, err := s.limitRules.LockLimitRules(ctx)
+		if err != nil {
+			return change{}, fmt.Errorf("failed to lock limit rules: %w", err)
+		}
+
+		ch := change{after: rule.Limits}
+		before, found := findLimitRule(rules, rule.Subnet, rule.ClientID)
+		if found {
+			ch.before = before.Limits
+		}
+		if found && before.Limits == rule.Limits {
+			return ch, nil
+		}
+
+		if err := s.limitRules.SetLimitRule(ctx, actorFromContext(ctx), rule); err != nil {
+			return change{}, fmt.Errorf("failed to set limit rule: %w", err)
+		}
+
+		return ch, nil
+	})
+	if err != nil {
+		return settings.LimitRule{}, err
+	}
+
+	return rule, nil
+}
+
+func (s *Service) RemoveLimitRule(ctx context.Context, subnet, clientID string) error {
+	args := map[string]any{"subnet": subnet, "client_id": clientID}
+
+	return s.audited(ctx, args, func(ctx context.Context) (change, error) {
+		subnet, err := normalizeRuleSelector("", subnet, clientID)
+		if err != nil {
+			return change{}, err
+		}
+
+		rules, err := s.limitRules.LockLimitRules(ctx)
+		if err != nil {
+			return change{}, fmt.Errorf("failed to lock limit rules: %w", err)
+		}
+
+		before, found := findLimitRule(rules, subnet, clientID)
+		if !found {
+			return change{}, service.ErrLimitRuleNotFound
+		}
+
+		if _, err := s.limitRules.RemoveLimitRule(ctx, subnet, clientID); err != nil {
+			return change{}, fmt.Errorf("failed to remove limit rule: %w", err)
+		}
+
+		return change{before: before.Limits}, nil
+	})
+}
+
+// normalizeRuleSelector returns the subnet with host bits cleared, so "10.0.0.1/24" and "10.0.0.0/24"
+// select the same rule. Errors are reported on fieldPrefix+"subnet".
+func normalizeRuleSelector(fieldPrefix, subnet, clientID string) (string, error) {
+	if subnet == "" {
+		if clientID == "" {
+			return "", service.NewInvalidArgumentError(fieldPrefix+"subnet", service.ErrNoRuleSelector)
+		}
+		return "", nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return "", service.NewInvalidArgumentError(fieldPrefix+"subnet", service.ErrInvalidCIDR)
+	}
+
+	return ipNet.String(), nil
+}
+
+func findLimitRule(rules []settings.LimitRule, subnet, clientID string) (settings.LimitRule, bool) {
+	for _, rule := range rules {
+		if rule.Subnet == subnet && rule.ClientID == clientID {
+			return rule, true
+		}
+	}
+	return settings.LimitRule{}, false
+}
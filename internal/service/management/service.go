@@ -1,11 +1,14 @@
 package management
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 
 	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/audit"
 )
 
 type ListType int
@@ -15,19 +18,37 @@ const (
 	BlacklistType ListType = 2
 )
 
+// SubnetProvider serves CheckAccess from caches that must be dropped once the lists change.
 type SubnetProvider interface {
-	Add(ctx context.Context, listType int, cidr string) error
-	Remove(ctx context.Context, listType int, cidr string) (deletedCount int64, err error)
+	InvalidateCache(ctx context.Context)
 }
 
+// NopSubnetProvider caches nothing, so there is nothing to drop.
+type NopSubnetProvider struct{}
+
+func (NopSubnetProvider) InvalidateCache(context.Context) {}
+
+// SubnetRepository keeps the lists of every tenant, the default tenant "" owns the global lists.
 type SubnetRepository interface {
-	ListWithOffsetLimit(ctx context.Context, listType int, offset, limit uint64) ([]string, error)
+	Add(ctx context.Context, tenant string, listType int, cidr string) (added bool, err error)
+	Remove(ctx context.Context, tenant string, listType int, cidr string) (deletedCount int64, err error)
+	ListWithOffsetLimit(ctx context.Context, tenant string, listType int, offset, limit uint64) ([]string, error)
 }
 
 type RateLimitResetter interface {
-	ResetByIP(ctx context.Context, ip string) error
-	ResetByLogin(ctx context.Context, login string) error
-	ResetByPassword(ctx context.Context, password string) error
+	ResetByIP(ctx context.Context, tenant, ip string) (existed bool, err error)
+	ResetByLogin(ctx context.Context, tenant, login string) (existed bool, err error)
+	ResetByPassword(ctx context.Context, tenant, password string) (existed bool, err error)
+}
+
+// Transactor runs fn in a Postgres transaction that SubnetRepository and AuditLog join through ctx.
+type Transactor interface {
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type AuditLog interface {
+	Insert(ctx context.Context, event audit.Event) error
+	ListEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error)
 }
 
 type Service struct {
@@ -35,91 +56,257 @@ type Service struct {
 	provider          SubnetProvider
 	repository        SubnetRepository
 	rateLimitResetter RateLimitResetter
+	stuffingSources   StuffingSources
+	sprayAlerts       SprayAlerts
+	transactor        Transactor
+	auditLog          AuditLog
+	limits            LimitsStore
+	limitRules        LimitRulesStore
+	tenants           TenantStore
+	honeypot          HoneypotStore
+	configuredLimits  ConfiguredLimits
 }
 
-func NewService(
-	logger *slog.Logger,
-	provider SubnetProvider,
-	repository SubnetRepository,
-	rateLimitResetter RateLimitResetter,
-) *Service {
+// Dependencies of Service, Logger and SubnetProvider default to no-ops, every store is required.
+type Dependencies struct {
+	Logger            *slog.Logger
+	SubnetProvider    SubnetProvider
+	SubnetRepository  SubnetRepository
+	RateLimitResetter RateLimitResetter
+	StuffingSources   StuffingSources
+	SprayAlerts       SprayAlerts
+	Transactor        Transactor
+	AuditLog          AuditLog
+	LimitsStore       LimitsStore
+	LimitRulesStore   LimitRulesStore
+	TenantStore       TenantStore
+	HoneypotStore     HoneypotStore
+	ConfiguredLimits  ConfiguredLimits
+}
+
+func NewService(deps Dependencies) *Service {
 	return &Service{
-		logger:            logger,
-		provider:          provider,
-		repository:        repository,
-		rateLimitResetter: rateLimitResetter,
+		logger:            cmp.Or(deps.Logger, slog.New(slog.DiscardHandler)),
+		provider:          cmp.Or[SubnetProvider](deps.SubnetProvider, NopSubnetProvider{}),
+		repository:        deps.SubnetRepository,
+		rateLimitResetter: deps.RateLimitResetter,
+		stuffingSources:   deps.StuffingSources,
+		sprayAlerts:       deps.SprayAlerts,
+		transactor:        deps.Transactor,
+		auditLog:          deps.AuditLog,
+		limits:            deps.LimitsStore,
+		limitRules:        deps.LimitRulesStore,
+		tenants:           deps.TenantStore,
+		honeypot:          deps.HoneypotStore,
+		configuredLimits:  deps.ConfiguredLimits,
 	}
 }
 
-func (s *Service) AddToWhitelist(ctx context.Context, cidr string) error {
-	if err := s.provider.Add(ctx, int(WhitelistType), cidr); err != nil {
-		return fmt.Errorf("failed to add subnet to whitelist: %w", err)
+func validateCIDR(cidr string) error {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return service.NewInvalidArgumentError("subnet.cidr", service.ErrInvalidCIDR)
 	}
 	return nil
 }
 
-func (s *Service) RemoveFromWhitelist(ctx context.Context, cidr string) error {
-	deletedCount, err := s.provider.Remove(ctx, int(WhitelistType), cidr)
-	if err != nil {
-		return fmt.Errorf("failed to remove subnet from whitelist: %w", err)
-	}
-	if deletedCount == 0 {
-		return service.ErrSubnetNotFound
-	}
-	return nil
+// AddToWhitelist adds cidr to the whitelist of tenant, the whitelist of the default tenant "" applies to every tenant.
+func (s *Service) AddToWhitelist(ctx context.Context, tenant, cidr string) error {
+	return s.addToList(ctx, tenant, WhitelistType, cidr)
 }
 
-func (s *Service) ListWhitelist(ctx context.Context, offset, limit uint64) ([]string, error) {
-	subnets, err := s.repository.ListWithOffsetLimit(ctx, int(WhitelistType), offset, limit)
+func (s *Service) RemoveFromWhitelist(ctx context.Context, tenant, cidr string) error {
+	return s.removeFromList(ctx, tenant, WhitelistType, cidr)
+}
+
+func (s *Service) ListWhitelist(ctx context.Context, tenant string, offset, limit uint64) ([]string, error) {
+	return s.listSubnets(ctx, tenant, WhitelistType, offset, limit)
+}
+
+// AddToBlacklist adds cidr to the blacklist of tenant, the blacklist of the default tenant "" applies to every tenant.
+func (s *Service) AddToBlacklist(ctx context.Context, tenant, cidr string) error {
+	return s.addToList(ctx, tenant, BlacklistType, cidr)
+}
+
+func (s *Service) RemoveFromBlacklist(ctx context.Context, tenant, cidr string) error {
+	return s.removeFromList(ctx, tenant, BlacklistType, cidr)
+}
+
+func (s *Service) ListBlacklist(ctx context.Context, tenant string, offset, limit uint64) ([]string, error) {
+	return s.listSubnets(ctx, tenant, BlacklistType, offset, limit)
+}
+
+func (s *Service) listSubnets(
+	ctx context.Context,
+	tenant string,
+	listType ListType,
+	offset, limit uint64,
+) ([]string, error) {
+	args := map[string]any{"tenant": tenant, "list": listType.String(), "offset": offset, "limit": limit}
+
+	var subnets []string
+	err := s.auditedRead(ctx, args, func(ctx context.Context) error {
+		if err := s.checkTenant(ctx, tenant, s.tenants.GetTenant); err != nil {
+			return err
+		}
+
+		var err error
+		subnets, err = s.repository.ListWithOffsetLimit(ctx, tenant, int(listType), offset, limit)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", listType, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list whitelist: %w", err)
+		return nil, err
 	}
+
 	return subnets, nil
 }
 
-func (s *Service) AddToBlacklist(ctx context.Context, cidr string) error {
-	if err := s.provider.Add(ctx, int(BlacklistType), cidr); err != nil {
-		return fmt.Errorf("failed to add subnet to blacklist: %w", err)
+func (s *Service) addToList(ctx context.Context, tenant string, listType ListType, cidr string) error {
+	args := map[string]any{"tenant": tenant, "list": listType.String(), "cidr": cidr}
+
+	err := s.audited(ctx, args, func(ctx context.Context) (change, error) {
+		if err := validateCIDR(cidr); err != nil {
+			return change{}, err
+		}
+		if err := s.checkTenant(ctx, tenant, s.tenants.LockTenant); err != nil {
+			return change{}, err
+		}
+
+		added, err := s.repository.Add(ctx, tenant, int(listType), cidr)
+		if err != nil {
+			return change{}, fmt.Errorf("failed to add subnet to %s: %w", listType, err)
+		}
+
+		return change{before: subnetState{Listed: !added}, after: subnetState{Listed: true}}, nil
+	})
+	if err != nil {
+		return err
 	}
+
+	s.provider.InvalidateCache(ctx)
+
 	return nil
 }
 
-func (s *Service) RemoveFromBlacklist(ctx context.Context, cidr string) error {
-	deletedCount, err := s.provider.Remove(ctx, int(BlacklistType), cidr)
+func (s *Service) removeFromList(ctx context.Context, tenant string, listType ListType, cidr string) error {
+	args := map[string]any{"tenant": tenant, "list": listType.String(), "cidr": cidr}
+
+	err := s.audited(ctx, args, func(ctx context.Context) (change, error) {
+		if err := validateCIDR(cidr); err != nil {
+			return change{}, err
+		}
+		if err := s.checkTenant(ctx, tenant, s.tenants.LockTenant); err != nil {
+			return change{}, err
+		}
+
+		deletedCount, err := s.repository.Remove(ctx, tenant, int(listType), cidr)
+		if err != nil {
+			return change{}, fmt.Errorf("failed to remove subnet from %s: %w", listType, err)
+		}
+		if deletedCount == 0 {
+			return change{}, service.ErrSubnetNotFound
+		}
+
+		return change{before: subnetState{Listed: true}, after: subnetState{Listed: false}}, nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to remove subnet from blacklist: %w", err)
-	}
-	if deletedCount == 0 {
-		return service.ErrSubnetNotFound
+		return err
 	}
+
+	s.provider.InvalidateCache(ctx)
+
 	return nil
 }
 
-func (s *Service) ListBlacklist(ctx context.Context, offset, limit uint64) ([]string, error) {
-	subnets, err := s.repository.ListWithOffsetLimit(ctx, int(BlacklistType), offset, limit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list blacklist: %w", err)
+func (s *Service) ResetBucketByIP(ctx context.Context, tenant, ip string) (bool, error) {
+	args := map[string]any{"tenant": tenant, "ip": ip}
+
+	validate := func() error {
+		if net.ParseIP(ip) == nil {
+			return service.NewInvalidArgumentError("ip", service.ErrInvalidIP)
+		}
+		return nil
 	}
-	return subnets, nil
+
+	return s.resetBucket(ctx, tenant, args, validate, func(ctx context.Context) (bool, error) {
+		existed, err := s.rateLimitResetter.ResetByIP(ctx, tenant, ip)
+		if err != nil {
+			return false, fmt.Errorf("failed to reset IP bucket: %w", err)
+		}
+		return existed, nil
+	})
 }
 
-func (s *Service) ResetBucketByIP(ctx context.Context, ip string) (bool, error) {
-	if err := s.rateLimitResetter.ResetByIP(ctx, ip); err != nil {
-		return false, fmt.Errorf("failed to reset IP bucket: %w", err)
+func (s *Service) ResetBucketByLogin(ctx context.Context, tenant, login string) (bool, error) {
+	args := map[string]any{"tenant": tenant, "login": login}
+
+	validate := func() error {
+		if login == "" {
+			return service.NewInvalidArgumentError("login", service.ErrInvalidLogin)
+		}
+		return nil
 	}
-	return true, nil
+
+	return s.resetBucket(ctx, tenant, args, validate, func(ctx context.Context) (bool, error) {
+		existed, err := s.rateLimitResetter.ResetByLogin(ctx, tenant, login)
+		if err != nil {
+			return false, fmt.Errorf("failed to reset login bucket: %w", err)
+		}
+		return existed, nil
+	})
 }
 
-func (s *Service) ResetBucketByLogin(ctx context.Context, login string) (bool, error) {
-	if err := s.rateLimitResetter.ResetByLogin(ctx, login); err != nil {
-		return false, fmt.Errorf("failed to reset login bucket: %w", err)
+// ResetBucketByPassword never writes the password to the audit log.
+func (s *Service) ResetBucketByPassword(ctx context.Context, tenant, password string) (bool, error) {
+	args := map[string]any{"tenant": tenant, "password": redacted}
+
+	validate := func() error {
+		if password == "" {
+			return service.NewInvalidArgumentError("password", service.ErrInvalidPassword)
+		}
+		return nil
 	}
-	return true, nil
+
+	return s.resetBucket(ctx, tenant, args, validate, func(ctx context.Context) (bool, error) {
+		existed, err := s.rateLimitResetter.ResetByPassword(ctx, tenant, password)
+		if err != nil {
+			return false, fmt.Errorf("failed to reset password bucket: %w", err)
+		}
+		return existed, nil
+	})
 }
 
-func (s *Service) ResetBucketByPassword(ctx context.Context, password string) (bool, error) {
-	if err := s.rateLimitResetter.ResetByPassword(ctx, password); err != nil {
-		return false, fmt.Errorf("failed to reset password bucket: %w", err)
+// resetBucket reports whether there was a bucket to reset. Buckets live in Redis, so the reset is recorded
+// in the audit log before it is made.
+func (s *Service) resetBucket(
+	ctx context.Context,
+	tenant string,
+	args map[string]any,
+	validate func() error,
+	reset func(ctx context.Context) (bool, error),
+) (bool, error) {
+	check := func(ctx context.Context) error {
+		if err := validate(); err != nil {
+			return err
+		}
+		return s.checkTenant(ctx, tenant, s.tenants.GetTenant)
+	}
+
+	var existed bool
+	err := s.auditedExternal(ctx, args, check, func(ctx context.Context) (change, error) {
+		var err error
+		existed, err = reset(ctx)
+		if err != nil {
+			return change{}, err
+		}
+
+		return change{before: bucketState{Exists: existed}, after: bucketState{Exists: false}}, nil
+	})
+	if err != nil {
+		return false, err
 	}
-	return true, nil
+
+	return existed, nil
 }
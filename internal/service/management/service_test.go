@@ -0,0 +1,843 @@
+package management
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/api/grpc/interceptor"
+	"github.com/FluVirus2/antibruteforce/internal/auth"
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/audit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+	"google.golang.org/grpc"
+)
+
+type txKey struct{}
+
+// mockStore keeps subnets, limit overrides, limit rules, tenants and audit events,
+// writes made inside InTx are applied only on commit.
+type mockStore struct {
+	subnets     map[string]bool
+	events      []audit.Event
+	invalidated int
+	insertErr   error
+	limits      settings.Limits
+	history     []settings.HistoryEntry
+	rules       []settings.LimitRule
+	tenants     map[string]settings.Limits
+	honeypot    map[string]bool
+	configured  settings.Limits
+}
+
+type mockTx struct {
+	subnets  map[string]bool
+	events   []audit.Event
+	limits   settings.Limits
+	history  []settings.HistoryEntry
+	rules    []settings.LimitRule
+	tenants  map[string]settings.Limits
+	honeypot map[string]bool
+}
+
+func newMockStore() *mockStore {
+	return &mockStore{
+		subnets:  make(map[string]bool),
+		tenants:  make(map[string]settings.Limits),
+		honeypot: make(map[string]bool),
+	}
+}
+
+func (m *mockStore) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx := &mockTx{
+		subnets:  maps.Clone(m.subnets),
+		limits:   m.limits,
+		rules:    slices.Clone(m.rules),
+		tenants:  maps.Clone(m.tenants),
+		honeypot: maps.Clone(m.honeypot),
+	}
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	m.subnets = tx.subnets
+	m.events = append(m.events, tx.events...)
+	m.limits = tx.limits
+	m.history = append(m.history, tx.history...)
+	m.rules = tx.rules
+	m.tenants = tx.tenants
+	m.honeypot = tx.honeypot
+	return nil
+}
+
+// subnetKey keeps subnets of the default tenant under their CIDR.
+func subnetKey(tenant, cidr string) string {
+	if tenant == "" {
+		return cidr
+	}
+	return tenant + "/" + cidr
+}
+
+func (m *mockStore) Add(ctx context.Context, tenant string, _ int, cidr string) (bool, error) {
+	tx := ctx.Value(txKey{}).(*mockTx)
+	existed := tx.subnets[subnetKey(tenant, cidr)]
+	tx.subnets[subnetKey(tenant, cidr)] = true
+	return !existed, nil
+}
+
+func (m *mockStore) Remove(ctx context.Context, tenant string, _ int, cidr string) (int64, error) {
+	tx := ctx.Value(txKey{}).(*mockTx)
+	if !tx.subnets[subnetKey(tenant, cidr)] {
+		return 0, nil
+	}
+	delete(tx.subnets, subnetKey(tenant, cidr))
+	return 1, nil
+}
+
+func (m *mockStore) ListWithOffsetLimit(context.Context, string, int, uint64, uint64) ([]string, error) {
+	return nil, nil
+}
+
+func (m *mockStore) InvalidateCache(context.Context) {
+	m.invalidated++
+}
+
+func (m *mockStore) Insert(ctx context.Context, event audit.Event) error {
+	if m.insertErr != nil {
+		return m.insertErr
+	}
+
+	if tx, ok := ctx.Value(txKey{}).(*mockTx); ok {
+		tx.events = append(tx.events, event)
+	} else {
+		m.events = append(m.events, event)
+	}
+	return nil
+}
+
+func (m *mockStore) ListEvents(context.Context, audit.Filter) ([]audit.Event, error) {
+	return m.events, nil
+}
+
+func (m *mockStore) GetLimits(context.Context) (settings.Limits, error) {
+	return m.limits, nil
+}
+
+func (m *mockStore) LockLimits(ctx context.Context) (settings.Limits, error) {
+	return ctx.Value(txKey{}).(*mockTx).limits, nil
+}
+
+func (m *mockStore) SetLimits(ctx context.Context, actor string, before, after settings.Limits) error {
+	tx := ctx.Value(txKey{}).(*mockTx)
+	tx.limits = after
+	tx.history = append(tx.history, settings.HistoryEntry{
+		ID:     int64(len(m.history) + len(tx.history) + 1),
+		Actor:  actor,
+		Before: before,
+		After:  after,
+	})
+	return nil
+}
+
+func (m *mockStore) ListLimitsHistory(context.Context, uint64, uint64) ([]settings.HistoryEntry, error) {
+	return m.history, nil
+}
+
+func (m *mockStore) LimitsHistoryEntry(_ context.Context, id int64) (settings.HistoryEntry, error) {
+	for _, entry := range m.history {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return settings.HistoryEntry{}, settings.ErrHistoryEntryNotFound
+}
+
+func (m *mockStore) ListLimitRules(context.Context) ([]settings.LimitRule, error) {
+	return m.rules, nil
+}
+
+func (m *mockStore) LockLimitRules(ctx context.Context) ([]settings.LimitRule, error) {
+	return ctx.Value(txKey{}).(*mockTx).rules, nil
+}
+
+func (m *mockStore) SetLimitRule(ctx context.Context, _ string, rule settings.LimitRule) error {
+	tx := ctx.Value(txKey{}).(*mockTx)
+	for i, r := range tx.rules {
+		if r.Subnet == rule.Subnet && r.ClientID == rule.ClientID {
+			tx.rules[i] = rule
+			return nil
+		}
+	}
+	tx.rules = append(tx.rules, rule)
+	return nil
+}
+
+func (m *mockStore) RemoveLimitRule(ctx context.Context, subnet, clientID string) (bool, error) {
+	tx := ctx.Value(txKey{}).(*mockTx)
+	n := len(tx.rules)
+	tx.rules = slices.DeleteFunc(tx.rules, func(r settings.LimitRule) bool {
+		return r.Subnet == subnet && r.ClientID == clientID
+	})
+	return len(tx.rules) < n, nil
+}
+
+func (m *mockStore) ListTenants(context.Context) ([]settings.Tenant, error) {
+	var tenants []settings.Tenant
+	for name, limits := range m.tenants {
+		tenants = append(tenants, settings.Tenant{Name: name, Limits: limits})
+	}
+	return tenants, nil
+}
+
+func (m *mockStore) GetTenant(_ context.Context, name string) (settings.Tenant, error) {
+	limits, ok := m.tenants[name]
+	if !ok {
+		return settings.Tenant{}, settings.ErrTenantNotFound
+	}
+	return settings.Tenant{Name: name, Limits: limits}, nil
+}
+
+func (m *mockStore) LockTenant(ctx context.Context, name string) (settings.Tenant, error) {
+	limits, ok := ctx.Value(txKey{}).(*mockTx).tenants[name]
+	if !ok {
+		return settings.Tenant{}, settings.ErrTenantNotFound
+	}
+	return settings.Tenant{Name: name, Limits: limits}, nil
+}
+
+func (m *mockStore) SetTenant(ctx context.Context, _ string, tenant settings.Tenant) error {
+	ctx.Value(txKey{}).(*mockTx).tenants[tenant.Name] = tenant.Limits
+	return nil
+}
+
+func (m *mockStore) DeleteTenant(ctx context.Context, name string) error {
+	tx := ctx.Value(txKey{}).(*mockTx)
+	delete(tx.tenants, name)
+	maps.DeleteFunc(tx.subnets, func(key string, _ bool) bool {
+		return strings.HasPrefix(key, name+"/")
+	})
+	return nil
+}
+
+func (m *mockStore) ListHoneypotLogins(context.Context) ([]string, error) {
+	return slices.Sorted(maps.Keys(m.honeypot)), nil
+}
+
+func (m *mockStore) AddHoneypotLogin(ctx context.Context, _, login string) (bool, error) {
+	tx := ctx.Value(txKey{}).(*mockTx)
+	existed := tx.honeypot[login]
+	tx.honeypot[login] = true
+	return !existed, nil
+}
+
+func (m *mockStore) RemoveHoneypotLogin(ctx context.Context, login string) (bool, error) {
+	tx := ctx.Value(txKey{}).(*mockTx)
+	existed := tx.honeypot[login]
+	delete(tx.honeypot, login)
+	return existed, nil
+}
+
+func (m *mockStore) ConfiguredLimits() settings.Limits {
+	return m.configured
+}
+
+type mockResetter struct {
+	existed  bool
+	resetErr error
+	resets   int
+	sources  []ratelimit.StuffingSource
+	// sprayAlert is nil while no spray alert is active.
+	sprayAlert *ratelimit.SprayAlert
+}
+
+func (m *mockResetter) ResetByIP(context.Context, string, string) (bool, error) {
+	return m.reset()
+}
+func (m *mockResetter) ResetByLogin(context.Context, string, string) (bool, error) {
+	return m.reset()
+}
+func (m *mockResetter) ResetByPassword(context.Context, string, string) (bool, error) {
+	return m.reset()
+}
+
+func (m *mockResetter) reset() (bool, error) {
+	if m.resetErr != nil {
+		return false, m.resetErr
+	}
+	m.resets++
+	return m.existed, nil
+}
+
+func (m *mockResetter) TopStuffingSources(_ context.Context, _ string, limit int) ([]ratelimit.StuffingSource, error) {
+	return m.sources[:min(limit, len(m.sources))], nil
+}
+
+func (m *mockResetter) SprayAlert(context.Context) (ratelimit.SprayAlert, bool, error) {
+	if m.sprayAlert == nil {
+		return ratelimit.SprayAlert{}, false, nil
+	}
+	return *m.sprayAlert, true, nil
+}
+
+func (m *mockResetter) ClearSprayAlert(context.Context) (bool, error) {
+	existed := m.sprayAlert != nil
+	m.sprayAlert = nil
+	return existed, nil
+}
+
+func newTestService(store *mockStore, resetter *mockResetter) *Service {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	return NewService(Dependencies{
+		Logger:            logger,
+		SubnetProvider:    store,
+		SubnetRepository:  store,
+		RateLimitResetter: resetter,
+		StuffingSources:   resetter,
+		SprayAlerts:       resetter,
+		Transactor:        store,
+		AuditLog:          store,
+		LimitsStore:       store,
+		LimitRulesStore:   store,
+		TenantStore:       store,
+		HoneypotStore:     store,
+		ConfiguredLimits:  store,
+	})
+}
+
+func callerContext(name, rpc string) context.Context {
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{Name: name, Role: auth.RoleAdmin})
+	return service.WithRPC(ctx, rpc)
+}
+
+func TestAuditedSubnetChanges(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{})
+	ctx := callerContext("alice", "/test/AddIPToWhiteList")
+
+	if err := svc.AddToWhitelist(ctx, "", "10.0.0.0/8"); err != nil {
+		t.Fatalf("AddToWhitelist() unexpected error = %v", err)
+	}
+
+	if !store.subnets["10.0.0.0/8"] {
+		t.Errorf("subnet was not committed")
+	}
+	if store.invalidated != 1 {
+		t.Errorf("cache invalidated %d times, want 1", store.invalidated)
+	}
+	if len(store.events) != 1 {
+		t.Fatalf("recorded %d events, want 1", len(store.events))
+	}
+
+	event := store.events[0]
+	if event.Actor != "alice" || event.RPC != "/test/AddIPToWhiteList" || event.Result != audit.ResultOK {
+		t.Errorf("event = %+v, want ok event of alice for AddIPToWhiteList", event)
+	}
+	if string(event.Before) != `{"listed":false}` || string(event.After) != `{"listed":true}` {
+		t.Errorf("diff = %s -> %s, want listed false -> true", event.Before, event.After)
+	}
+
+	var args map[string]string
+	if err := json.Unmarshal(event.Arguments, &args); err != nil {
+		t.Fatalf("arguments are not JSON: %v", err)
+	}
+	if args["cidr"] != "10.0.0.0/8" || args["list"] != "whitelist" {
+		t.Errorf("arguments = %v, want whitelist 10.0.0.0/8", args)
+	}
+}
+
+func TestAuditedFailures(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{})
+
+	err := svc.RemoveFromBlacklist(context.Background(), "", "192.168.0.0/16")
+	if !errors.Is(err, service.ErrSubnetNotFound) {
+		t.Fatalf("RemoveFromBlacklist() error = %v, want ErrSubnetNotFound", err)
+	}
+
+	err = svc.AddToBlacklist(context.Background(), "", "not a cidr")
+	if !errors.Is(err, service.ErrInvalidCIDR) {
+		t.Fatalf("AddToBlacklist() error = %v, want ErrInvalidCIDR", err)
+	}
+
+	if len(store.events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(store.events))
+	}
+	for _, event := range store.events {
+		if event.Result != audit.ResultError || event.Error == "" || event.Actor != anonymousActor {
+			t.Errorf("event = %+v, want anonymous error event", event)
+		}
+	}
+	if store.invalidated != 0 {
+		t.Errorf("cache invalidated after failed calls")
+	}
+}
+
+func TestAuditInsertFailureRollsBackChange(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	store.insertErr = errors.New("connection refused")
+	svc := newTestService(store, &mockResetter{})
+
+	if err := svc.AddToWhitelist(context.Background(), "", "10.0.0.0/8"); err == nil {
+		t.Fatalf("AddToWhitelist() succeeded although the audit event could not be written")
+	}
+
+	if store.subnets["10.0.0.0/8"] {
+		t.Errorf("subnet was committed without its audit event")
+	}
+}
+
+func TestAuditedReads(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{})
+	ctx := callerContext("alice", "/test/ListIPAddressBlackList")
+
+	if _, err := svc.ListBlacklist(ctx, "", 10, 20); err != nil {
+		t.Fatalf("ListBlacklist() error = %v", err)
+	}
+	if _, err := svc.ListBlacklist(ctx, "acme", 0, 20); !errors.Is(err, service.ErrTenantNotFound) {
+		t.Errorf("ListBlacklist() of an unknown tenant error = %v, want %v", err, service.ErrTenantNotFound)
+	}
+
+	if len(store.events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(store.events))
+	}
+	event := store.events[0]
+	if event.Actor != "alice" || event.Result != audit.ResultOK || event.Before != nil || event.After != nil ||
+		string(event.Arguments) != `{"limit":20,"list":"blacklist","offset":10,"tenant":""}` {
+		t.Errorf("read event = %+v, want the arguments without a diff", event)
+	}
+	if event := store.events[1]; event.Result != audit.ResultError || event.Error == "" {
+		t.Errorf("failed read event = %+v, want the error", event)
+	}
+
+	store.insertErr = errors.New("connection refused")
+	if _, err := svc.GetLimits(ctx); err == nil {
+		t.Errorf("GetLimits() succeeded although the audit event could not be written")
+	}
+	if err := svc.AuditWatch(ctx, map[string]any{"ip": "10.0.0.1"}); err == nil {
+		t.Errorf("AuditWatch() succeeded although the audit event could not be written")
+	}
+}
+
+// watchStream is a server stream of an authenticated caller.
+type watchStream struct {
+	grpc.ServerStream
+}
+
+func (watchStream) Context() context.Context {
+	return auth.WithIdentity(context.Background(), auth.Identity{Name: "alice", Role: auth.RoleAdmin})
+}
+
+func TestAuditWatchRecordsStreamRPC(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{})
+
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Management/WatchDecisions", IsServerStream: true}
+	err := interceptor.StreamRPCName()(nil, watchStream{}, info, func(_ any, stream grpc.ServerStream) error {
+		return svc.AuditWatch(stream.Context(), map[string]any{"ip": "10.0.0.1"})
+	})
+	if err != nil {
+		t.Fatalf("AuditWatch() error = %v", err)
+	}
+
+	if len(store.events) != 1 || store.events[0].RPC != info.FullMethod {
+		t.Errorf("audit events = %+v, want one with rpc %s", store.events, info.FullMethod)
+	}
+}
+
+func TestResetBucketByPasswordIsRedacted(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{existed: true})
+
+	wasDone, err := svc.ResetBucketByPassword(context.Background(), "", "hunter2")
+	if err != nil || !wasDone {
+		t.Fatalf("ResetBucketByPassword() = %v, %v, want true, nil", wasDone, err)
+	}
+
+	if len(store.events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(store.events))
+	}
+	for _, event := range store.events {
+		if strings.Contains(string(event.Arguments), "hunter2") {
+			t.Errorf("password leaked into audit arguments: %s", event.Arguments)
+		}
+	}
+	if string(store.events[1].Before) != `{"exists":true}` {
+		t.Errorf("before = %s, want existing bucket", store.events[1].Before)
+	}
+}
+
+func TestResetBucketIsAuditedBeforeReset(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	resetter := &mockResetter{existed: true}
+	svc := newTestService(store, resetter)
+
+	if _, err := svc.ResetBucketByLogin(context.Background(), "", "alice"); err != nil {
+		t.Fatalf("ResetBucketByLogin() error = %v", err)
+	}
+	if len(store.events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(store.events))
+	}
+	if store.events[0].Result != audit.ResultPending || store.events[1].Result != audit.ResultOK {
+		t.Errorf("audit events = %+v, want a pending event followed by the outcome", store.events)
+	}
+
+	// an invalid call never reaches Redis, so it is recorded as failed without a pending event
+	if _, err := svc.ResetBucketByLogin(context.Background(), "", ""); !errors.Is(err, service.ErrInvalidLogin) {
+		t.Errorf("ResetBucketByLogin() error = %v, want %v", err, service.ErrInvalidLogin)
+	}
+	if len(store.events) != 3 || store.events[2].Result != audit.ResultError {
+		t.Errorf("audit events = %+v, want the invalid call recorded as failed", store.events)
+	}
+
+	resetter.resetErr = errors.New("connection refused")
+	if _, err := svc.ResetBucketByLogin(context.Background(), "", "alice"); err == nil {
+		t.Error("ResetBucketByLogin() error = nil, want the reset error")
+	}
+	if len(store.events) != 5 || store.events[4].Result != audit.ResultError || store.events[4].Before != nil {
+		t.Errorf("audit events = %+v, want the failed reset recorded", store.events)
+	}
+
+	resetter.resetErr = nil
+	resetter.resets = 0
+	store.insertErr = errors.New("connection refused")
+	if _, err := svc.ResetBucketByLogin(context.Background(), "", "alice"); err == nil {
+		t.Error("ResetBucketByLogin() error = nil, want the audit error")
+	}
+	if resetter.resets != 0 {
+		t.Errorf("bucket was reset %d times without an audit event", resetter.resets)
+	}
+}
+
+func TestLimitsChanges(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	store.configured = settings.Limits{Login: 10, Password: 100, IP: 1000}
+	svc := newTestService(store, &mockResetter{})
+	ctx := callerContext("alice", "/test/UpdateLimits")
+
+	limits, err := svc.UpdateLimits(ctx, settings.Limits{IP: 50})
+	if err != nil {
+		t.Fatalf("UpdateLimits() error = %v", err)
+	}
+	if want := (settings.Limits{Login: 10, Password: 100, IP: 50}); limits.Effective != want {
+		t.Errorf("effective limits = %+v, want %+v", limits.Effective, want)
+	}
+
+	if _, err := svc.UpdateLimits(ctx, settings.Limits{Login: 3}); err != nil {
+		t.Fatalf("UpdateLimits() error = %v", err)
+	}
+	if want := (settings.Limits{Login: 3, IP: 50}); store.limits != want {
+		t.Errorf("overrides = %+v, want %+v merged with the previous ones", store.limits, want)
+	}
+
+	// reverting the first change restores what was there before it, the later login override goes with it
+	limits, err = svc.RevertLimits(ctx, 1)
+	if err != nil {
+		t.Fatalf("RevertLimits() error = %v", err)
+	}
+	if !limits.Overrides.IsZero() || limits.Effective != store.configured {
+		t.Errorf("limits after revert = %+v, want the configured ones", limits)
+	}
+
+	if len(store.history) != 3 || store.history[2].Before != (settings.Limits{Login: 3, IP: 50}) {
+		t.Errorf("history = %+v, want the revert recorded as a third change", store.history)
+	}
+	if len(store.events) != 3 || string(store.events[0].After) != `{"ip":50}` {
+		t.Errorf("audit events = %+v, want every change audited", store.events)
+	}
+}
+
+func TestLimitsValidation(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{})
+
+	_, err := svc.UpdateLimits(context.Background(), settings.Limits{IP: -1})
+	if !errors.Is(err, service.ErrInvalidLimit) {
+		t.Errorf("UpdateLimits() with a negative limit error = %v, want ErrInvalidLimit", err)
+	}
+	if _, err := svc.UpdateLimits(context.Background(), settings.Limits{}); !errors.Is(err, service.ErrNoLimits) {
+		t.Errorf("UpdateLimits() without limits error = %v, want ErrNoLimits", err)
+	}
+	if _, err := svc.RevertLimits(context.Background(), 42); !errors.Is(err, service.ErrLimitsChangeNotFound) {
+		t.Errorf("RevertLimits() of an unknown change error = %v, want ErrLimitsChangeNotFound", err)
+	}
+
+	if len(store.history) != 0 {
+		t.Errorf("rejected changes made %d history entries", len(store.history))
+	}
+}
+
+func TestLimitRules(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{})
+	ctx := callerContext("alice", "/test/SetLimitRule")
+
+	rule, err := svc.SetLimitRule(ctx, settings.LimitRule{Subnet: "10.0.0.17/28", Limits: settings.Limits{IP: 5000}})
+	if err != nil {
+		t.Fatalf("SetLimitRule() error = %v", err)
+	}
+	if rule.Subnet != "10.0.0.16/28" {
+		t.Errorf("SetLimitRule() subnet = %q, want host bits cleared", rule.Subnet)
+	}
+
+	// the same selector replaces the rule instead of adding one
+	_, err = svc.SetLimitRule(ctx, settings.LimitRule{Subnet: "10.0.0.16/28", Limits: settings.Limits{IP: 3000}})
+	if err != nil {
+		t.Fatalf("SetLimitRule() error = %v", err)
+	}
+	_, err = svc.SetLimitRule(ctx, settings.LimitRule{ClientID: "mobile", Limits: settings.Limits{Login: 3}})
+	if err != nil {
+		t.Fatalf("SetLimitRule() error = %v", err)
+	}
+
+	rules, err := svc.ListLimitRules(ctx)
+	if err != nil {
+		t.Fatalf("ListLimitRules() error = %v", err)
+	}
+	want := []settings.LimitRule{
+		{Subnet: "10.0.0.16/28", Limits: settings.Limits{IP: 3000}},
+		{ClientID: "mobile", Limits: settings.Limits{Login: 3}},
+	}
+	if !slices.Equal(rules, want) {
+		t.Errorf("ListLimitRules() = %+v, want %+v", rules, want)
+	}
+
+	if err := svc.RemoveLimitRule(ctx, "10.0.0.20/28", ""); err != nil {
+		t.Fatalf("RemoveLimitRule() error = %v", err)
+	}
+	if err := svc.RemoveLimitRule(ctx, "10.0.0.16/28", ""); !errors.Is(err, service.ErrLimitRuleNotFound) {
+		t.Errorf("RemoveLimitRule() of a removed rule error = %v, want ErrLimitRuleNotFound", err)
+	}
+
+	if len(store.events) != 6 || string(store.events[1].Before) != `{"ip":5000}` || store.events[0].Before != nil {
+		t.Errorf("audit events = %+v, want every call audited with the replaced limits", store.events)
+	}
+}
+
+func TestLimitRuleValidation(t *testing.T) {
+	t.Parallel()
+
+	svc := newTestService(newMockStore(), &mockResetter{})
+
+	tests := []struct {
+		name      string
+		rule      settings.LimitRule
+		wantErr   error
+		wantField string
+	}{
+		{
+			name:      "no selector",
+			rule:      settings.LimitRule{Limits: settings.Limits{IP: 1}},
+			wantErr:   service.ErrNoRuleSelector,
+			wantField: "rule.subnet",
+		},
+		{
+			name:      "invalid subnet",
+			rule:      settings.LimitRule{Subnet: "10.0.0.1", Limits: settings.Limits{IP: 1}},
+			wantErr:   service.ErrInvalidCIDR,
+			wantField: "rule.subnet",
+		},
+		{
+			name:      "negative limit",
+			rule:      settings.LimitRule{ClientID: "admin", Limits: settings.Limits{Login: -1}},
+			wantErr:   service.ErrInvalidLimit,
+			wantField: "rule.limits.login",
+		},
+		{
+			name:      "no limits",
+			rule:      settings.LimitRule{ClientID: "admin"},
+			wantErr:   service.ErrNoLimits,
+			wantField: "rule.limits",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.SetLimitRule(context.Background(), tt.rule)
+
+			var invalidArgErr *service.InvalidArgumentError
+			if !errors.Is(err, tt.wantErr) || !errors.As(err, &invalidArgErr) || invalidArgErr.Field != tt.wantField {
+				t.Errorf("SetLimitRule() error = %v, want %v on %s", err, tt.wantErr, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestTenants(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{})
+	ctx := callerContext("alice", "/test/SetTenant")
+
+	err := svc.AddToWhitelist(ctx, "shop", "10.0.0.0/8")
+	var invalidArgErr *service.InvalidArgumentError
+	if !errors.Is(err, service.ErrTenantNotFound) || !errors.As(err, &invalidArgErr) || invalidArgErr.Field != "tenant" {
+		t.Fatalf("AddToWhitelist() of an unknown tenant error = %v, want %v", err, service.ErrTenantNotFound)
+	}
+
+	if err := svc.SetTenant(ctx, settings.Tenant{Name: "Shop"}); !errors.Is(err, service.ErrInvalidTenant) {
+		t.Errorf("SetTenant() with an invalid name error = %v, want %v", err, service.ErrInvalidTenant)
+	}
+	if err := svc.SetTenant(ctx, settings.Tenant{Name: "shop"}); err != nil {
+		t.Fatalf("SetTenant() error = %v", err)
+	}
+	if err := svc.SetTenant(ctx, settings.Tenant{Name: "shop", Limits: settings.Limits{IP: 50}}); err != nil {
+		t.Fatalf("SetTenant() error = %v", err)
+	}
+	if err := svc.AddToWhitelist(ctx, "shop", "10.0.0.0/8"); err != nil {
+		t.Fatalf("AddToWhitelist() error = %v", err)
+	}
+	if store.subnets["10.0.0.0/8"] || !store.subnets["shop/10.0.0.0/8"] {
+		t.Errorf("subnets = %v, want 10.0.0.0/8 only in the whitelist of shop", store.subnets)
+	}
+
+	tenants, err := svc.ListTenants(ctx)
+	want := []settings.Tenant{{Name: "shop", Limits: settings.Limits{IP: 50}}}
+	if err != nil || !slices.Equal(tenants, want) {
+		t.Errorf("ListTenants() = %v, %v, want %v", tenants, err, want)
+	}
+
+	invalidated := store.invalidated
+	if err := svc.DeleteTenant(ctx, "shop"); err != nil {
+		t.Fatalf("DeleteTenant() error = %v", err)
+	}
+	if len(store.subnets) != 0 || store.invalidated != invalidated+1 {
+		t.Errorf("subnets = %v after DeleteTenant(), want none and the cache invalidated", store.subnets)
+	}
+	if err := svc.DeleteTenant(ctx, "shop"); !errors.Is(err, service.ErrTenantNotFound) {
+		t.Errorf("DeleteTenant() of a deleted tenant error = %v, want %v", err, service.ErrTenantNotFound)
+	}
+
+	if len(store.events) != 8 || string(store.events[3].Before) != "{}" || string(store.events[6].Before) != `{"ip":50}` {
+		t.Errorf("audit events = %+v, want every call audited with the replaced limits", store.events)
+	}
+}
+
+func TestListStuffingSources(t *testing.T) {
+	t.Parallel()
+
+	resetter := &mockResetter{sources: []ratelimit.StuffingSource{
+		{Kind: ratelimit.SourceSubnet, Source: "10.0.0.0/24", DistinctLogins: 40},
+		{Kind: ratelimit.SourceIP, Source: "10.0.0.1", DistinctLogins: 25},
+	}}
+	svc := newTestService(newMockStore(), resetter)
+	ctx := context.Background()
+
+	sources, err := svc.ListStuffingSources(ctx, "", 0)
+	if err != nil || len(sources) != 2 {
+		t.Errorf("ListStuffingSources() = %v, %v, want every source kept", sources, err)
+	}
+
+	sources, err = svc.ListStuffingSources(ctx, "", 1)
+	if err != nil || len(sources) != 1 || sources[0].Source != "10.0.0.0/24" {
+		t.Errorf("ListStuffingSources() with limit 1 = %v, %v, want the top source", sources, err)
+	}
+
+	if _, err := svc.ListStuffingSources(ctx, "acme", 0); !errors.Is(err, service.ErrTenantNotFound) {
+		t.Errorf("ListStuffingSources() of an unknown tenant error = %v, want ErrTenantNotFound", err)
+	}
+}
+
+func TestSprayAlert(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	resetter := &mockResetter{sprayAlert: &ratelimit.SprayAlert{Tenant: "acme"}}
+	svc := newTestService(store, resetter)
+	ctx := callerContext("alice", "/test/ClearSprayAlert")
+
+	alert, active, err := svc.GetSprayAlert(ctx)
+	if err != nil || !active || alert.Tenant != "acme" {
+		t.Errorf("GetSprayAlert() = %+v, %v, %v, want the alert raised by acme", alert, active, err)
+	}
+
+	for _, want := range []bool{true, false} {
+		wasActive, err := svc.ClearSprayAlert(ctx)
+		if err != nil || wasActive != want {
+			t.Errorf("ClearSprayAlert() = %v, %v, want %v", wasActive, err, want)
+		}
+	}
+
+	if _, active, _ := svc.GetSprayAlert(ctx); active {
+		t.Errorf("GetSprayAlert() after ClearSprayAlert() is still active")
+	}
+	if len(store.events) != 6 {
+		t.Fatalf("recorded %d events, want 6", len(store.events))
+	}
+	if event := store.events[2]; string(event.Before) != `{"active":true}` || string(event.After) != `{"active":false}` {
+		t.Errorf("diff = %s -> %s, want active true -> false", event.Before, event.After)
+	}
+}
+
+func TestHoneypotLogins(t *testing.T) {
+	t.Parallel()
+
+	store := newMockStore()
+	svc := newTestService(store, &mockResetter{})
+	ctx := callerContext("alice", "/test/AddHoneypotLogin")
+
+	for _, login := range []string{"root", "admin", "admin"} {
+		if err := svc.AddHoneypotLogin(ctx, login); err != nil {
+			t.Fatalf("AddHoneypotLogin(%q) error = %v", login, err)
+		}
+	}
+
+	logins, err := svc.ListHoneypotLogins(ctx)
+	if err != nil || !slices.Equal(logins, []string{"admin", "root"}) {
+		t.Errorf("ListHoneypotLogins() = %v, %v, want [admin root]", logins, err)
+	}
+
+	if err := svc.AddHoneypotLogin(ctx, ""); !errors.Is(err, service.ErrInvalidLogin) {
+		t.Errorf("AddHoneypotLogin() of an empty login error = %v, want %v", err, service.ErrInvalidLogin)
+	}
+	if err := svc.RemoveHoneypotLogin(ctx, "guest"); !errors.Is(err, service.ErrHoneypotLoginNotFound) {
+		t.Errorf("RemoveHoneypotLogin() of an unknown login error = %v, want %v", err, service.ErrHoneypotLoginNotFound)
+	}
+	if err := svc.RemoveHoneypotLogin(ctx, "root"); err != nil {
+		t.Fatalf("RemoveHoneypotLogin() error = %v", err)
+	}
+	if logins, _ := svc.ListHoneypotLogins(ctx); !slices.Equal(logins, []string{"admin"}) {
+		t.Errorf("ListHoneypotLogins() after removing root = %v, want [admin]", logins)
+	}
+
+	if len(store.events) != 8 {
+		t.Fatalf("recorded %d events, want 8", len(store.events))
+	}
+	if event := store.events[2]; string(event.Before) != `{"honeypot":true}` ||
+		string(event.After) != `{"honeypot":true}` {
+		t.Errorf("diff of adding a honeypot again = %s -> %s, want unchanged", event.Before, event.After)
+	}
+	if event := store.events[6]; string(event.Before) != `{"honeypot":true}` ||
+		string(event.After) != `{"honeypot":false}` {
+		t.Errorf("diff of removing a honeypot = %s -> %s, want true -> false", event.Before, event.After)
+	}
+}
@@ -0,0 +1,52 @@
+package management
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+// SprayAlerts hold the spray alert, which is shared by every tenant.
+type SprayAlerts interface {
+	SprayAlert(ctx context.Context) (ratelimit.SprayAlert, bool, error)
+	ClearSprayAlert(ctx context.Context) (bool, error)
+}
+
+// GetSprayAlert returns the active spray alert, active is false when rate limits are not tightened.
+func (s *Service) GetSprayAlert(ctx context.Context) (_ ratelimit.SprayAlert, active bool, _ error) {
+	var alert ratelimit.SprayAlert
+	err := s.auditedRead(ctx, map[string]any{}, func(ctx context.Context) error {
+		var err error
+		alert, active, err = s.sprayAlerts.SprayAlert(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get spray alert: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ratelimit.SprayAlert{}, false, err
+	}
+
+	return alert, active, nil
+}
+
+// ClearSprayAlert lifts the tightened rate limits before the alert expires and reports whether it was active.
+// Spraying that goes on raises a new alert.
+func (s *Service) ClearSprayAlert(ctx context.Context) (bool, error) {
+	var existed bool
+	err := s.auditedExternal(ctx, map[string]any{}, nil, func(ctx context.Context) (change, error) {
+		var err error
+		existed, err = s.sprayAlerts.ClearSprayAlert(ctx)
+		if err != nil {
+			return change{}, fmt.Errorf("failed to clear spray alert: %w", err)
+		}
+
+		return change{before: sprayAlertState{Active: existed}, after: sprayAlertState{Active: false}}, nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return existed, nil
+}
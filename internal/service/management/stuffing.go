@@ -0,0 +1,44 @@
+package management
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage/ratelimit"
+)
+
+// StuffingSources ranks the sources of a tenant by the distinct logins tried from them.
+type StuffingSources interface {
+	TopStuffingSources(ctx context.Context, tenant string, limit int) ([]ratelimit.StuffingSource, error)
+}
+
+// ListStuffingSources lists the sources with the most distinct logins, the most first. A zero limit
+// or one above ratelimit.TopSourcesKept lists every source kept.
+func (s *Service) ListStuffingSources(
+	ctx context.Context,
+	tenant string,
+	limit uint64,
+) ([]ratelimit.StuffingSource, error) {
+	if limit == 0 || limit > ratelimit.TopSourcesKept {
+		limit = ratelimit.TopSourcesKept
+	}
+
+	var sources []ratelimit.StuffingSource
+	err := s.auditedRead(ctx, map[string]any{"tenant": tenant, "limit": limit}, func(ctx context.Context) error {
+		if err := s.checkTenant(ctx, tenant, s.tenants.GetTenant); err != nil {
+			return err
+		}
+
+		var err error
+		sources, err = s.stuffingSources.TopStuffingSources(ctx, tenant, int(limit))
+		if err != nil {
+			return fmt.Errorf("failed to list stuffing sources: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sources, nil
+}
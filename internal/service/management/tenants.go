@@ -0,0 +1,129 @@
+package management
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/FluVirus2/antibruteforce/internal/service"
+	"github.com/FluVirus2/antibruteforce/internal/storage/settings"
+)
+
+// tenantNamePattern keeps names usable in Redis keys and metric labels.
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// TenantStore keeps tenants shared by all replicas. Changes join the transaction
+// started by Transactor, every replica picks them up once they are committed.
+type TenantStore interface {
+	ListTenants(ctx context.Context) ([]settings.Tenant, error)
+	GetTenant(ctx context.Context, name string) (settings.Tenant, error)
+	LockTenant(ctx context.Context, name string) (settings.Tenant, error)
+	SetTenant(ctx context.Context, actor string, tenant settings.Tenant) error
+	DeleteTenant(ctx context.Context, name string) error
+}
+
+func (s *Service) ListTenants(ctx context.Context) ([]settings.Tenant, error) {
+	var tenants []settings.Tenant
+	err := s.auditedRead(ctx, map[string]any{}, func(ctx context.Context) error {
+		var err error
+		tenants, err = s.tenants.ListTenants(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list tenants: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
+
+// SetTenant creates the tenant or replaces its limits. Zero limits fall back to the global ones.
+func (s *Service) SetTenant(ctx context.Context, tenant settings.Tenant) error {
+	args := map[string]any{
+		"name":     tenant.Name,
+		"login":    tenant.Limits.Login,
+		"password": tenant.Limits.Password,
+		"ip":       tenant.Limits.IP,
+	}
+
+	return s.audited(ctx, args, func(ctx context.Context) (change, error) {
+		if !tenantNamePattern.MatchString(tenant.Name) {
+			return change{}, service.NewInvalidArgumentError("tenant.name", service.ErrInvalidTenant)
+		}
+		if !tenant.Limits.IsZero() {
+			if err := validateLimits("tenant.limits", tenant.Limits); err != nil {
+				return change{}, err
+			}
+		}
+
+		before, err := s.tenants.LockTenant(ctx, tenant.Name)
+		found := err == nil
+		if err != nil && !errors.Is(err, settings.ErrTenantNotFound) {
+			return change{}, fmt.Errorf("failed to lock tenant: %w", err)
+		}
+
+		ch := change{after: tenant.Limits}
+		if found {
+			ch.before = before.Limits
+		}
+		if found && before.Limits == tenant.Limits {
+			return ch, nil
+		}
+
+		if err := s.tenants.SetTenant(ctx, actorFromContext(ctx), tenant); err != nil {
+			return change{}, fmt.Errorf("failed to set tenant: %w", err)
+		}
+
+		return ch, nil
+	})
+}
+
+// DeleteTenant removes the tenant together with its lists, its buckets expire on their own.
+func (s *Service) DeleteTenant(ctx context.Context, name string) error {
+	err := s.audited(ctx, map[string]any{"name": name}, func(ctx context.Context) (change, error) {
+		before, err := s.tenants.LockTenant(ctx, name)
+		if errors.Is(err, settings.ErrTenantNotFound) {
+			return change{}, service.ErrTenantNotFound
+		}
+		if err != nil {
+			return change{}, fmt.Errorf("failed to lock tenant: %w", err)
+		}
+
+		if err := s.tenants.DeleteTenant(ctx, name); err != nil {
+			return change{}, fmt.Errorf("failed to delete tenant: %w", err)
+		}
+
+		return change{before: before.Limits}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.provider.InvalidateCache(ctx)
+
+	return nil
+}
+
+// checkTenant accepts the default tenant "" and tenants found by get, which either reads or locks the tenant.
+func (s *Service) checkTenant(
+	ctx context.Context,
+	tenant string,
+	get func(ctx context.Context, name string) (settings.Tenant, error),
+) error {
+	if tenant == "" {
+		return nil
+	}
+
+	_, err := get(ctx, tenant)
+	if errors.Is(err, settings.ErrTenantNotFound) {
+		return service.NewInvalidArgumentError("tenant", service.ErrTenantNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	return nil
+}
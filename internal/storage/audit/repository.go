@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+)
+
+const componentName = "audit_repository"
+
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+	// ResultPending is recorded before changing a store outside Postgres, the outcome follows in its own event.
+	ResultPending = "pending"
+)
+
+// Event is one row of the append-only audit_events table.
+// Arguments, Before and After hold JSON, Before and After are nil for failed calls.
+type Event struct {
+	ID        int64
+	CreatedAt time.Time
+	Actor     string
+	RPC       string
+	Arguments json.RawMessage
+	Before    json.RawMessage
+	After     json.RawMessage
+	Result    string
+	Error     string
+}
+
+// Filter narrows ListEvents, zero values match everything.
+type Filter struct {
+	Actor  string
+	RPC    string
+	Result string
+	Since  time.Time
+	Until  time.Time
+	Offset uint64
+	Limit  uint64
+}
+
+type Repository struct {
+	pool     storage.Querier
+	observer storage.CallObserver
+	logger   *slog.Logger
+}
+
+func NewRepository(pool storage.Querier, observer storage.CallObserver, logger *slog.Logger) *Repository {
+	return &Repository{
+		pool:     pool,
+		observer: observer,
+		logger:   logger,
+	}
+}
+
+// Insert joins the transaction in ctx if there is one, so the event is committed together with the change.
+func (r *Repository) Insert(ctx context.Context, event Event) (err error) {
+	defer storage.ObserveCall(r.observer, componentName, "insert", time.Now(), &err)
+
+	_, err = storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`INSERT INTO audit_events (actor, rpc, arguments, before, after, result, error)
+		 VALUES ($1, $2, COALESCE($3::jsonb, '{}'), $4, $5, $6, NULLIF($7, ''))`,
+		event.Actor, event.RPC, nullableJSON(event.Arguments), nullableJSON(event.Before), nullableJSON(event.After),
+		event.Result, event.Error)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit event for %q: %w", event.RPC, err)
+	}
+
+	return nil
+}
+
+// ListEvents returns matching events, newest first.
+func (r *Repository) ListEvents(ctx context.Context, filter Filter) (_ []Event, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "list_events", time.Now(), &err)
+
+	var conditions []string
+	var args []any
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Actor != "" {
+		addCondition("actor = $%d", filter.Actor)
+	}
+	if filter.RPC != "" {
+		addCondition("rpc = $%d", filter.RPC)
+	}
+	if filter.Result != "" {
+		addCondition("result = $%d", filter.Result)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("created_at < $%d", filter.Until)
+	}
+
+	query := `SELECT id, created_at, actor, rpc, arguments, before, after, result, COALESCE(error, '')
+		FROM audit_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Offset, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC OFFSET $%d LIMIT $%d", len(args)-1, len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Actor, &event.RPC,
+			&event.Arguments, &event.Before, &event.After, &event.Result, &event.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event row: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit events: %w", err)
+	}
+
+	return events, nil
+}
+
+func nullableJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
@@ -0,0 +1,157 @@
+package breached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+)
+
+const (
+	DefaultRefreshInterval = time.Minute
+	componentName          = "breached"
+)
+
+// Corpus keeps an index of the corpus file and rebuilds it in the background when the file changes.
+// Lookups use the previous index until the new one is ready, so a rebuild never blocks them.
+type Corpus struct {
+	corpusPath string
+	indexPath  string
+	observer   storage.CallObserver
+	logger     *slog.Logger
+
+	index atomic.Pointer[Index]
+	// mu serializes refreshes, builtFrom is the modification time of the corpus the index was built from.
+	mu        sync.Mutex
+	builtFrom time.Time
+}
+
+// NewCorpus indexes the corpus at corpusPath into indexPath, nothing is breached until Refresh succeeds.
+func NewCorpus(corpusPath, indexPath string, observer storage.CallObserver, logger *slog.Logger) *Corpus {
+	return &Corpus{
+		corpusPath: corpusPath,
+		indexPath:  indexPath,
+		observer:   observer,
+		logger:     logger,
+	}
+}
+
+// Refresh replaces the index when the corpus changed since it was built. An index file newer than the corpus
+// is opened as it is, so a restart does not rebuild it. A failed refresh keeps the previous index.
+func (c *Corpus) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.corpusPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat breached password corpus: %w", err)
+	}
+	if c.index.Load() != nil && info.ModTime().Equal(c.builtFrom) {
+		return nil
+	}
+
+	idx, err := c.openIndex(ctx, info.ModTime())
+	if err != nil {
+		return err
+	}
+
+	previous := c.index.Swap(idx)
+	c.builtFrom = info.ModTime()
+	c.logger.Info("loaded breached password corpus", "hashes", idx.Len(), "corpus", c.corpusPath)
+
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			c.logger.Warn("failed to close previous breached password index", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// openIndex reuses an index file written after modTime, on the first refresh only.
+func (c *Corpus) openIndex(ctx context.Context, modTime time.Time) (*Index, error) {
+	if c.index.Load() == nil {
+		if info, err := os.Stat(c.indexPath); err == nil && !info.ModTime().Before(modTime) {
+			idx, err := OpenIndex(c.indexPath)
+			if err == nil {
+				return idx, nil
+			}
+			c.logger.Warn("rebuilding breached password index", "error", err)
+		}
+	}
+
+	if err := c.build(ctx); err != nil {
+		return nil, err
+	}
+	return OpenIndex(c.indexPath)
+}
+
+// build writes the index next to the current one and renames it over, the current file stays open
+// for lookups until the new index replaces it.
+func (c *Corpus) build(ctx context.Context) (err error) {
+	defer storage.ObserveCall(c.observer, componentName, "build_index", time.Now(), &err)
+
+	corpus, err := os.Open(c.corpusPath)
+	if err != nil {
+		return fmt.Errorf("failed to open breached password corpus: %w", err)
+	}
+	defer corpus.Close()
+
+	tmp := c.indexPath + ".tmp"
+	if _, err := BuildIndex(ctx, corpus, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to index breached password corpus: %w", err)
+	}
+	if err := os.Rename(tmp, c.indexPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace breached password index: %w", err)
+	}
+
+	return nil
+}
+
+// Run refreshes the index every interval until ctx is done, and closes it then.
+func (c *Corpus) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Refresh(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Warn("keeping previous breached password index", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if idx := c.index.Swap(nil); idx != nil {
+				idx.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Breached tells whether password is in the corpus, it is false until the first index is loaded.
+func (c *Corpus) Breached(password string) (_ bool, err error) {
+	defer storage.ObserveCall(c.observer, componentName, "lookup", time.Now(), &err)
+
+	for {
+		idx := c.index.Load()
+		if idx == nil {
+			return false, nil
+		}
+
+		breached, err := idx.Contains(password)
+		// the index was replaced between loading and reading it
+		if errors.Is(err, ErrClosed) && c.index.Load() != idx {
+			continue
+		}
+		return breached, err
+	}
+}
@@ -0,0 +1,87 @@
+package breached
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+)
+
+func TestCorpusRefresh(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	corpusPath := filepath.Join(dir, "pwned.txt")
+	indexPath := filepath.Join(dir, "pwned.idx")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	write := func(modTime time.Time, passwords ...string) {
+		t.Helper()
+		if err := os.WriteFile(corpusPath, []byte(corpusOf(passwords...)), 0o600); err != nil {
+			t.Fatalf("failed to write corpus: %v", err)
+		}
+		if err := os.Chtimes(corpusPath, modTime, modTime); err != nil {
+			t.Fatalf("failed to touch corpus: %v", err)
+		}
+	}
+	breached := func(c *Corpus, password string) bool {
+		t.Helper()
+		got, err := c.Breached(password)
+		if err != nil {
+			t.Fatalf("Breached() error = %v", err)
+		}
+		return got
+	}
+
+	corpus := NewCorpus(corpusPath, indexPath, storage.NopCallObserver{}, logger)
+	if breached(corpus, "123456") {
+		t.Errorf("Breached() before the first refresh = true, want false")
+	}
+
+	start := time.Now().Add(-time.Hour)
+	write(start, "123456")
+	if err := corpus.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if !breached(corpus, "123456") {
+		t.Errorf("Breached() = false, want true")
+	}
+
+	write(start.Add(time.Minute), "qwerty")
+	if err := corpus.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() of a changed corpus error = %v", err)
+	}
+	if breached(corpus, "123456") || !breached(corpus, "qwerty") {
+		t.Errorf("Breached() still answers from the previous corpus")
+	}
+
+	// a broken corpus keeps the previous index
+	if err := os.WriteFile(corpusPath, []byte("not a hash\n"), 0o600); err != nil {
+		t.Fatalf("failed to write corpus: %v", err)
+	}
+	if err := corpus.Refresh(ctx); err == nil {
+		t.Errorf("Refresh() of a broken corpus error = nil, want an error")
+	}
+	if !breached(corpus, "qwerty") {
+		t.Errorf("Breached() after a failed refresh = false, want the previous index")
+	}
+
+	// after a restart the index written later than the corpus is reused
+	write(start.Add(2*time.Minute), "letmein")
+	if err := os.Chtimes(indexPath, start.Add(3*time.Minute), start.Add(3*time.Minute)); err != nil {
+		t.Fatalf("failed to touch index: %v", err)
+	}
+	restarted := NewCorpus(corpusPath, indexPath, storage.NopCallObserver{}, logger)
+	if err := restarted.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if !breached(restarted, "qwerty") {
+		t.Errorf("Breached() after a restart did not reuse the index")
+	}
+}
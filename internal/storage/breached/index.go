@@ -0,0 +1,245 @@
+// Package breached looks passwords up in a local corpus of breached ones, such as a HIBP SHA-1 dump.
+package breached
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec // the corpus is keyed by SHA-1, it is not used for security here
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// indexMagic starts every index file, the digit is the format version.
+	indexMagic = "ABFPWND1"
+	// fanoutSize is one entry per first two bytes of a hash.
+	fanoutSize = 1 << 16
+	headerSize = len(indexMagic) + 8*fanoutSize
+	recordSize = sha1.Size
+)
+
+var (
+	ErrUnsorted     = errors.New("corpus is not sorted by hash")
+	ErrInvalidIndex = errors.New("invalid breached password index")
+	ErrClosed       = errors.New("breached password index is closed")
+)
+
+// Index is a sorted file of SHA-1 hashes. A fan-out table of the first two bytes of every hash narrows
+// a lookup down to a few thousand records even for the full HIBP corpus, they are binary searched
+// straight from the file, so the index takes almost no memory.
+type Index struct {
+	// mu keeps Close from closing the file under a lookup.
+	mu   sync.RWMutex
+	file *os.File
+	// ends hold how many records have a prefix up to and including every two bytes.
+	ends [fanoutSize]uint64
+}
+
+// BuildIndex writes an index of the corpus read from r to path and returns how many hashes it holds.
+// Lines are a hex SHA-1 hash optionally followed by ":" and a count, as in HIBP dumps, and must be
+// sorted by hash. Duplicate hashes are kept once.
+func BuildIndex(ctx context.Context, r io.Reader, path string) (_ uint64, err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create index: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close index: %w", closeErr)
+		}
+	}()
+
+	// records follow the header, which is written last, when the counts are known
+	if _, err := file.Seek(int64(headerSize), io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to write index: %w", err)
+	}
+
+	var ends [fanoutSize]uint64
+	var count uint64
+	var previous [recordSize]byte
+	w := bufio.NewWriter(file)
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		if line%100000 == 0 && ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		hash, ok, err := parseLine(scanner.Text())
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if !ok {
+			continue
+		}
+
+		switch cmp := bytes.Compare(hash[:], previous[:]); {
+		case count > 0 && cmp < 0:
+			return 0, fmt.Errorf("line %d: %w", line, ErrUnsorted)
+		case count > 0 && cmp == 0:
+			continue
+		}
+
+		if _, err := w.Write(hash[:]); err != nil {
+			return 0, fmt.Errorf("failed to write index: %w", err)
+		}
+		ends[prefix(hash)]++
+		previous = hash
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read corpus: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write index: %w", err)
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, indexMagic)
+	var end uint64
+	for p, n := range ends {
+		end += n
+		binary.BigEndian.PutUint64(header[len(indexMagic)+8*p:], end)
+	}
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return 0, fmt.Errorf("failed to write index header: %w", err)
+	}
+
+	return count, nil
+}
+
+// parseLine skips empty lines.
+func parseLine(line string) (hash [recordSize]byte, ok bool, err error) {
+	line, _, _ = strings.Cut(line, ":")
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return hash, false, nil
+	}
+
+	if len(line) != hex.EncodedLen(recordSize) {
+		return hash, false, fmt.Errorf("%q is not a SHA-1 hash", line)
+	}
+	if _, err := hex.Decode(hash[:], []byte(line)); err != nil {
+		return hash, false, fmt.Errorf("%q is not a SHA-1 hash: %w", line, err)
+	}
+
+	return hash, true, nil
+}
+
+func prefix(hash [recordSize]byte) int {
+	return int(hash[0])<<8 | int(hash[1])
+}
+
+// OpenIndex opens an index written by BuildIndex.
+func OpenIndex(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+
+	idx := &Index{file: file}
+	if err := idx.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+func (idx *Index) readHeader() error {
+	header := make([]byte, headerSize)
+	if _, err := idx.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidIndex, err)
+	}
+	if string(header[:len(indexMagic)]) != indexMagic {
+		return fmt.Errorf("%w: unknown format", ErrInvalidIndex)
+	}
+
+	for p := range idx.ends {
+		idx.ends[p] = binary.BigEndian.Uint64(header[len(indexMagic)+8*p:])
+	}
+
+	info, err := idx.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat index: %w", err)
+	}
+	if want := int64(headerSize) + int64(idx.Len())*recordSize; info.Size() != want { //nolint:gosec // sizes fit
+		return fmt.Errorf("%w: %d bytes, want %d", ErrInvalidIndex, info.Size(), want)
+	}
+
+	return nil
+}
+
+// Len is the number of hashes in the index.
+func (idx *Index) Len() uint64 {
+	return idx.ends[fanoutSize-1]
+}
+
+// Contains tells whether the SHA-1 hash of password is in the index.
+func (idx *Index) Contains(password string) (bool, error) {
+	hash := sha1.Sum([]byte(password)) //nolint:gosec // see the import
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.file == nil {
+		return false, ErrClosed
+	}
+
+	p := prefix(hash)
+	var lo uint64
+	if p > 0 {
+		lo = idx.ends[p-1]
+	}
+	hi := idx.ends[p]
+
+	record := make([]byte, recordSize)
+	read := func(i uint64) error {
+		_, err := idx.file.ReadAt(record, int64(headerSize)+int64(i)*recordSize) //nolint:gosec // offsets fit
+		return err
+	}
+
+	var readErr error
+	n := int(hi - lo) //nolint:gosec // a bucket is far smaller than an int
+	i := sort.Search(n, func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		readErr = read(lo + uint64(i))
+		return readErr != nil || bytes.Compare(record, hash[:]) >= 0
+	})
+	if readErr != nil {
+		return false, fmt.Errorf("failed to read index: %w", readErr)
+	}
+	if i == n {
+		return false, nil
+	}
+
+	// the last record read need not be the one found
+	if err := read(lo + uint64(i)); err != nil {
+		return false, fmt.Errorf("failed to read index: %w", err)
+	}
+	return bytes.Equal(record, hash[:]), nil
+}
+
+// Close waits for lookups in flight, later ones fail with ErrClosed.
+func (idx *Index) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.file == nil {
+		return nil
+	}
+
+	err := idx.file.Close()
+	idx.file = nil
+	return err
+}
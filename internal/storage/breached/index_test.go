@@ -0,0 +1,115 @@
+package breached
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // the corpus is keyed by SHA-1
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// corpusOf writes a HIBP-style corpus of passwords, sorted by hash.
+func corpusOf(passwords ...string) string {
+	lines := make([]string, len(passwords))
+	for i, password := range passwords {
+		hash := sha1.Sum([]byte(password)) //nolint:gosec // see the import
+		lines[i] = strings.ToUpper(hex.EncodeToString(hash[:])) + ":42"
+	}
+	slices.Sort(lines)
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "corpus.idx")
+	corpus := corpusOf("123456", "password", "qwerty", "password", "letmein") + "\n"
+
+	count, err := BuildIndex(context.Background(), strings.NewReader(corpus), path)
+	if err != nil {
+		t.Fatalf("BuildIndex() error = %v", err)
+	}
+	if count != 4 {
+		t.Errorf("BuildIndex() = %d hashes, want 4 without the duplicate", count)
+	}
+
+	idx, err := OpenIndex(path)
+	if err != nil {
+		t.Fatalf("OpenIndex() error = %v", err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"123456", true},
+		{"password", true},
+		{"letmein", true},
+		{"Password", false},
+		{"correct horse battery staple", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got, err := idx.Contains(tt.password); err != nil || got != tt.want {
+			t.Errorf("Contains(%q) = %v, %v, want %v", tt.password, got, err, tt.want)
+		}
+	}
+
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := idx.Contains("123456"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Contains() after Close() error = %v, want ErrClosed", err)
+	}
+}
+
+func TestBuildIndexErrors(t *testing.T) {
+	t.Parallel()
+
+	sorted := corpusOf("123456", "password")
+	lines := strings.SplitAfter(sorted, "\n")
+
+	tests := []struct {
+		name   string
+		corpus string
+		want   error
+	}{
+		{"unsorted", lines[1] + lines[0], ErrUnsorted},
+		{"not a hash", "5BAA61E4C9B93F3F:3\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "corpus.idx")
+			_, err := BuildIndex(context.Background(), strings.NewReader(tt.corpus), path)
+			if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("BuildIndex() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenIndexTruncated(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "corpus.idx")
+	if _, err := BuildIndex(context.Background(), strings.NewReader(corpusOf("123456")), path); err != nil {
+		t.Fatalf("BuildIndex() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+
+	if _, err := OpenIndex(path); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("OpenIndex() of a truncated index error = %v, want ErrInvalidIndex", err)
+	}
+}
@@ -3,6 +3,12 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net"
+
+	"github.com/FluVirus2/antibruteforce/pkg/breaker"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
 )
 
 var ErrCacheMiss = errors.New("cache miss")
@@ -19,3 +25,25 @@ func (e *UnexpectedDataFormatError) Error() string {
 func (e *UnexpectedDataFormatError) Unwrap() error {
 	return e.Cause
 }
+
+// IsUnavailable reports whether err means that Postgres or Redis could not be reached,
+// as opposed to a query or data error.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	var connectErr *pgconn.ConnectError
+
+	switch {
+	case errors.As(err, &netErr), errors.As(err, &connectErr):
+		return true
+	case errors.Is(err, redis.ErrClosed), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.Is(err, ErrTimeout), errors.Is(err, breaker.ErrOpen):
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/pkg/breaker"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrTimeout = errors.New("dependency call timed out")
+
+// Guard bounds every call to one dependency with a timeout and a circuit breaker,
+// so a hung Postgres or Redis fails calls quickly instead of stalling every request.
+type Guard struct {
+	name    string
+	timeout time.Duration
+	breaker *breaker.Breaker
+}
+
+// NewGuard creates a guard for the dependency called name. A zero timeout leaves calls bounded
+// only by the caller's context.
+func NewGuard(name string, timeout time.Duration, config breaker.Config, logger *slog.Logger) *Guard {
+	config.OnStateChange = func(from, to breaker.State) {
+		logger.Warn("circuit breaker changed state", "dependency", name, "from", from.String(), "to", to.String())
+	}
+
+	return &Guard{
+		name:    name,
+		timeout: timeout,
+		breaker: breaker.New(config),
+	}
+}
+
+func (g *Guard) Name() string {
+	return g.name
+}
+
+func (g *Guard) Breaker() *breaker.Breaker {
+	return g.breaker
+}
+
+// Do runs fn unless the breaker is open. Only failures that mean the dependency is unhealthy count
+// against the breaker: timeouts and connection errors, but not query errors or cancellation by the caller.
+func (g *Guard) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	done, err := g.breaker.Allow()
+	if err != nil {
+		return g.rejected(err)
+	}
+
+	callCtx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	err = g.classify(ctx, callCtx, fn(callCtx))
+	done(err != nil && ctx.Err() == nil && IsUnavailable(err))
+
+	return err
+}
+
+func (g *Guard) rejected(err error) error {
+	return fmt.Errorf("%s: %w", g.name, err)
+}
+
+func (g *Guard) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, g.timeout)
+}
+
+// classify replaces errors caused by the guard's own deadline with ErrTimeout,
+// so they are reported as an unavailable dependency rather than as the caller's deadline.
+func (g *Guard) classify(ctx, callCtx context.Context, err error) error {
+	if err == nil || ctx.Err() != nil || !expired(ctx, callCtx) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s after %s: %v", ErrTimeout, g.name, g.timeout, err)
+}
+
+// expired reports whether the guard's deadline has passed and it was not inherited from ctx.
+// Clients set it on their sockets, so it is compared with the clock: the socket may time out
+// slightly before callCtx reports DeadlineExceeded.
+func expired(ctx, callCtx context.Context) bool {
+	deadline, ok := callCtx.Deadline()
+	if !ok || time.Now().Before(deadline) {
+		return false
+	}
+
+	parent, ok := ctx.Deadline()
+	return !ok || parent.After(deadline)
+}
+
+// RedisHook routes every command and pipeline of a client through the guard.
+// The client needs ContextTimeoutEnabled, otherwise it ignores the guard's deadline while reading.
+func (g *Guard) RedisHook() redis.Hook {
+	return redisGuardHook{guard: g}
+}
+
+type redisGuardHook struct {
+	guard *Guard
+}
+
+func (h redisGuardHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h redisGuardHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := h.guard.Do(ctx, func(ctx context.Context) error {
+			return next(ctx, cmd)
+		})
+		if err != nil {
+			cmd.SetErr(err)
+		}
+		return err
+	}
+}
+
+func (h redisGuardHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := h.guard.Do(ctx, func(ctx context.Context) error {
+			return next(ctx, cmds)
+		})
+		// other errors are already set on the commands they belong to
+		if errors.Is(err, ErrTimeout) || errors.Is(err, breaker.ErrOpen) {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+		}
+		return err
+	}
+}
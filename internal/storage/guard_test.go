@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/pkg/breaker"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type fault int32
+
+const (
+	faultNone fault = iota
+	// faultBlackhole keeps connections open but drops everything sent over them, like a hung server.
+	faultBlackhole
+	// faultRefuse closes every connection, like a crashed server.
+	faultRefuse
+)
+
+// faultProxy forwards TCP connections to upstream and injects faults into them on demand.
+type faultProxy struct {
+	listener net.Listener
+	upstream string
+	fault    atomic.Int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newFaultProxy(t *testing.T, upstream string) *faultProxy {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	p := &faultProxy{listener: listener, upstream: upstream}
+	t.Cleanup(p.close)
+	go p.serve()
+
+	return p
+}
+
+func (p *faultProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *faultProxy) inject(f fault) {
+	p.fault.Store(int32(f))
+	if f == faultRefuse {
+		p.closeConns()
+	}
+}
+
+func (p *faultProxy) serve() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if fault(p.fault.Load()) == faultRefuse {
+			_ = client.Close()
+			continue
+		}
+
+		server, err := net.Dial("tcp", p.upstream)
+		if err != nil {
+			_ = client.Close()
+			continue
+		}
+
+		p.mu.Lock()
+		p.conns = append(p.conns, client, server)
+		p.mu.Unlock()
+
+		go p.pipe(server, client)
+		go p.pipe(client, server)
+	}
+}
+
+func (p *faultProxy) pipe(dst, src net.Conn) {
+	defer dst.Close()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+
+		if fault(p.fault.Load()) == faultBlackhole {
+			continue
+		}
+
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func (p *faultProxy) closeConns() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+func (p *faultProxy) close() {
+	_ = p.listener.Close()
+	p.closeConns()
+}
+
+func TestGuardTripsOnRedisFaults(t *testing.T) {
+	t.Parallel()
+
+	const (
+		timeout     = 100 * time.Millisecond
+		openTimeout = 300 * time.Millisecond
+	)
+
+	tests := []struct {
+		name        string
+		fault       fault
+		wantTimeout bool
+	}{
+		{name: "hung server", fault: faultBlackhole, wantTimeout: true},
+		{name: "refused connections", fault: faultRefuse, wantTimeout: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := miniredis.RunT(t)
+			proxy := newFaultProxy(t, server.Addr())
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			guard := NewGuard("redis", timeout, breaker.Config{FailureThreshold: 2, OpenTimeout: openTimeout}, logger)
+
+			client := redis.NewClient(&redis.Options{
+				Addr:                  proxy.Addr(),
+				MaxRetries:            -1,
+				ContextTimeoutEnabled: true,
+			})
+			client.AddHook(guard.RedisHook())
+			defer client.Close()
+
+			ctx := context.Background()
+			if err := client.Set(ctx, "key", "value", 0).Err(); err != nil {
+				t.Fatalf("set before the fault: %v", err)
+			}
+
+			proxy.inject(tt.fault)
+			for i := range 2 {
+				start := time.Now()
+				err := client.Get(ctx, "key").Err()
+				if !IsUnavailable(err) {
+					t.Fatalf("call %d during the fault: err = %v, want an unavailable error", i, err)
+				}
+				if errors.Is(err, ErrTimeout) != tt.wantTimeout {
+					t.Errorf("call %d during the fault: err = %v, timeout = %v", i, err, tt.wantTimeout)
+				}
+				if elapsed := time.Since(start); elapsed > 3*timeout {
+					t.Errorf("call %d during the fault took %s, want it bounded by the %s timeout", i, elapsed, timeout)
+				}
+			}
+
+			if state := guard.Breaker().State(); state != breaker.StateOpen {
+				t.Fatalf("breaker state = %s after consecutive failures, want open", state)
+			}
+
+			start := time.Now()
+			if err := client.Get(ctx, "key").Err(); !errors.Is(err, breaker.ErrOpen) {
+				t.Fatalf("call with open breaker: err = %v, want ErrOpen", err)
+			}
+			if elapsed := time.Since(start); elapsed > timeout/2 {
+				t.Errorf("call with open breaker took %s, want it to fail fast", elapsed)
+			}
+
+			proxy.inject(faultNone)
+			time.Sleep(openTimeout)
+
+			if got, err := client.Get(ctx, "key").Result(); err != nil || got != "value" {
+				t.Fatalf("probe after recovery = %q, %v", got, err)
+			}
+			if state := guard.Breaker().State(); state != breaker.StateClosed {
+				t.Errorf("breaker state = %s after a successful probe, want closed", state)
+			}
+		})
+	}
+}
+
+func TestGuardIgnoresCallerAndQueryErrors(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	guard := NewGuard("postgres", time.Second, breaker.Config{FailureThreshold: 1}, logger)
+
+	errQuery := errors.New("syntax error")
+	if err := guard.Do(context.Background(), func(context.Context) error {
+		return errQuery
+	}); !errors.Is(err, errQuery) {
+		t.Errorf("query error = %v, want it returned as is", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := guard.Do(ctx, func(ctx context.Context) error {
+		return &net.OpError{Op: "read", Net: "tcp", Err: ctx.Err()}
+	}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error of a canceled call = %v, want context.Canceled", err)
+	}
+
+	deadlineCtx, cancelDeadline := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancelDeadline()
+	if err := guard.Do(deadlineCtx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}); !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout) {
+		t.Errorf("error past the caller's deadline = %v, want context.DeadlineExceeded", err)
+	}
+
+	if state := guard.Breaker().State(); state != breaker.StateClosed {
+		t.Errorf("breaker state = %s, want closed: none of the errors was the dependency's fault", state)
+	}
+}
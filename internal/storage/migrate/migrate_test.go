@@ -0,0 +1,223 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testConnStringKey points the database tests at a Postgres they may create schemas in.
+const testConnStringKey = "ABF_TEST_PGSQL_CONNECTION_STRING"
+
+func TestLoadEmbedded(t *testing.T) {
+	t.Parallel()
+
+	sub, err := fs.Sub(embedded, "migrations")
+	if err != nil {
+		t.Fatalf("fs.Sub() error = %v", err)
+	}
+
+	migrations, err := Load(sub)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %d has version %d, want versions without gaps", i, m.Version)
+		}
+	}
+}
+
+func TestLoadRejectsInvalid(t *testing.T) {
+	t.Parallel()
+
+	file := func(data string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(data)}
+	}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "missing down step",
+			fsys: fstest.MapFS{"0001_init.up.sql": file("CREATE TABLE t ()")},
+		},
+		{
+			name: "version used twice",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":    file("CREATE TABLE t ()"),
+				"0001_init.down.sql":  file("DROP TABLE t"),
+				"0001_other.up.sql":   file("CREATE TABLE o ()"),
+				"0001_other.down.sql": file("DROP TABLE o"),
+			},
+		},
+		{
+			name: "unexpected file",
+			fsys: fstest.MapFS{"README.md": file("migrations")},
+		},
+		{
+			name: "zero version",
+			fsys: fstest.MapFS{
+				"0000_init.up.sql":   file("CREATE TABLE t ()"),
+				"0000_init.down.sql": file("DROP TABLE t"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(tt.fsys); !errors.Is(err, ErrInvalidMigration) {
+				t.Errorf("Load() error = %v, want ErrInvalidMigration", err)
+			}
+		})
+	}
+}
+
+// freshDatabase returns a pool whose connections use a new empty schema, dropped after the test.
+func freshDatabase(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	connString := os.Getenv(testConnStringKey)
+	if connString == "" {
+		t.Skipf("%s is not set", testConnStringKey)
+	}
+
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = admin.Close(context.Background()) })
+
+	schema := fmt.Sprintf("abf_migrate_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	})
+
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		t.Fatalf("failed to parse connection string: %v", err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool
+}
+
+func TestMigrationsOnFreshDatabase(t *testing.T) {
+	pool := freshDatabase(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	migrator, err := New(pool, logger)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	total := len(migrator.migrations)
+
+	// replicas starting together apply every migration exactly once
+	var wg sync.WaitGroup
+	applied := make([]int, 2)
+	errs := make([]error, 2)
+	for i := range applied {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied[i], errs[i] = migrator.Up(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if applied[0]+applied[1] != total {
+		t.Errorf("concurrent Up() applied %d and %d migrations, want %d in total",
+			applied[0], applied[1], total)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied() {
+			t.Errorf("migration %04d_%s is pending after Up()", s.Version, s.Name)
+		}
+	}
+
+	usable := []string{
+		`INSERT INTO subnets (subnet_type, subnet) VALUES (1, '10.0.0.0/8')`,
+		`INSERT INTO audit_events (actor, rpc, result) VALUES ('test', 'Test', 'ok')`,
+		`INSERT INTO settings (name, value, updated_by) VALUES ('limits', '{}', 'test')`,
+		`INSERT INTO limit_rules (client_id, login_limit, updated_by) VALUES ('app', 5, 'test')`,
+		`INSERT INTO tenants (name, updated_by) VALUES ('shop', 'test')`,
+		`INSERT INTO subnets (tenant, subnet_type, subnet) VALUES ('shop', 1, '10.0.0.0/8')`,
+	}
+	for _, statement := range usable {
+		if _, err := pool.Exec(ctx, statement); err != nil {
+			t.Errorf("failed to use the migrated tables: %s: %v", statement, err)
+		}
+	}
+
+	if _, err := pool.Exec(ctx, `DELETE FROM audit_events`); err == nil {
+		t.Error("audit_events accepted a delete, want it append-only")
+	}
+	_, err = pool.Exec(ctx, `INSERT INTO limit_rules (client_id, ip_limit, updated_by) VALUES ('app', 5, 'test')`)
+	if err == nil {
+		t.Error("limit_rules accepted a second rule for the same client, want one rule per subnet and client")
+	}
+	_, err = pool.Exec(ctx, `INSERT INTO subnets (subnet_type, subnet) VALUES (1, '10.0.0.0/8')`)
+	if err == nil {
+		t.Error("subnets accepted a duplicate global subnet, want one per tenant and list")
+	}
+
+	for i := total; i > 0; i-- {
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			t.Fatalf("Down() error = %v", err)
+		}
+		if reverted == nil || reverted.Version != int64(i) {
+			t.Fatalf("Down() reverted %+v, want version %d", reverted, i)
+		}
+	}
+
+	if reverted, err := migrator.Down(ctx); err != nil || reverted != nil {
+		t.Errorf("Down() on an empty schema = %+v, %v, want nothing reverted", reverted, err)
+	}
+
+	var tables int
+	err = pool.QueryRow(ctx, `SELECT count(*) FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'`).Scan(&tables)
+	if err != nil || tables != 0 {
+		t.Errorf("%d tables left after reverting everything, err = %v", tables, err)
+	}
+
+	if applied, err := migrator.Up(ctx); err != nil || applied != total {
+		t.Errorf("Up() after reverting everything = %d, %v, want %d", applied, err, total)
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS subnets;
+DROP TABLE IF EXISTS list_types;
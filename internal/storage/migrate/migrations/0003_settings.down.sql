@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS settings_history;
+DROP TABLE IF EXISTS settings;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS settings (
+    name        TEXT PRIMARY KEY,
+    value       JSONB NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by  TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS settings_history (
+    id          BIGSERIAL PRIMARY KEY,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name        TEXT NOT NULL,
+    actor       TEXT NOT NULL,
+    before      JSONB,
+    after       JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_settings_history_name ON settings_history (name, id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS limit_rules (
+    id              BIGSERIAL PRIMARY KEY,
+    subnet          CIDR,
+    client_id       TEXT,
+    login_limit     BIGINT NOT NULL DEFAULT 0,
+    password_limit  BIGINT NOT NULL DEFAULT 0,
+    ip_limit        BIGINT NOT NULL DEFAULT 0,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by      TEXT NOT NULL,
+    CHECK (subnet IS NOT NULL OR client_id IS NOT NULL),
+    UNIQUE NULLS NOT DISTINCT (subnet, client_id)
+);
@@ -0,0 +1,5 @@
+DELETE FROM subnets WHERE tenant IS NOT NULL;
+ALTER TABLE subnets DROP CONSTRAINT IF EXISTS subnets_tenant_subnet_type_subnet_key;
+ALTER TABLE subnets DROP COLUMN IF EXISTS tenant;
+ALTER TABLE subnets ADD CONSTRAINT subnets_subnet_type_subnet_key UNIQUE (subnet_type, subnet);
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS tenants (
+    name            TEXT PRIMARY KEY,
+    login_limit     BIGINT NOT NULL DEFAULT 0,
+    password_limit  BIGINT NOT NULL DEFAULT 0,
+    ip_limit        BIGINT NOT NULL DEFAULT 0,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by      TEXT NOT NULL
+);
+
+-- subnets without a tenant form the global lists, which apply to every tenant
+ALTER TABLE subnets ADD COLUMN IF NOT EXISTS tenant TEXT REFERENCES tenants(name) ON DELETE CASCADE;
+ALTER TABLE subnets DROP CONSTRAINT IF EXISTS subnets_subnet_type_subnet_key;
+ALTER TABLE subnets ADD CONSTRAINT subnets_tenant_subnet_type_subnet_key
+    UNIQUE NULLS NOT DISTINCT (tenant, subnet_type, subnet);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS honeypot_logins;
+DROP INDEX IF EXISTS subnets_expires_at_idx;
+ALTER TABLE subnets DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,9 @@
+-- subnets added by honeypot logins expire, the ones added by operators never do
+ALTER TABLE subnets ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS subnets_expires_at_idx ON subnets (expires_at) WHERE expires_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS honeypot_logins (
+    login           TEXT PRIMARY KEY,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by      TEXT NOT NULL
+);
@@ -0,0 +1,19 @@
+package storage
+
+import "time"
+
+// CallObserver is notified about every call a storage makes to Postgres or Redis.
+type CallObserver interface {
+	ObserveCall(component, operation string, duration time.Duration, err error)
+}
+
+type NopCallObserver struct{}
+
+func (NopCallObserver) ObserveCall(string, string, time.Duration, error) {}
+
+// ObserveCall is meant to be deferred right at the start of a storage method with a named error result:
+//
+//	defer storage.ObserveCall(s.observer, "ratelimit", "count_and_increment", time.Now(), &err)
+func ObserveCall(observer CallObserver, component, operation string, start time.Time, err *error) {
+	observer.ObserveCall(component, operation, time.Since(start), *err)
+}
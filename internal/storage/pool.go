@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Pool is the Postgres pool as seen by repositories, it runs every call through a Guard.
+// Rows returned by Query hold the guard's deadline until they are closed.
+type Pool struct {
+	pool  *pgxpool.Pool
+	guard *Guard
+}
+
+func NewPool(pool *pgxpool.Pool, guard *Guard) *Pool {
+	return &Pool{
+		pool:  pool,
+		guard: guard,
+	}
+}
+
+func (p *Pool) Exec(ctx context.Context, sql string, args ...any) (tag pgconn.CommandTag, err error) {
+	err = p.guard.Do(ctx, func(ctx context.Context) error {
+		tag, err = p.pool.Exec(ctx, sql, args...)
+		return err
+	})
+	return tag, err
+}
+
+func (p *Pool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return &guardedRow{
+		pool: p,
+		ctx:  ctx,
+		sql:  sql,
+		args: args,
+	}
+}
+
+// Query cannot use Guard.Do, since the deadline has to outlive the call until rows are read.
+func (p *Pool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	done, err := p.guard.breaker.Allow()
+	if err != nil {
+		return nil, p.guard.rejected(err)
+	}
+
+	callCtx, cancel := p.guard.withTimeout(ctx)
+	finish := func(err error) error {
+		err = p.guard.classify(ctx, callCtx, err)
+		done(err != nil && ctx.Err() == nil && IsUnavailable(err))
+		cancel()
+		return err
+	}
+
+	rows, err := p.pool.Query(callCtx, sql, args...)
+	if err != nil {
+		return nil, finish(err)
+	}
+
+	return &guardedRows{Rows: rows, finish: finish}, nil
+}
+
+func (p *Pool) Ping(ctx context.Context) error {
+	return p.guard.Do(ctx, p.pool.Ping)
+}
+
+type guardedRow struct {
+	pool *Pool
+	ctx  context.Context
+	sql  string
+	args []any
+}
+
+func (r *guardedRow) Scan(dest ...any) error {
+	return r.pool.guard.Do(r.ctx, func(ctx context.Context) error {
+		return r.pool.pool.QueryRow(ctx, r.sql, r.args...).Scan(dest...)
+	})
+}
+
+type guardedRows struct {
+	pgx.Rows
+
+	finish func(err error) error
+	once   sync.Once
+	err    error
+}
+
+func (r *guardedRows) Close() {
+	r.once.Do(func() {
+		r.Rows.Close()
+		r.err = r.finish(r.Rows.Err())
+	})
+}
+
+func (r *guardedRows) Err() error {
+	if err := r.Rows.Err(); err != nil {
+		r.Close()
+		return r.err
+	}
+	return nil
+}
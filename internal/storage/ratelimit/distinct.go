@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// Sources of distinct logins are an IP and the network around it.
+const (
+	SourceSubnetPrefixIPv4 = 24
+	SourceSubnetPrefixIPv6 = 64
+)
+
+// Kinds of login sources.
+const (
+	SourceIP     = "ip"
+	SourceSubnet = "subnet"
+)
+
+// TopSourcesKept is how many sources with the most distinct logins are kept for every window.
+const TopSourcesKept = 100
+
+// distinctScript counts a login into the HyperLogLog of the current window and returns the distinct logins
+// of the current and the previous window together.
+// KEYS: current window, previous window.
+// ARGV: login, ttl in milliseconds.
+var distinctScript = redis.NewScript(`
+redis.call('PFADD', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return redis.call('PFCOUNT', KEYS[1], KEYS[2])
+`)
+
+// SetDistinctWindow changes how long distinct logins of a source are counted, zero stops counting them.
+// Logins are counted in fixed windows, a count covers the current window and the previous one.
+func (s *Storage) SetDistinctWindow(window time.Duration) {
+	s.distinctWindow.Store(int64(window))
+}
+
+// StuffingSource is a source with the estimated number of distinct logins tried from it.
+type StuffingSource struct {
+	Kind           string
+	Source         string
+	DistinctLogins int64
+}
+
+// sourceSubnet is the network around ip, empty for invalid IPs.
+func sourceSubnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if ip4 := parsed.To4(); ip4 != nil {
+		mask := net.CIDRMask(SourceSubnetPrefixIPv4, 8*net.IPv4len)
+		return (&net.IPNet{IP: ip4.Mask(mask), Mask: mask}).String()
+	}
+	mask := net.CIDRMask(SourceSubnetPrefixIPv6, 8*net.IPv6len)
+	return (&net.IPNet{IP: parsed.Mask(mask), Mask: mask}).String()
+}
+
+// distinctKey tags the key with the source, so in a Redis cluster both windows of a source share a slot
+// and distinctScript can read them together. Sources are IPs or networks, they never contain "}".
+func distinctKey(tenant, kind string, slot int64, source string) string {
+	return bucketKey(tenant, "logins_"+kind, "{"+source+"}|"+strconv.FormatInt(slot, 10))
+}
+
+func topSourcesKey(tenant string, slot int64) string {
+	return bucketKey(tenant, "logins_top", strconv.FormatInt(slot, 10))
+}
+
+// distinctCmd is a queued distinctScript with what is needed to rank its source afterwards.
+type distinctCmd struct {
+	*redis.Cmd
+
+	top    string
+	member string
+	ttl    time.Duration
+}
+
+// queueDistinctLogins counts the login for the IP and its subnet, the commands are nil when window is zero.
+func queueDistinctLogins(
+	ctx context.Context,
+	pipe redis.Pipeliner,
+	keys RequestKeys,
+	now time.Time,
+	window time.Duration,
+) (ip, subnet *distinctCmd) {
+	if window <= 0 {
+		return nil, nil
+	}
+
+	slot := now.UnixNano() / int64(window)
+	ttl := strconv.FormatInt((2 * window).Milliseconds(), 10)
+
+	// EVAL instead of EVALSHA, a script missing from the cache would fail the whole pipeline
+	queue := func(kind, source string) *distinctCmd {
+		cmd := distinctScript.Eval(ctx, pipe,
+			[]string{distinctKey(keys.Tenant, kind, slot, source), distinctKey(keys.Tenant, kind, slot-1, source)},
+			keys.Login, ttl,
+		)
+		return &distinctCmd{Cmd: cmd, top: topSourcesKey(keys.Tenant, slot), member: kind + "|" + source, ttl: 2 * window}
+	}
+
+	ip = queue(SourceIP, keys.IP)
+	if s := sourceSubnet(keys.IP); s != "" {
+		subnet = queue(SourceSubnet, s)
+	}
+
+	return ip, subnet
+}
+
+func distinctCount(cmd *redis.Cmd) int64 {
+	if cmd == nil {
+		return 0
+	}
+	n, _ := cmd.Int64()
+	return n
+}
+
+// count is the distinct logins of the source, zero for a nil command.
+func (c *distinctCmd) count() int64 {
+	if c == nil {
+		return 0
+	}
+	return distinctCount(c.Cmd)
+}
+
+// rankSources ranks counted sources among the top sources of their window. The top sources live in
+// another cluster slot than the counts, so they take a pipeline of their own. Ranking is best effort,
+// a failure only leaves the top sources behind and is logged.
+func (s *Storage) rankSources(ctx context.Context, cmds []countCmds) {
+	pipe := s.client.Pipeline()
+	ttls := make(map[string]time.Duration)
+	for _, c := range cmds {
+		for _, cmd := range []*distinctCmd{c.ipLogins, c.subnetLogins} {
+			if n := cmd.count(); n > 0 {
+				pipe.ZAddGT(ctx, cmd.top, redis.Z{Score: float64(n), Member: cmd.member})
+				ttls[cmd.top] = cmd.ttl
+			}
+		}
+	}
+	if len(ttls) == 0 {
+		return
+	}
+
+	for top, ttl := range ttls {
+		pipe.ZRemRangeByRank(ctx, top, 0, -TopSourcesKept-1)
+		pipe.PExpire(ctx, top, ttl)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.logger.Warn("failed to rank stuffing sources", "requests", len(cmds), "error", err)
+	}
+}
+
+// TopStuffingSources lists up to limit sources of the tenant with the most distinct logins,
+// the most first. It is empty while distinct logins are not counted.
+func (s *Storage) TopStuffingSources(ctx context.Context, tenant string, limit int) (_ []StuffingSource, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "top_stuffing_sources", time.Now(), &err)
+
+	window := time.Duration(s.distinctWindow.Load())
+	if window <= 0 {
+		return nil, nil
+	}
+
+	slot := time.Now().UnixNano() / int64(window)
+
+	pipe := s.client.Pipeline()
+	current := pipe.ZRangeWithScores(ctx, topSourcesKey(tenant, slot), 0, -1)
+	previous := pipe.ZRangeWithScores(ctx, topSourcesKey(tenant, slot-1), 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read top stuffing sources: %w", err)
+	}
+
+	// a source keeps the larger count of both windows
+	counts := make(map[string]int64)
+	for _, z := range append(previous.Val(), current.Val()...) {
+		member, _ := z.Member.(string)
+		counts[member] = max(counts[member], int64(z.Score))
+	}
+
+	sources := make([]StuffingSource, 0, len(counts))
+	for member, n := range counts {
+		kind, source, _ := strings.Cut(member, "|")
+		sources = append(sources, StuffingSource{Kind: kind, Source: source, DistinctLogins: n})
+	}
+
+	slices.SortFunc(sources, func(a, b StuffingSource) int {
+		return cmp.Or(cmp.Compare(b.DistinctLogins, a.DistinctLogins), cmp.Compare(a.Source, b.Source))
+	})
+
+	return sources[:min(limit, len(sources))], nil
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// LockoutHistory is how long a lockout is remembered after the last one of the same IP or login.
+const LockoutHistory = 24 * time.Hour
+
+// AttemptKey names a login used from an IP, for allowances and lockouts that do not depend on the password.
+type AttemptKey struct {
+	Tenant string
+	Login  string
+	IP     string
+}
+
+// allowanceKey puts the IP first, IPs never contain "|", so any login is told apart.
+func allowanceKey(key AttemptKey) string {
+	return bucketKey(key.Tenant, "allowance", key.IP+"|"+key.Login)
+}
+
+// lockoutKey counts lockouts of one IP or login, dimension tells which.
+func lockoutKey(tenant, dimension, value string) string {
+	return bucketKey(tenant, "lockouts_"+dimension, value)
+}
+
+// GrantAllowance replaces a previous allowance of the key, so it expires ttl after the last passed challenge.
+func (s *Storage) GrantAllowance(ctx context.Context, key AttemptKey, ttl time.Duration) (err error) {
+	defer storage.ObserveCall(s.observer, componentName, "grant_allowance", time.Now(), &err)
+
+	if err := s.client.Set(ctx, allowanceKey(key), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store allowance: %w", err)
+	}
+	return nil
+}
+
+// HasAllowances reports for every key whether it has an allowance, using a single pipeline.
+func (s *Storage) HasAllowances(ctx context.Context, keys []AttemptKey) (_ []bool, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "has_allowances", time.Now(), &err)
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Exists(ctx, allowanceKey(key))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read %d allowances: %w", len(keys), err)
+	}
+
+	granted := make([]bool, len(cmds))
+	for i, cmd := range cmds {
+		granted[i] = cmd.Val() > 0
+	}
+
+	return granted, nil
+}
+
+// RecordLockouts counts a lockout for the IP and for the login of every key, using a single pipeline.
+func (s *Storage) RecordLockouts(ctx context.Context, keys []AttemptKey) (err error) {
+	defer storage.ObserveCall(s.observer, componentName, "record_lockouts", time.Now(), &err)
+
+	pipe := s.client.Pipeline()
+	for _, key := range keys {
+		for _, k := range []string{lockoutKey(key.Tenant, "ip", key.IP), lockoutKey(key.Tenant, "login", key.Login)} {
+			pipe.Incr(ctx, k)
+			pipe.Expire(ctx, k, LockoutHistory)
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record %d lockouts: %w", len(keys), err)
+	}
+	return nil
+}
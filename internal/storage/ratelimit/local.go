@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LocalStorage is an in-process sliding window limiter with the same semantics as Storage.
+// Counters are per instance and start empty, so it is only meant to stand in while Redis is unavailable.
+// It keeps no lockout history, counts no distinct logins and sees no spray alerts, so RequestCounts.Lockouts,
+// LoginsFromIP, LoginsFromSubnet, LoginsWithPassword and SprayAlert are always zero.
+type LocalStorage struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	window    time.Duration
+	requests  map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewLocalStorage(window time.Duration) *LocalStorage {
+	return &LocalStorage{
+		window:   window,
+		now:      time.Now,
+		requests: make(map[string][]time.Time),
+	}
+}
+
+func (s *LocalStorage) SetWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.window = window
+}
+
+func (s *LocalStorage) CountAndIncrement(_ context.Context, keys RequestKeys) (RequestCounts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	return s.countAndIncrement(keys, now), nil
+}
+
+func (s *LocalStorage) CountAndIncrementBatch(_ context.Context, keys []RequestKeys) ([]RequestCounts, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	counts := make([]RequestCounts, len(keys))
+	for i, k := range keys {
+		counts[i] = s.countAndIncrement(k, now)
+	}
+
+	return counts, nil
+}
+
+func (s *LocalStorage) countAndIncrement(keys RequestKeys, now time.Time) RequestCounts {
+	var counts RequestCounts
+	counts.IP, counts.Refills.IP = s.add(ipKey(keys.Tenant, keys.IP), now, keys.Limits.IP)
+	counts.Login, counts.Refills.Login = s.add(loginKey(keys.Tenant, keys.Login), now, keys.Limits.Login)
+	counts.Password, counts.Refills.Password = s.add(passwordKey(keys.Tenant, keys.Password), now, keys.Limits.Password)
+
+	return counts
+}
+
+// add drops requests that left the window, records a new one and returns how many there were before it
+// and the refill of the bucket under limit, see Refills.
+func (s *LocalStorage) add(key string, now time.Time, limit int64) (int64, time.Duration) {
+	requests := append(s.trim(s.requests[key], now), now)
+	s.requests[key] = requests
+
+	// the limit-th newest request has to leave, like in Storage
+	i := int64(len(requests)) - limit
+	switch {
+	case limit <= 0:
+		i = 0
+	case i < 0:
+		return int64(len(requests) - 1), 0
+	}
+
+	return int64(len(requests) - 1), requests[i].Add(s.window).Sub(now)
+}
+
+func (s *LocalStorage) trim(requests []time.Time, now time.Time) []time.Time {
+	windowStart := now.Add(-s.window)
+
+	i := 0
+	for i < len(requests) && !requests[i].After(windowStart) {
+		i++
+	}
+
+	return requests[i:]
+}
+
+// sweep forgets keys without requests in the window, at most once per window.
+func (s *LocalStorage) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.window {
+		return
+	}
+	s.lastSweep = now
+
+	for key, requests := range s.requests {
+		if requests = s.trim(requests, now); len(requests) == 0 {
+			delete(s.requests, key)
+		} else {
+			s.requests[key] = requests
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func refillsIn(d time.Duration) Refills {
+	return Refills{IP: d, Login: d, Password: d}
+}
+
+func TestLocalStorageSlidingWindow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	s := NewLocalStorage(time.Minute)
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	alice := RequestKeys{IP: "10.0.0.1", Login: "alice", Password: "secret"}
+	bob := RequestKeys{IP: "10.0.0.1", Login: "bob", Password: "secret"}
+
+	if counts, _ := s.CountAndIncrement(ctx, alice); counts != (RequestCounts{Refills: refillsIn(time.Minute)}) {
+		t.Errorf("first request counts = %+v, want zeros", counts)
+	}
+
+	now = now.Add(30 * time.Second)
+	counts, _ := s.CountAndIncrementBatch(ctx, []RequestKeys{alice, bob})
+	want := []RequestCounts{
+		{IP: 1, Login: 1, Password: 1, Refills: refillsIn(30 * time.Second)},
+		{
+			IP: 2, Login: 0, Password: 2,
+			Refills: Refills{IP: 30 * time.Second, Login: time.Minute, Password: 30 * time.Second},
+		},
+	}
+	for i := range want {
+		if counts[i] != want[i] {
+			t.Errorf("batch counts[%d] = %+v, want %+v", i, counts[i], want[i])
+		}
+	}
+
+	// the first request leaves the window, the batched ones are still in it
+	now = now.Add(31 * time.Second)
+	want[0] = RequestCounts{IP: 2, Login: 1, Password: 2, Refills: refillsIn(29 * time.Second)}
+	if counts, _ := s.CountAndIncrement(ctx, alice); counts != want[0] {
+		t.Errorf("counts after the first request expired = %+v", counts)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if counts, _ := s.CountAndIncrement(ctx, bob); counts != (RequestCounts{Refills: refillsIn(time.Minute)}) {
+		t.Errorf("counts after the window passed = %+v, want zeros", counts)
+	}
+
+	if len(s.requests) != 3 {
+		t.Errorf("sweep kept %d keys, want only the 3 keys of the last request", len(s.requests))
+	}
+}
+
+func TestLocalStorageSetWindow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	s := NewLocalStorage(time.Minute)
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	alice := RequestKeys{IP: "10.0.0.1", Login: "alice", Password: "secret"}
+
+	_, _ = s.CountAndIncrement(ctx, alice)
+	now = now.Add(30 * time.Second)
+
+	s.SetWindow(10 * time.Second)
+	if counts, _ := s.CountAndIncrement(ctx, alice); counts != (RequestCounts{Refills: refillsIn(10 * time.Second)}) {
+		t.Errorf("counts after shrinking the window = %+v, want zeros", counts)
+	}
+
+	s.SetWindow(time.Minute)
+	now = now.Add(20 * time.Second)
+	want := RequestCounts{IP: 1, Login: 1, Password: 1, Refills: refillsIn(40 * time.Second)}
+	if counts, _ := s.CountAndIncrement(ctx, alice); counts != want {
+		t.Errorf("counts after growing the window = %+v, want the previous request", counts)
+	}
+}
+
+func TestLocalStorageRefillsUnderLimit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	s := NewLocalStorage(time.Minute)
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	alice := RequestKeys{IP: "10.0.0.1", Login: "alice", Password: "secret", Limits: Limits{Login: 2}}
+
+	// requests every 10s, the bucket takes another one once all but the newest left the window
+	want := []time.Duration{0, 50 * time.Second, 50 * time.Second, 50 * time.Second}
+	for i, w := range want {
+		counts, _ := s.CountAndIncrement(ctx, alice)
+		if counts.Login != int64(i) || counts.Refills.Login != w {
+			t.Errorf("request %d: login count %d, refill %v, want %d and %v", i, counts.Login, counts.Refills.Login, i, w)
+		}
+		now = now.Add(10 * time.Second)
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// sprayAlertKey is shared by all tenants, a spray alert tightens limits service-wide.
+const sprayAlertKey = keyPrefix + ":spray_alert"
+
+// SprayTracking counts distinct logins tried with every password. Its counters name passwords like
+// the password rate limit buckets do, see passwordDigest. A zero Window stops counting.
+type SprayTracking struct {
+	Window time.Duration
+}
+
+// SetSprayTracking changes how distinct logins per password are counted for subsequent calls.
+// Logins are counted in fixed windows, a count covers the current window and the previous one.
+// While tracking is on, counts also tell whether a spray alert is active.
+func (s *Storage) SetSprayTracking(tracking SprayTracking) {
+	s.spray.Store(&tracking)
+}
+
+// SprayAlert is raised when a password was tried against too many logins, see RaiseSprayAlert.
+type SprayAlert struct {
+	// Tenant is the tenant whose attempts raised the alert.
+	Tenant    string
+	RaisedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// sprayKey tags the key with the digest, like distinctKey. The digest is hex, so it never contains "}".
+func sprayKey(tenant string, slot int64, digest string) string {
+	return bucketKey(tenant, "logins_password", "{"+digest+"}|"+strconv.FormatInt(slot, 10))
+}
+
+// queueSpray counts the login for the password and reads the spray alert, the commands are nil
+// while spray tracking is off.
+func queueSpray(
+	ctx context.Context,
+	pipe redis.Pipeliner,
+	keys RequestKeys,
+	digest string,
+	now time.Time,
+	tracking *SprayTracking,
+) (logins *redis.Cmd, alert *redis.IntCmd) {
+	if tracking == nil || tracking.Window <= 0 {
+		return nil, nil
+	}
+
+	slot := now.UnixNano() / int64(tracking.Window)
+	ttl := strconv.FormatInt((2 * tracking.Window).Milliseconds(), 10)
+
+	// EVAL instead of EVALSHA, see queueDistinctLogins
+	logins = distinctScript.Eval(ctx, pipe,
+		[]string{sprayKey(keys.Tenant, slot, digest), sprayKey(keys.Tenant, slot-1, digest)},
+		keys.Login, ttl,
+	)
+
+	return logins, pipe.Exists(ctx, sprayAlertKey)
+}
+
+func alertActive(cmd *redis.IntCmd) bool {
+	return cmd != nil && cmd.Val() > 0
+}
+
+// RaiseSprayAlert raises the spray alert for ttl on behalf of tenant. An active alert is left as it is,
+// so raised tells whether this call raised it.
+func (s *Storage) RaiseSprayAlert(ctx context.Context, tenant string, ttl time.Duration) (raised bool, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "raise_spray_alert", time.Now(), &err)
+
+	value := strconv.FormatInt(time.Now().UnixNano(), 10) + "|" + tenant
+	raised, err = s.client.SetNX(ctx, sprayAlertKey, value, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to raise spray alert: %w", err)
+	}
+	return raised, nil
+}
+
+// SprayAlert returns the active spray alert, ok is false when there is none.
+func (s *Storage) SprayAlert(ctx context.Context) (_ SprayAlert, ok bool, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "spray_alert", time.Now(), &err)
+
+	pipe := s.client.Pipeline()
+	value := pipe.Get(ctx, sprayAlertKey)
+	ttl := pipe.PTTL(ctx, sprayAlertKey)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return SprayAlert{}, false, fmt.Errorf("failed to read spray alert: %w", err)
+	}
+
+	// the alert may expire between both commands
+	if value.Err() != nil || ttl.Val() <= 0 {
+		return SprayAlert{}, false, nil
+	}
+
+	raisedAt, tenant, _ := strings.Cut(value.Val(), "|")
+	nanos, err := strconv.ParseInt(raisedAt, 10, 64)
+	if err != nil {
+		return SprayAlert{}, false, fmt.Errorf("failed to parse spray alert %q: %w", value.Val(), err)
+	}
+
+	return SprayAlert{
+		Tenant:    tenant,
+		RaisedAt:  time.Unix(0, nanos),
+		ExpiresAt: time.Now().Add(ttl.Val()),
+	}, true, nil
+}
+
+// ClearSprayAlert ends the spray alert before it expires and reports whether one was active.
+func (s *Storage) ClearSprayAlert(ctx context.Context) (existed bool, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "clear_spray_alert", time.Now(), &err)
+
+	deleted, err := s.client.Del(ctx, sprayAlertKey).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to clear spray alert: %w", err)
+	}
+	return deleted > 0, nil
+}
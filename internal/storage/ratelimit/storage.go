@@ -2,114 +2,373 @@ package ratelimit
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/FluVirus2/antibruteforce/internal/tracing"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-const keyPrefix = "ratelimit"
+const (
+	// keyPrefix is not a hash tag, so in a Redis cluster buckets spread over all slots.
+	// Keys read together by one command carry a hash tag of their own, see distinctKey.
+	keyPrefix     = "ratelimit"
+	componentName = "ratelimit"
+)
+
+var tracer = otel.Tracer("github.com/FluVirus2/antibruteforce/internal/storage/ratelimit")
 
 type Storage struct {
-	client *redis.Client
-	window time.Duration
-	logger *slog.Logger
+	client         redis.UniversalClient
+	window         atomic.Int64
+	distinctWindow atomic.Int64
+	spray          atomic.Pointer[SprayTracking]
+	passwordKey    atomic.Pointer[[]byte]
+	observer       storage.CallObserver
+	logger         *slog.Logger
 }
 
-func NewStorage(client *redis.Client, window time.Duration, logger *slog.Logger) *Storage {
-	return &Storage{
-		client: client,
-		window: window,
-		logger: logger,
+func NewStorage(
+	client redis.UniversalClient,
+	window time.Duration,
+	observer storage.CallObserver,
+	logger *slog.Logger,
+) *Storage {
+	s := &Storage{
+		client:   client,
+		observer: observer,
+		logger:   logger,
 	}
+	s.window.Store(int64(window))
+
+	return s
+}
+
+// SetWindow changes the window for subsequent calls. Buckets written with a shorter window
+// may expire early, so a longer window takes full effect after the old one has passed.
+func (s *Storage) SetWindow(window time.Duration) {
+	s.window.Store(int64(window))
 }
 
+// SetPasswordKey changes the key of the HMACs that name passwords in Redis for subsequent calls, see
+// passwordDigest. Buckets and counters named under the previous key are not found anymore and expire.
+func (s *Storage) SetPasswordKey(key []byte) {
+	s.passwordKey.Store(&key)
+}
+
+// passwordDigest names a password in keys, so Redis never holds tried passwords. Without a key the HMAC
+// is a plain hash, which gives away passwords that are guessed.
+func (s *Storage) passwordDigest(password string) string {
+	var key []byte
+	if k := s.passwordKey.Load(); k != nil {
+		key = *k
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RequestKeys name the buckets of one request, buckets of different tenants never mix.
 type RequestKeys struct {
+	Tenant   string
 	IP       string
 	Login    string
 	Password string
+	// Limits are what the request is checked against, refills are read for them. AlertLimits replace them
+	// while a spray alert is active, they are ignored when zero.
+	Limits      Limits
+	AlertLimits Limits
+}
+
+// Limits are how many requests each bucket takes within the window.
+type Limits struct {
+	IP       int64
+	Login    int64
+	Password int64
 }
 
 type RequestCounts struct {
 	IP       int64
 	Login    int64
 	Password int64
+	// Lockouts are the lockouts of the IP or the login within LockoutHistory, whichever had more.
+	Lockouts int64
+	Refills  Refills
+	// LoginsFromIP and LoginsFromSubnet estimate the distinct logins tried from the IP and its subnet,
+	// this one included, see SetDistinctWindow. They are zero while distinct logins are not counted.
+	LoginsFromIP     int64
+	LoginsFromSubnet int64
+	// LoginsWithPassword estimates the distinct logins tried with the password, this one included,
+	// and SprayAlert tells whether a spray alert is active, see SetSprayTracking. Both are zero
+	// while spray tracking is off.
+	LoginsWithPassword int64
+	SprayAlert         bool
 }
 
-func (s *Storage) ipKey(ip string) string {
-	return fmt.Sprintf("%s:ip:%s", keyPrefix, ip)
+// Refills tell how long until each bucket has room for another request under its limit, counting the request
+// itself, so they are never longer than the window. They are zero while a bucket has room, a zero limit
+// reads how long until the oldest request leaves the window instead.
+type Refills struct {
+	IP       time.Duration
+	Login    time.Duration
+	Password time.Duration
 }
 
-func (s *Storage) loginKey(login string) string {
-	return fmt.Sprintf("%s:login:%s", keyPrefix, login)
+// all lists the limits in the order of the IP, login and password buckets.
+func (l Limits) all() [3]int64 {
+	return [3]int64{l.IP, l.Login, l.Password}
 }
 
-func (s *Storage) passwordKey(password string) string {
-	return fmt.Sprintf("%s:password:%s", keyPrefix, password)
+// bucketKey keeps the keys of the default tenant as they were before tenants existed.
+// Keys of other tenants start with "@", which no dimension does, so they never collide with them.
+func bucketKey(tenant, dimension, value string) string {
+	if tenant == "" {
+		return keyPrefix + ":" + dimension + ":" + value
+	}
+	return keyPrefix + ":@" + tenant + ":" + dimension + ":" + value
 }
 
-func (s *Storage) CountAndIncrement(ctx context.Context, keys RequestKeys) (RequestCounts, error) {
-	now := time.Now()
-	windowStart := now.Add(-s.window)
-	windowStartStr := fmt.Sprintf("%d", windowStart.UnixNano())
+func ipKey(tenant, ip string) string {
+	return bucketKey(tenant, "ip", ip)
+}
+
+func loginKey(tenant, login string) string {
+	return bucketKey(tenant, "login", login)
+}
+
+// passwordKey names the bucket by the digest of the password, see passwordDigest.
+func passwordKey(tenant, digest string) string {
+	return bucketKey(tenant, "password", digest)
+}
+
+type countCmds struct {
+	ip       *redis.IntCmd
+	login    *redis.IntCmd
+	password *redis.IntCmd
+	// lockouts of the IP and the login, MGET of a single key since GET fails the pipeline on a missing key
+	lockouts []*redis.SliceCmd
+
+	member       string
+	refills      [3]*redis.ZSliceCmd
+	alertRefills [3]*redis.ZSliceCmd
+
+	ipLogins     *distinctCmd
+	subnetLogins *distinctCmd
+
+	passwordLogins *redis.Cmd
+	sprayAlert     *redis.IntCmd
+}
+
+func (c countCmds) counts(now time.Time, window time.Duration) RequestCounts {
+	counts := RequestCounts{
+		IP:       c.ip.Val(),
+		Login:    c.login.Val(),
+		Password: c.password.Val(),
+		Refills: Refills{
+			IP:       refill(c.refills[0], c.member, now, window),
+			Login:    refill(c.refills[1], c.member, now, window),
+			Password: refill(c.refills[2], c.member, now, window),
+		},
+		LoginsFromIP:       c.ipLogins.count(),
+		LoginsFromSubnet:   c.subnetLogins.count(),
+		LoginsWithPassword: distinctCount(c.passwordLogins),
+		SprayAlert:         alertActive(c.sprayAlert),
+	}
+
+	// missing lockout counters are nil, counters written by RecordLockouts are decimal strings
+	for _, cmd := range c.lockouts {
+		str, _ := cmd.Val()[0].(string)
+		if n, err := strconv.ParseInt(str, 10, 64); err == nil && n > counts.Lockouts {
+			counts.Lockouts = n
+		}
+	}
+
+	if counts.SprayAlert && c.alertRefills[0] != nil {
+		counts.Refills = Refills{
+			IP:       refill(c.alertRefills[0], c.member, now, window),
+			Login:    refill(c.alertRefills[1], c.member, now, window),
+			Password: refill(c.alertRefills[2], c.member, now, window),
+		}
+	}
+
+	return counts
+}
+
+// queueRefill reads the request that has to leave the window before the bucket takes another one.
+// With the request itself added, that is the limit-th newest one: all but limit-1 requests have to leave.
+func queueRefill(ctx context.Context, pipe redis.Pipeliner, key string, limit int64) *redis.ZSliceCmd {
+	index := -limit
+	if limit <= 0 {
+		index = 0
+	}
+	return pipe.ZRangeWithScores(ctx, key, index, index)
+}
+
+// refill is zero when the bucket holds fewer requests than its limit, so none has to leave. The request itself
+// leaves after exactly the window, its float score is not precise enough to tell.
+func refill(cmd *redis.ZSliceCmd, member string, now time.Time, window time.Duration) time.Duration {
+	z := cmd.Val()
+	switch {
+	case len(z) == 0:
+		return 0
+	case z[0].Member == member:
+		return window
+	}
+	return max(time.Unix(0, int64(z[0].Score)).Add(window).Sub(now), 0)
+}
+
+func (s *Storage) queueCountAndIncrement(
+	ctx context.Context,
+	pipe redis.Pipeliner,
+	keys RequestKeys,
+	now time.Time,
+	window time.Duration,
+	member string,
+) countCmds {
+	windowStartStr := fmt.Sprintf("%d", now.Add(-window).UnixNano())
 	score := float64(now.UnixNano())
+
+	ip := ipKey(keys.Tenant, keys.IP)
+	login := loginKey(keys.Tenant, keys.Login)
+	digest := s.passwordDigest(keys.Password)
+	password := passwordKey(keys.Tenant, digest)
+
+	pipe.ZRemRangeByScore(ctx, ip, "0", windowStartStr)
+	pipe.ZRemRangeByScore(ctx, login, "0", windowStartStr)
+	pipe.ZRemRangeByScore(ctx, password, "0", windowStartStr)
+
+	cmds := countCmds{
+		member:   member,
+		ip:       pipe.ZCard(ctx, ip),
+		login:    pipe.ZCard(ctx, login),
+		password: pipe.ZCard(ctx, password),
+		lockouts: []*redis.SliceCmd{
+			pipe.MGet(ctx, lockoutKey(keys.Tenant, "ip", keys.IP)),
+			pipe.MGet(ctx, lockoutKey(keys.Tenant, "login", keys.Login)),
+		},
+	}
+
+	cmds.ipLogins, cmds.subnetLogins = queueDistinctLogins(ctx, pipe, keys, now, time.Duration(s.distinctWindow.Load()))
+	cmds.passwordLogins, cmds.sprayAlert = queueSpray(ctx, pipe, keys, digest, now, s.spray.Load())
+
+	pipe.ZAdd(ctx, ip, redis.Z{Score: score, Member: member})
+	pipe.ZAdd(ctx, login, redis.Z{Score: score, Member: member})
+	pipe.ZAdd(ctx, password, redis.Z{Score: score, Member: member})
+
+	pipe.Expire(ctx, ip, window+time.Second)
+	pipe.Expire(ctx, login, window+time.Second)
+	pipe.Expire(ctx, password, window+time.Second)
+
+	buckets := [3]string{ip, login, password}
+	for i, limit := range keys.Limits.all() {
+		cmds.refills[i] = queueRefill(ctx, pipe, buckets[i], limit)
+	}
+	if cmds.sprayAlert != nil && keys.AlertLimits != (Limits{}) && keys.AlertLimits != keys.Limits {
+		for i, limit := range keys.AlertLimits.all() {
+			cmds.alertRefills[i] = queueRefill(ctx, pipe, buckets[i], limit)
+		}
+	}
+
+	return cmds
+}
+
+func (s *Storage) CountAndIncrement(ctx context.Context, keys RequestKeys) (_ RequestCounts, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "count_and_increment", time.Now(), &err)
+
+	ctx, span := tracer.Start(ctx, "ratelimit.Storage/CountAndIncrement")
+	defer tracing.EndSpan(span, &err)
+
+	now := time.Now()
 	member := fmt.Sprintf("%d", now.UnixNano())
 
-	ipKey := s.ipKey(keys.IP)
-	loginKey := s.loginKey(keys.Login)
-	passwordKey := s.passwordKey(keys.Password)
+	window := time.Duration(s.window.Load())
 
 	pipe := s.client.Pipeline()
+	cmds := s.queueCountAndIncrement(ctx, pipe, keys, now, window, member)
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return RequestCounts{}, fmt.Errorf("failed to count and record requests: %w", err)
+	}
+	s.rankSources(ctx, []countCmds{cmds})
 
-	pipe.ZRemRangeByScore(ctx, ipKey, "0", windowStartStr)
-	pipe.ZRemRangeByScore(ctx, loginKey, "0", windowStartStr)
-	pipe.ZRemRangeByScore(ctx, passwordKey, "0", windowStartStr)
+	return cmds.counts(now, window), nil
+}
 
-	ipCountCmd := pipe.ZCard(ctx, ipKey)
-	loginCountCmd := pipe.ZCard(ctx, loginKey)
-	passwordCountCmd := pipe.ZCard(ctx, passwordKey)
+// CountAndIncrementBatch does the same as CountAndIncrement for every item using a single pipeline.
+// Items are applied in order, so an item observes the increments of all previous items in the batch.
+func (s *Storage) CountAndIncrementBatch(ctx context.Context, keys []RequestKeys) (_ []RequestCounts, err error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
 
-	pipe.ZAdd(ctx, ipKey, redis.Z{Score: score, Member: member})
-	pipe.ZAdd(ctx, loginKey, redis.Z{Score: score, Member: member})
-	pipe.ZAdd(ctx, passwordKey, redis.Z{Score: score, Member: member})
+	defer storage.ObserveCall(s.observer, componentName, "count_and_increment_batch", time.Now(), &err)
 
-	pipe.Expire(ctx, ipKey, s.window+time.Second)
-	pipe.Expire(ctx, loginKey, s.window+time.Second)
-	pipe.Expire(ctx, passwordKey, s.window+time.Second)
+	ctx, span := tracer.Start(ctx, "ratelimit.Storage/CountAndIncrementBatch")
+	span.SetAttributes(attribute.Int("abf.batch.size", len(keys)))
+	defer tracing.EndSpan(span, &err)
+
+	now := time.Now()
+	window := time.Duration(s.window.Load())
 
-	_, err := pipe.Exec(ctx)
+	pipe := s.client.Pipeline()
+	cmds := make([]countCmds, len(keys))
+	for i, k := range keys {
+		member := fmt.Sprintf("%d-%d", now.UnixNano(), i)
+		cmds[i] = s.queueCountAndIncrement(ctx, pipe, k, now, window, member)
+	}
+
+	_, err = pipe.Exec(ctx)
 	if err != nil {
-		return RequestCounts{}, fmt.Errorf("failed to count and record requests: %w", err)
+		return nil, fmt.Errorf("failed to count and record %d batched requests: %w", len(keys), err)
 	}
+	s.rankSources(ctx, cmds)
 
-	return RequestCounts{
-		IP:       ipCountCmd.Val(),
-		Login:    loginCountCmd.Val(),
-		Password: passwordCountCmd.Val(),
-	}, nil
+	counts := make([]RequestCounts, len(cmds))
+	for i, c := range cmds {
+		counts[i] = c.counts(now, window)
+	}
+
+	return counts, nil
 }
 
-func (s *Storage) ResetByIP(ctx context.Context, ip string) error {
-	err := s.client.Del(ctx, s.ipKey(ip)).Err()
+// ResetByIP, ResetByLogin and ResetByPassword report whether the tenant had a bucket to reset.
+func (s *Storage) ResetByIP(ctx context.Context, tenant, ip string) (existed bool, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "reset_by_ip", time.Now(), &err)
+
+	deleted, err := s.client.Del(ctx, ipKey(tenant, ip)).Result()
 	if err != nil {
-		return fmt.Errorf("failed to reset IP rate limit: %w", err)
+		return false, fmt.Errorf("failed to reset IP rate limit: %w", err)
 	}
-	return nil
+	return deleted > 0, nil
 }
 
-func (s *Storage) ResetByLogin(ctx context.Context, login string) error {
-	err := s.client.Del(ctx, s.loginKey(login)).Err()
+func (s *Storage) ResetByLogin(ctx context.Context, tenant, login string) (existed bool, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "reset_by_login", time.Now(), &err)
+
+	deleted, err := s.client.Del(ctx, loginKey(tenant, login)).Result()
 	if err != nil {
-		return fmt.Errorf("failed to reset login rate limit: %w", err)
+		return false, fmt.Errorf("failed to reset login rate limit: %w", err)
 	}
-	return nil
+	return deleted > 0, nil
 }
 
-func (s *Storage) ResetByPassword(ctx context.Context, password string) error {
-	err := s.client.Del(ctx, s.passwordKey(password)).Err()
+func (s *Storage) ResetByPassword(ctx context.Context, tenant, password string) (existed bool, err error) {
+	defer storage.ObserveCall(s.observer, componentName, "reset_by_password", time.Now(), &err)
+
+	deleted, err := s.client.Del(ctx, passwordKey(tenant, s.passwordDigest(password))).Result()
 	if err != nil {
-		return fmt.Errorf("failed to reset password rate limit: %w", err)
+		return false, fmt.Errorf("failed to reset password rate limit: %w", err)
 	}
-	return nil
+	return deleted > 0, nil
 }
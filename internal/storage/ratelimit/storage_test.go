@@ -0,0 +1,425 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestStorageInRedisCluster(t *testing.T) {
+	t.Parallel()
+
+	// the slots are split between two nodes, so a command over keys of both nodes would miss some of them
+	nodes := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t)}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: nodes[0].Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: nodes[1].Addr()}}},
+			}, nil
+		},
+	})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewStorage(client, time.Minute, storage.NopCallObserver{}, logger)
+	ctx := context.Background()
+
+	s.SetDistinctWindow(10 * time.Minute)
+	s.SetPasswordKey([]byte("0123456789abcdef"))
+	s.SetSprayTracking(SprayTracking{Window: time.Hour})
+	if err := s.RecordLockouts(ctx, []AttemptKey{{Login: "bob", IP: "10.0.0.9"}}); err != nil {
+		t.Fatalf("RecordLockouts() error = %v", err)
+	}
+
+	alice := RequestKeys{IP: "10.0.0.1", Login: "alice", Password: "secret"}
+	bob := RequestKeys{IP: "10.0.0.2", Login: "bob", Password: "secret"}
+
+	if _, err := s.CountAndIncrement(ctx, alice); err != nil {
+		t.Fatalf("CountAndIncrement() error = %v", err)
+	}
+
+	counts, err := s.CountAndIncrementBatch(ctx, []RequestKeys{alice, bob})
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	want := []RequestCounts{
+		{IP: 1, Login: 1, Password: 1, LoginsFromIP: 1, LoginsFromSubnet: 1, LoginsWithPassword: 1},
+		{IP: 0, Login: 0, Password: 2, Lockouts: 1, LoginsFromIP: 1, LoginsFromSubnet: 2, LoginsWithPassword: 2},
+	}
+	for i := range want {
+		refills := counts[i].Refills
+		counts[i].Refills = Refills{}
+		if counts[i] != want[i] {
+			t.Errorf("batch counts[%d] = %+v, want %+v", i, counts[i], want[i])
+		}
+		if refills.IP <= 0 || refills.IP > time.Minute || refills.Password >= time.Minute {
+			t.Errorf("batch refills[%d] = %+v, want the earlier requests within the window", i, refills)
+		}
+	}
+
+	sources, err := s.TopStuffingSources(ctx, "", 1)
+	wantSources := []StuffingSource{{Kind: SourceSubnet, Source: "10.0.0.0/24", DistinctLogins: 2}}
+	if err != nil || !slices.Equal(sources, wantSources) {
+		t.Errorf("TopStuffingSources() = %+v, %v, want %+v", sources, err, wantSources)
+	}
+
+	keys := [][]string{nodes[0].Keys(), nodes[1].Keys()}
+	if len(keys[0]) == 0 || len(keys[1]) == 0 {
+		t.Errorf("nodes hold %v and %v, want counters on both", keys[0], keys[1])
+	}
+
+	if existed, err := s.ResetByLogin(ctx, "", "alice"); err != nil || !existed {
+		t.Errorf("ResetByLogin() = %v, %v, want the bucket reset", existed, err)
+	}
+}
+
+func TestStorageRefillsUnderLimit(t *testing.T) {
+	t.Parallel()
+
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewStorage(client, time.Minute, storage.NopCallObserver{}, logger)
+	s.SetPasswordKey([]byte("0123456789abcdef"))
+	s.SetSprayTracking(SprayTracking{Window: time.Hour})
+	ctx := context.Background()
+
+	alice := RequestKeys{
+		IP: "10.0.0.1", Login: "alice", Password: "secret",
+		Limits: Limits{Login: 2}, AlertLimits: Limits{Login: 1},
+	}
+
+	// the fourth request is over the limit, the third one has to leave before the bucket takes another
+	var sent []time.Time
+	var counts RequestCounts
+	for range 4 {
+		sent = append(sent, time.Now())
+		time.Sleep(20 * time.Millisecond)
+
+		var err error
+		if counts, err = s.CountAndIncrement(ctx, alice); err != nil {
+			t.Fatalf("CountAndIncrement() error = %v", err)
+		}
+	}
+	done := time.Now()
+
+	earliest, latest := sent[2].Add(time.Minute).Sub(done), sent[3].Add(time.Minute).Sub(sent[3])
+	if counts.Login != 3 || counts.Refills.Login < earliest || counts.Refills.Login > latest {
+		t.Errorf("login count %d, refill %v, want 3 and between %v and %v",
+			counts.Login, counts.Refills.Login, earliest, latest)
+	}
+
+	// during a spray alert only the request itself fits
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.RaiseSprayAlert(ctx, "", time.Hour); err != nil {
+		t.Fatalf("RaiseSprayAlert() error = %v", err)
+	}
+	before := time.Now()
+	counts, err := s.CountAndIncrement(ctx, alice)
+	if err != nil {
+		t.Fatalf("CountAndIncrement() error = %v", err)
+	}
+	if earliest := time.Minute - time.Since(before); counts.Refills.Login < earliest {
+		t.Errorf("refill during a spray alert = %v, want at least %v", counts.Refills.Login, earliest)
+	}
+}
+
+func TestStorageSeparatesTenants(t *testing.T) {
+	t.Parallel()
+
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewStorage(client, time.Minute, storage.NopCallObserver{}, logger)
+	ctx := context.Background()
+
+	admin := RequestKeys{IP: "10.0.0.1", Login: "admin", Password: "secret"}
+	acmeAdmin := admin
+	acmeAdmin.Tenant = "acme"
+
+	counts, err := s.CountAndIncrementBatch(ctx, []RequestKeys{admin, admin, acmeAdmin})
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	if counts[2] != (RequestCounts{Refills: Refills{IP: time.Minute, Login: time.Minute, Password: time.Minute}}) {
+		t.Errorf("counts of another tenant = %+v, want buckets of its own", counts[2])
+	}
+
+	if existed, err := s.ResetByLogin(ctx, "acme", "admin"); err != nil || !existed {
+		t.Errorf("ResetByLogin() = %v, %v, want the tenant bucket reset", existed, err)
+	}
+	counts, err = s.CountAndIncrementBatch(ctx, []RequestKeys{admin, acmeAdmin})
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	if counts[0].Login != 2 || counts[1].Login != 0 {
+		t.Errorf("login counts after a tenant reset = %d and %d, want 2 and 0", counts[0].Login, counts[1].Login)
+	}
+}
+
+func TestStorageAllowances(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewStorage(client, time.Minute, storage.NopCallObserver{}, logger)
+	ctx := context.Background()
+
+	alice := AttemptKey{Login: "alice", IP: "10.0.0.1"}
+	otherIP := AttemptKey{Login: "alice", IP: "10.0.0.2"}
+	otherTenant := AttemptKey{Tenant: "acme", Login: "alice", IP: "10.0.0.1"}
+
+	if err := s.GrantAllowance(ctx, alice, 10*time.Minute); err != nil {
+		t.Fatalf("GrantAllowance() error = %v", err)
+	}
+
+	granted, err := s.HasAllowances(ctx, []AttemptKey{alice, otherIP, otherTenant})
+	if err != nil {
+		t.Fatalf("HasAllowances() error = %v", err)
+	}
+	if !granted[0] || granted[1] || granted[2] {
+		t.Errorf("HasAllowances() = %v, want only the granted login and IP", granted)
+	}
+
+	mr.FastForward(11 * time.Minute)
+	granted, err = s.HasAllowances(ctx, []AttemptKey{alice})
+	if err != nil {
+		t.Fatalf("HasAllowances() error = %v", err)
+	}
+	if granted[0] {
+		t.Error("HasAllowances() = true after the allowance expired")
+	}
+}
+
+func TestStorageLockouts(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewStorage(client, time.Minute, storage.NopCallObserver{}, logger)
+	ctx := context.Background()
+
+	alice := AttemptKey{Login: "alice", IP: "10.0.0.1"}
+	aliceElsewhere := AttemptKey{Login: "alice", IP: "10.0.0.2"}
+	if err := s.RecordLockouts(ctx, []AttemptKey{alice, aliceElsewhere}); err != nil {
+		t.Fatalf("RecordLockouts() error = %v", err)
+	}
+
+	counts, err := s.CountAndIncrementBatch(ctx, []RequestKeys{
+		{IP: "10.0.0.1", Login: "bob", Password: "secret"},
+		{IP: "10.0.0.3", Login: "alice", Password: "secret"},
+		{Tenant: "acme", IP: "10.0.0.1", Login: "alice", Password: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	for i, want := range []int64{1, 2, 0} {
+		if counts[i].Lockouts != want {
+			t.Errorf("counts[%d].Lockouts = %d, want %d", i, counts[i].Lockouts, want)
+		}
+	}
+
+	mr.FastForward(LockoutHistory + time.Minute)
+	counts, err = s.CountAndIncrementBatch(ctx, []RequestKeys{{IP: "10.0.0.3", Login: "alice", Password: "secret"}})
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	if counts[0].Lockouts != 0 {
+		t.Errorf("Lockouts = %d after the history expired, want 0", counts[0].Lockouts)
+	}
+}
+
+func TestStorageDistinctLogins(t *testing.T) {
+	t.Parallel()
+
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewStorage(client, time.Minute, storage.NopCallObserver{}, logger)
+	ctx := context.Background()
+
+	attempt := func(ip, login string) RequestKeys {
+		return RequestKeys{IP: ip, Login: login, Password: "secret"}
+	}
+	attempts := []RequestKeys{
+		attempt("10.0.0.1", "alice"),
+		attempt("10.0.0.1", "bob"),
+		attempt("10.0.0.1", "alice"),
+		attempt("10.0.0.2", "carol"),
+	}
+
+	counts, err := s.CountAndIncrementBatch(ctx, attempts)
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	if counts[3].LoginsFromIP != 0 || counts[3].LoginsFromSubnet != 0 {
+		t.Errorf("counts without a distinct window = %+v, want no distinct logins", counts[3])
+	}
+	if sources, err := s.TopStuffingSources(ctx, "", 10); err != nil || len(sources) != 0 {
+		t.Errorf("TopStuffingSources() without a distinct window = %v, %v, want none", sources, err)
+	}
+
+	s.SetDistinctWindow(10 * time.Minute)
+	counts, err = s.CountAndIncrementBatch(ctx, attempts)
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	want := [][2]int64{{1, 1}, {2, 2}, {2, 2}, {1, 3}}
+	for i, w := range want {
+		if got := [2]int64{counts[i].LoginsFromIP, counts[i].LoginsFromSubnet}; got != w {
+			t.Errorf("counts[%d] distinct logins from the IP and the subnet = %v, want %v", i, got, w)
+		}
+	}
+
+	sources, err := s.TopStuffingSources(ctx, "", 2)
+	if err != nil {
+		t.Fatalf("TopStuffingSources() error = %v", err)
+	}
+	wantSources := []StuffingSource{
+		{Kind: SourceSubnet, Source: "10.0.0.0/24", DistinctLogins: 3},
+		{Kind: SourceIP, Source: "10.0.0.1", DistinctLogins: 2},
+	}
+	if !slices.Equal(sources, wantSources) {
+		t.Errorf("TopStuffingSources() = %+v, want %+v", sources, wantSources)
+	}
+
+	if sources, err := s.TopStuffingSources(ctx, "acme", 10); err != nil || len(sources) != 0 {
+		t.Errorf("TopStuffingSources() of another tenant = %v, %v, want none", sources, err)
+	}
+}
+
+func TestStorageNamesPasswordsByDigest(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewStorage(client, time.Minute, storage.NopCallObserver{}, logger)
+	ctx := context.Background()
+
+	s.SetPasswordKey([]byte("0123456789abcdef"))
+	s.SetSprayTracking(SprayTracking{Window: time.Hour})
+	passwords := []string{"Summer2026!", "hunter2"}
+	for _, password := range passwords {
+		keys := []RequestKeys{
+			{IP: "10.0.0.1", Login: "alice", Password: password},
+			{Tenant: "acme", IP: "10.0.0.1", Login: "alice", Password: password},
+		}
+		if _, err := s.CountAndIncrementBatch(ctx, keys); err != nil {
+			t.Fatalf("CountAndIncrementBatch() error = %v", err)
+		}
+	}
+
+	for _, key := range mr.Keys() {
+		for _, password := range passwords {
+			if strings.Contains(key, password) {
+				t.Errorf("key %q holds the plain password %q", key, password)
+			}
+		}
+	}
+
+	if existed, err := s.ResetByPassword(ctx, "acme", "hunter2"); err != nil || !existed {
+		t.Errorf("ResetByPassword() = %v, %v, want the bucket of the password reset", existed, err)
+	}
+
+	// buckets named under another key are not found
+	s.SetPasswordKey([]byte("fedcba9876543210"))
+	if existed, err := s.ResetByPassword(ctx, "", "hunter2"); err != nil || existed {
+		t.Errorf("ResetByPassword() under another key = %v, %v, want no bucket", existed, err)
+	}
+}
+
+//nolint:funlen
+func TestStorageSprayTracking(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewStorage(client, time.Minute, storage.NopCallObserver{}, logger)
+	ctx := context.Background()
+
+	attempts := []RequestKeys{
+		{IP: "10.0.0.1", Login: "alice", Password: "Summer2026!"},
+		{IP: "10.0.0.2", Login: "bob", Password: "Summer2026!"},
+		{IP: "10.0.0.3", Login: "alice", Password: "Summer2026!"},
+		{IP: "10.0.0.4", Login: "carol", Password: "hunter2"},
+	}
+
+	counts, err := s.CountAndIncrementBatch(ctx, attempts)
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	if counts[2].LoginsWithPassword != 0 {
+		t.Errorf("counts without spray tracking = %+v, want no distinct logins", counts[2])
+	}
+
+	s.SetPasswordKey([]byte("0123456789abcdef"))
+	s.SetSprayTracking(SprayTracking{Window: time.Hour})
+	counts, err = s.CountAndIncrementBatch(ctx, attempts)
+	if err != nil {
+		t.Fatalf("CountAndIncrementBatch() error = %v", err)
+	}
+	for i, want := range []int64{1, 2, 2, 1} {
+		if counts[i].LoginsWithPassword != want || counts[i].SprayAlert {
+			t.Errorf("counts[%d] = %d distinct logins, alert %v, want %d without an alert",
+				i, counts[i].LoginsWithPassword, counts[i].SprayAlert, want)
+		}
+	}
+
+	if _, ok, err := s.SprayAlert(ctx); err != nil || ok {
+		t.Errorf("SprayAlert() before raising = %v, %v, want none", ok, err)
+	}
+
+	for _, want := range []bool{true, false} {
+		raised, err := s.RaiseSprayAlert(ctx, "acme", time.Hour)
+		if err != nil || raised != want {
+			t.Errorf("RaiseSprayAlert() = %v, %v, want %v", raised, err, want)
+		}
+	}
+
+	alert, ok, err := s.SprayAlert(ctx)
+	if err != nil || !ok || alert.Tenant != "acme" {
+		t.Fatalf("SprayAlert() = %+v, %v, %v, want the alert raised by acme", alert, ok, err)
+	}
+	if until := time.Until(alert.ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("alert expires in %v, want an hour", until)
+	}
+
+	count, err := s.CountAndIncrement(ctx, attempts[3])
+	if err != nil {
+		t.Fatalf("CountAndIncrement() error = %v", err)
+	}
+	if !count.SprayAlert {
+		t.Errorf("counts during an alert = %+v, want the alert", count)
+	}
+
+	for _, want := range []bool{true, false} {
+		existed, err := s.ClearSprayAlert(ctx)
+		if err != nil || existed != want {
+			t.Errorf("ClearSprayAlert() = %v, %v, want %v", existed, err, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisMode string
+
+const (
+	RedisModeSingle   RedisMode = "single"
+	RedisModeSentinel RedisMode = "sentinel"
+	RedisModeCluster  RedisMode = "cluster"
+)
+
+var (
+	ErrUnknownRedisMode     = errors.New("unknown redis mode")
+	ErrNoSentinelMasterName = errors.New("sentinel master name is required")
+	ErrClusterDatabase      = errors.New("redis cluster supports only database 0")
+)
+
+func ParseRedisMode(s string) (RedisMode, error) {
+	switch mode := RedisMode(s); mode {
+	case RedisModeSingle, RedisModeSentinel, RedisModeCluster:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownRedisMode, s)
+	}
+}
+
+type RedisConfig struct {
+	Mode RedisMode
+	// URL is a redis:// or rediss:// URL. It sets credentials, the database, TLS and pool options
+	// in every mode. Its address is the server, the first sentinel or the first cluster seed.
+	URL string
+	// Addrs are the other sentinels or cluster seeds, unused by a single server.
+	Addrs []string
+	// MasterName is the name of the master monitored by the sentinels.
+	MasterName       string
+	SentinelPassword string
+}
+
+// NewRedisClient creates a client for the configured deployment. Context timeouts are always enabled,
+// so that Guard deadlines are honored.
+func NewRedisClient(config RedisConfig) (redis.UniversalClient, error) {
+	opts, err := redis.ParseURL(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis url: %w", err)
+	}
+	opts.ContextTimeoutEnabled = true
+
+	universal := &redis.UniversalOptions{
+		Addrs:                 append([]string{opts.Addr}, config.Addrs...),
+		ClientName:            opts.ClientName,
+		DB:                    opts.DB,
+		Protocol:              opts.Protocol,
+		Username:              opts.Username,
+		Password:              opts.Password,
+		SentinelPassword:      config.SentinelPassword,
+		MaxRetries:            opts.MaxRetries,
+		MinRetryBackoff:       opts.MinRetryBackoff,
+		MaxRetryBackoff:       opts.MaxRetryBackoff,
+		DialTimeout:           opts.DialTimeout,
+		ReadTimeout:           opts.ReadTimeout,
+		WriteTimeout:          opts.WriteTimeout,
+		ContextTimeoutEnabled: opts.ContextTimeoutEnabled,
+		PoolFIFO:              opts.PoolFIFO,
+		PoolSize:              opts.PoolSize,
+		PoolTimeout:           opts.PoolTimeout,
+		MinIdleConns:          opts.MinIdleConns,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxActiveConns:        opts.MaxActiveConns,
+		ConnMaxIdleTime:       opts.ConnMaxIdleTime,
+		ConnMaxLifetime:       opts.ConnMaxLifetime,
+		TLSConfig:             opts.TLSConfig,
+		MasterName:            config.MasterName,
+	}
+
+	switch config.Mode {
+	case RedisModeSingle, "":
+		return redis.NewClient(opts), nil
+	case RedisModeSentinel:
+		if config.MasterName == "" {
+			return nil, ErrNoSentinelMasterName
+		}
+		return redis.NewFailoverClient(universal.Failover()), nil
+	case RedisModeCluster:
+		if opts.DB != 0 {
+			return nil, ErrClusterDatabase
+		}
+		return redis.NewClusterClient(universal.Cluster()), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRedisMode, config.Mode)
+	}
+}
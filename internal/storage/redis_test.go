@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+// runSentinel starts a sentinel that reports master as the master called name.
+func runSentinel(t *testing.T, name string, master *miniredis.Miniredis) *miniredis.Miniredis {
+	t.Helper()
+
+	sentinel := miniredis.RunT(t)
+	host, port, err := net.SplitHostPort(master.Addr())
+	if err != nil {
+		t.Fatalf("failed to split master address: %v", err)
+	}
+
+	err = sentinel.Server().Register("SENTINEL", func(c *server.Peer, _ string, args []string) {
+		switch {
+		case len(args) == 2 && strings.EqualFold(args[0], "get-master-addr-by-name") && args[1] == name:
+			c.WriteStrings([]string{host, port})
+		case len(args) == 2 && strings.EqualFold(args[0], "get-master-addr-by-name"):
+			c.WriteNull()
+		case len(args) == 2 && strings.EqualFold(args[0], "sentinels"):
+			c.WriteLen(0)
+		default:
+			c.WriteError("ERR unsupported SENTINEL subcommand")
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to register SENTINEL: %v", err)
+	}
+
+	return sentinel
+}
+
+func TestNewRedisClient(t *testing.T) {
+	t.Parallel()
+
+	master := miniredis.RunT(t)
+	sentinel := runSentinel(t, "abf", master)
+	unreachable := "127.0.0.1:1"
+
+	tests := []struct {
+		name   string
+		config RedisConfig
+	}{
+		{
+			name:   "single",
+			config: RedisConfig{Mode: RedisModeSingle, URL: "redis://" + master.Addr()},
+		},
+		{
+			name: "sentinel behind an unreachable one",
+			config: RedisConfig{
+				Mode:       RedisModeSentinel,
+				URL:        "redis://" + unreachable,
+				Addrs:      []string{sentinel.Addr()},
+				MasterName: "abf",
+			},
+		},
+		{
+			name:   "cluster",
+			config: RedisConfig{Mode: RedisModeCluster, URL: "redis://" + master.Addr()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewRedisClient(tt.config)
+			if err != nil {
+				t.Fatalf("NewRedisClient() error = %v", err)
+			}
+			defer client.Close()
+
+			key := "{test}:" + tt.name
+			if err := client.Set(context.Background(), key, "value", 0).Err(); err != nil {
+				t.Fatalf("set through %s client: %v", tt.config.Mode, err)
+			}
+			if got, _ := master.Get(key); got != "value" {
+				t.Errorf("master has %q = %q, want the value set through the client", key, got)
+			}
+		})
+	}
+}
+
+func TestNewRedisClientRejectsConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		config  RedisConfig
+		wantErr error
+	}{
+		{
+			name:    "sentinel without master name",
+			config:  RedisConfig{Mode: RedisModeSentinel, URL: "redis://localhost:26379"},
+			wantErr: ErrNoSentinelMasterName,
+		},
+		{
+			name:    "cluster with database",
+			config:  RedisConfig{Mode: RedisModeCluster, URL: "redis://localhost:6379/2"},
+			wantErr: ErrClusterDatabase,
+		},
+		{
+			name:    "unknown mode",
+			config:  RedisConfig{Mode: "ring", URL: "redis://localhost:6379"},
+			wantErr: ErrUnknownRedisMode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewRedisClient(tt.config)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewRedisClient() error = %v, want %v", err, tt.wantErr)
+			}
+			if client != nil {
+				_ = client.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package settings
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+)
+
+const honeypotLoginsName = "honeypot_logins"
+
+// ListHoneypotLogins returns the logins no real user has, sorted.
+func (r *Repository) ListHoneypotLogins(ctx context.Context) (_ []string, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "list_honeypot_logins", time.Now(), &err)
+
+	rows, err := storage.QuerierFromContext(ctx, r.pool).Query(ctx,
+		`SELECT login FROM honeypot_logins ORDER BY login`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", honeypotLoginsName, err)
+	}
+	defer rows.Close()
+
+	var logins []string
+	for rows.Next() {
+		var login string
+		if err := rows.Scan(&login); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", honeypotLoginsName, err)
+		}
+		logins = append(logins, login)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s: %w", honeypotLoginsName, err)
+	}
+
+	return logins, nil
+}
+
+// AddHoneypotLogin reports false when the login was already a honeypot, otherwise it notifies NotifyChannel.
+// It must run in a transaction, so that the notification is committed together with the change.
+func (r *Repository) AddHoneypotLogin(ctx context.Context, actor, login string) (added bool, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "add_honeypot_login", time.Now(), &err)
+
+	cmdTag, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`INSERT INTO honeypot_logins (login, updated_by) VALUES ($1, $2) ON CONFLICT (login) DO NOTHING`,
+		login, actor)
+	if err != nil {
+		return false, fmt.Errorf("failed to add honeypot login: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	return true, r.notify(ctx, honeypotLoginsName)
+}
+
+// RemoveHoneypotLogin reports false when the login was not a honeypot. Like AddHoneypotLogin it must run
+// in a transaction.
+func (r *Repository) RemoveHoneypotLogin(ctx context.Context, login string) (removed bool, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "remove_honeypot_login", time.Now(), &err)
+
+	cmdTag, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`DELETE FROM honeypot_logins WHERE login = $1`, login)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove honeypot login: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	return true, r.notify(ctx, honeypotLoginsName)
+}
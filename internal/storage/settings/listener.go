@@ -0,0 +1,78 @@
+package settings
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const DefaultRetryInterval = 5 * time.Second
+
+// Listener follows setting changes made by any replica through Postgres notifications.
+type Listener struct {
+	pool          *pgxpool.Pool
+	retryInterval time.Duration
+	logger        *slog.Logger
+}
+
+// NewListener takes the bare pool, the listening connection is held for as long as Run runs
+// and would never fit a call timeout.
+func NewListener(pool *pgxpool.Pool, retryInterval time.Duration, logger *slog.Logger) *Listener {
+	return &Listener{
+		pool:          pool,
+		retryInterval: retryInterval,
+		logger:        logger,
+	}
+}
+
+// Run calls onChange with the name of every changed setting until ctx is done.
+// Notifications sent while the connection is down are lost, so onChange is also called
+// with an empty name after each connect to reload everything.
+func (l *Listener) Run(ctx context.Context, onChange func(ctx context.Context, name string)) {
+	for {
+		err := l.listen(ctx, onChange)
+		if ctx.Err() != nil {
+			return
+		}
+
+		l.logger.Warn("lost settings notifications, reconnecting", "error", err, "retry_in", l.retryInterval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context, onChange func(ctx context.Context, name string)) error {
+	pooled, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	// a listening connection must not go back to the pool
+	conn := pooled.Hijack()
+	defer func() {
+		_ = conn.Close(context.WithoutCancel(ctx))
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{NotifyChannel}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", NotifyChannel, err)
+	}
+
+	onChange(ctx, "")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+
+		onChange(ctx, notification.Payload)
+	}
+}
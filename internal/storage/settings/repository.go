@@ -0,0 +1,232 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+const componentName = "settings_repository"
+
+// NotifyChannel is notified with the setting name whenever a setting changes.
+const NotifyChannel = "abf_settings"
+
+const limitsName = "limits"
+
+var ErrHistoryEntryNotFound = errors.New("settings history entry not found")
+
+// Limits override the configured rate limits, zero fields are not overridden.
+type Limits struct {
+	Login    int64 `json:"login,omitempty"`
+	Password int64 `json:"password,omitempty"`
+	IP       int64 `json:"ip,omitempty"`
+}
+
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Merge returns l with the positive fields of overrides replacing its own.
+func (l Limits) Merge(overrides Limits) Limits {
+	if overrides.Login > 0 {
+		l.Login = overrides.Login
+	}
+	if overrides.Password > 0 {
+		l.Password = overrides.Password
+	}
+	if overrides.IP > 0 {
+		l.IP = overrides.IP
+	}
+	return l
+}
+
+// HistoryEntry is one change of a setting, Before and After are zero when the setting was unset.
+type HistoryEntry struct {
+	ID        int64
+	CreatedAt time.Time
+	Actor     string
+	Before    Limits
+	After     Limits
+}
+
+type Repository struct {
+	pool     storage.Querier
+	observer storage.CallObserver
+	logger   *slog.Logger
+}
+
+func NewRepository(pool storage.Querier, observer storage.CallObserver, logger *slog.Logger) *Repository {
+	return &Repository{
+		pool:     pool,
+		observer: observer,
+		logger:   logger,
+	}
+}
+
+func (r *Repository) GetLimits(ctx context.Context) (_ Limits, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "get_limits", time.Now(), &err)
+
+	return r.get(ctx)
+}
+
+// LockLimits returns the limits and locks them until the transaction in ctx ends.
+// An advisory lock is taken, since there is no row to lock while the limits are not set.
+func (r *Repository) LockLimits(ctx context.Context) (_ Limits, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "lock_limits", time.Now(), &err)
+
+	_, err = storage.QuerierFromContext(ctx, r.pool).Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, limitsName)
+	if err != nil {
+		return Limits{}, fmt.Errorf("failed to lock %s setting: %w", limitsName, err)
+	}
+
+	return r.get(ctx)
+}
+
+func (r *Repository) get(ctx context.Context) (Limits, error) {
+	var raw []byte
+	err := storage.QuerierFromContext(ctx, r.pool).QueryRow(ctx,
+		`SELECT value FROM settings WHERE name = $1`, limitsName).Scan(&raw)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Limits{}, nil
+	}
+	if err != nil {
+		return Limits{}, fmt.Errorf("failed to get %s setting: %w", limitsName, err)
+	}
+
+	var limits Limits
+	if err := json.Unmarshal(raw, &limits); err != nil {
+		return Limits{}, fmt.Errorf("failed to decode %s setting: %w", limitsName, err)
+	}
+
+	return limits, nil
+}
+
+// SetLimits stores after, records the change in the history and notifies NotifyChannel.
+// Zero limits remove the setting. It must run in a transaction, so that the history entry and
+// the notification are committed together with the change.
+func (r *Repository) SetLimits(ctx context.Context, actor string, before, after Limits) (err error) {
+	defer storage.ObserveCall(r.observer, componentName, "set_limits", time.Now(), &err)
+
+	querier := storage.QuerierFromContext(ctx, r.pool)
+
+	if after.IsZero() {
+		_, err = querier.Exec(ctx, `DELETE FROM settings WHERE name = $1`, limitsName)
+	} else {
+		_, err = querier.Exec(ctx,
+			`INSERT INTO settings (name, value, updated_by) VALUES ($1, $2, $3)
+			 ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value, updated_by = EXCLUDED.updated_by,
+			 updated_at = NOW()`,
+			limitsName, encode(after), actor)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store %s setting: %w", limitsName, err)
+	}
+
+	_, err = querier.Exec(ctx,
+		`INSERT INTO settings_history (name, actor, before, after) VALUES ($1, $2, $3, $4)`,
+		limitsName, actor, encode(before), encode(after))
+	if err != nil {
+		return fmt.Errorf("failed to record %s setting history: %w", limitsName, err)
+	}
+
+	return r.notify(ctx, limitsName)
+}
+
+// notify tells every replica listening on NotifyChannel that the setting name changed.
+func (r *Repository) notify(ctx context.Context, name string) error {
+	_, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx, `SELECT pg_notify($1, $2)`, NotifyChannel, name)
+	if err != nil {
+		return fmt.Errorf("failed to notify about %s change: %w", name, err)
+	}
+
+	return nil
+}
+
+// ListLimitsHistory returns changes of the limits, newest first.
+func (r *Repository) ListLimitsHistory(ctx context.Context, offset, limit uint64) (_ []HistoryEntry, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "list_limits_history", time.Now(), &err)
+
+	rows, err := r.pool.Query(ctx,
+		`SELECT id, created_at, actor, before, after FROM settings_history
+		 WHERE name = $1 ORDER BY id DESC OFFSET $2 LIMIT $3`,
+		limitsName, offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s setting history: %w", limitsName, err)
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s setting history: %w", limitsName, err)
+	}
+
+	return entries, nil
+}
+
+func (r *Repository) LimitsHistoryEntry(ctx context.Context, id int64) (_ HistoryEntry, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "limits_history_entry", time.Now(), &err)
+
+	row := storage.QuerierFromContext(ctx, r.pool).QueryRow(ctx,
+		`SELECT id, created_at, actor, before, after FROM settings_history WHERE name = $1 AND id = $2`,
+		limitsName, id)
+
+	entry, err := scanHistoryEntry(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return HistoryEntry{}, ErrHistoryEntryNotFound
+	}
+
+	return entry, err
+}
+
+func scanHistoryEntry(row pgx.Row) (HistoryEntry, error) {
+	var entry HistoryEntry
+	var before, after []byte
+	if err := row.Scan(&entry.ID, &entry.CreatedAt, &entry.Actor, &before, &after); err != nil {
+		return HistoryEntry{}, fmt.Errorf("failed to scan settings history row: %w", err)
+	}
+
+	if err := decode(before, &entry.Before); err != nil {
+		return HistoryEntry{}, err
+	}
+	if err := decode(after, &entry.After); err != nil {
+		return HistoryEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// encode returns nil for zero limits, so they are stored as NULL.
+func encode(limits Limits) any {
+	if limits.IsZero() {
+		return nil
+	}
+
+	raw, _ := json.Marshal(limits)
+	return string(raw)
+}
+
+func decode(raw []byte, limits *Limits) error {
+	if raw == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, limits); err != nil {
+		return fmt.Errorf("failed to decode settings history entry: %w", err)
+	}
+
+	return nil
+}
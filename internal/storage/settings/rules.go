@@ -0,0 +1,111 @@
+package settings
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+)
+
+const limitRulesName = "limit_rules"
+
+// LimitRule replaces limits for attempts from Subnet made through ClientID, an empty field matches anything.
+type LimitRule struct {
+	Subnet   string
+	ClientID string
+	Limits   Limits
+}
+
+func (r *Repository) ListLimitRules(ctx context.Context) (_ []LimitRule, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "list_limit_rules", time.Now(), &err)
+
+	return r.listRules(ctx)
+}
+
+// LockLimitRules returns every rule and locks them until the transaction in ctx ends.
+func (r *Repository) LockLimitRules(ctx context.Context) (_ []LimitRule, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "lock_limit_rules", time.Now(), &err)
+
+	_, err = storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`SELECT pg_advisory_xact_lock(hashtext($1))`, limitRulesName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", limitRulesName, err)
+	}
+
+	return r.listRules(ctx)
+}
+
+func (r *Repository) listRules(ctx context.Context) ([]LimitRule, error) {
+	rows, err := storage.QuerierFromContext(ctx, r.pool).Query(ctx,
+		`SELECT COALESCE(subnet::text, ''), COALESCE(client_id, ''), login_limit, password_limit, ip_limit
+		 FROM limit_rules ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", limitRulesName, err)
+	}
+	defer rows.Close()
+
+	var rules []LimitRule
+	for rows.Next() {
+		var rule LimitRule
+		err := rows.Scan(&rule.Subnet, &rule.ClientID, &rule.Limits.Login, &rule.Limits.Password, &rule.Limits.IP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", limitRulesName, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s: %w", limitRulesName, err)
+	}
+
+	return rules, nil
+}
+
+// SetLimitRule creates the rule for its subnet and client or replaces its limits, then notifies NotifyChannel.
+// It must run in a transaction, so that the notification is committed together with the change.
+func (r *Repository) SetLimitRule(ctx context.Context, actor string, rule LimitRule) (err error) {
+	defer storage.ObserveCall(r.observer, componentName, "set_limit_rule", time.Now(), &err)
+
+	querier := storage.QuerierFromContext(ctx, r.pool)
+
+	_, err = querier.Exec(ctx,
+		`INSERT INTO limit_rules (subnet, client_id, login_limit, password_limit, ip_limit, updated_by)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (subnet, client_id) DO UPDATE SET login_limit = EXCLUDED.login_limit,
+		 password_limit = EXCLUDED.password_limit, ip_limit = EXCLUDED.ip_limit,
+		 updated_by = EXCLUDED.updated_by, updated_at = NOW()`,
+		nullIfEmpty(rule.Subnet), nullIfEmpty(rule.ClientID),
+		rule.Limits.Login, rule.Limits.Password, rule.Limits.IP, actor)
+	if err != nil {
+		return fmt.Errorf("failed to store limit rule: %w", err)
+	}
+
+	return r.notify(ctx, limitRulesName)
+}
+
+// RemoveLimitRule reports false when there was no rule for subnet and clientID.
+// Like SetLimitRule it must run in a transaction.
+func (r *Repository) RemoveLimitRule(ctx context.Context, subnet, clientID string) (removed bool, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "remove_limit_rule", time.Now(), &err)
+
+	cmdTag, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`DELETE FROM limit_rules
+		 WHERE subnet IS NOT DISTINCT FROM $1::cidr AND client_id IS NOT DISTINCT FROM $2::text`,
+		nullIfEmpty(subnet), nullIfEmpty(clientID))
+	if err != nil {
+		return false, fmt.Errorf("failed to remove limit rule: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	return true, r.notify(ctx, limitRulesName)
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,109 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+const tenantsName = "tenants"
+
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// Tenant separates lists and rate limit buckets of one product, Limits replace the global limits for it.
+type Tenant struct {
+	Name   string
+	Limits Limits
+}
+
+func (r *Repository) ListTenants(ctx context.Context) (_ []Tenant, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "list_tenants", time.Now(), &err)
+
+	rows, err := storage.QuerierFromContext(ctx, r.pool).Query(ctx,
+		`SELECT name, login_limit, password_limit, ip_limit FROM tenants ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", tenantsName, err)
+	}
+	defer rows.Close()
+
+	var tenants []Tenant
+	for rows.Next() {
+		var tenant Tenant
+		if err := rows.Scan(&tenant.Name, &tenant.Limits.Login, &tenant.Limits.Password, &tenant.Limits.IP); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", tenantsName, err)
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s: %w", tenantsName, err)
+	}
+
+	return tenants, nil
+}
+
+func (r *Repository) GetTenant(ctx context.Context, name string) (_ Tenant, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "get_tenant", time.Now(), &err)
+
+	return r.getTenant(ctx, name, "")
+}
+
+// LockTenant returns the tenant and locks it until the transaction in ctx ends, so it cannot be deleted meanwhile.
+func (r *Repository) LockTenant(ctx context.Context, name string) (_ Tenant, err error) {
+	defer storage.ObserveCall(r.observer, componentName, "lock_tenant", time.Now(), &err)
+
+	return r.getTenant(ctx, name, " FOR UPDATE")
+}
+
+func (r *Repository) getTenant(ctx context.Context, name, lockClause string) (Tenant, error) {
+	tenant := Tenant{Name: name}
+	err := storage.QuerierFromContext(ctx, r.pool).QueryRow(ctx,
+		`SELECT login_limit, password_limit, ip_limit FROM tenants WHERE name = $1`+lockClause,
+		name).Scan(&tenant.Limits.Login, &tenant.Limits.Password, &tenant.Limits.IP)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Tenant{}, ErrTenantNotFound
+	}
+	if err != nil {
+		return Tenant{}, fmt.Errorf("failed to get tenant %q: %w", name, err)
+	}
+
+	return tenant, nil
+}
+
+// SetTenant creates the tenant or replaces its limits, then notifies NotifyChannel.
+// It must run in a transaction, so that the notification is committed together with the change.
+func (r *Repository) SetTenant(ctx context.Context, actor string, tenant Tenant) (err error) {
+	defer storage.ObserveCall(r.observer, componentName, "set_tenant", time.Now(), &err)
+
+	_, err = storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`INSERT INTO tenants (name, login_limit, password_limit, ip_limit, updated_by)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (name) DO UPDATE SET login_limit = EXCLUDED.login_limit,
+		 password_limit = EXCLUDED.password_limit, ip_limit = EXCLUDED.ip_limit,
+		 updated_by = EXCLUDED.updated_by, updated_at = NOW()`,
+		tenant.Name, tenant.Limits.Login, tenant.Limits.Password, tenant.Limits.IP, actor)
+	if err != nil {
+		return fmt.Errorf("failed to store tenant %q: %w", tenant.Name, err)
+	}
+
+	return r.notify(ctx, tenantsName)
+}
+
+// DeleteTenant removes the tenant together with its lists. Like SetTenant it must run in a transaction.
+func (r *Repository) DeleteTenant(ctx context.Context, name string) (err error) {
+	defer storage.ObserveCall(r.observer, componentName, "delete_tenant", time.Now(), &err)
+
+	cmdTag, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx, `DELETE FROM tenants WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant %q: %w", name, err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrTenantNotFound
+	}
+
+	return r.notify(ctx, tenantsName)
+}
@@ -8,12 +8,15 @@ import (
 	"log/slog"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/FluVirus2/antibruteforce/internal/storage"
 	"github.com/redis/go-redis/v9"
 )
 
+// Cache keys carry no hash tag, so in a Redis cluster they spread over all slots
+// and every command below touches a single key.
 const (
 	subnetListCacheKeyPrefix = "subnets:list:"
 	subnetListCacheKeyAll    = subnetListCacheKeyPrefix + "*"
@@ -22,35 +25,53 @@ const (
 	defaultCacheTTL          = 10 * time.Minute
 )
 
-type ipCheckResult struct {
+type IPCheckResult struct {
 	InWhitelist bool
 	InBlacklist bool
 }
 
+const cacheComponentName = "subnet_cache"
+
 type Cache struct {
-	redis  *redis.Client
-	logger *slog.Logger
-	ttl    time.Duration
+	redis    redis.UniversalClient
+	observer storage.CallObserver
+	logger   *slog.Logger
+	ttl      time.Duration
 }
 
-func NewCache(redis *redis.Client, logger *slog.Logger) *Cache {
+func NewCache(redis redis.UniversalClient, observer storage.CallObserver, logger *slog.Logger) *Cache {
 	return &Cache{
-		redis:  redis,
-		logger: logger,
-		ttl:    defaultCacheTTL,
+		redis:    redis,
+		observer: observer,
+		logger:   logger,
+		ttl:      defaultCacheTTL,
+	}
+}
+
+// tenantKeyPart keeps the keys of the default tenant as they were before tenants existed.
+// Tenant names never contain "/", so keys of different tenants never collide.
+func tenantKeyPart(tenant string) string {
+	if tenant == "" {
+		return ""
 	}
+	return "@" + tenant + "/"
 }
 
-func ipCheckResultKey(ip string) string {
-	return ipCheckCacheKeyPrefix + ip
+func ipCheckResultKey(tenant, ip string) string {
+	return ipCheckCacheKeyPrefix + tenantKeyPart(tenant) + ip
 }
 
-func subnetListKey(listType int) string {
-	return subnetListCacheKeyPrefix + strconv.Itoa(listType)
+func subnetListKey(tenant string, listType int) string {
+	return subnetListCacheKeyPrefix + tenantKeyPart(tenant) + strconv.Itoa(listType)
 }
 
-func (c *Cache) GetIPCheckResult(ctx context.Context, ip string) (inWhitelist bool, inBlacklist bool, err error) {
-	key := ipCheckResultKey(ip)
+// Check results are cached per tenant, they cover the global lists as well as the lists of the tenant.
+//
+//nolint:lll
+func (c *Cache) GetIPCheckResult(ctx context.Context, tenant, ip string) (inWhitelist bool, inBlacklist bool, err error) {
+	defer storage.ObserveCall(c.observer, cacheComponentName, "get_ip_check_result", time.Now(), &err)
+
+	key := ipCheckResultKey(tenant, ip)
 	data, err := c.redis.Get(ctx, key).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
@@ -59,7 +80,7 @@ func (c *Cache) GetIPCheckResult(ctx context.Context, ip string) (inWhitelist bo
 		return false, false, fmt.Errorf("failed to get IP check result from cache for %q: %w", ip, err)
 	}
 
-	var result ipCheckResult
+	var result IPCheckResult
 	if err := json.Unmarshal([]byte(data), &result); err != nil {
 		return false, false, &storage.UnexpectedDataFormatError{
 			Key:   key,
@@ -70,9 +91,12 @@ func (c *Cache) GetIPCheckResult(ctx context.Context, ip string) (inWhitelist bo
 	return result.InWhitelist, result.InBlacklist, nil
 }
 
-func (c *Cache) SetIPCheckResult(ctx context.Context, ip string, inWhitelist bool, inBlacklist bool) error {
-	key := ipCheckResultKey(ip)
-	result := ipCheckResult{
+//nolint:lll
+func (c *Cache) SetIPCheckResult(ctx context.Context, tenant, ip string, inWhitelist bool, inBlacklist bool) (err error) {
+	defer storage.ObserveCall(c.observer, cacheComponentName, "set_ip_check_result", time.Now(), &err)
+
+	key := ipCheckResultKey(tenant, ip)
+	result := IPCheckResult{
 		InWhitelist: inWhitelist,
 		InBlacklist: inBlacklist,
 	}
@@ -88,7 +112,82 @@ func (c *Cache) SetIPCheckResult(ctx context.Context, ip string, inWhitelist boo
 	return nil
 }
 
-func (c *Cache) SetBothSubnetLists(ctx context.Context, whitelistSubnets, blacklistSubnets []string) error {
+// GetIPCheckResults returns cached check results for the given IPs. IPs without a cached result are absent in the map.
+//
+//nolint:lll
+func (c *Cache) GetIPCheckResults(ctx context.Context, tenant string, ips []string) (_ map[string]IPCheckResult, err error) {
+	defer storage.ObserveCall(c.observer, cacheComponentName, "get_ip_check_results", time.Now(), &err)
+
+	if len(ips) == 0 {
+		return map[string]IPCheckResult{}, nil
+	}
+
+	keys := make([]string, len(ips))
+	for i, ip := range ips {
+		keys[i] = ipCheckResultKey(tenant, ip)
+	}
+
+	pipe := c.redis.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	// a missing key fails its GET with redis.Nil, the other errors are checked per command
+	_, _ = pipe.Exec(ctx)
+
+	results := make(map[string]IPCheckResult, len(ips))
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %d IP check results from cache: %w", len(ips), err)
+		}
+
+		var result IPCheckResult
+		if err := json.Unmarshal([]byte(data), &result); err != nil {
+			return nil, &storage.UnexpectedDataFormatError{
+				Key:   keys[i],
+				Cause: err,
+			}
+		}
+		results[ips[i]] = result
+	}
+
+	return results, nil
+}
+
+//nolint:lll
+func (c *Cache) SetIPCheckResults(ctx context.Context, tenant string, results map[string]IPCheckResult) (err error) {
+	defer storage.ObserveCall(c.observer, cacheComponentName, "set_ip_check_results", time.Now(), &err)
+
+	if len(results) == 0 {
+		return nil
+	}
+
+	pipe := c.redis.Pipeline()
+	for ip, result := range results {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("failed to marshal IP check result for %q: %w", ip, err)
+		}
+		pipe.Set(ctx, ipCheckResultKey(tenant, ip), data, c.ttl)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to set %d IP check results in cache: %w", len(results), err)
+	}
+
+	return nil
+}
+
+// SetBothSubnetLists caches the lists that apply to the tenant, the global lists included.
+//
+//nolint:lll
+func (c *Cache) SetBothSubnetLists(ctx context.Context, tenant string, whitelistSubnets, blacklistSubnets []string) (err error) {
+	defer storage.ObserveCall(c.observer, cacheComponentName, "set_both_subnet_lists", time.Now(), &err)
+
 	pipe := c.redis.Pipeline()
 
 	lists := []struct {
@@ -101,7 +200,7 @@ func (c *Cache) SetBothSubnetLists(ctx context.Context, whitelistSubnets, blackl
 
 	for _, list := range lists {
 		if len(list.subnets) > 0 {
-			key := subnetListKey(list.listType)
+			key := subnetListKey(tenant, list.listType)
 			members := make([]interface{}, len(list.subnets))
 			for i, subnet := range list.subnets {
 				members[i] = subnet
@@ -119,13 +218,15 @@ func (c *Cache) SetBothSubnetLists(ctx context.Context, whitelistSubnets, blackl
 	return nil
 }
 
-func (c *Cache) InvalidateAll(ctx context.Context) error {
-	listKeys, err := c.redis.Keys(ctx, subnetListCacheKeyAll).Result()
+func (c *Cache) InvalidateAll(ctx context.Context) (err error) {
+	defer storage.ObserveCall(c.observer, cacheComponentName, "invalidate_all", time.Now(), &err)
+
+	listKeys, err := c.keys(ctx, subnetListCacheKeyAll)
 	if err != nil {
 		return fmt.Errorf("failed to find subnet list cache keys: %w", err)
 	}
 
-	ipKeys, err := c.redis.Keys(ctx, ipCheckCacheKeyAll).Result()
+	ipKeys, err := c.keys(ctx, ipCheckCacheKeyAll)
 	if err != nil {
 		return fmt.Errorf("failed to find IP check cache keys: %w", err)
 	}
@@ -137,26 +238,83 @@ func (c *Cache) InvalidateAll(ctx context.Context) error {
 		return nil
 	}
 
-	if err := c.redis.Del(ctx, allKeys...).Err(); err != nil {
+	pipe := c.redis.Pipeline()
+	for _, key := range allKeys {
+		pipe.Del(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("failed to delete %d cache keys: %w", len(allKeys), err)
 	}
 
 	return nil
 }
 
-func (c *Cache) AreBothListsCached(ctx context.Context) (bothCached bool) {
-	whitelistKey := subnetListKey(WhitelistTypeID)
-	blacklistKey := subnetListKey(BlacklistTypeID)
+// InvalidateHosts drops what was cached for the hosts after they were added to a list of their tenant:
+// their check results for that tenant and the cached list. Results other tenants cached for a host added to
+// the global lists are kept until they expire.
+func (c *Cache) InvalidateHosts(ctx context.Context, listType int, hosts []ExpiringHost) (err error) {
+	defer storage.ObserveCall(c.observer, cacheComponentName, "invalidate_hosts", time.Now(), &err)
+
+	keys := make(map[string]struct{}, 2*len(hosts))
+	for _, host := range hosts {
+		keys[ipCheckResultKey(host.Tenant, host.IP)] = struct{}{}
+		keys[subnetListKey(host.Tenant, listType)] = struct{}{}
+	}
+
+	pipe := c.redis.Pipeline()
+	for key := range keys {
+		pipe.Del(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete %d cache keys: %w", len(keys), err)
+	}
 
-	count, err := c.redis.Exists(ctx, whitelistKey, blacklistKey).Result()
-	return err == nil && count == 2
+	return nil
 }
 
-func (c *Cache) GetBothSubnetLists(ctx context.Context) (whitelistSubnets, blacklistSubnets []string, err error) {
+// keys asks every master of a cluster, since KEYS is sent to a single node.
+func (c *Cache) keys(ctx context.Context, pattern string) ([]string, error) {
+	cluster, ok := c.redis.(*redis.ClusterClient)
+	if !ok {
+		return c.redis.Keys(ctx, pattern).Result()
+	}
+
+	var mu sync.Mutex
+	var keys []string
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		masterKeys, err := master.Keys(ctx, pattern).Result()
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, masterKeys...)
+		return nil
+	})
+
+	return keys, err
+}
+
+func (c *Cache) AreBothListsCached(ctx context.Context, tenant string) (bothCached bool) {
+	whitelistKey := subnetListKey(tenant, WhitelistTypeID)
+	blacklistKey := subnetListKey(tenant, BlacklistTypeID)
+
 	pipe := c.redis.Pipeline()
+	whitelistCmd := pipe.Exists(ctx, whitelistKey)
+	blacklistCmd := pipe.Exists(ctx, blacklistKey)
+	_, err := pipe.Exec(ctx)
+	return err == nil && whitelistCmd.Val() == 1 && blacklistCmd.Val() == 1
+}
 
-	whitelistKey := subnetListKey(WhitelistTypeID)
-	blacklistKey := subnetListKey(BlacklistTypeID)
+//nolint:lll
+func (c *Cache) GetBothSubnetLists(ctx context.Context, tenant string) (whitelistSubnets, blacklistSubnets []string, err error) {
+	defer storage.ObserveCall(c.observer, cacheComponentName, "get_both_subnet_lists", time.Now(), &err)
+
+	pipe := c.redis.Pipeline()
+
+	whitelistKey := subnetListKey(tenant, WhitelistTypeID)
+	blacklistKey := subnetListKey(tenant, BlacklistTypeID)
 
 	whitelistCmd := pipe.SMembers(ctx, whitelistKey)
 	blacklistCmd := pipe.SMembers(ctx, blacklistKey)
@@ -179,13 +337,13 @@ func (c *Cache) GetBothSubnetLists(ctx context.Context) (whitelistSubnets, black
 }
 
 //nolint:lll
-func (c *Cache) CheckIPInBothCachedSubnets(ctx context.Context, ipStr string) (inWhitelist bool, inBlacklist bool, err error) {
+func (c *Cache) CheckIPInBothCachedSubnets(ctx context.Context, tenant, ipStr string) (inWhitelist bool, inBlacklist bool, err error) {
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
 		return false, false, fmt.Errorf("invalid IP address: %q", ipStr)
 	}
 
-	whitelistSubnets, blacklistSubnets, err := c.GetBothSubnetLists(ctx)
+	whitelistSubnets, blacklistSubnets, err := c.GetBothSubnetLists(ctx, tenant)
 	if err != nil {
 		return false, false, fmt.Errorf("failed to get both cached subnet lists for IP %q: %w", ipStr, err)
 	}
@@ -196,6 +354,32 @@ func (c *Cache) CheckIPInBothCachedSubnets(ctx context.Context, ipStr string) (i
 	return inWhitelist, inBlacklist, nil
 }
 
+//nolint:lll
+func (c *Cache) CheckIPsInBothCachedSubnets(ctx context.Context, tenant string, ipStrs []string) (map[string]IPCheckResult, error) {
+	ips := make([]net.IP, len(ipStrs))
+	for i, ipStr := range ipStrs {
+		ips[i] = net.ParseIP(ipStr)
+		if ips[i] == nil {
+			return nil, fmt.Errorf("invalid IP address: %q", ipStr)
+		}
+	}
+
+	whitelistSubnets, blacklistSubnets, err := c.GetBothSubnetLists(ctx, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get both cached subnet lists for %d IPs: %w", len(ipStrs), err)
+	}
+
+	results := make(map[string]IPCheckResult, len(ipStrs))
+	for i, ip := range ips {
+		results[ipStrs[i]] = IPCheckResult{
+			InWhitelist: c.checkIPInSubnetList(ip, whitelistSubnets, WhitelistTypeID),
+			InBlacklist: c.checkIPInSubnetList(ip, blacklistSubnets, BlacklistTypeID),
+		}
+	}
+
+	return results, nil
+}
+
 func (c *Cache) checkIPInSubnetList(ip net.IP, subnets []string, listType int) bool {
 	for _, cidr := range subnets {
 		_, ipNet, err := net.ParseCIDR(cidr)
@@ -0,0 +1,101 @@
+package subnet
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCacheInRedisCluster(t *testing.T) {
+	t.Parallel()
+
+	// the slots are split between two nodes, so a command over keys of both nodes would miss some of them
+	nodes := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t)}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: nodes[0].Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: nodes[1].Addr()}}},
+			}, nil
+		},
+	})
+	defer client.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := NewCache(client, storage.NopCallObserver{}, logger)
+	ctx := context.Background()
+
+	if err := c.SetBothSubnetLists(ctx, "", []string{"10.0.0.0/8"}, []string{"192.168.0.0/16"}); err != nil {
+		t.Fatalf("SetBothSubnetLists() error = %v", err)
+	}
+
+	results := map[string]IPCheckResult{
+		"10.0.0.1":    {InWhitelist: true},
+		"192.168.0.1": {InBlacklist: true},
+		"172.16.0.1":  {},
+	}
+	if err := c.SetIPCheckResults(ctx, "", results); err != nil {
+		t.Fatalf("SetIPCheckResults() error = %v", err)
+	}
+
+	got, err := c.GetIPCheckResults(ctx, "", []string{"10.0.0.1", "192.168.0.1", "172.16.0.1", "8.8.8.8"})
+	if err != nil {
+		t.Fatalf("GetIPCheckResults() error = %v", err)
+	}
+	if len(got) != len(results) {
+		t.Errorf("GetIPCheckResults() = %v, want %v", got, results)
+	}
+	for ip, want := range results {
+		if got[ip] != want {
+			t.Errorf("GetIPCheckResults()[%q] = %+v, want %+v", ip, got[ip], want)
+		}
+	}
+
+	// tenants share IPs, but not their check results
+	acme := map[string]IPCheckResult{"10.0.0.1": {InBlacklist: true}}
+	if err := c.SetIPCheckResults(ctx, "acme", acme); err != nil {
+		t.Fatalf("SetIPCheckResults() error = %v", err)
+	}
+	if got, err := c.GetIPCheckResults(ctx, "acme", []string{"10.0.0.1", "192.168.0.1"}); err != nil || len(got) != 1 {
+		t.Errorf("GetIPCheckResults() of a tenant = %v, %v, want only its own result", got, err)
+	}
+	if inWhitelist, _, err := c.GetIPCheckResult(ctx, "", "10.0.0.1"); err != nil || !inWhitelist {
+		t.Errorf("GetIPCheckResult() of the default tenant = %v, %v, want it unchanged", inWhitelist, err)
+	}
+
+	keys := [][]string{nodes[0].Keys(), nodes[1].Keys()}
+	if len(keys[0]) == 0 || len(keys[1]) == 0 {
+		t.Errorf("nodes hold %v and %v, want cache keys on both", keys[0], keys[1])
+	}
+
+	// listing a host for a tenant only drops what that tenant cached for it
+	hosts := []ExpiringHost{{Tenant: "acme", IP: "10.0.0.1"}, {Tenant: "", IP: "172.16.0.1"}}
+	if err := c.InvalidateHosts(ctx, BlacklistTypeID, hosts); err != nil {
+		t.Fatalf("InvalidateHosts() error = %v", err)
+	}
+	if got, err := c.GetIPCheckResults(ctx, "acme", []string{"10.0.0.1"}); err != nil || len(got) != 0 {
+		t.Errorf("GetIPCheckResults() of an invalidated host = %v, %v, want a miss", got, err)
+	}
+	got, err = c.GetIPCheckResults(ctx, "", []string{"10.0.0.1", "192.168.0.1", "172.16.0.1"})
+	if err != nil || len(got) != 2 {
+		t.Errorf("GetIPCheckResults() after InvalidateHosts() = %v, %v, want all but 172.16.0.1", got, err)
+	}
+	if c.AreBothListsCached(ctx, "") {
+		t.Error("lists are still cached after InvalidateHosts()")
+	}
+
+	if err := c.InvalidateAll(ctx); err != nil {
+		t.Fatalf("InvalidateAll() error = %v", err)
+	}
+	if c.AreBothListsCached(ctx, "") {
+		t.Error("lists are still cached after InvalidateAll()")
+	}
+	if left := len(nodes[0].Keys()) + len(nodes[1].Keys()); left != 0 {
+		t.Errorf("%d keys left after InvalidateAll()", left)
+	}
+}
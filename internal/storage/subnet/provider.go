@@ -4,28 +4,71 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"time"
 
 	"github.com/FluVirus2/antibruteforce/internal/storage"
+	"github.com/FluVirus2/antibruteforce/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// DefaultExpiryInterval is how often RunExpiry looks for expired subnets.
+const DefaultExpiryInterval = time.Minute
+
+const (
+	IPResultCache   = "ip_result"
+	SubnetListCache = "subnet_lists"
+)
+
+// Values of the sourceAttribute span attribute, telling which branch answered a subnet check.
+const (
+	sourceAttribute   = "abf.subnet.source"
+	sourceIPResult    = "ip_result_cache"
+	sourceCachedLists = "cached_lists"
+	sourceDatabase    = "database"
+)
+
+var tracer = otel.Tracer("github.com/FluVirus2/antibruteforce/internal/storage/subnet")
+
+// CacheObserver is told whether a lookup was answered by one of the caches.
+type CacheObserver interface {
+	ObserveCacheLookup(cache string, hit bool)
+}
+
 type Provider struct {
-	repo   *Repository
-	cache  *Cache
-	logger *slog.Logger
+	repo          *Repository
+	cache         *Cache
+	cacheObserver CacheObserver
+	logger        *slog.Logger
 }
 
-func NewProvider(repo *Repository, cache *Cache, logger *slog.Logger) *Provider {
+func NewProvider(repo *Repository, cache *Cache, cacheObserver CacheObserver, logger *slog.Logger) *Provider {
 	return &Provider{
-		repo:   repo,
-		cache:  cache,
-		logger: logger,
+		repo:          repo,
+		cache:         cache,
+		cacheObserver: cacheObserver,
+		logger:        logger,
+	}
+}
+
+func (p *Provider) observeCacheLookup(cache string, hits, total int) {
+	for i := 0; i < total; i++ {
+		p.cacheObserver.ObserveCacheLookup(cache, i < hits)
 	}
 }
 
-func (p *Provider) CheckIPInBothLists(ctx context.Context, ip string) (inWhitelist bool, inBlacklist bool, err error) {
+// CheckIPInBothLists checks the IP against the global lists and the lists of the tenant.
+//
+//nolint:lll
+func (p *Provider) CheckIPInBothLists(ctx context.Context, tenant, ip string) (inWhitelist bool, inBlacklist bool, err error) {
+	ctx, span := tracer.Start(ctx, "subnet.Provider/CheckIPInBothLists")
+	defer tracing.EndSpan(span, &err)
+
 	if p.cache != nil {
-		inWhitelist, inBlacklist, err := p.cache.GetIPCheckResult(ctx, ip)
+		inWhitelist, inBlacklist, err := p.cache.GetIPCheckResult(ctx, tenant, ip)
+		p.cacheObserver.ObserveCacheLookup(IPResultCache, err == nil)
 		if err == nil {
+			span.SetAttributes(attribute.String(sourceAttribute, sourceIPResult))
 			return inWhitelist, inBlacklist, nil
 		}
 
@@ -36,28 +79,33 @@ func (p *Provider) CheckIPInBothLists(ctx context.Context, ip string) (inWhiteli
 
 	var bothCached bool
 	if p.cache != nil {
-		bothCached = p.cache.AreBothListsCached(ctx)
+		bothCached = p.cache.AreBothListsCached(ctx, tenant)
+		p.cacheObserver.ObserveCacheLookup(SubnetListCache, bothCached)
 	}
 
 	//nolint: nestif
 	if bothCached {
-		inWhitelist, inBlacklist, err = p.cache.CheckIPInBothCachedSubnets(ctx, ip)
+		inWhitelist, inBlacklist, err = p.scanCachedLists(ctx, tenant, ip)
 		if err != nil {
 			p.logger.Warn("cache error when checking IP in cached subnets, falling back to database", "ip", ip, "error", err)
-			inWhitelist, inBlacklist, err = p.repo.CheckIPInBothLists(ctx, ip)
+			inWhitelist, inBlacklist, err = p.queryDatabase(ctx, tenant, ip)
 			if err != nil {
 				return false, false, err
 			}
+			span.SetAttributes(attribute.String(sourceAttribute, sourceDatabase))
+		} else {
+			span.SetAttributes(attribute.String(sourceAttribute, sourceCachedLists))
 		}
 	} else {
-		inWhitelist, inBlacklist, err = p.repo.CheckIPInBothLists(ctx, ip)
+		inWhitelist, inBlacklist, err = p.queryDatabase(ctx, tenant, ip)
 		if err != nil {
 			return false, false, err
 		}
+		span.SetAttributes(attribute.String(sourceAttribute, sourceDatabase))
 	}
 
 	if p.cache != nil {
-		if err := p.cache.SetIPCheckResult(ctx, ip, inWhitelist, inBlacklist); err != nil {
+		if err := p.cache.SetIPCheckResult(ctx, tenant, ip, inWhitelist, inBlacklist); err != nil {
 			p.logger.Warn("failed to cache IP check result", "ip", ip, "error", err)
 		}
 	}
@@ -65,31 +113,213 @@ func (p *Provider) CheckIPInBothLists(ctx context.Context, ip string) (inWhiteli
 	return inWhitelist, inBlacklist, nil
 }
 
-func (p *Provider) Add(ctx context.Context, listType int, cidr string) error {
-	if err := p.repo.Add(ctx, listType, cidr); err != nil {
-		return err
+//nolint:lll
+func (p *Provider) scanCachedLists(ctx context.Context, tenant, ip string) (inWhitelist bool, inBlacklist bool, err error) {
+	ctx, span := tracer.Start(ctx, "subnet.Provider/scanCachedLists")
+	defer tracing.EndSpan(span, &err)
+
+	return p.cache.CheckIPInBothCachedSubnets(ctx, tenant, ip)
+}
+
+//nolint:lll
+func (p *Provider) queryDatabase(ctx context.Context, tenant, ip string) (inWhitelist bool, inBlacklist bool, err error) {
+	ctx, span := tracer.Start(ctx, "subnet.Provider/queryDatabase")
+	defer tracing.EndSpan(span, &err)
+
+	return p.repo.CheckIPInBothLists(ctx, tenant, ip)
+}
+
+// CheckIPsInBothLists checks every IP the same way as CheckIPInBothLists does, but resolves
+// all of them in one pass: a single cache read, then a single list scan or database query for the misses.
+// Results are returned in the order of ips.
+//
+//nolint:lll
+func (p *Provider) CheckIPsInBothLists(ctx context.Context, tenant string, ips []string) (_ []IPCheckResult, err error) {
+	ctx, span := tracer.Start(ctx, "subnet.Provider/CheckIPsInBothLists")
+	defer tracing.EndSpan(span, &err)
+
+	unique := make([]string, 0, len(ips))
+	resolved := make(map[string]IPCheckResult, len(ips))
+	seen := make(map[string]struct{}, len(ips))
+	for _, ip := range ips {
+		if _, ok := seen[ip]; !ok {
+			seen[ip] = struct{}{}
+			unique = append(unique, ip)
+		}
 	}
 
+	misses := unique
 	if p.cache != nil {
-		if err := p.cache.InvalidateAll(ctx); err != nil {
-			p.logger.Warn("failed to invalidate cache after add", "listType", listType, "cidr", cidr, "error", err)
+		cached, err := p.cache.GetIPCheckResults(ctx, tenant, unique)
+		if err != nil {
+			p.logger.Warn("cache error when getting IP check results, falling back to database",
+				"ips", len(unique), "error", err)
+		} else {
+			misses = make([]string, 0, len(unique)-len(cached))
+			for _, ip := range unique {
+				if result, ok := cached[ip]; ok {
+					resolved[ip] = result
+				} else {
+					misses = append(misses, ip)
+				}
+			}
 		}
+		p.observeCacheLookup(IPResultCache, len(unique)-len(misses), len(unique))
 	}
 
-	return nil
+	span.SetAttributes(
+		attribute.Int("abf.subnet.ips", len(unique)),
+		attribute.Int("abf.subnet.cache_misses", len(misses)),
+	)
+
+	if len(misses) > 0 {
+		checked, err := p.checkIPsInLists(ctx, tenant, misses)
+		if err != nil {
+			return nil, err
+		}
+
+		for ip, result := range checked {
+			resolved[ip] = result
+		}
+
+		if p.cache != nil {
+			if err := p.cache.SetIPCheckResults(ctx, tenant, checked); err != nil {
+				p.logger.Warn("failed to cache IP check results", "ips", len(checked), "error", err)
+			}
+		}
+	}
+
+	results := make([]IPCheckResult, len(ips))
+	for i, ip := range ips {
+		results[i] = resolved[ip]
+	}
+
+	return results, nil
 }
 
-func (p *Provider) Remove(ctx context.Context, listType int, cidr string) (deletedCount int64, err error) {
-	deletedCount, err = p.repo.Remove(ctx, listType, cidr)
+//nolint:lll
+func (p *Provider) checkIPsInLists(ctx context.Context, tenant string, ips []string) (map[string]IPCheckResult, error) {
+	if p.cache == nil {
+		return p.queryDatabaseBatch(ctx, tenant, ips)
+	}
+
+	bothCached := p.cache.AreBothListsCached(ctx, tenant)
+	p.cacheObserver.ObserveCacheLookup(SubnetListCache, bothCached)
+
+	if bothCached {
+		results, err := p.scanCachedListsBatch(ctx, tenant, ips)
+		if err == nil {
+			return results, nil
+		}
+
+		p.logger.Warn("cache error when checking IPs in cached subnets, falling back to database",
+			"ips", len(ips), "error", err)
+	}
+
+	return p.queryDatabaseBatch(ctx, tenant, ips)
+}
+
+//nolint:lll
+func (p *Provider) scanCachedListsBatch(ctx context.Context, tenant string, ips []string) (_ map[string]IPCheckResult, err error) {
+	ctx, span := tracer.Start(ctx, "subnet.Provider/scanCachedLists")
+	defer tracing.EndSpan(span, &err)
+
+	return p.cache.CheckIPsInBothCachedSubnets(ctx, tenant, ips)
+}
+
+//nolint:lll
+func (p *Provider) queryDatabaseBatch(ctx context.Context, tenant string, ips []string) (_ map[string]IPCheckResult, err error) {
+	ctx, span := tracer.Start(ctx, "subnet.Provider/queryDatabase")
+	defer tracing.EndSpan(span, &err)
+
+	return p.repo.CheckIPsInBothLists(ctx, tenant, ips)
+}
+
+// Add and Remove invalidate the cache right away, so they must not be called inside a transaction.
+// Transactional callers change the Repository directly and call InvalidateCache after commit.
+func (p *Provider) Add(ctx context.Context, tenant string, listType int, cidr string) (added bool, err error) {
+	added, err = p.repo.Add(ctx, tenant, listType, cidr)
+	if err != nil {
+		return false, err
+	}
+
+	p.InvalidateCache(ctx)
+
+	return added, nil
+}
+
+// AddHostsWithExpiry adds the hosts in one batch, see Repository.AddHostsWithExpiry. Unlike Add it does not
+// invalidate the whole cache, only what was cached for the hosts, see Cache.InvalidateHosts.
+//
+//nolint:lll
+func (p *Provider) AddHostsWithExpiry(ctx context.Context, listType int, hosts []ExpiringHost) (listed int64, err error) {
+	listed, err = p.repo.AddHostsWithExpiry(ctx, listType, hosts)
 	if err != nil {
 		return 0, err
 	}
 
 	if p.cache != nil {
-		if err := p.cache.InvalidateAll(ctx); err != nil {
-			p.logger.Warn("failed to invalidate cache after remove", "listType", listType, "cidr", cidr, "error", err)
+		if err := p.cache.InvalidateHosts(ctx, listType, hosts); err != nil {
+			p.logger.Warn("failed to invalidate subnet cache of hosts", "hosts", len(hosts), "error", err)
 		}
 	}
 
+	return listed, nil
+}
+
+//nolint:lll
+func (p *Provider) Remove(ctx context.Context, tenant string, listType int, cidr string) (deletedCount int64, err error) {
+	deletedCount, err = p.repo.Remove(ctx, tenant, listType, cidr)
+	if err != nil {
+		return 0, err
+	}
+
+	p.InvalidateCache(ctx)
+
 	return deletedCount, nil
 }
+
+// DeleteExpired removes expired subnets and invalidates the cache when there were any.
+func (p *Provider) DeleteExpired(ctx context.Context) error {
+	deletedCount, err := p.repo.DeleteExpired(ctx)
+	if err != nil {
+		return err
+	}
+
+	if deletedCount > 0 {
+		p.logger.Info("deleted expired subnets", "count", deletedCount)
+		p.InvalidateCache(ctx)
+	}
+
+	return nil
+}
+
+// RunExpiry deletes expired subnets every interval until ctx is done, so a subnet stays listed
+// for up to interval after its expiry. Every replica runs it, deleting twice does no harm.
+func (p *Provider) RunExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Warn("failed to delete expired subnets", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// InvalidateCache drops cached lists and IP check results after the lists have changed.
+func (p *Provider) InvalidateCache(ctx context.Context) {
+	if p.cache == nil {
+		return
+	}
+
+	if err := p.cache.InvalidateAll(ctx); err != nil {
+		p.logger.Warn("failed to invalidate subnet cache", "error", err)
+	}
+}
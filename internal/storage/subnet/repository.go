@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/FluVirus2/antibruteforce/internal/storage"
 )
 
 const (
@@ -14,42 +15,138 @@ const (
 	BlacklistTypeID int = 2
 )
 
+const repositoryComponentName = "subnet_repository"
+
+// Lists are the subnets of one tenant.
+type Lists struct {
+	Whitelist []string
+	Blacklist []string
+}
+
+// ExpiringHost is a single address listed for a tenant until TTL passes.
+type ExpiringHost struct {
+	Tenant string
+	IP     string
+	TTL    time.Duration
+}
+
+// HostCIDR returns the single address subnet of a valid IP, IPv4-mapped IPv6 addresses are listed as IPv4.
+func HostCIDR(ip string) string {
+	parsed := net.ParseIP(ip)
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String() + "/32"
+	}
+	return parsed.String() + "/128"
+}
+
+// tenantArg stores the lists of the default tenant with a NULL tenant. They are the global lists,
+// which apply to every tenant on top of its own.
+func tenantArg(tenant string) any {
+	if tenant == "" {
+		return nil
+	}
+	return tenant
+}
+
 type Repository struct {
-	pool   *pgxpool.Pool
-	logger *slog.Logger
+	pool     storage.Querier
+	observer storage.CallObserver
+	logger   *slog.Logger
 }
 
-func NewRepository(pool *pgxpool.Pool, logger *slog.Logger) *Repository {
+func NewRepository(pool storage.Querier, observer storage.CallObserver, logger *slog.Logger) *Repository {
 	return &Repository{
-		pool:   pool,
-		logger: logger,
+		pool:     pool,
+		observer: observer,
+		logger:   logger,
 	}
 }
 
-func (r *Repository) Add(ctx context.Context, listType int, cidr string) error {
+// Add reports false when the subnet was already in the tenant list. A subnet listed until an expiry,
+// see AddWithExpiry, is listed for good afterwards.
+func (r *Repository) Add(ctx context.Context, tenant string, listType int, cidr string) (added bool, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "add", time.Now(), &err)
+
 	if _, _, err := net.ParseCIDR(cidr); err != nil {
-		return fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		return false, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+	}
+
+	cmdTag, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`INSERT INTO subnets (tenant, subnet_type, subnet) VALUES ($1, $2, $3)
+         ON CONFLICT (tenant, subnet_type, subnet) DO UPDATE SET expires_at = NULL
+         WHERE subnets.expires_at IS NOT NULL`,
+		tenantArg(tenant), listType, cidr)
+	if err != nil {
+		return false, fmt.Errorf("failed to add subnet %q to list type %d: %w", cidr, listType, err)
+	}
+
+	return cmdTag.RowsAffected() > 0, nil
+}
+
+// AddHostsWithExpiry lists every host as a single address subnet of its tenant until its TTL passes,
+// see DeleteExpired, in one statement. A host that is already listed without an expiry stays listed for good,
+// an expiring one is extended. listed counts the hosts added or extended.
+//
+//nolint:lll
+func (r *Repository) AddHostsWithExpiry(ctx context.Context, listType int, hosts []ExpiringHost) (listed int64, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "add_hosts_with_expiry", time.Now(), &err)
+
+	tenants := make([]string, len(hosts))
+	cidrs := make([]string, len(hosts))
+	ttls := make([]float64, len(hosts))
+	for i, host := range hosts {
+		if net.ParseIP(host.IP) == nil {
+			return 0, fmt.Errorf("invalid ip %q", host.IP)
+		}
+		tenants[i] = host.Tenant
+		cidrs[i] = HostCIDR(host.IP)
+		ttls[i] = host.TTL.Seconds()
+	}
+
+	// a row must not be inserted twice by one statement, so hosts are grouped first
+	cmdTag, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`INSERT INTO subnets (tenant, subnet_type, subnet, expires_at)
+		 SELECT NULLIF(h.tenant, ''), $1, h.cidr::cidr, NOW() + make_interval(secs => MAX(h.ttl))
+		 FROM unnest($2::text[], $3::text[], $4::float8[]) AS h(tenant, cidr, ttl)
+		 GROUP BY h.tenant, h.cidr
+		 ON CONFLICT (tenant, subnet_type, subnet) DO UPDATE
+		 SET expires_at = GREATEST(subnets.expires_at, EXCLUDED.expires_at)
+		 WHERE subnets.expires_at IS NOT NULL`,
+		listType, tenants, cidrs, ttls)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add %d hosts to list type %d with expiry: %w", len(hosts), listType, err)
 	}
 
-	_, err := r.pool.Exec(ctx,
-		`INSERT INTO subnets (subnet_type, subnet) VALUES ($1, $2)
-         ON CONFLICT (subnet_type, subnet) DO NOTHING`,
-		listType, cidr)
+	return cmdTag.RowsAffected(), nil
+}
+
+// notExpired skips subnets whose expiry has passed but which DeleteExpired has not removed yet.
+const notExpired = `(expires_at IS NULL OR expires_at > NOW())`
+
+// DeleteExpired removes subnets whose expiry has passed.
+func (r *Repository) DeleteExpired(ctx context.Context) (deletedCount int64, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "delete_expired", time.Now(), &err)
+
+	cmdTag, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`DELETE FROM subnets WHERE expires_at <= NOW()`)
 	if err != nil {
-		return fmt.Errorf("failed to add subnet %q to list type %d: %w", cidr, listType, err)
+		return 0, fmt.Errorf("failed to delete expired subnets: %w", err)
 	}
 
-	return nil
+	return cmdTag.RowsAffected(), nil
 }
 
-func (r *Repository) Remove(ctx context.Context, listType int, cidr string) (deletedCount int64, err error) {
+//nolint:lll
+func (r *Repository) Remove(ctx context.Context, tenant string, listType int, cidr string) (deletedCount int64, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "remove", time.Now(), &err)
+
 	if _, _, err := net.ParseCIDR(cidr); err != nil {
 		return 0, fmt.Errorf("invalid cidr %q: %w", cidr, err)
 	}
 
-	cmdTag, err := r.pool.Exec(ctx,
-		`DELETE FROM subnets WHERE subnet_type = $1 AND subnet = $2`,
-		listType, cidr)
+	cmdTag, err := storage.QuerierFromContext(ctx, r.pool).Exec(ctx,
+		`DELETE FROM subnets WHERE tenant IS NOT DISTINCT FROM $1::text AND subnet_type = $2 AND subnet = $3`,
+		tenantArg(tenant), listType, cidr)
 	if err != nil {
 		return 0, fmt.Errorf("failed to remove subnet %q from list type %d: %w", cidr, listType, err)
 	}
@@ -57,11 +154,17 @@ func (r *Repository) Remove(ctx context.Context, listType int, cidr string) (del
 	return cmdTag.RowsAffected(), nil
 }
 
-func (r *Repository) ListWithOffsetLimit(ctx context.Context, listType int, offset, limit uint64) ([]string, error) {
+// ListWithOffsetLimit lists the own subnets of the tenant, the global ones are listed for the default tenant.
+//
+//nolint:lll
+func (r *Repository) ListWithOffsetLimit(ctx context.Context, tenant string, listType int, offset, limit uint64) (_ []string, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "list_with_offset_limit", time.Now(), &err)
+
 	rows, err := r.pool.Query(ctx,
-		`SELECT subnet::text FROM subnets WHERE subnet_type = $1
+		`SELECT subnet::text FROM subnets WHERE tenant IS NOT DISTINCT FROM $1::text AND subnet_type = $2
+         AND `+notExpired+`
          ORDER BY subnet
-         OFFSET $2 LIMIT $3`, listType, offset, limit)
+         OFFSET $3 LIMIT $4`, tenantArg(tenant), listType, offset, limit)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"failed to query subnets for list type %d (offset=%d, limit=%d): %w",
@@ -85,9 +188,13 @@ func (r *Repository) ListWithOffsetLimit(ctx context.Context, listType int, offs
 	return subnets, nil
 }
 
-func (r *Repository) List(ctx context.Context, listType int) ([]string, error) {
+// List returns the global subnets of the list.
+func (r *Repository) List(ctx context.Context, listType int) (_ []string, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "list", time.Now(), &err)
+
 	rows, err := r.pool.Query(ctx,
-		`SELECT subnet::text FROM subnets WHERE subnet_type = $1 ORDER BY subnet`,
+		`SELECT subnet::text FROM subnets WHERE tenant IS NULL AND subnet_type = $1 AND `+notExpired+`
+		 ORDER BY subnet`,
 		listType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query all subnets for list type %d: %w", listType, err)
@@ -110,52 +217,115 @@ func (r *Repository) List(ctx context.Context, listType int) ([]string, error) {
 	return subnets, nil
 }
 
-func (r *Repository) GetBothLists(ctx context.Context) (whitelistSubnets, blacklistSubnets []string, err error) {
+// GetAllLists returns the lists of every tenant, the global lists are returned for the default tenant "".
+func (r *Repository) GetAllLists(ctx context.Context) (_ map[string]Lists, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "get_all_lists", time.Now(), &err)
+
 	rows, err := r.pool.Query(ctx,
-		`SELECT subnet_type, subnet::text FROM subnets 
-		 WHERE subnet_type IN ($1, $2) 
-		 ORDER BY subnet_type, subnet`,
+		`SELECT COALESCE(tenant, ''), subnet_type, subnet::text FROM subnets 
+		 WHERE subnet_type IN ($1, $2) AND `+notExpired+`
+		 ORDER BY tenant NULLS FIRST, subnet_type, subnet`,
 		WhitelistTypeID, BlacklistTypeID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to query both subnet lists: %w", err)
+		return nil, fmt.Errorf("failed to query all subnet lists: %w", err)
 	}
 	defer rows.Close()
 
-	var whitelist, blacklist []string
+	all := map[string]Lists{"": {}}
 	for rows.Next() {
+		var tenant string
 		var listType int
 		var cidr string
-		if err := rows.Scan(&listType, &cidr); err != nil {
-			return nil, nil, fmt.Errorf("failed to scan subnet row: %w", err)
+		if err := rows.Scan(&tenant, &listType, &cidr); err != nil {
+			return nil, fmt.Errorf("failed to scan subnet row: %w", err)
 		}
 
+		lists := all[tenant]
 		switch listType {
 		case WhitelistTypeID:
-			whitelist = append(whitelist, cidr)
+			lists.Whitelist = append(lists.Whitelist, cidr)
 		case BlacklistTypeID:
-			blacklist = append(blacklist, cidr)
+			lists.Blacklist = append(lists.Blacklist, cidr)
 		default:
-			return nil, nil, fmt.Errorf("invalid subnet type %d", listType)
+			return nil, fmt.Errorf("invalid subnet type %d", listType)
 		}
+		all[tenant] = lists
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, nil, fmt.Errorf("error iterating both subnet lists: %w", err)
+		return nil, fmt.Errorf("error iterating all subnet lists: %w", err)
 	}
 
-	return whitelist, blacklist, nil
+	return all, nil
 }
 
+// CheckIPInBothLists checks the IP against the global lists and the lists of the tenant.
+//
 //nolint:lll
-func (r *Repository) CheckIPInBothLists(ctx context.Context, ip string) (inWhitelist bool, inBlacklist bool, err error) {
+func (r *Repository) CheckIPInBothLists(ctx context.Context, tenant, ip string) (inWhitelist bool, inBlacklist bool, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "check_ip_in_both_lists", time.Now(), &err)
+
 	err = r.pool.QueryRow(ctx,
 		`SELECT
-			EXISTS(SELECT 1 FROM subnets WHERE subnet_type = $1 AND subnet >> $3::inet LIMIT 1) AS in_whitelist,
-			EXISTS(SELECT 1 FROM subnets WHERE subnet_type = $2 AND subnet >> $3::inet LIMIT 1) AS in_blacklist`,
-		WhitelistTypeID, BlacklistTypeID, ip).Scan(&inWhitelist, &inBlacklist)
+			EXISTS(SELECT 1 FROM subnets WHERE subnet_type = $1 AND (tenant IS NULL OR tenant = $4)
+			       AND subnet >>= $3::inet AND `+notExpired+` LIMIT 1) AS in_whitelist,
+			EXISTS(SELECT 1 FROM subnets WHERE subnet_type = $2 AND (tenant IS NULL OR tenant = $4)
+			       AND subnet >>= $3::inet AND `+notExpired+` LIMIT 1) AS in_blacklist`,
+		WhitelistTypeID, BlacklistTypeID, ip, tenant).Scan(&inWhitelist, &inBlacklist)
 	if err != nil {
 		return false, false, fmt.Errorf("failed to check IP %q in both lists: %w", ip, err)
 	}
 
 	return inWhitelist, inBlacklist, nil
 }
+
+//nolint:lll
+func (r *Repository) CheckIPsInBothLists(ctx context.Context, tenant string, ips []string) (_ map[string]IPCheckResult, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "check_ips_in_both_lists", time.Now(), &err)
+
+	rows, err := r.pool.Query(ctx,
+		`SELECT
+			ip,
+			EXISTS(SELECT 1 FROM subnets WHERE subnet_type = $1 AND (tenant IS NULL OR tenant = $4)
+			       AND subnet >>= ip::inet AND `+notExpired+` LIMIT 1) AS in_whitelist,
+			EXISTS(SELECT 1 FROM subnets WHERE subnet_type = $2 AND (tenant IS NULL OR tenant = $4)
+			       AND subnet >>= ip::inet AND `+notExpired+` LIMIT 1) AS in_blacklist
+		 FROM unnest($3::text[]) AS ip`,
+		WhitelistTypeID, BlacklistTypeID, ips, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %d IPs in both lists: %w", len(ips), err)
+	}
+	defer rows.Close()
+
+	results := make(map[string]IPCheckResult, len(ips))
+	for rows.Next() {
+		var ip string
+		var result IPCheckResult
+		if err := rows.Scan(&ip, &result.InWhitelist, &result.InBlacklist); err != nil {
+			return nil, fmt.Errorf("failed to scan IP check row: %w", err)
+		}
+		results[ip] = result
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating IP check rows: %w", err)
+	}
+
+	return results, nil
+}
+
+func (r *Repository) CountByType(ctx context.Context) (whitelistSize, blacklistSize int64, err error) {
+	defer storage.ObserveCall(r.observer, repositoryComponentName, "count_by_type", time.Now(), &err)
+
+	err = r.pool.QueryRow(ctx,
+		`SELECT
+			COUNT(*) FILTER (WHERE subnet_type = $1),
+			COUNT(*) FILTER (WHERE subnet_type = $2)
+		 FROM subnets WHERE `+notExpired,
+		WhitelistTypeID, BlacklistTypeID).Scan(&whitelistSize, &blacklistSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count subnets: %w", err)
+	}
+
+	return whitelistSize, blacklistSize, nil
+}
@@ -0,0 +1,223 @@
+package subnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultSnapshotInterval = 30 * time.Second
+
+var ErrNoSnapshot = errors.New("subnet lists have not been loaded yet")
+
+// ListsSource is implemented by Repository.
+type ListsSource interface {
+	GetAllLists(ctx context.Context) (map[string]Lists, error)
+}
+
+type parsedLists struct {
+	whitelist []*net.IPNet
+	blacklist []*net.IPNet
+	// blacklistedNear counts blacklisted subnets by the neighborhood they lie in, see BlacklistedNear.
+	blacklistedNear map[string]int
+}
+
+type lists struct {
+	// tenants hold the lists of every tenant, the global lists are kept for the default tenant "".
+	tenants  map[string]parsedLists
+	loadedAt time.Time
+}
+
+// Snapshot keeps the last successfully loaded subnet lists in memory,
+// so IPs can still be checked while Postgres is unavailable.
+type Snapshot struct {
+	source ListsSource
+	logger *slog.Logger
+	lists  atomic.Pointer[lists]
+}
+
+func NewSnapshot(source ListsSource, logger *slog.Logger) *Snapshot {
+	return &Snapshot{
+		source: source,
+		logger: logger,
+	}
+}
+
+// Refresh replaces the snapshot with the current lists. A failed refresh keeps the previous snapshot.
+func (s *Snapshot) Refresh(ctx context.Context) error {
+	all, err := s.source.GetAllLists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to refresh subnet snapshot: %w", err)
+	}
+
+	tenants := make(map[string]parsedLists, len(all))
+	for tenant, l := range all {
+		blacklist := s.parse(l.Blacklist, BlacklistTypeID)
+		tenants[tenant] = parsedLists{
+			whitelist:       s.parse(l.Whitelist, WhitelistTypeID),
+			blacklist:       blacklist,
+			blacklistedNear: countNeighborhoods(blacklist),
+		}
+	}
+
+	s.lists.Store(&lists{tenants: tenants, loadedAt: time.Now()})
+
+	return nil
+}
+
+// Run refreshes the snapshot every interval until ctx is done.
+func (s *Snapshot) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Warn("keeping stale subnet snapshot", "loadedAt", s.LoadedAt(), "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LoadedAt is zero until the first successful refresh.
+func (s *Snapshot) LoadedAt() time.Time {
+	if l := s.lists.Load(); l != nil {
+		return l.loadedAt
+	}
+	return time.Time{}
+}
+
+//nolint:lll
+func (s *Snapshot) CheckIPInBothLists(_ context.Context, tenant, ip string) (inWhitelist bool, inBlacklist bool, err error) {
+	l := s.lists.Load()
+	if l == nil {
+		return false, false, ErrNoSnapshot
+	}
+
+	result, err := l.check(tenant, ip)
+	if err != nil {
+		return false, false, err
+	}
+
+	return result.InWhitelist, result.InBlacklist, nil
+}
+
+func (s *Snapshot) CheckIPsInBothLists(_ context.Context, tenant string, ips []string) ([]IPCheckResult, error) {
+	l := s.lists.Load()
+	if l == nil {
+		return nil, ErrNoSnapshot
+	}
+
+	results := make([]IPCheckResult, len(ips))
+	for i, ip := range ips {
+		result, err := l.check(tenant, ip)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+// Neighborhoods are the networks around an IP searched by BlacklistedNear.
+const (
+	NeighborhoodPrefixIPv4 = 16
+	NeighborhoodPrefixIPv6 = 48
+)
+
+// BlacklistedNear counts blacklisted subnets of the global lists and the lists of the tenant inside the /16
+// around an IPv4 address, or the /48 around an IPv6 one. Subnets larger than that are not counted.
+// Counts are taken on refresh, so it is a lookup. It is zero until the first successful refresh.
+func (s *Snapshot) BlacklistedNear(tenant, ipStr string) int {
+	l := s.lists.Load()
+	ip := net.ParseIP(ipStr)
+	if l == nil || ip == nil {
+		return 0
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	key := neighborhood(ip, bits)
+
+	near := l.tenants[""].blacklistedNear[key]
+	if tenant != "" {
+		near += l.tenants[tenant].blacklistedNear[key]
+	}
+
+	return near
+}
+
+// neighborhood names the network around ip searched by BlacklistedNear, bits tell the address family.
+func neighborhood(ip net.IP, bits int) string {
+	return ip.Mask(net.CIDRMask(neighborhoodPrefix(bits), bits)).String()
+}
+
+func neighborhoodPrefix(bits int) int {
+	if bits == 8*net.IPv4len {
+		return NeighborhoodPrefixIPv4
+	}
+	return NeighborhoodPrefixIPv6
+}
+
+// countNeighborhoods counts the subnets inside every neighborhood, subnets larger than one are left out.
+func countNeighborhoods(subnets []*net.IPNet) map[string]int {
+	counts := make(map[string]int)
+	for _, subnet := range subnets {
+		ones, bits := subnet.Mask.Size()
+		if ones >= neighborhoodPrefix(bits) {
+			counts[neighborhood(subnet.IP, bits)]++
+		}
+	}
+	return counts
+}
+
+func (s *Snapshot) parse(cidrs []string, listType int) []*net.IPNet {
+	subnets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			s.logger.Warn("skipping invalid CIDR in snapshot", "cidr", cidr, "listType", listType, "error", err)
+			continue
+		}
+		subnets = append(subnets, ipNet)
+	}
+
+	return subnets
+}
+
+// check looks at the global lists and the lists of the tenant.
+func (l *lists) check(tenant, ipStr string) (IPCheckResult, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return IPCheckResult{}, fmt.Errorf("invalid IP address: %q", ipStr)
+	}
+
+	global := l.tenants[""]
+	own := l.tenants[tenant]
+
+	return IPCheckResult{
+		InWhitelist: containsIP(global.whitelist, ip) || containsIP(own.whitelist, ip),
+		InBlacklist: containsIP(global.blacklist, ip) || containsIP(own.blacklist, ip),
+	}, nil
+}
+
+// containsIP is inclusive like subnet >>= ip in Postgres, so a /32 or /128 subnet contains its only address.
+func containsIP(subnets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range subnets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
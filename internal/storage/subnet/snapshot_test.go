@@ -0,0 +1,89 @@
+package subnet
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+type staticLists map[string]Lists
+
+func (s staticLists) GetAllLists(context.Context) (map[string]Lists, error) {
+	return s, nil
+}
+
+func TestSnapshotBlacklistedNear(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	snapshot := NewSnapshot(staticLists{
+		"": {
+			Whitelist: []string{"10.1.0.0/24"},
+			Blacklist: []string{"10.1.2.0/24", "10.1.3.7/32", "10.0.0.0/8", "10.2.0.0/24", "2001:db8:1::/64"},
+		},
+		"acme": {Blacklist: []string{"10.1.200.0/24"}},
+	}, logger)
+
+	if near := snapshot.BlacklistedNear("", "10.1.0.1"); near != 0 {
+		t.Errorf("BlacklistedNear() before the first refresh = %d, want 0", near)
+	}
+	if err := snapshot.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tenant string
+		ip     string
+		want   int
+	}{
+		{"subnets inside the /16", "", "10.1.0.1", 2},
+		{"tenant subnets count too", "acme", "10.1.0.1", 3},
+		{"other tenants are ignored", "other", "10.1.0.1", 2},
+		{"nothing around", "", "172.16.0.1", 0},
+		{"ipv6 /48", "", "2001:db8:1:ffff::1", 1},
+		{"invalid ip", "", "10.1.0.300", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := snapshot.BlacklistedNear(tt.tenant, tt.ip); got != tt.want {
+				t.Errorf("BlacklistedNear(%q, %q) = %d, want %d", tt.tenant, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotChecksSingleAddressSubnets(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	snapshot := NewSnapshot(staticLists{
+		"": {Whitelist: []string{"2001:db8::1/128"}, Blacklist: []string{"10.0.0.1/32"}},
+	}, logger)
+	if err := snapshot.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	tests := []struct {
+		ip            string
+		wantWhitelist bool
+		wantBlacklist bool
+	}{
+		{"10.0.0.1", false, true},
+		{"10.0.0.2", false, false},
+		{"2001:db8::1", true, false},
+		{"2001:db8::2", false, false},
+	}
+
+	for _, tt := range tests {
+		inWhitelist, inBlacklist, err := snapshot.CheckIPInBothLists(context.Background(), "", tt.ip)
+		if err != nil || inWhitelist != tt.wantWhitelist || inBlacklist != tt.wantBlacklist {
+			t.Errorf("CheckIPInBothLists(%q) = %v, %v, %v, want %v, %v",
+				tt.ip, inWhitelist, inBlacklist, err, tt.wantWhitelist, tt.wantBlacklist)
+		}
+	}
+}
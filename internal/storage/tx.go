@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Querier is implemented by both *pgxpool.Pool and pgx.Tx.
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type txKey struct{}
+
+// QuerierFromContext returns the transaction started by Transactor.InTx, or pool outside of one.
+// Repositories use it so their writes join the caller's transaction without extra parameters.
+func QuerierFromContext(ctx context.Context, pool Querier) Querier {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return pool
+}
+
+type Transactor struct {
+	pool *Pool
+}
+
+func NewTransactor(pool *Pool) *Transactor {
+	return &Transactor{
+		pool: pool,
+	}
+}
+
+// InTx runs fn in a transaction that is committed when fn returns nil and rolled back otherwise.
+// Nested calls reuse the outer transaction. The whole transaction is a single call of the pool's guard.
+func (t *Transactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	err := t.pool.guard.Do(ctx, func(ctx context.Context) error {
+		return pgx.BeginFunc(ctx, t.pool.pool, func(tx pgx.Tx) error {
+			return fn(context.WithValue(ctx, txKey{}, tx))
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("transaction failed: %w", err)
+	}
+
+	return nil
+}
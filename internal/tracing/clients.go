@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/FluVirus2/antibruteforce/internal/tracing"
+
+type pgxTracer struct {
+	tracer trace.Tracer
+}
+
+// NewPgxTracer creates a span for every query, to be set as pgx.ConnConfig.Tracer.
+// Only the parameterized SQL is recorded, never the arguments.
+func NewPgxTracer(provider trace.TracerProvider) pgx.QueryTracer {
+	return &pgxTracer{tracer: provider.Tracer(instrumentationName)}
+}
+
+func (t *pgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	operation := sqlOperation(data.SQL)
+	ctx, _ = t.tracer.Start(ctx, "postgres "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "postgresql"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.query.text", data.SQL),
+		),
+	)
+
+	return ctx
+}
+
+func (t *pgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	err := data.Err
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+	}
+	EndSpan(span, &err)
+}
+
+func sqlOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+
+	return strings.ToUpper(fields[0])
+}
+
+type redisHook struct {
+	tracer trace.Tracer
+}
+
+// NewRedisHook creates a span for every command and pipeline, to be added with redis.Client.AddHook.
+// Command arguments are not recorded since rate limit keys carry logins and passwords.
+func NewRedisHook(provider trace.TracerProvider) redis.Hook {
+	return &redisHook{tracer: provider.Tracer(instrumentationName)}
+}
+
+func (h *redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) (err error) {
+		ctx, span := h.tracer.Start(ctx, "redis "+cmd.FullName(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system.name", "redis"),
+				attribute.String("db.operation.name", cmd.FullName()),
+			),
+		)
+		defer endRedisSpan(span, &err)
+
+		return next(ctx, cmd)
+	}
+}
+
+func (h *redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) (err error) {
+		ctx, span := h.tracer.Start(ctx, "redis pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system.name", "redis"),
+				attribute.String("db.operation.name", "pipeline"),
+				attribute.Int("db.operation.batch.size", len(cmds)),
+			),
+		)
+		defer endRedisSpan(span, &err)
+
+		return next(ctx, cmds)
+	}
+}
+
+// endRedisSpan does not treat a missing key as a failure.
+func endRedisSpan(span trace.Span, err *error) {
+	if errors.Is(*err, redis.Nil) {
+		span.End()
+		return
+	}
+	EndSpan(span, err)
+}
@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRedisHookSpans(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	client.AddHook(NewRedisHook(provider))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+
+	if err := client.Get(ctx, "ratelimit:password:hunter2").Err(); !errors.Is(err, redis.Nil) {
+		t.Fatalf("Get() error = %v, want redis.Nil", err)
+	}
+
+	pipe := client.Pipeline()
+	pipe.Incr(ctx, "ratelimit:login:alice")
+	pipe.Incr(ctx, "ratelimit:login:bob")
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatalf("Exec() unexpected error = %v", err)
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		byName[span.Name] = span
+
+		for _, attr := range span.Attributes {
+			if strings.Contains(attr.Value.Emit(), "hunter2") || strings.Contains(attr.Value.Emit(), "alice") {
+				t.Errorf("span %q records command arguments in %q", span.Name, attr.Key)
+			}
+		}
+	}
+
+	get, ok := byName["redis get"]
+	if !ok {
+		t.Fatalf("no span for GET, got %v", spans)
+	}
+	if get.Status.Code == codes.Error {
+		t.Errorf("GET of a missing key is reported as an error")
+	}
+	if get.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("GET span is not a child of the caller span")
+	}
+
+	if _, ok := byName["redis pipeline"]; !ok {
+		t.Errorf("no span for pipeline, got %v", spans)
+	}
+}
+
+func TestSQLOperation(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		Name     string
+		SQL      string
+		Expected string
+	}{
+		{Name: "select", SQL: "select exists(select 1 from subnets)", Expected: "SELECT"},
+		{Name: "leading whitespace", SQL: "\n\t  INSERT INTO subnets VALUES ($1)", Expected: "INSERT"},
+		{Name: "empty", SQL: "  ", Expected: "QUERY"},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.Name, func(t *testing.T) {
+			t.Parallel()
+
+			if actual := sqlOperation(testcase.SQL); actual != testcase.Expected {
+				t.Errorf("sqlOperation(%q) = %q, want %q", testcase.SQL, actual, testcase.Expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultFailureThreshold = 5
+	DefaultOpenTimeout      = 10 * time.Second
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half_open"
+	case StateOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+type Config struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker rejects calls before letting a single probe through.
+	OpenTimeout time.Duration
+	// OnStateChange may be nil. It runs in its own goroutine, so notifications of quick
+	// successive transitions may arrive out of order.
+	OnStateChange func(from, to State)
+}
+
+// Breaker opens after FailureThreshold consecutive failures and rejects calls with ErrOpen.
+// After OpenTimeout it is half-open: one probe call is let through, it closes the breaker
+// on success and opens it again on failure. Calls made while the probe is in flight are rejected.
+type Breaker struct {
+	config Config
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	rejected atomic.Uint64
+	opened   atomic.Uint64
+}
+
+func New(config Config) *Breaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = DefaultFailureThreshold
+	}
+
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = DefaultOpenTimeout
+	}
+
+	return &Breaker{
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// Allow reports whether a call may proceed. When it may, done must be called exactly once
+// with whether the call failed.
+func (b *Breaker) Allow() (done func(failed bool), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.setState(StateHalfOpen)
+	}
+
+	switch b.state {
+	case StateOpen:
+		b.rejected.Add(1)
+		return nil, ErrOpen
+	case StateHalfOpen:
+		if b.probing {
+			b.rejected.Add(1)
+			return nil, ErrOpen
+		}
+		b.probing = true
+	}
+
+	var once sync.Once
+	return func(failed bool) {
+		once.Do(func() { b.record(failed) })
+	}, nil
+}
+
+func (b *Breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen {
+		b.probing = false
+	}
+
+	if !failed {
+		b.failures = 0
+		if b.state == StateHalfOpen {
+			b.setState(StateClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || (b.state == StateClosed && b.failures >= b.config.FailureThreshold) {
+		b.openedAt = b.now()
+		b.opened.Add(1)
+		b.setState(StateOpen)
+	}
+}
+
+// setState must be called with mu held.
+func (b *Breaker) setState(to State) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+
+	if b.config.OnStateChange != nil {
+		go b.config.OnStateChange(from, to)
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Rejected is the number of calls rejected with ErrOpen.
+func (b *Breaker) Rejected() uint64 {
+	return b.rejected.Load()
+}
+
+// Opened is the number of times the breaker has opened.
+func (b *Breaker) Opened() uint64 {
+	return b.opened.Load()
+}
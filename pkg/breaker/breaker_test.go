@@ -0,0 +1,106 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerStateMachine(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	b := New(Config{FailureThreshold: 3, OpenTimeout: 10 * time.Second})
+	b.now = func() time.Time { return now }
+
+	call := func(failed bool) error {
+		done, err := b.Allow()
+		if err != nil {
+			return err
+		}
+		done(failed)
+		return nil
+	}
+
+	// a success in between resets the count of consecutive failures
+	for _, failed := range []bool{true, true, false, true, true} {
+		if err := call(failed); err != nil {
+			t.Fatalf("call rejected while closed: %v", err)
+		}
+	}
+	if b.State() != StateClosed {
+		t.Fatalf("state = %s after non-consecutive failures, want closed", b.State())
+	}
+
+	if err := call(true); err != nil {
+		t.Fatalf("call rejected while closed: %v", err)
+	}
+	if b.State() != StateOpen || b.Opened() != 1 {
+		t.Fatalf("state = %s, opened = %d after threshold, want open once", b.State(), b.Opened())
+	}
+
+	if err := call(false); !errors.Is(err, ErrOpen) {
+		t.Fatalf("call while open: err = %v, want ErrOpen", err)
+	}
+
+	// the failed probe opens the breaker again for a whole timeout
+	now = now.Add(10 * time.Second)
+	if err := call(true); err != nil {
+		t.Fatalf("probe rejected after open timeout: %v", err)
+	}
+	if b.State() != StateOpen || b.Opened() != 2 {
+		t.Fatalf("state = %s, opened = %d after failed probe, want open twice", b.State(), b.Opened())
+	}
+
+	now = now.Add(5 * time.Second)
+	if err := call(false); !errors.Is(err, ErrOpen) {
+		t.Fatalf("call before the timeout after a failed probe: err = %v, want ErrOpen", err)
+	}
+
+	now = now.Add(5 * time.Second)
+	probeDone, err := b.Allow()
+	if err != nil {
+		t.Fatalf("probe rejected after open timeout: %v", err)
+	}
+	if b.State() != StateHalfOpen {
+		t.Fatalf("state = %s during probe, want half_open", b.State())
+	}
+	if err := call(false); !errors.Is(err, ErrOpen) {
+		t.Fatalf("call during probe: err = %v, want ErrOpen", err)
+	}
+
+	probeDone(false)
+	probeDone(true) // only the first report counts
+	if b.State() != StateClosed {
+		t.Fatalf("state = %s after successful probe, want closed", b.State())
+	}
+
+	if b.Rejected() != 3 {
+		t.Errorf("rejected = %d, want 3", b.Rejected())
+	}
+}
+
+func TestBreakerNotifiesStateChanges(t *testing.T) {
+	t.Parallel()
+
+	changes := make(chan [2]State, 1)
+	b := New(Config{
+		FailureThreshold: 1,
+		OnStateChange:    func(from, to State) { changes <- [2]State{from, to} },
+	})
+
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("call rejected while closed: %v", err)
+	}
+	done(true)
+
+	select {
+	case change := <-changes:
+		if change != [2]State{StateClosed, StateOpen} {
+			t.Errorf("state change = %s -> %s, want closed -> open", change[0], change[1])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("state change was not reported")
+	}
+}
@@ -0,0 +1,172 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultInterval = 10 * time.Second
+
+var ErrNoCertificates = errors.New("no certificates found in PEM file")
+
+// Reloader keeps a server certificate and an optional client CA bundle in memory
+// and swaps them when the files change on disk. Handshakes in flight keep the old values.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	logger       *slog.Logger
+
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+	modTimes  []time.Time
+}
+
+// NewReloader loads the files once and fails if they are not valid. clientCAFile may be empty.
+func NewReloader(certFile, keyFile, clientCAFile string, logger *slog.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		logger:       logger,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// Reload reads all files and swaps them in only if every one of them is valid.
+func (r *Reloader) Reload() error {
+	modTimes, err := r.currentModTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair %q, %q: %w", r.certFile, r.keyFile, err)
+	}
+
+	var pool *x509.CertPool
+	if r.clientCAFile != "" {
+		pool, err = LoadCertPool(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.clientCAs.Store(pool)
+	r.modTimes = modTimes
+
+	return nil
+}
+
+func (r *Reloader) currentModTimes() ([]time.Time, error) {
+	files := r.files()
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %q: %w", file, err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *Reloader) changed() bool {
+	modTimes, err := r.currentModTimes()
+	if err != nil {
+		// a rotation tool may be replacing the files right now, try again on the next tick
+		return false
+	}
+
+	for i := range modTimes {
+		if !modTimes[i].Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run polls the files every interval until ctx is done. A failed reload keeps the previous certificates.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+
+			if err := r.Reload(); err != nil {
+				r.logger.Error("failed to reload TLS certificates, keeping previous ones", "error", err)
+				continue
+			}
+
+			r.logger.Info("reloaded TLS certificates", "cert", r.certFile)
+		}
+	}
+}
+
+// ServerConfig returns a TLS config that always serves the latest loaded certificate.
+// When a client CA file is set, client certificates are verified against it and required if requireClientCert.
+func (r *Reloader) ServerConfig(requireClientCert bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			conf := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert.Load()},
+				ClientAuth:   tls.NoClientCert,
+			}
+
+			if pool := r.clientCAs.Load(); pool != nil {
+				conf.ClientCAs = pool
+				conf.ClientAuth = tls.VerifyClientCertIfGiven
+				if requireClientCert {
+					conf.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+
+			return conf, nil
+		},
+	}
+}
+
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file %q: %w", file, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: %q", ErrNoCertificates, file)
+	}
+
+	return pool, nil
+}